@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// reachableCommits returns every commit reachable from starts, the starts
+// included.
+func reachableCommits(repo string, starts ...string) map[string]bool {
+	seen := map[string]bool{}
+	pending := append([]string{}, starts...)
+	for len(pending) > 0 {
+		commit := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if commit == "" || seen[commit] {
+			continue
+		}
+		seen[commit] = true
+		pending = append(pending, readCommit(repo, commit).parents...)
+	}
+	return seen
+}
+
+// isAncestor reports whether ancestor can be reached from commit. A commit
+// is its own ancestor.
+func isAncestor(repo string, ancestor string, commit string) bool {
+	return newAncestryWalk(repo).inMergeBases(ancestor, commit)
+}
+
+// aheadBehind counts the commits only reachable from commit and the ones
+// only reachable from upstream.
+func aheadBehind(repo string, commit string, upstream string) (int, int) {
+	ours := reachableCommits(repo, commit)
+	theirs := reachableCommits(repo, upstream)
+	ahead, behind := 0, 0
+	for hexHash := range ours {
+		if !theirs[hexHash] {
+			ahead++
+		}
+	}
+	for hexHash := range theirs {
+		if !ours[hexHash] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+// mergeBases returns the best common ancestors of a and b, the common
+// ancestors that no other common ancestor descends from, newest first.
+func mergeBases(repo string, a string, b string) []string {
+	return newAncestryWalk(repo).mergeBases(a, []string{b})
+}
+
+// The marks painted on commits while looking for common ancestors
+const (
+	paintParent1 = 1 << iota
+	paintParent2
+	paintStale
+	paintResult
+)
+
+// ancestryWalk answers ancestry queries by painting commits down from the
+// ones asked about, like git. Generation numbers from the commit graph let
+// it stop before walking down to the root commits.
+type ancestryWalk struct {
+	repo    string
+	graph   *commitGraphFile
+	commits map[string]*ancestryCommit
+}
+
+type ancestryCommit struct {
+	parents    []string
+	date       int64
+	generation uint64
+	flags      int
+}
+
+func newAncestryWalk(repo string) *ancestryWalk {
+	return &ancestryWalk{repo: repo, graph: readCommitGraph(repo), commits: map[string]*ancestryCommit{}}
+}
+
+func (walk *ancestryWalk) commit(hexHash string) *ancestryCommit {
+	commit, ok := walk.commits[hexHash]
+	if !ok {
+		object := readCommit(walk.repo, hexHash)
+		commit = &ancestryCommit{parents: object.parents, date: commitDate(object), generation: walk.graph.generation(hexHash)}
+		walk.commits[hexHash] = commit
+	}
+	return commit
+}
+
+func (walk *ancestryWalk) clearFlags() {
+	for _, commit := range walk.commits {
+		commit.flags = 0
+	}
+}
+
+// insertByDate inserts hexHash into list, which is sorted newest first,
+// after the commits with the same date.
+func (walk *ancestryWalk) insertByDate(list []string, hexHash string) []string {
+	date := walk.commit(hexHash).date
+	at := 0
+	for at < len(list) && walk.commit(list[at]).date >= date {
+		at++
+	}
+	return slices.Insert(list, at, hexHash)
+}
+
+// paintDownToCommon paints what is reachable from one and from twos until
+// only commits below the common ancestors found are left to walk, or the
+// generation drops below minGeneration. It returns the common ancestors
+// found, some of which may be marked stale as ancestors of others.
+func (walk *ancestryWalk) paintDownToCommon(one string, twos []string, minGeneration uint64) []string {
+	queue := &commitQueue{}
+	byGeneration := minGeneration != 0 || walk.graph != nil && walk.graph.correctedDates
+	push := func(hexHash string) {
+		commit := walk.commit(hexHash)
+		generation := uint64(0)
+		if byGeneration {
+			generation = commit.generation
+		}
+		queue.pushGeneration(hexHash, generation, commit.date)
+	}
+	walk.commit(one).flags |= paintParent1
+	if len(twos) == 0 {
+		return []string{one}
+	}
+	push(one)
+	for _, two := range twos {
+		walk.commit(two).flags |= paintParent2
+		push(two)
+	}
+	found := []string{}
+	for slices.ContainsFunc(queue.items, func(item queuedCommit) bool { return walk.commit(item.hexHash).flags&paintStale == 0 }) {
+		hexHash := queue.pop()
+		commit := walk.commit(hexHash)
+		if commit.generation < minGeneration {
+			break
+		}
+		flags := commit.flags & (paintParent1 | paintParent2 | paintStale)
+		if flags == paintParent1|paintParent2 {
+			if commit.flags&paintResult == 0 {
+				commit.flags |= paintResult
+				found = walk.insertByDate(found, hexHash)
+			}
+			// Whatever a common ancestor reaches is not a best one
+			flags |= paintStale
+		}
+		for _, parent := range commit.parents {
+			if walk.commit(parent).flags&flags == flags {
+				continue
+			}
+			walk.commit(parent).flags |= flags
+			push(parent)
+		}
+	}
+	return found
+}
+
+// mergeBases returns the best common ancestors of one and any of twos,
+// newest first.
+func (walk *ancestryWalk) mergeBases(one string, twos []string) []string {
+	defer walk.clearFlags()
+	if slices.Contains(twos, one) {
+		return []string{one}
+	}
+	bases := []string{}
+	for _, hexHash := range walk.paintDownToCommon(one, twos, 0) {
+		if walk.commit(hexHash).flags&paintStale == 0 {
+			bases = walk.insertByDate(bases, hexHash)
+		}
+	}
+	walk.clearFlags()
+	if len(bases) < 2 {
+		return bases
+	}
+	// A common ancestor can be reached from another through a path the
+	// painting did not get to mark stale
+	result := []string{}
+	for _, hexHash := range walk.removeRedundant(bases) {
+		result = walk.insertByDate(result, hexHash)
+	}
+	return result
+}
+
+// removeRedundant drops the commits that can be reached from others in
+// commits, keeping the order of the rest.
+func (walk *ancestryWalk) removeRedundant(commits []string) []string {
+	minGeneration := uint64(generationInfinity)
+	for _, hexHash := range commits {
+		minGeneration = min(minGeneration, walk.commit(hexHash).generation)
+	}
+	redundant := make([]bool, len(commits))
+	for i, hexHash := range commits {
+		if redundant[i] {
+			continue
+		}
+		others, indices := []string{}, []int{}
+		for j, other := range commits {
+			if j != i && !redundant[j] {
+				others = append(others, other)
+				indices = append(indices, j)
+			}
+		}
+		walk.paintDownToCommon(hexHash, others, minGeneration)
+		if walk.commit(hexHash).flags&paintParent2 != 0 {
+			redundant[i] = true
+		}
+		for k, other := range others {
+			if walk.commit(other).flags&paintParent1 != 0 {
+				redundant[indices[k]] = true
+			}
+		}
+		walk.clearFlags()
+	}
+	kept := []string{}
+	for i, hexHash := range commits {
+		if !redundant[i] {
+			kept = append(kept, hexHash)
+		}
+	}
+	return kept
+}
+
+// inMergeBases reports whether commit can be reached from reference. The
+// walk never goes below the generation of commit, and commits with a higher
+// generation than reference cannot be reached from it at all.
+func (walk *ancestryWalk) inMergeBases(commit string, reference string) bool {
+	defer walk.clearFlags()
+	generation := walk.commit(commit).generation
+	if generation > walk.commit(reference).generation {
+		return false
+	}
+	walk.paintDownToCommon(commit, []string{reference}, generation)
+	return walk.commit(commit).flags&paintParent2 != 0
+}
+
+// octopusMergeBases returns the common ancestors for merging all commits at
+// once, by merging the bases found so far with one commit after the other.
+func (walk *ancestryWalk) octopusMergeBases(commits []string) []string {
+	if len(commits) == 0 {
+		return nil
+	}
+	bases := commits[:1]
+	for _, hexHash := range commits[1:] {
+		next := []string{}
+		for _, base := range bases {
+			next = append(next, walk.mergeBases(hexHash, []string{base})...)
+		}
+		bases = next
+	}
+	return bases
+}
+
+// reduceHeads drops duplicates and the commits reachable from others,
+// keeping the order of the rest.
+func (walk *ancestryWalk) reduceHeads(commits []string) []string {
+	unique := []string{}
+	for _, hexHash := range commits {
+		if !slices.Contains(unique, hexHash) {
+			unique = append(unique, hexHash)
+		}
+	}
+	return walk.removeRedundant(unique)
+}
+
+// forkPoint finds where commit forked from the history of refName, which
+// includes every commit its reflog says it pointed to. That is the merge
+// base of commit and those, as long as there is exactly one and the ref did
+// point to it at some time.
+func (walk *ancestryWalk) forkPoint(refName string, tip string, commit string) (string, bool) {
+	candidates := []string{}
+	add := func(hexHash string) {
+		if hexHash == zeroHex || slices.Contains(candidates, hexHash) || !objectExists(walk.repo, hexHash) {
+			return
+		}
+		if objectType, _ := readObject(walk.repo, hexHash); objectType != Commit {
+			fmt.Fprintf(os.Stderr, "error: Object %s not a commit\n", hexHash)
+			return
+		}
+		candidates = append(candidates, hexHash)
+	}
+	for i, entry := range readReflog(walk.repo, refName) {
+		if i == 0 {
+			add(entry.old)
+		}
+		add(entry.new)
+	}
+	if len(candidates) == 0 {
+		add(tip)
+	}
+	bases := walk.mergeBases(commit, candidates)
+	if len(bases) != 1 || !slices.Contains(candidates, bases[0]) {
+		return "", false
+	}
+	return bases[0], true
+}
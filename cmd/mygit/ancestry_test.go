@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func writeTestCommit(t *testing.T, message string, date int, parents ...string) string {
+	t.Helper()
+	content := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+	for _, parent := range parents {
+		content += "parent " + parent + "\n"
+	}
+	content += fmt.Sprintf("author A <a@x> %d +0000\ncommitter A <a@x> %d +0000\n\n%s\n", date, date, message)
+	return createCommitObject([]byte(content))
+}
+
+func TestAncestryWalk(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll(".git/objects", 0755); err != nil {
+		t.Fatal(err)
+	}
+	// b and c both fork from a and are merged into each other both ways,
+	// which leaves d and e with two best common ancestors
+	a := writeTestCommit(t, "a", 1000)
+	b := writeTestCommit(t, "b", 1100, a)
+	c := writeTestCommit(t, "c", 1200, a)
+	d := writeTestCommit(t, "d", 1300, b, c)
+	e := writeTestCommit(t, "e", 1400, c, b)
+	f := writeTestCommit(t, "f", 1500, e)
+	walk := newAncestryWalk(".")
+
+	if got, want := walk.mergeBases(d, []string{f}), []string{c, b}; !slices.Equal(got, want) {
+		t.Errorf("merge bases of d and f = %v, want %v", got, want)
+	}
+	if got, want := walk.mergeBases(b, []string{c}), []string{a}; !slices.Equal(got, want) {
+		t.Errorf("merge bases of b and c = %v, want %v", got, want)
+	}
+	if got, want := walk.mergeBases(e, []string{f, d}), []string{e}; !slices.Equal(got, want) {
+		t.Errorf("merge bases of e and f or d = %v, want %v", got, want)
+	}
+	if !walk.inMergeBases(a, f) || !walk.inMergeBases(f, f) || walk.inMergeBases(d, f) {
+		t.Errorf("wrong ancestry between a, d and f")
+	}
+	if got, want := walk.reduceHeads([]string{b, f, a, d, f}), []string{f, d}; !slices.Equal(got, want) {
+		t.Errorf("reduced heads = %v, want %v", got, want)
+	}
+	if got, want := walk.reduceHeads(walk.octopusMergeBases([]string{d, f, b})), []string{b}; !slices.Equal(got, want) {
+		t.Errorf("octopus merge bases = %v, want %v", got, want)
+	}
+}
+
+func TestMergeBaseWithMixedGenerationLayers(t *testing.T) {
+	repo := newTestRepo(t)
+	for i := range 7 {
+		if i == 4 {
+			realGit(t, repo, "commit-graph", "write", "--reachable", "--split")
+		}
+		mygit(t, repo, "commit", "--allow-empty", "-m", fmt.Sprint(i))
+	}
+	// The new layer has topological levels only, so the corrected commit
+	// dates of the first one must not be used either
+	realGit(t, repo, "-c", "commitGraph.generationVersion=1", "commit-graph", "write", "--reachable", "--split=no-merge")
+	if result := runMygit(t, repo, "", "merge-base", "--is-ancestor", "HEAD~6", "HEAD"); result.code != 0 {
+		t.Errorf("merge-base --is-ancestor HEAD~6 HEAD = %d %q", result.code, result.stderr)
+	}
+}
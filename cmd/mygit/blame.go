@@ -0,0 +1,1122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+*  ###################### BLAME ##############################
+*
+*  blame attributes every line of a file to the commit that introduced it.
+*  Lines start out suspected of the final version of the file, an origin:
+*  a path in a commit. Commits are visited newest first, and each diffs its
+*  origins against the same path, or the path it was renamed from, in its
+*  parents. Lines a parent has too are passed on to the parent, the lines
+*  that are left were changed by the commit and are blamed on it.
+*
+*  Lines of ignored commits are passed on a second time. Each changed line
+*  is matched against the similar lines of the parent's side of its hunk,
+*  the most certain match first, and moved to its best match. Lines that
+*  match nothing stay with the ignored commit and are unblamable.
+*
+*  <hash> [<path>] [<line>] (<author> <date> <line>) <text>
+ */
+
+// blameCommit is a commit visited by blame, which for the working tree is
+// made up with an all zero name.
+type blameCommit struct {
+	hexHash string
+	commit  commitObject
+	origins []*blameOrigin
+	// boundary commits are marked with a caret: the root commit, unless
+	// --root is given
+	boundary bool
+	// shown tells whether the details of the commit were printed yet
+	shown bool
+}
+
+// blameOrigin is a version of the file: the blob at path in a commit.
+type blameOrigin struct {
+	commit *blameCommit
+	path   string
+	blob   string
+	perm   ObjectPerm
+	// suspects are the final lines still suspected to come from here
+	suspects []int
+	// previous is the origin of the first parent lines could be passed to
+	previous *blameOrigin
+	// guilty is set once lines are blamed on the origin
+	guilty  bool
+	content []byte
+	loaded  bool
+}
+
+// blameLine is where a line of the final file currently comes from, and
+// how it got there.
+type blameLine struct {
+	origin *blameOrigin
+	// sLno is the line number in the file of origin, counting from zero
+	sLno int
+	// ignored lines were passed on by an ignored commit, unblamable lines
+	// were changed by one and could not be matched
+	ignored, unblamable bool
+}
+
+// blameOptions control how lines are followed through history.
+type blameOptions struct {
+	lineDiff lineDiffOptions
+	showRoot bool
+	ignored  map[string]bool
+}
+
+// blameWalk tracks the lines of the final file until all are blamed.
+type blameWalk struct {
+	repo    string
+	options blameOptions
+	path    string
+	// start is the origin of the final file, and final its lines
+	start   *blameOrigin
+	final   []string
+	lines   []blameLine
+	commits map[string]*blameCommit
+	queue   commitQueue
+}
+
+// newBlameWalk prepares blaming path at the commit hexHash, named rev.
+// Without a commit the file in the working tree is blamed, on top of HEAD
+// and the commits being merged.
+func newBlameWalk(repo string, hexHash string, rev string, name string, options blameOptions) (*blameWalk, error) {
+	walk := &blameWalk{repo: repo, options: options, path: name, commits: map[string]*blameCommit{}}
+	if hexHash == "" {
+		commit, err := walk.worktreeCommit(name)
+		if err != nil {
+			return nil, err
+		}
+		walk.start = commit.origins[0]
+	} else {
+		commit := walk.commit(hexHash)
+		file, ok := treeFileAt(repo, commit.commit.tree, name)
+		if !ok || file.perm == GITLINK {
+			return nil, fmt.Errorf("no such path %s in %s", name, rev)
+		}
+		walk.start = walk.origin(commit, name)
+		walk.start.blob, walk.start.perm = hex.EncodeToString(file.sha[:]), file.perm
+	}
+	walk.final = splitLines(walk.content(walk.start))
+	walk.lines = make([]blameLine, len(walk.final))
+	return walk, nil
+}
+
+// worktreeCommit makes up the commit holding the working tree version of
+// name.
+func (walk *blameWalk) worktreeCommit(name string) (*blameCommit, error) {
+	head := headCommit(walk.repo)
+	if head == "" {
+		return nil, fmt.Errorf("no such ref: HEAD")
+	}
+	parents := []string{head}
+	if merging, err := os.ReadFile(filepath.Join(walk.repo, ".git", "MERGE_HEAD")); err == nil {
+		parents = append(parents, strings.Fields(string(merging))...)
+	}
+	found := slices.ContainsFunc(parents, func(parent string) bool {
+		_, ok := treeFileAt(walk.repo, walk.commit(parent).commit.tree, name)
+		return ok
+	})
+	index := readIndex(walk.repo)
+	if !found && !slices.ContainsFunc(index, func(entry indexEntry) bool { return entry.name == name }) {
+		return nil, fmt.Errorf("no such path '%s' in HEAD", name)
+	}
+	info, err := os.Lstat(worktreePath(walk.repo, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot lstat '%s': No such file or directory", name)
+	}
+	exitIfError(err, fmt.Sprintf("fatal: Cannot lstat '%s': %s", name, err))
+	perm, sha := hashWorktreeFile(walk.repo, name, info)
+	now := formatGitDate(time.Now())
+	ident := "Not Committed Yet <not.committed.yet> " + now
+	commit := &blameCommit{hexHash: zeroHex, commit: commitObject{
+		tree:      zeroHex,
+		parents:   parents,
+		author:    ident,
+		committer: ident,
+		message:   fmt.Sprintf("Version of %s from %s\n", name, name),
+	}}
+	walk.commits[zeroHex] = commit
+	origin := walk.origin(commit, name)
+	origin.blob, origin.perm = hex.EncodeToString(sha[:]), perm
+	origin.content = walk.worktreeContent(name, perm)
+	origin.loaded = true
+	return commit, nil
+}
+
+func (walk *blameWalk) worktreeContent(name string, perm ObjectPerm) []byte {
+	if perm == SYMLINK {
+		target, err := os.Readlink(worktreePath(walk.repo, name))
+		exitIfError(err, fmt.Sprintf("fatal: cannot readlink '%s': %s", name, err))
+		return []byte(target)
+	}
+	content, err := os.ReadFile(worktreePath(walk.repo, name))
+	exitIfError(err, fmt.Sprintf("fatal: cannot open or read '%s': %s", name, err))
+	return content
+}
+
+func (walk *blameWalk) commit(hexHash string) *blameCommit {
+	if commit, ok := walk.commits[hexHash]; ok {
+		return commit
+	}
+	commit := &blameCommit{hexHash: hexHash, commit: readCommit(walk.repo, hexHash)}
+	walk.commits[hexHash] = commit
+	return commit
+}
+
+// origin returns the origin of name in commit, creating it if needed.
+func (walk *blameWalk) origin(commit *blameCommit, name string) *blameOrigin {
+	for _, origin := range commit.origins {
+		if origin.path == name {
+			return origin
+		}
+	}
+	origin := &blameOrigin{commit: commit, path: name}
+	commit.origins = append(commit.origins, origin)
+	return origin
+}
+
+func (walk *blameWalk) content(origin *blameOrigin) []byte {
+	if !origin.loaded {
+		origin.content = readBlob(walk.repo, origin.blob)
+		origin.loaded = true
+	}
+	return origin.content
+}
+
+// treeFileAt finds the file at the slash separated path name below the
+// tree treeHex.
+func treeFileAt(repo string, treeHex string, name string) (treeFile, bool) {
+	if treeHex == emptyTreeHex {
+		return treeFile{}, false
+	}
+	first, rest, nested := strings.Cut(name, "/")
+	for _, entry := range readTreeEntries(repo, treeHex) {
+		if entry.name != first {
+			continue
+		}
+		if !nested {
+			return treeFile{perm: entry.perm, sha: entry.sha}, !isTreePerm(entry.perm)
+		}
+		if !isTreePerm(entry.perm) {
+			return treeFile{}, false
+		}
+		return treeFileAt(repo, hex.EncodeToString(entry.sha[:]), rest)
+	}
+	return treeFile{}, false
+}
+
+// blame suspects the lines of ranges, pairs of zero based start and end
+// lines, of the final file, and follows them until each is blamed.
+func (walk *blameWalk) blame(ranges [][2]int) {
+	origin := walk.start
+	for _, lineRange := range ranges {
+		for lno := lineRange[0]; lno < lineRange[1]; lno++ {
+			walk.lines[lno] = blameLine{origin: origin, sLno: lno}
+			origin.suspects = append(origin.suspects, lno)
+		}
+	}
+	if len(origin.suspects) == 0 {
+		return
+	}
+	walk.queue.push(origin.commit.hexHash, commitDate(origin.commit.commit))
+	for walk.queue.Len() > 0 {
+		commit := walk.commits[walk.queue.pop()]
+		for {
+			index := slices.IndexFunc(commit.origins, func(origin *blameOrigin) bool {
+				return len(origin.suspects) > 0
+			})
+			if index < 0 {
+				break
+			}
+			suspect := commit.origins[index]
+			walk.passBlame(suspect)
+			if len(commit.commit.parents) == 0 && !walk.options.showRoot {
+				commit.boundary = true
+			}
+			if len(suspect.suspects) > 0 {
+				suspect.guilty = true
+				suspect.suspects = nil
+			}
+		}
+	}
+}
+
+// passBlame passes the lines suspected of origin that its parents have
+// too on to them.
+func (walk *blameWalk) passBlame(origin *blameOrigin) {
+	parents := origin.commit.commit.parents
+	porigins := make([]*blameOrigin, len(parents))
+	// The same path is looked for in every parent before renames are
+	for pass := 0; pass < 2; pass++ {
+		for i, parent := range parents {
+			if porigins[i] != nil {
+				continue
+			}
+			var porigin *blameOrigin
+			if pass == 0 {
+				porigin = walk.findOrigin(walk.commit(parent), origin)
+			} else {
+				porigin = walk.findRename(walk.commit(parent), origin)
+			}
+			if porigin == nil {
+				continue
+			}
+			if porigin.blob == origin.blob {
+				walk.passWholeBlame(origin, porigin)
+				return
+			}
+			same := slices.ContainsFunc(porigins[:i], func(other *blameOrigin) bool {
+				return other != nil && other.blob == porigin.blob
+			})
+			if !same {
+				porigins[i] = porigin
+			}
+		}
+	}
+	for _, porigin := range porigins {
+		if porigin == nil {
+			continue
+		}
+		if origin.previous == nil {
+			origin.previous = porigin
+		}
+		walk.passToParent(origin, porigin, false)
+		if len(origin.suspects) == 0 {
+			return
+		}
+	}
+	if !walk.options.ignored[origin.commit.hexHash] {
+		return
+	}
+	for _, porigin := range porigins {
+		if porigin == nil {
+			continue
+		}
+		walk.passToParent(origin, porigin, true)
+		if len(origin.suspects) == 0 {
+			return
+		}
+	}
+}
+
+// findOrigin returns the origin for the path of origin in parent, unless
+// it is missing there or is not the same kind of file.
+func (walk *blameWalk) findOrigin(parent *blameCommit, origin *blameOrigin) *blameOrigin {
+	for _, porigin := range parent.origins {
+		if porigin.path == origin.path {
+			return porigin
+		}
+	}
+	file, ok := treeFileAt(walk.repo, parent.commit.tree, origin.path)
+	if !ok || fileKind(file.perm) != fileKind(origin.perm) {
+		return nil
+	}
+	porigin := walk.origin(parent, origin.path)
+	porigin.blob, porigin.perm = hex.EncodeToString(file.sha[:]), file.perm
+	return porigin
+}
+
+// findRename returns the origin in parent of the file the path of origin
+// was renamed from.
+func (walk *blameWalk) findRename(parent *blameCommit, origin *blameOrigin) *blameOrigin {
+	var pairs []filePair
+	if origin.commit.hexHash == zeroHex {
+		sides, _ := indexSides(readIndex(walk.repo))
+		sha, _ := hex.DecodeString(origin.blob)
+		sides[origin.path] = diffSide{perm: origin.perm, sha: [20]byte(sha), worktree: true}
+		pairs = pairFiles(treeSides(commitFiles(walk.repo, parent.hexHash)), sides, nil, nil, false)
+	} else {
+		pairs = diffTrees(walk.repo, parent.commit.tree, origin.commit.commit.tree, treeDiffOptions{recursive: true}, nil)
+	}
+	// Only the path followed is a rename destination, and deleted files
+	// are the sources
+	candidates := []filePair{}
+	for _, pair := range pairs {
+		if pair.status == 'D' || pair.status == 'A' && pair.name == origin.path {
+			candidates = append(candidates, pair)
+		}
+	}
+	for _, pair := range detectRenames(walk.repo, candidates, renameOptions{detect: findRenames, minimumScore: defaultSimilarityScore}) {
+		if pair.status == 'R' && pair.name == origin.path {
+			porigin := walk.origin(parent, pair.oldName)
+			porigin.blob, porigin.perm = hex.EncodeToString(pair.old.sha[:]), pair.old.perm
+			return porigin
+		}
+	}
+	return nil
+}
+
+// passWholeBlame passes every line of origin to porigin, which has the same
+// content.
+func (walk *blameWalk) passWholeBlame(origin *blameOrigin, porigin *blameOrigin) {
+	if !porigin.loaded && origin.loaded {
+		porigin.content, porigin.loaded = origin.content, true
+	}
+	suspects := origin.suspects
+	origin.suspects = nil
+	walk.queueBlames(porigin, suspects)
+}
+
+// queueBlames makes porigin suspected of lines, and queues its commit when
+// none of its origins were suspected of any.
+func (walk *blameWalk) queueBlames(porigin *blameOrigin, lines []int) {
+	if len(lines) == 0 {
+		return
+	}
+	for _, lno := range lines {
+		walk.lines[lno].origin = porigin
+	}
+	commit := porigin.commit
+	if !slices.ContainsFunc(commit.origins, func(origin *blameOrigin) bool { return len(origin.suspects) > 0 }) {
+		walk.queue.push(commit.hexHash, commitDate(commit.commit))
+	}
+	porigin.suspects = append(porigin.suspects, lines...)
+}
+
+// passToParent passes the lines suspected of target that are unchanged
+// from parent on to parent. When ignoring the changes of target, changed
+// lines are passed on to the line of parent they are most similar to.
+func (walk *blameWalk) passToParent(target *blameOrigin, parent *blameOrigin, ignore bool) {
+	if len(target.suspects) == 0 {
+		return
+	}
+	parentContent, targetContent := walk.content(parent), walk.content(target)
+	changes := blameDiff(parentContent, targetContent, walk.options.lineDiff)
+	// Guesses are made for every hunk in order, as matched lines of the
+	// parent can not be matched again
+	guesses := make([][]int, len(changes))
+	if ignore {
+		matcher := &lineMatcher{parent: lineFingerprints(parentContent), target: lineFingerprints(targetContent)}
+		for i, change := range changes {
+			guesses[i] = matcher.guess(change.i1, change.chg1, change.i2, change.chg2)
+		}
+	}
+	passed, kept := []int{}, []int{}
+	for _, lno := range target.suspects {
+		line := &walk.lines[lno]
+		i, _ := slices.BinarySearchFunc(changes, line.sLno, func(change lineChange, sLno int) int {
+			if change.i2+change.chg2 <= sLno {
+				return -1
+			}
+			return 1
+		})
+		switch {
+		case i < len(changes) && changes[i].i2 <= line.sLno:
+			if !ignore {
+				kept = append(kept, lno)
+				break
+			}
+			if guess := guesses[i][line.sLno-changes[i].i2]; guess >= 0 {
+				line.sLno, line.ignored = guess, true
+				passed = append(passed, lno)
+			} else {
+				line.unblamable = true
+				kept = append(kept, lno)
+			}
+		case i < len(changes):
+			line.sLno += changes[i].i1 - changes[i].i2
+			passed = append(passed, lno)
+		default:
+			if len(changes) > 0 {
+				last := changes[len(changes)-1]
+				line.sLno += last.i1 + last.chg1 - last.i2 - last.chg2
+			}
+			passed = append(passed, lno)
+		}
+	}
+	target.suspects = kept
+	walk.queueBlames(parent, passed)
+}
+
+// blameDiff compares the lines of two versions of a file. Like git, a
+// common tail is left out in blocks of 1024 bytes, keeping its first line.
+func blameDiff(a []byte, b []byte, options lineDiffOptions) []lineChange {
+	const block = 1024
+	trimmed := 0
+	for trimmed+block <= min(len(a), len(b)) && string(a[len(a)-trimmed-block:len(a)-trimmed]) == string(b[len(b)-trimmed-block:len(b)-trimmed]) {
+		trimmed += block
+	}
+	recovered := 0
+	for recovered < trimmed {
+		recovered++
+		if a[len(a)-trimmed+recovered-1] == '\n' {
+			break
+		}
+	}
+	a, b = a[:len(a)-trimmed+recovered], b[:len(b)-trimmed+recovered]
+	return diffLines(splitLines(a), splitLines(b), options)
+}
+
+// fingerprint is the multiset of byte pairs of a line, lower cased, with
+// whitespace turned into zero bytes and pairs of whitespace left out. The
+// similarity of two lines is the size of the intersection of theirs.
+type fingerprint map[uint16]int
+
+func lineFingerprints(content []byte) []fingerprint {
+	lines := splitLines(content)
+	prints := make([]fingerprint, len(lines))
+	for i, line := range lines {
+		prints[i] = fingerprint{}
+		var previous uint16
+		for j := 0; j <= len(line); j++ {
+			var c uint16
+			if j < len(line) && !isXdiffSpace(line[j]) {
+				c = uint16(line[j])
+				if c >= 'A' && c <= 'Z' {
+					c += 'a' - 'A'
+				}
+			}
+			if pair := previous | c<<8; pair != 0 {
+				prints[i][pair]++
+			}
+			previous = c
+		}
+	}
+	return prints
+}
+
+func (print fingerprint) similarity(other fingerprint) int {
+	common := 0
+	for pair, count := range other {
+		common += min(count, print[pair])
+	}
+	return common
+}
+
+func (print fingerprint) subtract(other fingerprint) {
+	for pair, count := range other {
+		if print[pair] <= count {
+			delete(print, pair)
+		} else {
+			print[pair] -= count
+		}
+	}
+}
+
+const (
+	certainNothingMatches  = -2
+	certaintyNotCalculated = -1
+)
+
+// lineMatcher finds the lines of the parent's side of a hunk that the
+// lines of the target's side are most similar to, keeping them in order.
+// Each line of the target is compared to the lines of the parent around
+// the one at the same relative position.
+type lineMatcher struct {
+	parent, target []fingerprint
+	// the hunk
+	startA, lengthA, startB, lengthB int
+	// maxA is how far from its closest line lines of the parent are
+	// compared, and maxB how far apart lines of the target can be that
+	// are compared with the same line
+	maxA, maxB int
+	// similarities holds a row of 2*maxA+1 similarities, scaled down the
+	// further from the closest line, for each line of the target
+	similarities, certainties []int
+	result, secondBest        []int
+}
+
+// match returns the line of the parent, or -1, each line of the target
+// side of the hunk is matched with.
+func (matcher *lineMatcher) match(startA int, lengthA int, startB int, lengthB int) []int {
+	if lengthA <= 0 {
+		return nil
+	}
+	matcher.startA, matcher.lengthA, matcher.startB, matcher.lengthB = startA, lengthA, startB, lengthB
+	matcher.maxA = min(10, lengthA-1)
+	matcher.maxB = ((2*matcher.maxA+1)*lengthB - 1) / lengthA
+	matcher.similarities = make([]int, lengthB*(2*matcher.maxA+1))
+	for i := range matcher.similarities {
+		matcher.similarities[i] = -1
+	}
+	matcher.certainties, matcher.result, matcher.secondBest = make([]int, lengthB), make([]int, lengthB), make([]int, lengthB)
+	for i := range lengthB {
+		matcher.certainties[i], matcher.result[i], matcher.secondBest[i] = certaintyNotCalculated, -1, -1
+	}
+	matcher.recurse(startA, lengthA, 0, lengthB)
+	return matcher.result
+}
+
+// closestLine maps a line of the target to the parent's line at the same
+// relative position in the hunk.
+func (matcher *lineMatcher) closestLine(lineB int) int {
+	return ((lineB-matcher.startB)*2+1)*matcher.lengthA/(matcher.lengthB*2) + matcher.startA
+}
+
+func (matcher *lineMatcher) similarity(lineA int, localB int, closestA int) *int {
+	return &matcher.similarities[lineA-closestA+matcher.maxA+localB*(2*matcher.maxA+1)]
+}
+
+// findBest finds the best and second best match among lengthA lines of
+// the parent from startA for the line localB of the hunk. How much better
+// the best is makes the certainty of the match.
+func (matcher *lineMatcher) findBest(startA int, lengthA int, localB int) {
+	if matcher.certainties[localB] != certaintyNotCalculated {
+		return
+	}
+	closest := matcher.closestLine(matcher.startB+localB) - startA
+	best, secondBest, bestIndex, secondBestIndex := 0, 0, 0, 0
+	for i := max(closest-matcher.maxA, 0); i < min(closest+matcher.maxA+1, lengthA); i++ {
+		similarity := matcher.similarity(i, localB, closest)
+		if *similarity == -1 {
+			*similarity = matcher.target[matcher.startB+localB].similarity(matcher.parent[startA+i]) * (1000 - abs(i-closest))
+		}
+		if *similarity > best {
+			secondBest, secondBestIndex = best, bestIndex
+			best, bestIndex = *similarity, i
+		} else if *similarity > secondBest {
+			secondBest, secondBestIndex = *similarity, i
+		}
+	}
+	if best == 0 {
+		matcher.certainties[localB], matcher.result[localB] = certainNothingMatches, -1
+		return
+	}
+	matcher.certainties[localB] = best*2 - secondBest
+	matcher.result[localB] = startA + bestIndex
+	matcher.secondBest[localB] = startA + secondBestIndex
+}
+
+// recurse matches the lengthB lines of the hunk from offsetB with lengthA
+// lines of the parent from startA. The most certain match splits both
+// into the lines before and after it, which are matched in turn.
+func (matcher *lineMatcher) recurse(startA int, lengthA int, offsetB int, lengthB int) {
+	mostCertain, mostCertainty := -1, -1
+	for i := range lengthB {
+		matcher.findBest(startA, lengthA, offsetB+i)
+		if matcher.certainties[offsetB+i] > mostCertainty {
+			mostCertain, mostCertainty = i, matcher.certainties[offsetB+i]
+		}
+	}
+	if mostCertain == -1 {
+		return
+	}
+	lineA := matcher.result[offsetB+mostCertain]
+	// Other lines can not match the same parts of the line again
+	matcher.parent[lineA].subtract(matcher.target[matcher.startB+offsetB+mostCertain])
+	invalidateMin, invalidateMax := max(mostCertain-matcher.maxB, 0), min(mostCertain+matcher.maxB+1, lengthB)
+	for i := invalidateMin; i < invalidateMax; i++ {
+		closest := matcher.closestLine(matcher.startB+offsetB+i) - startA
+		if abs(lineA-startA-closest) <= matcher.maxA {
+			*matcher.similarity(lineA-startA, offsetB+i, closest) = -1
+		}
+	}
+	// Matches out of order with the most certain one are redone
+	for i := mostCertain - 1; i >= invalidateMin; i-- {
+		if matcher.certainties[offsetB+i] >= 0 && (matcher.result[offsetB+i] >= lineA || matcher.secondBest[offsetB+i] >= lineA) {
+			matcher.certainties[offsetB+i] = certaintyNotCalculated
+		}
+	}
+	for i := mostCertain + 1; i < invalidateMax; i++ {
+		if matcher.certainties[offsetB+i] >= 0 && (matcher.result[offsetB+i] <= lineA || matcher.secondBest[offsetB+i] <= lineA) {
+			matcher.certainties[offsetB+i] = certaintyNotCalculated
+		}
+	}
+	if mostCertain > 0 {
+		matcher.recurse(startA, lineA+1-startA, offsetB, mostCertain)
+	}
+	if mostCertain+1 < lengthB {
+		matcher.recurse(lineA, lengthA+startA-lineA, offsetB+mostCertain+1, lengthB-mostCertain-1)
+	}
+}
+
+// guess returns the line of the parent each line of the target side of a
+// hunk is most similar to, or -1. Lines without a match in the hunk are
+// looked for in the whole parent.
+func (matcher *lineMatcher) guess(startA int, lengthA int, startB int, lengthB int) []int {
+	guesses := matcher.match(startA, lengthA, startB, lengthB)
+	if guesses == nil {
+		guesses = make([]int, lengthB)
+		for i := range guesses {
+			guesses[i] = -1
+		}
+	}
+	for i, guess := range guesses {
+		if guess < 0 {
+			guesses[i] = matcher.scanParent(startB + i)
+		}
+	}
+	return guesses
+}
+
+// scanParent returns the line of the parent most similar to lineB, if it
+// is similar enough, and the closest to lineB of equally similar ones.
+func (matcher *lineMatcher) scanParent(lineB int) int {
+	best, bestIndex := 10, -1
+	for i, print := range matcher.parent {
+		similarity := matcher.target[lineB].similarity(print)
+		if similarity < best || similarity == best && bestIndex != -1 && abs(bestIndex-lineB) < abs(i-lineB) {
+			continue
+		}
+		best, bestIndex = similarity, i
+	}
+	return bestIndex
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// parseLineRanges turns the -L options into sorted and merged ranges of
+// zero based lines, with the end excluded. Each range is looked for from
+// the line after the one before it.
+func parseLineRanges(specs []string, lines []string, name string) ([][2]int, error) {
+	count := len(lines)
+	if count > 0 && len(specs) == 0 {
+		specs = []string{"1"}
+	}
+	ranges := [][2]int{}
+	anchor := 1
+	for _, spec := range specs {
+		bottom, top, err := parseLineRange(spec, lines, anchor)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 && (top != 0 || bottom != 0) || count < bottom {
+			if count == 1 {
+				return nil, fmt.Errorf("file %s has only 1 line", name)
+			}
+			return nil, fmt.Errorf("file %s has only %d lines", name, count)
+		}
+		bottom = max(bottom, 1)
+		if top < 1 || count < top {
+			top = count
+		}
+		ranges = append(ranges, [2]int{bottom - 1, top})
+		anchor = top + 1
+	}
+	slices.SortStableFunc(ranges, func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	})
+	merged := [][2]int{}
+	for _, r := range ranges {
+		switch {
+		case r[0] == r[1]:
+		case len(merged) > 0 && r[0] <= merged[len(merged)-1][1]:
+			merged[len(merged)-1][1] = max(merged[len(merged)-1][1], r[1])
+		default:
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
+
+// errLineRangeUsage is returned for -L arguments that can not be parsed.
+var errLineRangeUsage = fmt.Errorf("usage")
+
+// parseLineRange parses "<start>,<end>" or ":<funcname>" into one based
+// lines, zero when not given.
+func parseLineRange(spec string, lines []string, anchor int) (int, int, error) {
+	anchor = min(max(anchor, 1), len(lines)+1)
+	if strings.HasPrefix(spec, ":") || strings.HasPrefix(spec, "^:") {
+		return parseFuncnameRange(spec, lines, anchor)
+	}
+	begin, rest, err := parseLineLocation(spec, lines, -anchor)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := 0
+	if strings.HasPrefix(rest, ",") {
+		end, rest, err = parseLineLocation(rest[1:], lines, begin+1)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if rest != "" {
+		return 0, 0, errLineRangeUsage
+	}
+	if begin != 0 && end != 0 && end < begin {
+		begin, end = end, begin
+	}
+	return begin, end, nil
+}
+
+// parseLineLocation parses a line number, an offset from begin like "+3"
+// or "-3", or a /regex/ searched from line begin, which is negated when
+// the location starts a range.
+func parseLineLocation(spec string, lines []string, begin int) (int, string, error) {
+	if begin >= 1 && (strings.HasPrefix(spec, "+") || strings.HasPrefix(spec, "-")) {
+		digits := leadingNumber(spec[1:])
+		if digits == "" {
+			return 0, spec, nil
+		}
+		num, _ := strconv.Atoi(digits)
+		if num == 0 {
+			return 0, "", fmt.Errorf("-L invalid empty range")
+		}
+		rest := spec[1+len(digits):]
+		if spec[0] == '+' {
+			return begin + num - 2, rest, nil
+		}
+		return max(begin-num, 1), rest, nil
+	}
+	if digits := leadingNumber(spec); digits != "" {
+		num, _ := strconv.Atoi(digits)
+		if num <= 0 {
+			return 0, "", fmt.Errorf("-L invalid line number: %d", num)
+		}
+		return num, spec[len(digits):], nil
+	}
+	if begin < 0 {
+		if strings.HasPrefix(spec, "^") {
+			begin, spec = 1, spec[1:]
+		} else {
+			begin = -begin
+		}
+	}
+	if !strings.HasPrefix(spec, "/") {
+		return 0, spec, nil
+	}
+	end := 1
+	for end < len(spec) && spec[end] != '/' {
+		if spec[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(spec) {
+		return 0, spec, nil
+	}
+	pattern := spec[1:end]
+	begin = min(begin-1, len(lines))
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return 0, "", fmt.Errorf("-L parameter '%s' starting at line %d: %s", pattern, begin+1, err)
+	}
+	text := strings.Join(lines[begin:], "")
+	match := re.FindStringIndex(text)
+	if match == nil {
+		return 0, "", fmt.Errorf("-L parameter '%s' starting at line %d: No match", pattern, begin+1)
+	}
+	for offset := 0; ; {
+		more := begin < len(lines)
+		begin++
+		if !more {
+			break
+		}
+		offset += len(lines[begin-1])
+		if match[0] < offset {
+			break
+		}
+	}
+	return begin, spec[end+1:], nil
+}
+
+// leadingNumber returns the digits spec starts with, after an optional
+// sign.
+func leadingNumber(spec string) string {
+	end := 0
+	if strings.HasPrefix(spec, "-") || strings.HasPrefix(spec, "+") {
+		end = 1
+	}
+	start := end
+	for end < len(spec) && spec[end] >= '0' && spec[end] <= '9' {
+		end++
+	}
+	if end == start {
+		return ""
+	}
+	return spec[:end]
+}
+
+// parseFuncnameRange finds the first function line from anchor matching
+// the regex after the colon, and the lines up to the next function line.
+func parseFuncnameRange(spec string, lines []string, anchor int) (int, int, error) {
+	if strings.HasPrefix(spec, "^") {
+		anchor, spec = 1, spec[1:]
+	}
+	end := 1
+	for end < len(spec) && spec[end] != ':' {
+		if spec[end] == '\\' && end+1 < len(spec) {
+			end++
+		}
+		end++
+	}
+	if end == 1 || end != len(spec) {
+		return 0, 0, errLineRangeUsage
+	}
+	pattern := spec[1:end]
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-L parameter '%s': %s", pattern, err)
+	}
+	isFunction := func(line string) bool {
+		_, ok := functionLine(line)
+		return ok
+	}
+	begin := -1
+	for i := anchor - 1; i < len(lines) && begin < 0; i++ {
+		if isFunction(lines[i]) && re.MatchString(lines[i]) {
+			begin = i
+		}
+	}
+	if begin < 0 {
+		return 0, 0, fmt.Errorf("-L parameter '%s' starting at line %d: no match", pattern, anchor)
+	}
+	last := begin + 1
+	for last < len(lines) && !isFunction(lines[last]) {
+		last++
+	}
+	return begin + 1, last, nil
+}
+
+// readIgnoreRevs reads a file of full object names of commits, one per
+// line, with comments after '#'. Names of objects that are no commits are
+// skipped.
+func readIgnoreRevs(repo string, name string, ignored map[string]bool) error {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("could not open object name list: %s", name)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !isHexHash(line) || len(line) != 40 {
+			return fmt.Errorf("invalid object name: %s", line)
+		}
+		if !objectExists(repo, line) {
+			continue
+		}
+		if commit, err := peelToType(repo, line, Commit); err == nil {
+			ignored[commit] = true
+		}
+	}
+	return nil
+}
+
+// blameEntry is a run of consecutive lines coming from consecutive lines
+// of the same origin.
+type blameEntry struct {
+	origin              *blameOrigin
+	lno, sLno, count    int
+	ignored, unblamable bool
+}
+
+// entries coalesces the blamed lines into entries.
+func (walk *blameWalk) entries() []blameEntry {
+	entries := []blameEntry{}
+	for lno, line := range walk.lines {
+		if line.origin == nil {
+			continue
+		}
+		if n := len(entries); n > 0 {
+			last := &entries[n-1]
+			if last.origin == line.origin && last.lno+last.count == lno && last.sLno+last.count == line.sLno && last.ignored == line.ignored && last.unblamable == line.unblamable {
+				last.count++
+				continue
+			}
+		}
+		entries = append(entries, blameEntry{origin: line.origin, lno: lno, sLno: line.sLno, count: 1, ignored: line.ignored, unblamable: line.unblamable})
+	}
+	return entries
+}
+
+// blameOutput holds the options of how blamed lines are printed.
+type blameOutput struct {
+	porcelain, linePorcelain bool
+	showName, showNumber     bool
+	showEmail, longNames     bool
+	noAuthor, rawTimestamp   bool
+	blankBoundary            bool
+	markUnblamable           bool
+	markIgnored              bool
+	date                     string
+}
+
+// blameDateWidths are the widths dates are padded to, the longest date of
+// each format.
+var blameDateWidths = map[string]int{
+	"": 30, "default": 30, "local": 30,
+	"iso": 25, "iso8601": 25, "iso-strict": 25, "iso8601-strict": 25,
+	"rfc": 31, "rfc2822": 31,
+	"raw": 16, "unix": 10, "short": 10,
+	"relative": 22,
+}
+
+// commitInfo returns the author, committer and subject of a commit the
+// way blame shows them.
+func commitInfo(commit *blameCommit) (identity, identity, string) {
+	author, committer := parseIdentity(commit.commit.author), parseIdentity(commit.commit.committer)
+	summary := strings.TrimLeft(commit.commit.message, "\n")
+	summary, _, _ = strings.Cut(summary, "\n")
+	if summary == "" {
+		summary = "(" + commit.hexHash + ")"
+	}
+	return author, committer, summary
+}
+
+// printBlame prints the lines of the final file with what they are blamed
+// on.
+func (walk *blameWalk) printBlame(output blameOutput) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	entries := walk.entries()
+	if output.porcelain {
+		walk.printPorcelain(out, entries, output)
+		return
+	}
+	longestFile, longestAuthor, longestSource, longestLine := 0, 0, 0, 0
+	for _, entry := range entries {
+		if entry.origin.path != walk.path {
+			output.showName = true
+		}
+		longestFile = max(longestFile, len(entry.origin.path))
+		if commit := entry.origin.commit; !commit.shown {
+			commit.shown = true
+			author, _, _ := commitInfo(commit)
+			name := author.name
+			if output.showEmail {
+				name = "<" + author.email + ">"
+			}
+			longestAuthor = max(longestAuthor, utf8.RuneCountInString(name))
+		}
+		longestSource = max(longestSource, entry.sLno+entry.count)
+		longestLine = max(longestLine, entry.lno+entry.count)
+	}
+	sourceDigits, lineDigits := len(strconv.Itoa(longestSource)), len(strconv.Itoa(longestLine))
+	now := time.Now()
+	for _, entry := range entries {
+		commit := entry.origin.commit
+		author, _, _ := commitInfo(commit)
+		for i := range entry.count {
+			length, name := 8, commit.hexHash
+			if output.longNames {
+				length = 40
+			}
+			if commit.boundary {
+				if output.blankBoundary {
+					name = strings.Repeat(" ", 40)
+				} else {
+					length--
+					out.WriteString("^")
+				}
+			}
+			if output.markUnblamable && entry.unblamable {
+				length--
+				out.WriteString("*")
+			}
+			if output.markIgnored && entry.ignored {
+				length--
+				out.WriteString("?")
+			}
+			out.WriteString(name[:length])
+			if output.showName {
+				fmt.Fprintf(out, " %-*s", longestFile, entry.origin.path)
+			}
+			if output.showNumber {
+				fmt.Fprintf(out, " %*d", sourceDigits, entry.sLno+1+i)
+			}
+			if !output.noAuthor {
+				name := author.name
+				if output.showEmail {
+					name = "<" + author.email + ">"
+				}
+				fmt.Fprintf(out, " (%s%*s %10s", name, longestAuthor-utf8.RuneCountInString(name), "", blameDate(author.date, output, now))
+			}
+			fmt.Fprintf(out, " %*d) ", lineDigits, entry.lno+1+i)
+			out.WriteString(walk.final[entry.lno+i])
+			if !strings.HasSuffix(walk.final[entry.lno+i], "\n") {
+				out.WriteString("\n")
+			}
+		}
+	}
+}
+
+// blameDate formats the date of an author, padded to the width of the
+// longest date of its format.
+func blameDate(date string, output blameOutput, now time.Time) string {
+	if output.rawTimestamp {
+		return date
+	}
+	formatted, err := formatDate(date, output.date, now)
+	if err != nil {
+		formatted = date
+	}
+	if padding := blameDateWidths[output.date] - utf8.RuneCountInString(formatted); padding > 0 {
+		formatted += strings.Repeat(" ", padding)
+	}
+	return formatted
+}
+
+// printPorcelain prints each entry with a header, the details of its
+// commit the first time it is blamed, or for every line with
+// --line-porcelain, and its lines prefixed with a tab.
+func (walk *blameWalk) printPorcelain(out *bufio.Writer, entries []blameEntry, output blameOutput) {
+	morePaths := map[*blameCommit]bool{}
+	for _, entry := range entries {
+		commit := entry.origin.commit
+		guilty := 0
+		for _, origin := range commit.origins {
+			if origin.guilty {
+				guilty++
+			}
+		}
+		morePaths[commit] = guilty > 1
+	}
+	details := func(origin *blameOrigin) {
+		commit := origin.commit
+		if output.linePorcelain || !commit.shown {
+			commit.shown = true
+			author, committer, summary := commitInfo(commit)
+			for _, person := range []struct {
+				role string
+				id   identity
+			}{{"author", author}, {"committer", committer}} {
+				seconds, tz, _ := strings.Cut(person.id.date, " ")
+				fmt.Fprintf(out, "%s %s\n%s-mail <%s>\n%s-time %s\n%s-tz %s\n", person.role, person.id.name, person.role, person.id.email, person.role, seconds, person.role, tz)
+			}
+			fmt.Fprintf(out, "summary %s\n", summary)
+			if commit.boundary {
+				out.WriteString("boundary\n")
+			}
+		} else if !morePaths[commit] {
+			return
+		}
+		if previous := origin.previous; previous != nil {
+			fmt.Fprintf(out, "previous %s %s\n", previous.commit.hexHash, quotePath(previous.path))
+		}
+		fmt.Fprintf(out, "filename %s\n", quotePath(origin.path))
+	}
+	for _, entry := range entries {
+		hexHash := entry.origin.commit.hexHash
+		fmt.Fprintf(out, "%s %d %d %d\n", hexHash, entry.sLno+1, entry.lno+1, entry.count)
+		details(entry.origin)
+		for i := range entry.count {
+			if i > 0 {
+				fmt.Fprintf(out, "%s %d %d\n", hexHash, entry.sLno+1+i, entry.lno+1+i)
+				if output.linePorcelain {
+					details(entry.origin)
+				}
+			}
+			out.WriteString("\t" + walk.final[entry.lno+i])
+			if !strings.HasSuffix(walk.final[entry.lno+i], "\n") {
+				out.WriteString("\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseLineRanges(t *testing.T) {
+	lines := splitLines([]byte("int main()\n{\n  a;\n  b;\n}\n\nvoid f()\n{\n  c;\n}\n"))
+	cases := map[string]struct {
+		specs []string
+		want  [][2]int
+	}{
+		"whole file":            {nil, [][2]int{{0, 10}}},
+		"start and end":         {[]string{"2,4"}, [][2]int{{1, 4}}},
+		"swapped":               {[]string{"4,2"}, [][2]int{{1, 4}}},
+		"offset":                {[]string{"3,+2"}, [][2]int{{2, 4}}},
+		"backwards offset":      {[]string{"5,-3"}, [][2]int{{2, 5}}},
+		"open start":            {[]string{",2"}, [][2]int{{0, 2}}},
+		"open end":              {[]string{"9,"}, [][2]int{{8, 10}}},
+		"regex":                 {[]string{"/b;/,/}/"}, [][2]int{{3, 5}}},
+		"regex after previous":  {[]string{"1,2", "/{/"}, [][2]int{{0, 2}, {7, 10}}},
+		"function":              {[]string{":f"}, [][2]int{{6, 10}}},
+		"merged ranges":         {[]string{"6,8", "1,3", "3,5"}, [][2]int{{0, 8}}},
+		"end past the last one": {[]string{"8,20"}, [][2]int{{7, 10}}},
+	}
+	for name, c := range cases {
+		got, err := parseLineRanges(c.specs, lines, "m.c")
+		if err != nil || !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %v, %v, want %v", name, got, err, c.want)
+		}
+	}
+	for _, spec := range []string{"11", "0", "/nothing/", ":nothing"} {
+		if _, err := parseLineRanges([]string{spec}, lines, "m.c"); err == nil {
+			t.Errorf("%s: no error", spec)
+		}
+	}
+}
+
+func TestLineMatcher(t *testing.T) {
+	parent := []byte("int sum(int a, int b)\n{\n\treturn a + b;\n}\n")
+	target := []byte("int sum(int a, int b) {\n  return a + b;\n}\n")
+	matcher := &lineMatcher{parent: lineFingerprints(parent), target: lineFingerprints(target)}
+	// The reformatted lines match the lines they were made of, and the
+	// brace that was moved to the first line is left without a match
+	if got, want := matcher.guess(0, 4, 0, 3), []int{0, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// newBlameRepo creates a repository where a.txt is renamed to b.txt while
+// changing its third line, then gets a whitespace-only change to line four
+// and a change to line five that is meant to be ignored.
+func newBlameRepo(t *testing.T) (repo string, commits []string) {
+	t.Helper()
+	repo = newTestRepo(t)
+	commit := func(name string, message string, date string) {
+		mygit(t, repo, "add", name)
+		mygit(t, repo, "commit", "-a", "-m", message, "--date", date)
+		commits = append(commits, headCommit(repo))
+	}
+	writeTestFile(t, repo, "a.txt", "one\ntwo\nthree\nfour\nfive\n")
+	commit("a.txt", "base", "1500000000 +0000")
+	if err := os.Remove(filepath.Join(repo, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, repo, "b.txt", "one\ntwo\nTHREE\nfour\nfive\n")
+	commit("b.txt", "rename", "1500000100 +0000")
+	writeTestFile(t, repo, "b.txt", "one\ntwo\nTHREE\n  four\nfive\n")
+	commit("b.txt", "indent", "1500000200 +0000")
+	writeTestFile(t, repo, "b.txt", "one\ntwo\nTHREE\n  four\nfive!\n")
+	commit("b.txt", "reformat", "1500000300 +0000")
+	return repo, commits
+}
+
+func TestBlameFollowsRenamesAndIgnoresChanges(t *testing.T) {
+	repo, _ := newBlameRepo(t)
+	line := func(commit string, name string, date string, number int, text string) string {
+		return fmt.Sprintf("%s %s (A U Thor 2017-07-14 %s +0000 %d) %s\n", commit, name, date, number, text)
+	}
+	base := func(number int, text string) string {
+		return line("^2557fe3", "a.txt", "02:40:00", number, text)
+	}
+	rename := line("689d68be", "b.txt", "02:41:40", 3, "THREE")
+	indent := line("465d5b10", "b.txt", "02:43:20", 4, "  four")
+	reformat := line("8801cf11", "b.txt", "02:45:00", 5, "five!")
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, base(1, "one") + base(2, "two") + rename + indent + reformat},
+		{[]string{"-w"}, base(1, "one") + base(2, "two") + rename + base(4, "  four") + reformat},
+		{[]string{"--ignore-rev", "HEAD"}, base(1, "one") + base(2, "two") + rename + indent + base(5, "five!")},
+	}
+	for _, c := range cases {
+		if got := mygit(t, repo, append(append([]string{"blame"}, c.args...), "b.txt")...); got != c.want {
+			t.Errorf("blame %v =\n%s\nwant\n%s", c.args, got, c.want)
+		}
+	}
+
+	writeTestFile(t, repo, ".git-blame-ignore-revs", "# reformatting\n"+headCommit(repo)+"\n")
+	writeTestFile(t, repo, ".git/config", "[blame]\n\tignoreRevsFile = .git-blame-ignore-revs\n")
+	if got, want := mygit(t, repo, "blame", "b.txt"), cases[2].want; got != want {
+		t.Errorf("blame with blame.ignoreRevsFile =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBlamePorcelain(t *testing.T) {
+	repo, commits := newBlameRepo(t)
+	committer := "committer C O Mitter\ncommitter-mail <committer@example.com>\ncommitter-time 1600000000\ncommitter-tz +0000\n"
+	want := commits[0] + " 2 2 1\n" +
+		"author A U Thor\nauthor-mail <author@example.com>\nauthor-time 1500000000\nauthor-tz +0000\n" + committer +
+		"summary base\nboundary\nfilename a.txt\n\ttwo\n" +
+		commits[1] + " 3 3 1\n" +
+		"author A U Thor\nauthor-mail <author@example.com>\nauthor-time 1500000100\nauthor-tz +0000\n" + committer +
+		"summary rename\nprevious " + commits[0] + " a.txt\nfilename b.txt\n\tTHREE\n"
+	if got := mygit(t, repo, "blame", "--porcelain", "-L", "2,3", "b.txt"); got != want {
+		t.Errorf("blame --porcelain =\n%s\nwant\n%s", got, want)
+	}
+}
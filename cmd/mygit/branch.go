@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// branchListOptions selects and formats the branches listed by `branch`.
+type branchListOptions struct {
+	all      bool
+	remotes  bool
+	verbose  int
+	merged   string
+	contains string
+	patterns []string
+}
+
+type listedBranch struct {
+	refName string
+	display string
+	current bool
+	target  string
+	symref  string
+}
+
+// listBranches prints the local branches, or the remote-tracking ones, the
+// way `git branch` does.
+func listBranches(repo string, options branchListOptions) {
+	mergedInto, containing := "", ""
+	if options.merged != "" {
+		mergedInto = resolveCommit(repo, options.merged)
+	}
+	if options.contains != "" {
+		containing = resolveCommit(repo, options.contains)
+	}
+	current, onBranch := headBranch(repo)
+
+	branches := []listedBranch{}
+	if head := headCommit(repo); !onBranch && head != "" && !options.remotes && len(options.patterns) == 0 {
+		branches = append(branches, listedBranch{display: "(HEAD detached at " + head[:7] + ")", current: true, target: head})
+	}
+	prefixes := []string{"refs/heads/"}
+	if options.remotes {
+		prefixes = []string{"refs/remotes/"}
+	} else if options.all {
+		prefixes = append(prefixes, "refs/remotes/")
+	}
+	for _, prefix := range prefixes {
+		for _, refName := range listRefs(repo, prefix) {
+			name := strings.TrimPrefix(refName, prefix)
+			if !matchesBranchPatterns(name, options.patterns) {
+				continue
+			}
+			branch := listedBranch{refName: refName, display: name, current: onBranch && refName == current}
+			if prefix == "refs/remotes/" && options.all {
+				branch.display = "remotes/" + name
+			}
+			if target, ok := readSymbolicRef(repo, refName); ok {
+				branch.symref = strings.TrimPrefix(target, prefix)
+			}
+			if target, ok := resolveRef(repo, refName); ok {
+				branch.target = target
+			}
+			branches = append(branches, branch)
+		}
+	}
+
+	width := 0
+	shown := []listedBranch{}
+	for _, branch := range branches {
+		if mergedInto != "" && (branch.target == "" || !isAncestor(repo, branch.target, mergedInto)) {
+			continue
+		}
+		if containing != "" && (branch.target == "" || !isAncestor(repo, containing, branch.target)) {
+			continue
+		}
+		width = max(width, len(branch.display))
+		shown = append(shown, branch)
+	}
+	for _, branch := range shown {
+		marker := "  "
+		if branch.current {
+			marker = "* "
+		}
+		switch {
+		case branch.symref != "":
+			fmt.Printf("%s%s -> %s\n", marker, branch.display, branch.symref)
+		case options.verbose == 0 || branch.target == "":
+			fmt.Printf("%s%s\n", marker, branch.display)
+		default:
+			tracking := ""
+			if strings.HasPrefix(branch.refName, "refs/heads/") {
+				tracking = trackingSummary(repo, shortRefName(branch.refName), branch.target, options.verbose > 1)
+			}
+			subject := readCommit(repo, branch.target).subject()
+			fmt.Printf("%s%-*s %s %s%s\n", marker, width, branch.display, branch.target[:7], tracking, subject)
+		}
+	}
+}
+
+func matchesBranchPatterns(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// trackingSummary describes how branch relates to its upstream, like
+// "[ahead 1, behind 2] ". The upstream name is included when named is set.
+func trackingSummary(repo string, branch string, target string, named bool) string {
+	upstream, ok := branchUpstream(repo, branch)
+	if !ok {
+		return ""
+	}
+	parts := []string{}
+	upstreamHex, exists := resolveRef(repo, upstream)
+	if !exists {
+		parts = append(parts, "gone")
+	} else {
+		ahead, behind := aheadBehind(repo, target, upstreamHex)
+		if ahead > 0 {
+			parts = append(parts, fmt.Sprintf("ahead %d", ahead))
+		}
+		if behind > 0 {
+			parts = append(parts, fmt.Sprintf("behind %d", behind))
+		}
+	}
+	summary := strings.Join(parts, ", ")
+	if named {
+		if summary != "" {
+			summary = ": " + summary
+		}
+		summary = shortRefName(upstream) + summary
+	}
+	if summary == "" {
+		return ""
+	}
+	return "[" + summary + "] "
+}
+
+// createBranch creates the branch name at the commit start names. A branch
+// started from a remote-tracking branch tracks it.
+func createBranch(repo string, name string, start string, force bool) {
+	refName := "refs/heads/" + name
+	if !isValidRefName(refName) {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' is not a valid branch name\n", name)
+		os.Exit(128)
+	}
+	if refExists(repo, refName) {
+		if !force {
+			fmt.Fprintf(os.Stderr, "fatal: a branch named '%s' already exists\n", name)
+			os.Exit(128)
+		}
+		if current, ok := headBranch(repo); ok && current == refName {
+			fmt.Fprintf(os.Stderr, "fatal: Cannot force update the current branch.\n")
+			os.Exit(128)
+		}
+	}
+	// Messages name the current branch rather than HEAD
+	startName := start
+	if current, ok := headBranch(repo); ok && start == "HEAD" {
+		startName = shortRefName(current)
+	}
+	startHex, err := resolveRevision(repo, start)
+	if err == nil {
+		startHex, err = peelToType(repo, startHex, Commit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a valid object name: '%s'\n", startName)
+		os.Exit(128)
+	}
+	message := "branch: Created from " + startName
+	if refExists(repo, refName) {
+		message = "branch: Reset to " + startName
+	}
+	writeRef(repo, refName, startHex, message)
+	if startRef, ok := expandRefName(repo, start); ok && strings.HasPrefix(startRef, "refs/remotes/") {
+		if setBranchUpstream(repo, name, startRef) == nil {
+			fmt.Printf("branch '%s' set up to track '%s'.\n", name, shortRefName(startRef))
+		}
+	}
+}
+
+// deleteBranches deletes the named branches, or remote-tracking branches
+// when remotes is set, and returns the exit code. Unless force is set a
+// branch has to be merged into its upstream, or HEAD when it has none.
+func deleteBranches(repo string, names []string, remotes bool, force bool) int {
+	code := 0
+	current, onBranch := headBranch(repo)
+	for _, name := range names {
+		refName, kind := "refs/heads/"+name, "branch"
+		if remotes {
+			refName, kind = "refs/remotes/"+name, "remote-tracking branch"
+		}
+		if onBranch && refName == current {
+			absolute, _ := filepath.Abs(repo)
+			fmt.Fprintf(os.Stderr, "error: Cannot delete branch '%s' checked out at '%s'\n", name, absolute)
+			code = 1
+			continue
+		}
+		if !refExists(repo, refName) {
+			fmt.Fprintf(os.Stderr, "error: %s '%s' not found.\n", kind, name)
+			code = 1
+			continue
+		}
+		target, _ := resolveRef(repo, refName)
+		if !force && !remotes && target != "" {
+			mergedInto := headCommit(repo)
+			if upstream, ok := branchUpstream(repo, name); ok {
+				if upstreamHex, exists := resolveRef(repo, upstream); exists {
+					mergedInto = upstreamHex
+				}
+			}
+			if mergedInto == "" || !isAncestor(repo, target, mergedInto) {
+				fmt.Fprintf(os.Stderr, "error: The branch '%s' is not fully merged.\n", name)
+				fmt.Fprintf(os.Stderr, "If you are sure you want to delete it, run 'mygit branch -D %s'.\n", name)
+				code = 1
+				continue
+			}
+		}
+		if err := deleteRef(repo, refName); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to delete %s '%s': %s\n", kind, name, err)
+			code = 1
+			continue
+		}
+		if !remotes {
+			config := readConfigLines(repo)
+			config.removeSection(`branch "` + name + `"`)
+			config.save()
+		}
+		if target == "" {
+			fmt.Printf("Deleted %s %s.\n", kind, name)
+		} else {
+			fmt.Printf("Deleted %s %s (was %s).\n", kind, name, target[:7])
+		}
+	}
+	return code
+}
+
+// renameBranch renames oldName to newName, moving its configuration along
+// and updating HEAD when it is the current branch.
+func renameBranch(repo string, oldName string, newName string, force bool) {
+	oldRef, newRef := "refs/heads/"+oldName, "refs/heads/"+newName
+	current, onBranch := headBranch(repo)
+	isCurrent := onBranch && current == oldRef
+	if !refExists(repo, oldRef) && !isCurrent {
+		fmt.Fprintf(os.Stderr, "fatal: No branch named '%s'.\n", oldName)
+		os.Exit(128)
+	}
+	if !isValidRefName(newRef) {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' is not a valid branch name\n", newName)
+		os.Exit(128)
+	}
+	if oldRef == newRef {
+		return
+	}
+	if refExists(repo, newRef) {
+		if !force {
+			fmt.Fprintf(os.Stderr, "fatal: a branch named '%s' already exists\n", newName)
+			os.Exit(128)
+		}
+		if onBranch && current == newRef {
+			fmt.Fprintf(os.Stderr, "fatal: Cannot force update the current branch.\n")
+			os.Exit(128)
+		}
+	}
+	message := "Branch: renamed " + oldRef + " to " + newRef
+	if refExists(repo, oldRef) {
+		if refExists(repo, newRef) {
+			err := deleteRef(repo, newRef)
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+		}
+		err := renameRef(repo, oldRef, newRef, message)
+		exitIfError(err, fmt.Sprintf("fatal: Branch rename failed: %s", err))
+	}
+	if isCurrent {
+		writeSymbolicRef(repo, "HEAD", newRef, message)
+	}
+
+	config := readConfigLines(repo)
+	config.renameSection(`branch "`+oldName+`"`, `branch "`+newName+`"`)
+	config.save()
+}
+
+// branchUpstream returns the remote-tracking branch, or local branch, that
+// branch is configured to track.
+func branchUpstream(repo string, branch string) (string, bool) {
+	section, err := loadRepoConfig(repo).GetSection(`branch "` + branch + `"`)
+	if err != nil {
+		return "", false
+	}
+	remote, merge := section.Key("remote").String(), section.Key("merge").String()
+	if remote == "" || merge == "" {
+		return "", false
+	}
+	if remote == "." {
+		return merge, true
+	}
+	return "refs/remotes/" + remote + "/" + strings.TrimPrefix(merge, "refs/heads/"), true
+}
+
+// setBranchUpstream makes branch track upstream, which is either a local
+// branch or a remote-tracking branch of a configured remote.
+func setBranchUpstream(repo string, branch string, upstream string) error {
+	remote, merge := "", ""
+	if strings.HasPrefix(upstream, "refs/heads/") {
+		remote, merge = ".", upstream
+	} else if name, ok := strings.CutPrefix(upstream, "refs/remotes/"); ok {
+		remoteName, remoteBranch, _ := strings.Cut(name, "/")
+		if _, err := loadRepoConfig(repo).GetSection(`remote "` + remoteName + `"`); err == nil && remoteBranch != "HEAD" {
+			remote, merge = remoteName, "refs/heads/"+remoteBranch
+		}
+	}
+	if remote == "" {
+		return fmt.Errorf("cannot set up tracking information; starting point '%s' is not a branch", shortRefName(upstream))
+	}
+	config := readConfigLines(repo)
+	config.setValue(`branch "`+branch+`"`, "remote", remote)
+	config.setValue(`branch "`+branch+`"`, "merge", merge)
+	config.save()
+	return nil
+}
+
+// unsetBranchUpstream removes the tracking configuration of branch.
+func unsetBranchUpstream(repo string, branch string) {
+	if _, ok := branchUpstream(repo, branch); !ok {
+		fmt.Fprintf(os.Stderr, "fatal: Branch '%s' has no upstream information\n", branch)
+		os.Exit(128)
+	}
+	config := readConfigLines(repo)
+	config.unsetValue(`branch "`+branch+`"`, "remote")
+	config.unsetValue(`branch "`+branch+`"`, "merge")
+	config.save()
+}
+
+// currentBranchName returns the short name of the current branch, exiting
+// with message when HEAD is detached.
+func currentBranchName(repo string, message string) string {
+	branch, ok := headBranch(repo)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", message)
+		os.Exit(128)
+	}
+	return strings.TrimPrefix(branch, "refs/heads/")
+}
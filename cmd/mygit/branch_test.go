@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newBranchRepo creates a repository with a commit on main and a topic
+// branch one commit ahead of it, with main checked out.
+func newBranchRepo(t *testing.T) string {
+	t.Helper()
+	repo := newTestRepo(t)
+	mygit(t, repo, "commit", "--allow-empty", "-m", "base")
+	mygit(t, repo, "switch", "-c", "topic")
+	mygit(t, repo, "commit", "--allow-empty", "-m", "topic")
+	mygit(t, repo, "switch", "main")
+	return repo
+}
+
+func configEntry(repo string, section string, key string) string {
+	return loadRepoConfig(repo).Section(section).Key(key).String()
+}
+
+func TestBranchDeleteRefusesUnmergedBranch(t *testing.T) {
+	repo := newBranchRepo(t)
+	result := runMygit(t, repo, "", "branch", "-d", "topic")
+	want := "error: The branch 'topic' is not fully merged.\nIf you are sure you want to delete it, run 'mygit branch -D topic'.\n"
+	if result.code != 1 || result.stderr != want {
+		t.Fatalf("branch -d = %d %q, want %q", result.code, result.stderr, want)
+	}
+	if _, ok := resolveRef(repo, "refs/heads/topic"); !ok {
+		t.Fatal("unmerged branch was deleted")
+	}
+	mygit(t, repo, "branch", "-D", "topic")
+	if _, ok := resolveRef(repo, "refs/heads/topic"); ok {
+		t.Error("branch -D kept the branch")
+	}
+}
+
+func TestBranchRenameUpdatesHeadAndConfig(t *testing.T) {
+	repo := newBranchRepo(t)
+	mygit(t, repo, "switch", "topic")
+	mygit(t, repo, "branch", "-u", "main")
+	topic, _ := resolveRef(repo, "refs/heads/topic")
+	mygit(t, repo, "branch", "-m", "topic", "renamed")
+	if head, err := os.ReadFile(filepath.Join(repo, ".git", "HEAD")); err != nil || string(head) != "ref: refs/heads/renamed\n" {
+		t.Errorf("HEAD = %q, %v, want refs/heads/renamed", head, err)
+	}
+	if renamed, ok := resolveRef(repo, "refs/heads/renamed"); !ok || renamed != topic {
+		t.Errorf("renamed points at %s, want %s", renamed, topic)
+	}
+	if _, ok := resolveRef(repo, "refs/heads/topic"); ok {
+		t.Error("old branch name still exists")
+	}
+	if merge := configEntry(repo, `branch "renamed"`, "merge"); merge != "refs/heads/main" {
+		t.Errorf("branch.renamed.merge = %q, want refs/heads/main", merge)
+	}
+	if _, err := loadRepoConfig(repo).GetSection(`branch "topic"`); err == nil {
+		t.Error("config of the old branch name was kept")
+	}
+}
+
+func TestBranchSetUpstream(t *testing.T) {
+	repo := newBranchRepo(t)
+	config := "[remote \"origin\"]\n\turl = https://example.com/r.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"
+	if err := os.WriteFile(filepath.Join(repo, ".git", "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainHex, _ := resolveRef(repo, "refs/heads/main")
+	writeRef(repo, "refs/remotes/origin/main", mainHex, "")
+	cases := []struct {
+		args   []string
+		output string
+		remote string
+		merge  string
+	}{
+		{[]string{"-u", "origin/main", "topic"}, "branch 'topic' set up to track 'origin/main'.\n", "origin", "refs/heads/main"},
+		{[]string{"--set-upstream-to=main", "topic"}, "branch 'topic' set up to track 'main'.\n", ".", "refs/heads/main"},
+	}
+	for _, c := range cases {
+		if output := mygit(t, repo, append([]string{"branch"}, c.args...)...); output != c.output {
+			t.Errorf("branch %v printed %q, want %q", c.args, output, c.output)
+		}
+		remote, merge := configEntry(repo, `branch "topic"`, "remote"), configEntry(repo, `branch "topic"`, "merge")
+		if remote != c.remote || merge != c.merge {
+			t.Errorf("branch %v set remote %q merge %q, want %q %q", c.args, remote, merge, c.remote, c.merge)
+		}
+	}
+}
+
+func TestBranchConfigEditsKeepOtherLines(t *testing.T) {
+	repo := newBranchRepo(t)
+	configPath := filepath.Join(repo, ".git", "config")
+	remote := "[remote \"origin\"]\n\turl = https://example.com/r.git\n" +
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*\n\tfetch = +refs/tags/*:refs/tags/*\n# keep me\n"
+	if err := os.WriteFile(configPath, []byte(remote), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainHex, _ := resolveRef(repo, "refs/heads/main")
+	writeRef(repo, "refs/remotes/origin/main", mainHex, "")
+	mygit(t, repo, "branch", "trunk")
+	mygit(t, repo, "branch", "-u", "origin/main", "trunk")
+	mygit(t, repo, "branch", "-u", "main", "topic")
+	mygit(t, repo, "branch", "--unset-upstream", "topic")
+	mygit(t, repo, "branch", "-m", "trunk", "renamed")
+	want := remote + "[branch \"renamed\"]\n\tremote = origin\n\tmerge = refs/heads/main\n[branch \"topic\"]\n"
+	if config := readTestFile(t, repo, ".git/config"); config != want {
+		t.Fatalf("config =\n%s\nwant\n%s", config, want)
+	}
+
+	// Deleting a branch without configuration leaves the file untouched
+	old := time.Unix(1500000000, 0)
+	if err := os.Chtimes(configPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	mygit(t, repo, "branch", "trunk")
+	mygit(t, repo, "branch", "-d", "trunk")
+	if info := mustLstat(t, configPath); !info.ModTime().Equal(old) {
+		t.Error("branch -d of a branch without configuration rewrote the config")
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkoutTree writes every entry of the tree hexHash into the working
+// directory of repo below dir, which is "" for the top level tree, and
+// returns the index entries describing what it wrote.
+func checkoutTree(repo string, hexHash string, dir string) []indexEntry {
+	entries := []indexEntry{}
+	for _, entry := range readTreeEntries(repo, hexHash) {
+		name := path.Join(dir, entry.name)
+		entryHex := hex.EncodeToString(entry.sha[:])
+		if isTreePerm(entry.perm) {
+			err := os.MkdirAll(worktreePath(repo, name), 0755)
+			exitIfError(err, fmt.Sprintf("fatal: cannot create directory '%s': %s", name, err))
+			entries = append(entries, checkoutTree(repo, entryHex, name)...)
+			continue
+		}
+		checkoutFile(repo, name, entry.perm, entryHex)
+		entries = append(entries, newIndexEntry(repo, name, entry.perm, entry.sha))
+	}
+	return entries
+}
+
+// checkoutFile writes the object hexHash to name in the working directory of
+// repo, replacing whatever is there. Regular files get 0644 or 0755 depending
+// on perm, symlinks point at the blob content and gitlinks become an empty
+// directory since submodules are not cloned.
+func checkoutFile(repo string, name string, perm ObjectPerm, hexHash string) {
+	fullPath := worktreePath(repo, name)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+	exitIfError(err, fmt.Sprintf("fatal: cannot create directory for '%s': %s", name, err))
+	if info, err := os.Lstat(fullPath); err == nil && !(perm == GITLINK && info.IsDir()) {
+		err = os.RemoveAll(fullPath)
+		exitIfError(err, fmt.Sprintf("fatal: unable to unlink '%s': %s", name, err))
+	}
+	switch perm {
+	case GITLINK:
+		err = os.MkdirAll(fullPath, 0755)
+	case SYMLINK:
+		err = os.Symlink(string(readBlob(repo, hexHash)), fullPath)
+	case EXE:
+		err = os.WriteFile(fullPath, readBlob(repo, hexHash), 0755)
+	case FILE:
+		err = os.WriteFile(fullPath, readBlob(repo, hexHash), 0644)
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unsupported mode %s for '%s'\n", perm, name)
+		os.Exit(1)
+	}
+	exitIfError(err, fmt.Sprintf("fatal: unable to create file '%s': %s", name, err))
+}
+
+func readBlob(repo string, hexHash string) []byte {
+	objectType, content := readObject(repo, hexHash)
+	if objectType != Blob {
+		fmt.Fprintf(os.Stderr, "fatal: %s is not a blob object\n", hexHash)
+		os.Exit(1)
+	}
+	return content
+}
+
+// worktreePath converts a slash separated path from a tree or the index into
+// a path on disk.
+func worktreePath(repo string, name string) string {
+	return filepath.Join(repo, filepath.FromSlash(name))
+}
+
+type treeFile struct {
+	perm ObjectPerm
+	sha  [20]byte
+}
+
+// flattenTree collects every non tree entry reachable from the tree hexHash
+// into files, keyed by its slash separated path below dir.
+func flattenTree(repo string, hexHash string, dir string, files map[string]treeFile) {
+	for _, entry := range readTreeEntries(repo, hexHash) {
+		name := path.Join(dir, entry.name)
+		if isTreePerm(entry.perm) {
+			flattenTree(repo, hex.EncodeToString(entry.sha[:]), name, files)
+			continue
+		}
+		files[name] = treeFile{perm: entry.perm, sha: entry.sha}
+	}
+}
+
+// commitFiles returns the files recorded by commitHex, which may be empty
+// for an unborn branch.
+func commitFiles(repo string, commitHex string) map[string]treeFile {
+	files := map[string]treeFile{}
+	if commitHex != "" {
+		flattenTree(repo, readCommit(repo, commitHex).tree, "", files)
+	}
+	return files
+}
+
+// stageZeroEntries indexes the entries by path. Unmerged entries make any
+// operation that rewrites the index unsafe, so they are reported instead.
+func stageZeroEntries(entries []indexEntry) (map[string]indexEntry, bool) {
+	byName := map[string]indexEntry{}
+	for _, entry := range entries {
+		if entry.stage != 0 {
+			return nil, false
+		}
+		byName[entry.name] = entry
+	}
+	return byName, true
+}
+
+// hashWorktreeFile computes the mode and blob name git would record for the
+// file at name without writing the blob.
+func hashWorktreeFile(repo string, name string, info os.FileInfo) (ObjectPerm, [20]byte) {
+	fullPath := worktreePath(repo, name)
+	var content []byte
+	var err error
+	perm := permOfFileInfo(info)
+	if perm == SYMLINK {
+		var target string
+		target, err = os.Readlink(fullPath)
+		content = []byte(target)
+	} else {
+		content, err = os.ReadFile(fullPath)
+	}
+	exitIfError(err, fmt.Sprintf("fatal: unable to read '%s': %s", name, err))
+	return perm, [20]byte(hashContent(writeHeaderToContent(content, Blob)))
+}
+
+// permOfFileInfo returns the mode git records for a file with info, which
+// only keeps the executable bits of regular files.
+func permOfFileInfo(info os.FileInfo) ObjectPerm {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return SYMLINK
+	case info.Mode()&0111 != 0:
+		return EXE
+	default:
+		return FILE
+	}
+}
+
+// worktreeChanged reports whether the working tree file for entry differs
+// from what the index records. A different mode is always a change, while
+// matching stat data is trusted unless the file was modified in the same
+// second the index was written.
+func worktreeChanged(repo string, entry indexEntry) bool {
+	info, err := os.Lstat(worktreePath(repo, entry.name))
+	if err != nil {
+		return true
+	}
+	if entry.mode == 0160000 {
+		return !info.IsDir()
+	}
+	if info.IsDir() || indexModeFromPerm(permOfFileInfo(info)) != entry.mode {
+		return true
+	}
+	if statMatches(entry, info) && !isRacyEntry(repo, entry) {
+		return false
+	}
+	_, sha := hashWorktreeFile(repo, entry.name, info)
+	return sha != entry.sha
+}
+
+// statMatches compares the stat data recorded in entry with info the way
+// git's ce_match_stat does, leaving out the device which git ignores too.
+func statMatches(entry indexEntry, info os.FileInfo) bool {
+	current := indexEntry{}
+	fillIndexStat(&current, info)
+	return entry.mtimeSec == current.mtimeSec && entry.mtimeNsec == current.mtimeNsec &&
+		entry.ctimeSec == current.ctimeSec && entry.ctimeNsec == current.ctimeNsec &&
+		entry.ino == current.ino && entry.uid == current.uid && entry.gid == current.gid &&
+		entry.size == current.size
+}
+
+func isRacyEntry(repo string, entry indexEntry) bool {
+	info, err := os.Stat(indexPath(repo))
+	return err != nil || int64(entry.mtimeSec) >= info.ModTime().Unix()
+}
+
+// removeWorktreeFile deletes name and any directories left empty by it.
+func removeWorktreeFile(repo string, name string) {
+	err := os.RemoveAll(worktreePath(repo, name))
+	exitIfError(err, fmt.Sprintf("fatal: unable to unlink '%s': %s", name, err))
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(worktreePath(repo, dir)) != nil {
+			return
+		}
+	}
+}
+
+// switchWorktree moves the index and working tree from the commit HEAD
+// points to over to targetHex, keeping local changes to files that are the
+// same in both commits. Unless force is set, it refuses to touch files with
+// local modifications or untracked files that would be overwritten.
+func switchWorktree(repo string, targetHex string, force bool) {
+	if !updateWorktree(repo, commitFiles(repo, headCommit(repo)), commitFiles(repo, targetHex), force, "checkout") {
+		os.Exit(1)
+	}
+}
+
+// updateWorktree moves the index and working tree from the files of
+// current over to the ones of target like switchWorktree. When files are in
+// the way it explains why, naming the command doing it with action, and
+// returns false without changing anything.
+func updateWorktree(repo string, current map[string]treeFile, target map[string]treeFile, force bool, action string) bool {
+	index, ok := stageZeroEntries(readIndex(repo))
+	if !ok {
+		if !force {
+			fmt.Fprintf(os.Stderr, "error: you need to resolve your current index first\n")
+			os.Exit(1)
+		}
+		index = map[string]indexEntry{}
+		for _, entry := range readIndex(repo) {
+			if file, tracked := current[entry.name]; tracked {
+				index[entry.name] = newIndexEntry(repo, entry.name, file.perm, file.sha)
+			}
+		}
+	}
+	paths := map[string]bool{}
+	for name := range current {
+		paths[name] = true
+	}
+	for name := range target {
+		paths[name] = true
+	}
+	modified := []string{}
+	untracked := []string{}
+	removals := []string{}
+	updates := []string{}
+	for name := range paths {
+		currentFile, inCurrent := current[name]
+		targetFile, inTarget := target[name]
+		if inCurrent && inTarget && currentFile == targetFile && !force {
+			continue
+		}
+		entry, inIndex := index[name]
+		if inIndex && inTarget && permFromIndexMode(entry.mode) == targetFile.perm && entry.sha == targetFile.sha {
+			if force && worktreeChanged(repo, entry) {
+				updates = append(updates, name)
+			}
+			continue
+		}
+		if !force {
+			switch {
+			case inIndex && (!inCurrent || permFromIndexMode(entry.mode) != currentFile.perm || entry.sha != currentFile.sha):
+				modified = append(modified, name)
+				continue
+			case inIndex && worktreeChanged(repo, entry):
+				modified = append(modified, name)
+				continue
+			case !inIndex && inCurrent && inTarget:
+				modified = append(modified, name)
+				continue
+			case !inIndex && !inCurrent:
+				if info, err := os.Lstat(worktreePath(repo, name)); err == nil && !info.IsDir() {
+					untracked = append(untracked, name)
+					continue
+				}
+			}
+		}
+		if inTarget {
+			updates = append(updates, name)
+		} else if inIndex || inCurrent {
+			removals = append(removals, name)
+		}
+	}
+	if len(modified) > 0 || len(untracked) > 0 {
+		before := action
+		if action == "checkout" {
+			before = "switch branches"
+		}
+		if len(modified) > 0 {
+			sort.Strings(modified)
+			fmt.Fprintf(os.Stderr, "error: Your local changes to the following files would be overwritten by %s:\n", action)
+			for _, name := range modified {
+				fmt.Fprintf(os.Stderr, "\t%s\n", name)
+			}
+			fmt.Fprintf(os.Stderr, "Please commit your changes or stash them before you %s.\n", before)
+		}
+		if len(untracked) > 0 {
+			sort.Strings(untracked)
+			fmt.Fprintf(os.Stderr, "error: The following untracked working tree files would be overwritten by %s:\n", action)
+			for _, name := range untracked {
+				fmt.Fprintf(os.Stderr, "\t%s\n", name)
+			}
+			fmt.Fprintf(os.Stderr, "Please move or remove them before you %s.\n", before)
+		}
+		fmt.Fprintf(os.Stderr, "Aborting\n")
+		return false
+	}
+	// Removals go first so that a directory can be replaced by a file
+	for _, name := range removals {
+		if _, tracked := index[name]; tracked || force {
+			removeWorktreeFile(repo, name)
+		}
+		delete(index, name)
+	}
+	sort.Strings(updates)
+	for _, name := range updates {
+		file := target[name]
+		checkoutFile(repo, name, file.perm, hex.EncodeToString(file.sha[:]))
+		index[name] = newIndexEntry(repo, name, file.perm, file.sha)
+	}
+	entries := make([]indexEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	writeIndex(repo, entries)
+	return true
+}
+
+// pathspecMatches reports whether name is selected by spec, which matches
+// the path itself and everything below it.
+func pathspecMatches(name string, spec string) bool {
+	spec = strings.TrimSuffix(path.Clean(filepath.ToSlash(spec)), "/")
+	return spec == "." || name == spec || strings.HasPrefix(name, spec+"/")
+}
+
+// restorePaths restores the files selected by pathspecs from source, or
+// from the index when source is nil. The index is only restored when staged
+// is set and the working tree only when worktree is set.
+func restorePaths(repo string, pathspecs []string, source map[string]treeFile, staged bool, worktree bool) {
+	index, ok := stageZeroEntries(readIndex(repo))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: you need to resolve your current index first\n")
+		os.Exit(1)
+	}
+	fromIndex := source == nil
+	sourceFiles := source
+	if fromIndex {
+		sourceFiles = map[string]treeFile{}
+		for name, entry := range index {
+			sourceFiles[name] = treeFile{perm: permFromIndexMode(entry.mode), sha: entry.sha}
+		}
+	}
+	matched := map[string]bool{}
+	selected := map[string]bool{}
+	for _, candidates := range []map[string]bool{keysOf(sourceFiles), keysOfIndex(index)} {
+		for name := range candidates {
+			for _, spec := range pathspecs {
+				if pathspecMatches(name, spec) {
+					matched[spec] = true
+					selected[name] = true
+				}
+			}
+		}
+	}
+	for _, spec := range pathspecs {
+		if !matched[spec] {
+			fmt.Fprintf(os.Stderr, "error: pathspec '%s' did not match any file(s) known to git\n", spec)
+			os.Exit(1)
+		}
+	}
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file, inSource := sourceFiles[name]
+		if worktree {
+			if inSource {
+				checkoutFile(repo, name, file.perm, hex.EncodeToString(file.sha[:]))
+			} else if !fromIndex {
+				removeWorktreeFile(repo, name)
+			}
+		}
+		if staged {
+			if inSource {
+				index[name] = newIndexEntry(repo, name, file.perm, file.sha)
+				if !worktree {
+					// The working tree still holds the old content, so keep
+					// the stat data from invalidating the change
+					entry := index[name]
+					entry.mtimeSec, entry.mtimeNsec, entry.size = 0, 0, 0
+					index[name] = entry
+				}
+			} else {
+				delete(index, name)
+			}
+		} else if entry, tracked := index[name]; tracked && worktree && inSource &&
+			entry.sha == file.sha && permFromIndexMode(entry.mode) == file.perm {
+			index[name] = newIndexEntry(repo, name, file.perm, file.sha)
+		}
+	}
+	entries := make([]indexEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	writeIndex(repo, entries)
+}
+
+func keysOf(files map[string]treeFile) map[string]bool {
+	keys := map[string]bool{}
+	for name := range files {
+		keys[name] = true
+	}
+	return keys
+}
+
+func keysOfIndex(index map[string]indexEntry) map[string]bool {
+	keys := map[string]bool{}
+	for name := range index {
+		keys[name] = true
+	}
+	return keys
+}
+
+// switchToBranch checks out the branch refName and points HEAD at it.
+func switchToBranch(repo string, refName string, force bool) {
+	if current, ok := headBranch(repo); ok && current == refName {
+		switchWorktree(repo, headCommit(repo), force)
+		fmt.Fprintf(os.Stderr, "Already on '%s'\n", shortRefName(refName))
+		return
+	}
+	target, _ := resolveRef(repo, refName)
+	switchWorktree(repo, target, force)
+	writeSymbolicRef(repo, "HEAD", refName, checkoutMessage(repo, shortRefName(refName)))
+	fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", shortRefName(refName))
+}
+
+// createAndSwitchToBranch creates the branch name at startHex, which the
+// user gave as startName, and checks it out, like `git switch -c`.
+func createAndSwitchToBranch(repo string, name string, startName string, startHex string, force bool) {
+	refName := "refs/heads/" + name
+	if !isValidRefName(refName) {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' is not a valid branch name\n", name)
+		os.Exit(128)
+	}
+	if _, exists := resolveRef(repo, refName); exists {
+		fmt.Fprintf(os.Stderr, "fatal: a branch named '%s' already exists\n", name)
+		os.Exit(128)
+	}
+	switchWorktree(repo, startHex, force)
+	if startHex != "" {
+		writeRef(repo, refName, startHex, "branch: Created from "+startName)
+	}
+	writeSymbolicRef(repo, "HEAD", refName, checkoutMessage(repo, name))
+	fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", name)
+}
+
+// detachHead checks out commitHex, named targetName by the user, and
+// points HEAD directly at it.
+func detachHead(repo string, targetName string, commitHex string, force bool) {
+	switchWorktree(repo, commitHex, force)
+	writeRef(repo, "HEAD", commitHex, checkoutMessage(repo, targetName))
+	fmt.Fprintf(os.Stderr, "HEAD is now at %s %s\n", commitHex[:7], readCommit(repo, commitHex).subject())
+}
+
+// checkoutMessage is the reflog message of HEAD moving to target.
+func checkoutMessage(repo string, target string) string {
+	from := headCommit(repo)
+	if branch, ok := headBranch(repo); ok {
+		from = shortRefName(branch)
+	}
+	return "checkout: moving from " + from + " to " + target
+}
+
+// splitPathspecs separates the arguments before a "--" from the paths after
+// it, since go-flags drops the separator itself.
+func splitPathspecs(args []string) (options []string, pathspecs []string, hasSeparator bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
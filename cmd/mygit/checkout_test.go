@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGitlinkHex = "e800565cf760e1cb226e183b91177fa09511cac5"
+
+// writeModesTree writes a tree holding one entry of every mode and returns
+// its hash.
+func writeModesTree(t *testing.T) string {
+	t.Helper()
+	if err := os.MkdirAll(".git/objects", 0755); err != nil {
+		t.Fatal(err)
+	}
+	blob := func(content string) [20]byte {
+		hash, _ := hex.DecodeString(createBlobObject([]byte(content)))
+		return [20]byte(hash)
+	}
+	gitlink, _ := hex.DecodeString(testGitlinkHex)
+	sub := writeTreeObject([]tree{{perm: EXE, name: "tool", sha: blob("#!/bin/sh\n")}})
+	return hex.EncodeToString(writeTreeObject([]tree{
+		{perm: FILE, name: "file", sha: blob("plain\n")},
+		{perm: EXE, name: "run.sh", sha: blob("#!/bin/sh\necho hi\n")},
+		{perm: SYMLINK, name: "link", sha: blob("file")},
+		{perm: GITLINK, name: "module", sha: [20]byte(gitlink)},
+		{perm: DIR, name: "bin", sha: [20]byte(sub)},
+	}))
+}
+
+func TestCheckoutTreeModes(t *testing.T) {
+	chdirTemp(t)
+	entries := checkoutTree(".", writeModesTree(t), "")
+	modes := map[string]uint32{}
+	for _, entry := range entries {
+		modes[entry.name] = entry.mode
+	}
+	wantModes := map[string]uint32{
+		"file": 0100644, "run.sh": 0100755, "link": 0120000, "module": 0160000, "bin/tool": 0100755,
+	}
+	for name, want := range wantModes {
+		if modes[name] != want {
+			t.Errorf("index mode of %s = %o, want %o", name, modes[name], want)
+		}
+	}
+	for name, executable := range map[string]bool{"file": false, "run.sh": true, "bin/tool": true} {
+		info, err := os.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Mode().IsRegular() || (info.Mode()&0111 != 0) != executable {
+			t.Errorf("%s has mode %s", name, info.Mode())
+		}
+	}
+	if target, err := os.Readlink("link"); err != nil || target != "file" {
+		t.Errorf("link points at %q (%v), want file", target, err)
+	}
+	if files, err := os.ReadDir("module"); err != nil || len(files) != 0 {
+		t.Errorf("gitlink should be an empty directory, got %v (%v)", files, err)
+	}
+}
+
+func TestWorktreeUnchangedAfterCheckout(t *testing.T) {
+	chdirTemp(t)
+	entries := checkoutTree(".", writeModesTree(t), "")
+	writeIndex(".", entries)
+	for _, entry := range readIndex(".") {
+		if worktreeChanged(".", entry) {
+			t.Errorf("%s reported as changed", entry.name)
+		}
+	}
+}
+
+func TestWorktreeChangedSeesModeChanges(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll(".git/objects", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFixtureFile(t, fixtureFile{path: "f", content: "f\n"})
+	// An old mtime keeps the entry from being racily clean
+	old := time.Unix(1600000000, 0)
+	if err := os.Chtimes("f", old, old); err != nil {
+		t.Fatal(err)
+	}
+	perm, sha := hashWorktreeFile(".", "f", mustLstat(t, "f"))
+	entry := newIndexEntry(".", "f", perm, sha)
+	writeIndex(".", []indexEntry{entry})
+	if worktreeChanged(".", entry) {
+		t.Fatal("untouched file reported as changed")
+	}
+	if err := os.Chmod("f", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !worktreeChanged(".", entry) {
+		t.Error("chmod +x not reported as a change")
+	}
+}
+
+func mustLstat(t *testing.T, name string) os.FileInfo {
+	t.Helper()
+	info, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// newSwitchRepo creates a repository whose main branch has a file a, and a
+// side branch changing a and adding s.
+func newSwitchRepo(t *testing.T) string {
+	t.Helper()
+	repo := newTestRepo(t)
+	writeTestFile(t, repo, "a", "a\n")
+	realGit(t, repo, "add", "a")
+	realGit(t, repo, "commit", "-q", "-m", "one")
+	realGit(t, repo, "switch", "-q", "-c", "side")
+	writeTestFile(t, repo, "a", "b\n")
+	writeTestFile(t, repo, "s", "s\n")
+	realGit(t, repo, "add", "a", "s")
+	realGit(t, repo, "commit", "-q", "-m", "two")
+	realGit(t, repo, "switch", "-q", "main")
+	return repo
+}
+
+func readTestFile(t *testing.T, repo string, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(repo, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSwitchRefusesToOverwriteLocalChanges(t *testing.T) {
+	repo := newSwitchRepo(t)
+	writeTestFile(t, repo, "a", "local\n")
+	result := runMygit(t, repo, "", "switch", "side")
+	want := "error: Your local changes to the following files would be overwritten by checkout:\n\ta\n"
+	if result.code != 1 || !strings.HasPrefix(result.stderr, want) {
+		t.Fatalf("switch = %d %q, want a refusal", result.code, result.stderr)
+	}
+	if got := readTestFile(t, repo, "a"); got != "local\n" {
+		t.Errorf("a = %q after refused switch", got)
+	}
+	mygit(t, repo, "checkout", "-f", "side")
+	if got := readTestFile(t, repo, "a"); got != "b\n" {
+		t.Errorf("a = %q after checkout -f, want b", got)
+	}
+}
+
+func TestSwitchRefusesToOverwriteUntrackedFiles(t *testing.T) {
+	repo := newSwitchRepo(t)
+	writeTestFile(t, repo, "s", "untracked\n")
+	result := runMygit(t, repo, "", "switch", "side")
+	want := "error: The following untracked working tree files would be overwritten by checkout:\n\ts\n"
+	if result.code != 1 || !strings.HasPrefix(result.stderr, want) {
+		t.Fatalf("switch = %d %q, want a refusal", result.code, result.stderr)
+	}
+	mygit(t, repo, "switch", "-f", "side")
+	if got := readTestFile(t, repo, "s"); got != "s\n" {
+		t.Errorf("s = %q after switch -f", got)
+	}
+}
+
+func TestRestoreStaged(t *testing.T) {
+	repo := newSwitchRepo(t)
+	writeTestFile(t, repo, "a", "staged\n")
+	realGit(t, repo, "add", "a")
+	mygit(t, repo, "restore", "--staged", "a")
+	if got := readTestFile(t, repo, "a"); got != "staged\n" {
+		t.Errorf("restore --staged changed the working tree: a = %q", got)
+	}
+	if diff := realGit(t, repo, "diff", "--cached"); diff != "" {
+		t.Errorf("index still differs from HEAD:\n%s", diff)
+	}
+	mygit(t, repo, "restore", "a")
+	if got := readTestFile(t, repo, "a"); got != "a\n" {
+		t.Errorf("a = %q after restore, want a", got)
+	}
+}
+
+func TestCheckoutDetachRejectsUnknownRevision(t *testing.T) {
+	repo := newSwitchRepo(t)
+	result := runMygit(t, repo, "", "checkout", "--detach", "a")
+	if result.code != 128 || result.stderr != "fatal: invalid reference: a\n" {
+		t.Errorf("checkout --detach a = %d %q", result.code, result.stderr)
+	}
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+*  ###################### COMBINED DIFF ##############################
+*
+*  A merge is compared with all of its parents at once, showing only the
+*  files that differ from every parent. Each line of the result has a
+*  column per parent, marked '+' when the line is not in that parent and
+*  preceded by the lines lost from parents, marked '-' in their columns:
+*
+*  diff --cc <path>
+*  index <parent1>,<parent2>..<result>
+*  --- a/<path>
+*  +++ b/<path>
+*  @@@ -<start>,<count> -<start>,<count> +<start>,<count> @@@
+*  ...
+*
+*  Like git's --cc, hunks changed from a single parent, or where the
+*  result takes one of only two versions, are left out.
+ */
+
+// combinedParent is a parent of a merge as seen by a file of its result.
+type combinedParent struct {
+	side   diffSide
+	status byte
+}
+
+// combinedPath is a file of a merge that differs from every parent.
+type combinedPath struct {
+	name    string
+	result  diffSide
+	parents []combinedParent
+}
+
+// combinedPaths intersects the changes of the commit from each parent, in
+// the order of the changes.
+func combinedPaths(repo string, commit commitObject, renames renameOptions, pathspecs []string) []combinedPath {
+	var paths []combinedPath
+	treeOptions := treeDiffOptions{recursive: true, unmodified: renames.copiesHarder}
+	for n, parent := range commit.parents {
+		parentTree := readCommit(repo, parent).tree
+		pairs := detectRenames(repo, diffTrees(repo, parentTree, commit.tree, treeOptions, pathspecs), renames)
+		if n == 0 {
+			for _, pair := range pairs {
+				if pair.oldName == "" && pair.old.exists() && pair.old == pair.new {
+					continue
+				}
+				path := combinedPath{name: pair.name, result: pair.new, parents: make([]combinedParent, len(commit.parents))}
+				path.parents[0] = combinedParent{side: pair.old, status: pair.status}
+				paths = append(paths, path)
+			}
+			continue
+		}
+		kept, i := paths[:0], 0
+		for _, path := range paths {
+			for i < len(pairs) && strings.Compare(path.name, pairs[i].name) > 0 {
+				i++
+			}
+			if i == len(pairs) || path.name != pairs[i].name {
+				continue
+			}
+			path.parents[n] = combinedParent{side: pairs[i].old, status: pairs[i].status}
+			kept = append(kept, path)
+			i++
+		}
+		paths = kept
+	}
+	return paths
+}
+
+// writeCombinedDiff prints the changes of a merge: stats against its first
+// parent, and listings and patches of the files that differ from all of
+// its parents.
+func (writer *patchWriter) writeCombinedDiff(repo string, commit commitObject, renames renameOptions, pathspecs []string) {
+	output := writer.options.output
+	statFormats := output & (outputNumstat | outputStat | outputShortstat | outputDirstat)
+	if statFormats != 0 {
+		parentTree := readCommit(repo, commit.parents[0]).tree
+		treeOptions := treeDiffOptions{recursive: true, unmodified: renames.copiesHarder}
+		pairs := detectRenames(repo, diffTrees(repo, parentTree, commit.tree, treeOptions, pathspecs), renames)
+		statWriter := &patchWriter{out: writer.out, options: writer.options}
+		statWriter.options.output = statFormats
+		statWriter.writeDiff(repo, pairs)
+	}
+	paths := combinedPaths(repo, commit, renames, pathspecs)
+	if len(paths) == 0 {
+		return
+	}
+	separate := statFormats != 0
+	if output&(outputRaw|outputNameOnly|outputNameStatus) != 0 {
+		for _, path := range paths {
+			writer.writeCombinedListing(path)
+		}
+		separate = true
+	}
+	if output&outputPatch != 0 {
+		if separate {
+			fmt.Fprint(writer.out, "\n")
+		}
+		for _, path := range paths {
+			writer.writeCombinedPatch(repo, path)
+		}
+	}
+}
+
+// writeCombinedListing prints path in the raw, name-only or name-status
+// format, with a mode, object name and status for every parent.
+func (writer *patchWriter) writeCombinedListing(path combinedPath) {
+	switch {
+	case writer.options.output&outputNameOnly != 0:
+		fmt.Fprintln(writer.out, quotePath(path.name))
+		return
+	case writer.options.output&outputNameStatus != 0:
+	default:
+		var modes, names []string
+		for _, parent := range path.parents {
+			modes = append(modes, rawMode(parent.side))
+			names = append(names, writer.rawObjectName(parent.side))
+		}
+		fmt.Fprintf(writer.out, "%s%s %s %s %s ", strings.Repeat(":", len(path.parents)),
+			strings.Join(modes, " "), rawMode(path.result), strings.Join(names, " "), writer.rawObjectName(path.result))
+	}
+	for _, parent := range path.parents {
+		fmt.Fprintf(writer.out, "%c", parent.status)
+	}
+	fmt.Fprintf(writer.out, "\t%s\n", quotePath(path.name))
+}
+
+// lostLine is a line of some parents missing from the result. parents has
+// bit n set for parent n.
+type lostLine struct {
+	text    string
+	parents uint
+}
+
+// resultLine is a line of the result, with the lines lost before it. flag
+// has bit n set when the line is not in parent n; the next two bits mark
+// lines to show and context lines that do not show the lost lines before
+// them.
+type resultLine struct {
+	text string
+	flag uint
+	lost []*lostLine
+	// parentLost collects the lines lost from the parent being compared
+	parentLost []*lostLine
+	// parentStart is the line number in each parent where a hunk starting
+	// at this line starts.
+	parentStart []int
+}
+
+// combinedDiff compares the lines of a result with those of each parent.
+// lines has an extra line at the end that only holds the lines lost after
+// the last line of the result.
+type combinedDiff struct {
+	lines   []resultLine
+	parents int
+	options lineDiffOptions
+	context int
+}
+
+func newCombinedDiff(result []byte, parents int, options diffOptions) *combinedDiff {
+	diff := &combinedDiff{parents: parents, options: options.lineDiffOptions, context: options.context}
+	for _, line := range splitLines(result) {
+		diff.lines = append(diff.lines, resultLine{text: strings.TrimSuffix(line, "\n")})
+	}
+	diff.lines = append(diff.lines, resultLine{}, resultLine{})
+	for i := range diff.lines {
+		diff.lines[i].parentStart = make([]int, parents)
+	}
+	return diff
+}
+
+// count is the number of lines of the result.
+func (diff *combinedDiff) count() int {
+	return len(diff.lines) - 2
+}
+
+// compare records the lines of the result missing from parent n, and the
+// lines of the parent missing from the result.
+func (diff *combinedDiff) compare(result []string, parent []string, n int) {
+	mask := uint(1) << n
+	for _, change := range diffLines(parent, result, diff.options) {
+		// Lost lines hang on the line that took their place
+		bucket := change.i2
+		for _, line := range parent[change.i1 : change.i1+change.chg1] {
+			lost := &lostLine{text: strings.TrimSuffix(line, "\n"), parents: mask}
+			diff.lines[bucket].parentLost = append(diff.lines[bucket].parentLost, lost)
+		}
+		for i := change.i2; i < change.i2+change.chg2; i++ {
+			diff.lines[i].flag |= mask
+		}
+	}
+
+	start := 1
+	count := diff.count()
+	for i := 0; i <= count; i++ {
+		line := &diff.lines[i]
+		line.parentStart[n] = start
+		if len(line.parentLost) > 0 {
+			line.lost = diff.coalesce(line.lost, line.parentLost, n)
+			line.parentLost = nil
+		}
+		for _, lost := range line.lost {
+			if lost.parents&mask != 0 {
+				start++
+			}
+		}
+		if i < count && line.flag&mask == 0 {
+			start++
+		}
+	}
+	diff.lines[count+1].parentStart[n] = start
+}
+
+// reuse copies what was found for parent j to parent i, which has the same
+// version of the file.
+func (diff *combinedDiff) reuse(i int, j int) {
+	for k := range diff.lines {
+		line := &diff.lines[k]
+		line.parentStart[i] = line.parentStart[j]
+		if k > diff.count() {
+			continue
+		}
+		for _, lost := range line.lost {
+			if lost.parents&(1<<j) != 0 {
+				lost.parents |= 1 << i
+			}
+		}
+		if line.flag&(1<<j) != 0 {
+			line.flag |= 1 << i
+		}
+	}
+}
+
+// coalesce merges the lines lost from parent n into the lines lost from
+// earlier parents, sharing the lines of their longest common subsequence.
+func (diff *combinedDiff) coalesce(base []*lostLine, added []*lostLine, n int) []*lostLine {
+	if len(base) == 0 {
+		return added
+	}
+	const (
+		fromBase = iota
+		fromNew
+		fromBoth
+	)
+	lcs := make([][]int, len(base)+1)
+	directions := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(added)+1)
+		directions[i] = make([]int, len(added)+1)
+		directions[i][0] = fromBase
+	}
+	for j := 1; j <= len(added); j++ {
+		directions[0][j] = fromNew
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(added); j++ {
+			switch {
+			case lineKey(base[i-1].text, diff.options) == lineKey(added[j-1].text, diff.options):
+				lcs[i][j] = lcs[i-1][j-1] + 1
+				directions[i][j] = fromBoth
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j] = lcs[i][j-1]
+				directions[i][j] = fromNew
+			default:
+				lcs[i][j] = lcs[i-1][j]
+				directions[i][j] = fromBase
+			}
+		}
+	}
+	for i, j := len(base), len(added); i != 0 || j != 0; {
+		switch directions[i][j] {
+		case fromBoth:
+			base[i-1].parents |= 1 << n
+			i, j = i-1, j-1
+		case fromNew:
+			base = slices.Insert(base, i, added[j-1])
+			j--
+		default:
+			i--
+		}
+	}
+	return base
+}
+
+// interesting tells whether the line differs from some parent or has lines
+// lost before it.
+func (diff *combinedDiff) interesting(i int) bool {
+	return diff.lines[i].flag&diff.allMask() != 0 || len(diff.lines[i].lost) > 0
+}
+
+func (diff *combinedDiff) allMask() uint {
+	return 1<<diff.parents - 1
+}
+
+func (diff *combinedDiff) mark() uint {
+	return 1 << diff.parents
+}
+
+func (diff *combinedDiff) noPreDelete() uint {
+	return 2 << diff.parents
+}
+
+// findNext returns the first line from i on that is marked, or unmarked
+// when looking for uninteresting lines.
+func (diff *combinedDiff) findNext(i int, uninteresting bool) int {
+	for ; i <= diff.count(); i++ {
+		if (diff.lines[i].flag&diff.mark() == 0) == uninteresting {
+			return i
+		}
+	}
+	return i
+}
+
+// adjustHunkTail moves i, the first line after a hunk, back onto its last
+// line when that line is only there for the lines lost before it, as it
+// already serves as context.
+func (diff *combinedDiff) adjustHunkTail(hunkBegin int, i int) int {
+	if hunkBegin+1 <= i && diff.lines[i-1].flag&diff.allMask() == 0 {
+		i--
+	}
+	return i
+}
+
+// makeHunks marks the lines to show, dropping the hunks that take one of
+// only two versions, and reports whether any are left.
+func (diff *combinedDiff) makeHunks() bool {
+	count, mark, allMask := diff.count(), diff.mark(), diff.allMask()
+	for i := 0; i <= count; i++ {
+		if diff.interesting(i) {
+			diff.lines[i].flag |= mark
+		} else {
+			diff.lines[i].flag &^= mark
+		}
+	}
+	for i := 0; i <= count; {
+		for i <= count && diff.lines[i].flag&mark == 0 {
+			i++
+		}
+		if count < i {
+			break
+		}
+		hunkBegin := i
+		j := i + 1
+		for ; j <= count; j++ {
+			if diff.lines[j].flag&mark != 0 {
+				continue
+			}
+			// Look beyond the end for an interesting line within the
+			// context
+			lookahead := min(diff.adjustHunkTail(hunkBegin, j)+diff.context, count+1)
+			continued := false
+			for lookahead > 0 {
+				lookahead--
+				if lookahead < j {
+					break
+				}
+				if diff.lines[lookahead].flag&mark != 0 {
+					continued = true
+					break
+				}
+			}
+			if !continued {
+				break
+			}
+			j = lookahead
+		}
+		hunkEnd := j
+
+		// The hunk is only interesting when lines differ from different
+		// sets of parents, or from all of them.
+		sameDiff, interesting := uint(0), false
+		for j := i; j < hunkEnd && !interesting; j++ {
+			if thisDiff := diff.lines[j].flag & allMask; thisDiff != 0 {
+				if sameDiff == 0 {
+					sameDiff = thisDiff
+				} else if sameDiff != thisDiff {
+					interesting = true
+					break
+				}
+			}
+			for _, lost := range diff.lines[j].lost {
+				if sameDiff == 0 {
+					sameDiff = lost.parents
+				} else if sameDiff != lost.parents {
+					interesting = true
+					break
+				}
+			}
+		}
+		if !interesting && sameDiff != allMask {
+			for j := hunkBegin; j < hunkEnd; j++ {
+				diff.lines[j].flag &^= mark
+			}
+		}
+		i = hunkEnd
+	}
+	return diff.giveContext()
+}
+
+// giveContext marks the context lines around the marked lines, joining
+// hunks that are close.
+func (diff *combinedDiff) giveContext() bool {
+	count, mark := diff.count(), diff.mark()
+	i := diff.findNext(0, false)
+	if count < i {
+		return false
+	}
+	for i <= count {
+		j := max(i-diff.context, 0)
+		for ; j < i; j++ {
+			if diff.lines[j].flag&mark == 0 {
+				diff.lines[j].flag |= diff.noPreDelete()
+			}
+			diff.lines[j].flag |= mark
+		}
+		for {
+			j = diff.findNext(i, true)
+			if count < j {
+				return true
+			}
+			k := diff.findNext(j, false)
+			j = diff.adjustHunkTail(i, j)
+			if k < j+diff.context {
+				// The gap before the next interesting line is small
+				for ; j < k; j++ {
+					diff.lines[j].flag |= mark
+				}
+				i = k
+				continue
+			}
+			i = k
+			for end := min(j+diff.context, count+1); j < end; j++ {
+				diff.lines[j].flag |= mark
+			}
+			break
+		}
+	}
+	return true
+}
+
+// writeLines prints the marked lines as hunks.
+func (diff *combinedDiff) writeLines(writer *patchWriter) {
+	count, mark := diff.count(), diff.mark()
+	fragSet, fragReset := writer.paint(colorFrag)
+	oldSet, oldReset := writer.paint(colorOld)
+	newSet, newReset := writer.paint(colorNew)
+	markers := strings.Repeat("@", diff.parents+1)
+	for i := 0; ; {
+		comment := ""
+		for i <= count && diff.lines[i].flag&mark == 0 {
+			if _, ok := functionLine(diff.lines[i].text); ok {
+				comment = diff.lines[i].text
+			}
+			i++
+		}
+		if count < i {
+			break
+		}
+		hunkEnd := i + 1
+		for hunkEnd <= count && diff.lines[hunkEnd].flag&mark != 0 {
+			hunkEnd++
+		}
+		resultCount := hunkEnd - i
+		if count < hunkEnd {
+			resultCount--
+		}
+		nullContext := 0
+		if diff.context == 0 {
+			// Lines only holding lost lines are not shown without context
+			for j := i; j < hunkEnd; j++ {
+				if diff.lines[j].flag&(mark-1) == 0 {
+					nullContext++
+				}
+			}
+			resultCount -= nullContext
+		}
+
+		// Counts are unsigned in git, which wraps around for the lines
+		// left out without context
+		header := markers
+		for n := 0; n < diff.parents; n++ {
+			start, end := diff.lines[i].parentStart[n], diff.lines[hunkEnd].parentStart[n]
+			header += fmt.Sprintf(" -%d,%d", start, uint64(end-start-nullContext))
+		}
+		header += fmt.Sprintf(" +%d,%d %s", i+1, uint64(resultCount), markers)
+		// Like git, the comment stops short of its last non-space
+		// character
+		commentEnd := 0
+		for j := 0; j < min(len(comment), 40); j++ {
+			if !isXdiffSpace(comment[j]) {
+				commentEnd = j
+			}
+		}
+		if commentEnd > 0 {
+			header += fragReset + " " + fragReset + comment[:commentEnd]
+		}
+		fmt.Fprint(writer.out, fragSet+header+fragReset+"\n")
+
+		for i < hunkEnd {
+			line := diff.lines[i]
+			i++
+			if line.flag&diff.noPreDelete() == 0 {
+				for _, lost := range line.lost {
+					signs := ""
+					for n := 0; n < diff.parents; n++ {
+						if lost.parents&(1<<n) != 0 {
+							signs += "-"
+						} else {
+							signs += " "
+						}
+					}
+					writeCombinedLine(writer, oldSet+signs, lost.text, oldReset)
+				}
+			}
+			if count < i {
+				break
+			}
+			set := newSet
+			if line.flag&(mark-1) == 0 {
+				// The line only holds the lost lines in front of it
+				if diff.context == 0 {
+					continue
+				}
+				set = ""
+			}
+			signs := ""
+			for n := 0; n < diff.parents; n++ {
+				if line.flag&(1<<n) != 0 {
+					signs += "+"
+				} else {
+					signs += " "
+				}
+			}
+			writeCombinedLine(writer, set+signs, line.text, newReset)
+		}
+	}
+}
+
+// writeCombinedLine prints a line, keeping a carriage return at its end
+// after the color reset.
+func writeCombinedLine(writer *patchWriter, start string, text string, reset string) {
+	cr := ""
+	if strings.HasSuffix(text, "\r") {
+		text, cr = strings.TrimSuffix(text, "\r"), "\r"
+	}
+	fmt.Fprintf(writer.out, "%s%s%s%s\n", start, text, reset, cr)
+}
+
+// writeCombinedPatch prints the combined patch of path.
+func (writer *patchWriter) writeCombinedPatch(repo string, path combinedPath) {
+	result := path.result.content(repo, path.name)
+	modeDiffers, binary := false, isBinaryContent(result)
+	parentContents := make([][]byte, len(path.parents))
+	for n, parent := range path.parents {
+		modeDiffers = modeDiffers || parent.side.perm != path.result.perm
+		parentContents[n] = parent.side.content(repo, path.name)
+		binary = binary || isBinaryContent(parentContents[n])
+	}
+
+	var names []string
+	for _, parent := range path.parents {
+		names = append(names, abbreviatedSide(parent.side))
+	}
+	header := []string{
+		"diff --cc " + quotePath(path.name),
+		"index " + strings.Join(names, ",") + ".." + abbreviatedSide(path.result),
+	}
+	added, deleted := false, !path.result.exists()
+	if modeDiffers {
+		// A file is added when no parent had it
+		added = !deleted
+		for _, parent := range path.parents {
+			added = added && parent.status == 'A'
+		}
+		if added {
+			header = append(header, "new file mode "+rawMode(path.result))
+		} else {
+			var modes []string
+			for _, parent := range path.parents {
+				modes = append(modes, rawMode(parent.side))
+			}
+			line := "mode " + strings.Join(modes, ",")
+			if deleted {
+				line = "deleted file " + line
+			} else {
+				line += ".." + rawMode(path.result)
+			}
+			header = append(header, line)
+		}
+	}
+	if binary {
+		for _, line := range header {
+			writer.meta(line)
+		}
+		fmt.Fprintln(writer.out, "Binary files differ")
+		return
+	}
+
+	diff := newCombinedDiff(result, len(path.parents), writer.options)
+	resultLines := splitLines(result)
+	for n, parent := range path.parents {
+		j := slices.IndexFunc(path.parents[:n], func(other combinedParent) bool { return other.side.sha == parent.side.sha })
+		if j >= 0 {
+			diff.reuse(n, j)
+		} else {
+			diff.compare(resultLines, splitLines(parentContents[n]), n)
+		}
+	}
+	if !diff.makeHunks() && !modeDiffers {
+		return
+	}
+	from, to := quotePath("a/"+path.name), quotePath("b/"+path.name)
+	if added {
+		from = "/dev/null"
+	}
+	if deleted {
+		to = "/dev/null"
+	}
+	header = append(header, "--- "+from, "+++ "+to)
+	for _, line := range header {
+		writer.meta(line)
+	}
+	diff.writeLines(writer)
+}
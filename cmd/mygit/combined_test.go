@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestCombinedDiff(t *testing.T) {
+	ours := "int main()\n{\n  a;\n  BB;\n  c;\n  d;\n  E;\n  f;\n  g;\n}\n"
+	theirs := "int main()\n{\n  a;\n  B;\n  c;\n  d;\n  e;\n  f;\n  G;\n}\n"
+	result := "int main()\n{\n  a;\n  BBB;\n  c;\n  d;\n  E;\n  f;\n  G;\n}\n"
+	// Hunks taking the side of one parent are dropped, and like git the
+	// function name of the hunk header misses its last character. Both
+	// are what git shows for this merge.
+	cases := map[int]string{
+		3: "@@@ -1,10 -1,10 +1,10 @@@\n" +
+			"  int main()\n  {\n    a;\n-   BB;\n -  B;\n++  BBB;\n    c;\n    d;\n" +
+			" -  e;\n +  E;\n    f;\n-   g;\n+   G;\n  }\n",
+		1: "@@@ -3,3 -3,3 +3,3 @@@ int main(\n" +
+			"    a;\n-   BB;\n -  B;\n++  BBB;\n    c;\n",
+	}
+	for context, want := range cases {
+		var out bytes.Buffer
+		writer := &patchWriter{out: bufio.NewWriter(&out), options: diffOptions{lineDiffOptions: lineDiffOptions{algorithm: "myers"}, context: context}}
+		diff := newCombinedDiff([]byte(result), 2, writer.options)
+		diff.compare(splitLines([]byte(result)), splitLines([]byte(ours)), 0)
+		diff.compare(splitLines([]byte(result)), splitLines([]byte(theirs)), 1)
+		if !diff.makeHunks() {
+			t.Fatalf("no hunks with %d lines of context", context)
+		}
+		diff.writeLines(writer)
+		writer.out.Flush()
+		if out.String() != want {
+			t.Errorf("with %d lines of context got\n%s\nwant\n%s", context, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const emptyTreeHex = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+type commitHeader struct {
+	key   string
+	value string
+}
+
+// commitObject is a parsed commit. Headers other than tree, parent, author
+// and committer (encoding, gpgsig, mergetag...) are kept in order so that a
+// parsed commit encodes back to the same bytes.
+type commitObject struct {
+	tree      string
+	parents   []string
+	author    string
+	committer string
+	headers   []commitHeader
+	message   string
+}
+
+func parseCommit(content []byte) commitObject {
+	commit := commitObject{}
+	headerEnd := bytes.Index(content, []byte("\n\n"))
+	headerBlock := string(content)
+	if headerEnd >= 0 {
+		headerBlock = string(content[:headerEnd])
+		commit.message = string(content[headerEnd+2:])
+	}
+	headers := []commitHeader{}
+	for _, line := range strings.Split(headerBlock, "\n") {
+		if strings.HasPrefix(line, " ") && len(headers) > 0 {
+			// Continuation of a multi line header such as gpgsig
+			headers[len(headers)-1].value += "\n" + line[1:]
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		headers = append(headers, commitHeader{key: key, value: value})
+	}
+	for _, header := range headers {
+		switch header.key {
+		case "tree":
+			commit.tree = header.value
+		case "parent":
+			commit.parents = append(commit.parents, header.value)
+		case "author":
+			commit.author = header.value
+		case "committer":
+			commit.committer = header.value
+		default:
+			commit.headers = append(commit.headers, header)
+		}
+	}
+	return commit
+}
+
+// encode serializes the commit into the body of a commit object.
+func (commit commitObject) encode() []byte {
+	var buff bytes.Buffer
+	buff.WriteString("tree " + commit.tree + "\n")
+	for _, parent := range commit.parents {
+		buff.WriteString("parent " + parent + "\n")
+	}
+	buff.WriteString("author " + commit.author + "\n")
+	buff.WriteString("committer " + commit.committer + "\n")
+	for _, header := range commit.headers {
+		buff.WriteString(header.key + " " + strings.ReplaceAll(header.value, "\n", "\n ") + "\n")
+	}
+	buff.WriteString("\n")
+	buff.WriteString(commit.message)
+	return buff.Bytes()
+}
+
+// subject returns the first line of the commit message.
+func (commit commitObject) subject() string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(commit.message, "\n"), "\n")
+	return subject
+}
+
+func readCommit(repo string, hexHash string) commitObject {
+	objectType, content := readObject(repo, hexHash)
+	if objectType != Commit {
+		fmt.Fprintf(os.Stderr, "fatal: %s is not a commit object\n", hexHash)
+		os.Exit(1)
+	}
+	return parseCommit(content)
+}
+
+// cleanupMessage strips trailing whitespace from every line, collapses
+// consecutive blank lines and removes leading and trailing ones. Lines
+// starting with '#' are dropped when stripComments is set. The result is
+// empty or ends with a newline.
+func cleanupMessage(message string, stripComments bool) string {
+	lines := []string{}
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r\v\f")
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+const scissorsLine = "# ------------------------ >8 ------------------------"
+
+// cleanupCommitMessage applies one of git's --cleanup modes. "strip" drops
+// comments and extra whitespace, "whitespace" only the whitespace,
+// "scissors" additionally cuts everything from the scissors line on and
+// "verbatim" keeps the message untouched.
+func cleanupCommitMessage(message string, mode string) (string, error) {
+	switch mode {
+	case "verbatim":
+		return message, nil
+	case "whitespace":
+		return cleanupMessage(message, false), nil
+	case "strip":
+		return cleanupMessage(message, true), nil
+	case "scissors":
+		if strings.HasPrefix(message, scissorsLine+"\n") {
+			message = ""
+		} else if cut := strings.Index(message, "\n"+scissorsLine+"\n"); cut >= 0 {
+			message = message[:cut+1]
+		}
+		return cleanupMessage(message, false), nil
+	default:
+		return "", fmt.Errorf("Invalid cleanup mode %s", mode)
+	}
+}
+
+// joinMessageParagraphs turns the values of repeated -m options into a
+// message with one paragraph for each.
+func joinMessageParagraphs(paragraphs []string) string {
+	message := ""
+	for _, paragraph := range paragraphs {
+		if message != "" {
+			message += "\n"
+		}
+		message += paragraph + "\n"
+	}
+	return message
+}
+
+// readMessageFile reads a commit message from file, or from standard input
+// when file is "-".
+func readMessageFile(file string) string {
+	if file == "-" {
+		message, err := io.ReadAll(os.Stdin)
+		exitIfError(err, fmt.Sprintf("fatal: could not read log from standard input: %s", err))
+		return string(message)
+	}
+	message, err := os.ReadFile(file)
+	exitIfError(err, fmt.Sprintf("fatal: could not read log file '%s': %s", file, err))
+	return string(message)
+}
+
+// commitRequest holds the options of the commit command. The message is
+// written in the editor when edit is set.
+type commitRequest struct {
+	message    string
+	edit       bool
+	cleanup    string
+	all        bool
+	amend      bool
+	allowEmpty bool
+	author     string
+	date       string
+	sign       bool
+	signingKey string
+	trailers   []trailerLine
+	signoff    bool
+}
+
+// createCommit records the index as a new commit on top of HEAD, or in
+// place of it when amending, and advances the current branch.
+func createCommit(repo string, config *ini.File, request commitRequest) {
+	entries := readIndex(repo)
+	if request.all {
+		entries = stageTrackedChanges(repo, entries, func(string) bool { return true })
+		writeIndex(repo, entries)
+	}
+	if _, ok := stageZeroEntries(entries); !ok {
+		previous := ""
+		for _, entry := range entries {
+			if entry.stage != 0 && entry.name != previous {
+				fmt.Printf("U\t%s\n", entry.name)
+				previous = entry.name
+			}
+		}
+		fmt.Fprintf(os.Stderr, "error: Committing is not possible because you have unmerged files.\n"+
+			"hint: Fix them up in the work tree, and then use 'mygit add <file>'\n"+
+			"hint: as appropriate to mark resolution and make a commit.\n"+
+			"fatal: Exiting because of an unresolved conflict.\n")
+		os.Exit(128)
+	}
+	head := headCommit(repo)
+	commit := commitObject{tree: hex.EncodeToString(writeTreeFromIndex(entries))}
+	mergeHeads := readMergeHeads(repo)
+	// A cherry-pick that stopped keeps the author of the picked commit
+	pickHead, _ := os.ReadFile(gitPath(repo, "CHERRY_PICK_HEAD"))
+	var previous commitObject
+	if request.amend {
+		if mergeHeads != nil {
+			fmt.Fprintf(os.Stderr, "fatal: You are in the middle of a merge -- cannot amend.\n")
+			os.Exit(128)
+		}
+		if head == "" {
+			fmt.Fprintf(os.Stderr, "fatal: You have nothing to amend.\n")
+			os.Exit(128)
+		}
+		previous = readCommit(repo, head)
+		commit.parents = previous.parents
+	} else if head != "" {
+		commit.parents = append([]string{head}, mergeHeads...)
+	}
+	if !request.allowEmpty && !request.amend && mergeHeads == nil {
+		parentTree := emptyTreeHex
+		if len(commit.parents) > 0 {
+			parentTree = readCommit(repo, commit.parents[0]).tree
+		}
+		if commit.tree == parentTree {
+			if len(pickHead) > 0 {
+				printEmptyPick(repo, replayPick)
+				os.Exit(1)
+			}
+			if len(commit.parents) == 0 {
+				fmt.Println(`nothing to commit (create/copy files and use "mygit add" to track)`)
+			} else {
+				fmt.Println("nothing to commit, working tree clean")
+			}
+			os.Exit(1)
+		}
+	}
+
+	committer := committerIdentity(config)
+	author := authorIdentity(config)
+	if request.amend {
+		author = parseIdentity(previous.author)
+	} else if len(pickHead) > 0 {
+		author = parseIdentity(readCommit(repo, strings.TrimSpace(string(pickHead))).author)
+	}
+	if request.author != "" {
+		id, ok := parseNameEmail(request.author)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "fatal: --author '%s' is not 'Name <email>'\n", request.author)
+			os.Exit(128)
+		}
+		author.name, author.email = id.name, id.email
+	}
+	if request.date != "" {
+		date, err := parseDate(request.date)
+		exitIfError(err, fmt.Sprintf("fatal: %s", err))
+		author.date = date
+	}
+	commit.author = author.String()
+	commit.committer = committer.String()
+
+	cleanup := request.cleanup
+	if cleanup == "" || cleanup == "default" {
+		cleanup = "whitespace"
+		if request.edit {
+			cleanup = "strip"
+		}
+	}
+	// Like in git the sign-off and then the --trailer values are added
+	// before the editor opens, so that they can be edited too
+	message := request.message
+	if request.edit {
+		message = previous.message
+		if !request.amend {
+			message = pendingMergeMessage(repo)
+		}
+	}
+	if request.signoff {
+		message = addSignoff(message, committer)
+	}
+	message = addCommitTrailers(config, message, request.trailers)
+	if request.edit {
+		message = editCommitMessage(repo, config, message, cleanup)
+	}
+	cleaned, err := cleanupCommitMessage(message, cleanup)
+	exitIfError(err, fmt.Sprintf("fatal: %s", err))
+	if (cleanup != "verbatim" || cleaned == "") && onlySignoffs(cleaned) {
+		fmt.Fprintf(os.Stderr, "Aborting commit due to empty commit message.\n")
+		os.Exit(1)
+	}
+	commit.message = cleaned
+	if request.sign {
+		signCommit(config, &commit, request.signingKey)
+	}
+	commitHex := createCommitObject(commit.encode())
+	reflogMessage := "commit: " + commit.subject()
+	if request.amend {
+		reflogMessage = "commit (amend): " + commit.subject()
+	} else if mergeHeads != nil {
+		reflogMessage = "commit (merge): " + commit.subject()
+	} else if len(pickHead) > 0 {
+		reflogMessage = "commit (cherry-pick): " + commit.subject()
+	} else if len(commit.parents) == 0 {
+		reflogMessage = "commit (initial): " + commit.subject()
+	}
+	branch, onBranch := headBranch(repo)
+	if onBranch {
+		writeRef(repo, branch, commitHex, reflogMessage)
+	} else {
+		writeRef(repo, "HEAD", commitHex, reflogMessage)
+	}
+	removeMergeState(repo)
+	for _, name := range []string{"SQUASH_MSG", "CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		os.Remove(gitPath(repo, name))
+	}
+	printCommitSummary(repo, config, commitHex, request.amend || request.date != "" || len(pickHead) > 0, true)
+}
+
+// printCommitSummary shows the commit just made on HEAD and what it
+// changed. The author date is shown when it is not when the commit was
+// made. Git's sequencer never marks a root commit, hence markRoot.
+func printCommitSummary(repo string, config *ini.File, commitHex string, showDate bool, markRoot bool) {
+	commit := readCommit(repo, commitHex)
+	where := "detached HEAD"
+	if branch, ok := headBranch(repo); ok {
+		where = shortRefName(branch)
+	}
+	if markRoot && len(commit.parents) == 0 {
+		where += " (root-commit)"
+	}
+	fmt.Printf("[%s %s] %s\n", where, commitHex[:7], commit.subject())
+	author, committer := parseIdentity(commit.author), parseIdentity(commit.committer)
+	if author.name != committer.name || author.email != committer.email {
+		fmt.Printf(" Author: %s <%s>\n", author.name, author.email)
+	}
+	if showDate {
+		date, _ := formatDate(author.date, "default", time.Now())
+		fmt.Printf(" Date: %s\n", date)
+	}
+	if len(commit.parents) > 1 {
+		return
+	}
+	parentTree := emptyTreeHex
+	if len(commit.parents) == 1 {
+		parentTree = readCommit(repo, commit.parents[0]).tree
+	}
+	stat, _ := diffFlags{}.statOptions(repo, config)
+	pairs := detectRenames(repo, diffTrees(repo, parentTree, commit.tree, treeDiffOptions{recursive: true}, nil), renameOptions{detect: findRenames, minimumScore: defaultSimilarityScore})
+	out := bufio.NewWriter(os.Stdout)
+	writer := &patchWriter{out: out, options: diffOptions{
+		lineDiffOptions: lineDiffOptions{algorithm: "myers"},
+		output:          outputShortstat | outputSummary,
+		stat:            stat,
+	}}
+	writer.writeDiff(repo, pairs)
+	out.Flush()
+}
+
+// addSignoff adds the Signed-off-by trailer of committer to message. An
+// empty message keeps two blank lines in front of it, leaving room for the
+// subject and body. The trailer.* settings do not apply, as in git.
+func addSignoff(message string, committer identity) string {
+	signoff := trailerLine{token: "Signed-off-by", value: committer.name + " <" + committer.email + ">"}
+	if message == "" {
+		return "\n\n" + signoff.String() + "\n"
+	}
+	parsed := parseCommitMessage(message)
+	err := parsed.addTrailer(signoff, defaultTrailerPlacement)
+	exitIfError(err, fmt.Sprintf("fatal: %s", err))
+	return parsed.String()
+}
+
+// onlySignoffs tells whether message has nothing but blank lines and
+// Signed-off-by trailers, which git takes for an empty message.
+func onlySignoffs(message string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "Signed-off-by: ") {
+			return false
+		}
+	}
+	return true
+}
+
+// addCommitTrailers adds the --trailer values to message.
+func addCommitTrailers(config *ini.File, message string, trailers []trailerLine) string {
+	if len(trailers) == 0 {
+		return message
+	}
+	placement := trailerPlacementFromConfig(func(key string) string {
+		return configValue(".", config, "trailer", key)
+	})
+	parsed := parseCommitMessage(message)
+	for _, trailer := range trailers {
+		err := parsed.addTrailer(trailer, placement)
+		exitIfError(err, fmt.Sprintf("fatal: %s", err))
+	}
+	return parsed.String()
+}
+
+// editCommitMessage lets the user write the message in their editor,
+// starting from initial, and returns what they saved. The instructions
+// added to the template depend on the cleanup mode that will be applied.
+func editCommitMessage(repo string, config *ini.File, initial string, cleanup string) string {
+	messagePath := filepath.Join(repo, ".git", "COMMIT_EDITMSG")
+	template := initial + "\n"
+	for _, pending := range []struct{ ref, what string }{{"MERGE_HEAD", "merge"}, {"CHERRY_PICK_HEAD", "cherry-pick"}} {
+		if _, err := os.Stat(gitPath(repo, pending.ref)); err == nil {
+			template += "#\n" +
+				"# It looks like you may be committing a " + pending.what + ".\n" +
+				"# If this is not correct, please run\n" +
+				"#\tmygit update-ref -d " + pending.ref + "\n" +
+				"# and try again.\n" +
+				"#\n\n"
+			break
+		}
+	}
+	switch cleanup {
+	case "strip":
+		template += "# Please enter the commit message for your changes. Lines starting\n" +
+			"# with '#' will be ignored, and an empty message aborts the commit.\n"
+	case "scissors":
+		template += scissorsLine + "\n" +
+			"# Do not modify or remove the line above.\n" +
+			"# Everything below it will be ignored.\n"
+	default:
+		template += "# Please enter the commit message for your changes. Lines starting\n" +
+			"# with '#' will be kept; you may remove them yourself if you want to.\n" +
+			"# An empty message aborts the commit.\n"
+	}
+	if branch, ok := headBranch(repo); ok {
+		template += "#\n# On branch " + shortRefName(branch) + "\n"
+	}
+	err := os.WriteFile(messagePath, []byte(template), 0644)
+	exitIfError(err, fmt.Sprintf("fatal: could not write commit template: %s", err))
+	launchEditor(messagePath, config)
+	edited, err := os.ReadFile(messagePath)
+	exitIfError(err, fmt.Sprintf("fatal: could not read '%s': %s", messagePath, err))
+	return string(edited)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCommitRepo creates a repository with a single commit adding a and b,
+// whose files are dated in the past so that their index entries are not
+// racily clean.
+func newCommitRepo(t *testing.T) string {
+	t.Helper()
+	repo := newTestRepo(t)
+	old := time.Unix(1500000000, 0)
+	for _, name := range []string{"a", "b"} {
+		writeTestFile(t, repo, name, name+"\n")
+		if err := os.Chtimes(filepath.Join(repo, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mygit(t, repo, "add", "a", "b")
+	mygit(t, repo, "commit", "-m", "base")
+	return repo
+}
+
+func headTreeModes(t *testing.T, repo string) map[string]ObjectPerm {
+	t.Helper()
+	modes := map[string]ObjectPerm{}
+	for name, file := range commitFiles(repo, headCommit(repo)) {
+		modes[name] = file.perm
+	}
+	return modes
+}
+
+func TestCommitAllStagesModeChanges(t *testing.T) {
+	repo := newCommitRepo(t)
+	if err := os.Chmod(filepath.Join(repo, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	output := mygit(t, repo, "commit", "-a", "-m", "mode")
+	if !strings.Contains(output, " mode change 100644 => 100755 a\n") {
+		t.Errorf("commit output lacks the mode change:\n%s", output)
+	}
+	if modes := headTreeModes(t, repo); modes["a"] != EXE || modes["b"] != FILE {
+		t.Errorf("HEAD modes = %v, want a executable", modes)
+	}
+}
+
+func TestCommitAllSkipsUntrackedFiles(t *testing.T) {
+	repo := newCommitRepo(t)
+	writeTestFile(t, repo, "a", "changed\n")
+	writeTestFile(t, repo, "untracked", "u\n")
+	if err := os.Remove(filepath.Join(repo, "b")); err != nil {
+		t.Fatal(err)
+	}
+	mygit(t, repo, "commit", "-a", "-m", "all")
+	files := commitFiles(repo, headCommit(repo))
+	if _, ok := files["a"]; !ok || len(files) != 1 {
+		t.Errorf("HEAD holds %v, want only a", keysOf(files))
+	}
+}
+
+func TestCommitAllowEmpty(t *testing.T) {
+	repo := newCommitRepo(t)
+	base := headCommit(repo)
+	result := runMygit(t, repo, "", "commit", "-m", "empty")
+	if result.code != 1 || !strings.Contains(result.stdout, "nothing to commit, working tree clean") {
+		t.Fatalf("commit without changes = %d %q", result.code, result.stdout)
+	}
+	mygit(t, repo, "commit", "--allow-empty", "-m", "empty")
+	commit := readCommit(repo, headCommit(repo))
+	if len(commit.parents) != 1 || commit.parents[0] != base || commit.tree != readCommit(repo, base).tree {
+		t.Errorf("empty commit = %+v, want the tree of its parent %s", commit, base)
+	}
+}
+
+func TestCommitAmend(t *testing.T) {
+	repo := newCommitRepo(t)
+	writeTestFile(t, repo, "c", "c\n")
+	mygit(t, repo, "add", "c")
+	mygit(t, repo, "commit", "-m", "second")
+	second := readCommit(repo, headCommit(repo))
+	writeTestFile(t, repo, "d", "d\n")
+	mygit(t, repo, "add", "d")
+	mygit(t, repo, "commit", "--amend", "-m", "amended")
+	amended := readCommit(repo, headCommit(repo))
+	if amended.message != "amended\n" {
+		t.Errorf("message = %q, want amended", amended.message)
+	}
+	if strings.Join(amended.parents, " ") != strings.Join(second.parents, " ") {
+		t.Errorf("parents = %v, want those of the amended commit %v", amended.parents, second.parents)
+	}
+	if _, ok := commitFiles(repo, headCommit(repo))["d"]; !ok {
+		t.Error("amended commit lacks the newly staged d")
+	}
+}
+
+func TestCommitAuthorAndDate(t *testing.T) {
+	repo := newCommitRepo(t)
+	mygit(t, repo, "commit", "--allow-empty", "-m", "x", "--author", "X Y <x@y>", "--date", "1500000000 +0200")
+	commit := readCommit(repo, headCommit(repo))
+	if commit.author != "X Y <x@y> 1500000000 +0200" {
+		t.Errorf("author = %q", commit.author)
+	}
+	if commit.committer != "C O Mitter <committer@example.com> 1600000000 +0000" {
+		t.Errorf("committer = %q", commit.committer)
+	}
+}
+
+// newTreeRepo creates a repository and returns the tree of a single file a
+// along with the blob of a.
+func newTreeRepo(t *testing.T) (repo string, tree string, blob string) {
+	t.Helper()
+	repo = newTestRepo(t)
+	writeTestFile(t, repo, "a", "a\n")
+	blob = strings.TrimSpace(mygit(t, repo, "hash-object", "-w", "a"))
+	mygit(t, repo, "add", "a")
+	return repo, strings.TrimSpace(mygit(t, repo, "write-tree")), blob
+}
+
+func TestCommitTreeParents(t *testing.T) {
+	repo, tree, _ := newTreeRepo(t)
+	root := strings.TrimSpace(mygit(t, repo, "commit-tree", tree, "-m", "root"))
+	want := "tree " + tree + "\n" +
+		"author A U Thor <author@example.com> 1600000000 +0000\n" +
+		"committer C O Mitter <committer@example.com> 1600000000 +0000\n\nroot\n"
+	if _, content := readObject(repo, root); string(content) != want {
+		t.Errorf("root commit =\n%s\nwant\n%s", content, want)
+	}
+	second := strings.TrimSpace(mygit(t, repo, "commit-tree", tree, "-p", root, "-m", "second"))
+	merge := runMygit(t, repo, "", "commit-tree", tree, "-p", root, "-p", second, "-p", root, "-m", "merge")
+	if merge.code != 0 || merge.stderr != "error: duplicate parent "+root+" ignored\n" {
+		t.Fatalf("commit-tree with a duplicate parent = %d %q", merge.code, merge.stderr)
+	}
+	parents := readCommit(repo, strings.TrimSpace(merge.stdout)).parents
+	if strings.Join(parents, " ") != root+" "+second {
+		t.Errorf("parents = %v, want %s %s", parents, root, second)
+	}
+}
+
+func TestCommitTreeRejectsWrongObjectTypes(t *testing.T) {
+	repo, tree, blob := newTreeRepo(t)
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{blob, "-m", "x"}, "fatal: " + blob + " is not a valid 'tree' object\n"},
+		{[]string{tree, "-p", blob, "-m", "x"}, "fatal: " + blob + " is not a valid 'commit' object\n"},
+		{[]string{tree, "-p", "nosuch", "-m", "x"}, "fatal: not a valid object name nosuch\n"},
+	}
+	for _, c := range cases {
+		result := runMygit(t, repo, "", append([]string{"commit-tree"}, c.args...)...)
+		if result.code != 128 || result.stderr != c.want {
+			t.Errorf("commit-tree %v = %d %q, want %q", c.args, result.code, result.stderr, c.want)
+		}
+	}
+}
+
+func TestCleanupCommitMessage(t *testing.T) {
+	message := "\n\nsubject  \n\n\n# comment\nbody\t\n\n" + scissorsLine + "\ndiff\n\n"
+	cases := []struct {
+		mode string
+		want string
+	}{
+		{"verbatim", message},
+		{"whitespace", "subject\n\n# comment\nbody\n\n" + scissorsLine + "\ndiff\n"},
+		{"strip", "subject\n\nbody\n\ndiff\n"},
+		{"scissors", "subject\n\n# comment\nbody\n"},
+	}
+	for _, c := range cases {
+		got, err := cleanupCommitMessage(message, c.mode)
+		if err != nil || got != c.want {
+			t.Errorf("cleanupCommitMessage(%s) = %q, %v, want %q", c.mode, got, err, c.want)
+		}
+	}
+	if got, _ := cleanupCommitMessage(scissorsLine+"\nall cut\n", "scissors"); got != "" {
+		t.Errorf("leading scissors line kept %q", got)
+	}
+	if _, err := cleanupCommitMessage(message, "default"); err == nil {
+		t.Error("cleanupCommitMessage accepted an unknown mode")
+	}
+}
+
+func TestJoinMessageParagraphs(t *testing.T) {
+	cases := []struct {
+		paragraphs []string
+		want       string
+	}{
+		{nil, ""},
+		{[]string{"subject"}, "subject\n"},
+		{[]string{"subject", "body"}, "subject\n\nbody\n"},
+		{[]string{"subject", "line 1\nline 2", "trailer: x"}, "subject\n\nline 1\nline 2\n\ntrailer: x\n"},
+	}
+	for _, c := range cases {
+		if got := joinMessageParagraphs(c.paragraphs); got != c.want {
+			t.Errorf("joinMessageParagraphs(%q) = %q, want %q", c.paragraphs, got, c.want)
+		}
+	}
+}
+
+func TestCommitTreeMessageSources(t *testing.T) {
+	repo, tree, _ := newTreeRepo(t)
+	writeTestFile(t, repo, "msg", "from file\n")
+	cases := []struct {
+		args  []string
+		stdin string
+		want  string
+	}{
+		{[]string{"-m", "one", "-m", "two"}, "", "one\n\ntwo\n"},
+		{nil, "from stdin\n", "from stdin\n"},
+		{[]string{"-F", "-"}, "dash\n", "dash\n"},
+		{[]string{"-m", "subject", "-F", "msg"}, "", "subject\n\nfrom file\n"},
+		{[]string{"--cleanup=strip"}, "  \nkept\n# dropped\n\n", "kept\n"},
+	}
+	for _, c := range cases {
+		result := runMygit(t, repo, c.stdin, append([]string{"commit-tree", tree}, c.args...)...)
+		if result.code != 0 {
+			t.Errorf("commit-tree %v: exit %d\n%s", c.args, result.code, result.stderr)
+			continue
+		}
+		if got := readCommit(repo, strings.TrimSpace(result.stdout)).message; got != c.want {
+			t.Errorf("commit-tree %v message = %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestCommandsRequireRepository(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{{"add", "a"}, {"commit", "-m", "x"}} {
+		result := runMygit(t, dir, "", args...)
+		if result.code != 128 || result.stderr != "fatal: not a git repository (or any of the parent directories): .git\n" {
+			t.Errorf("%v outside a repository = %d %q", args, result.code, result.stderr)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+		t.Errorf("commands outside a repository created .git: %v", err)
+	}
+}
+
+func TestCommitSignoffAndTrailers(t *testing.T) {
+	repo := newCommitRepo(t)
+	signoff := "Signed-off-by: C O Mitter <committer@example.com>\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-s", "--trailer", "Reviewed-by: R <r@x>", "-m", "subject"}, "subject\n\n" + signoff + "Reviewed-by: R <r@x>\n"},
+		// Without -m both are in the message the editor starts from
+		{[]string{"--trailer", "Reviewed-by: R <r@x>", "-s"}, signoff + "Reviewed-by: R <r@x>\n"},
+		{[]string{"-s", "-m", "subject", "-m", signoff}, "subject\n\n" + signoff},
+	}
+	for _, c := range cases {
+		mygit(t, repo, append([]string{"commit", "--allow-empty"}, c.args...)...)
+		if got := readCommit(repo, headCommit(repo)).message; got != c.want {
+			t.Errorf("commit %v message = %q, want %q", c.args, got, c.want)
+		}
+	}
+	result := runMygit(t, repo, "", "commit", "--allow-empty", "-s")
+	if result.code != 1 || result.stderr != "Aborting commit due to empty commit message.\n" {
+		t.Errorf("commit -s with only the sign-off = %d %q", result.code, result.stderr)
+	}
+}
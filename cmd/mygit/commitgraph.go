@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+*  ###################### COMMIT GRAPH ########################
+*
+*  .git/objects/info/commit-graph, or the layers listed base first in
+*  .git/objects/info/commit-graphs/commit-graph-chain, caches the commit
+*  graph along with generation numbers:
+*
+*   C  G  P  H | Version | Hash version | Chunk count | Base layers
+*  43 47 50 48 |   01    |      01      |     nn      |     nn
+*
+*  followed by a table of (4 byte chunk id, 64 bit offset) entries ending
+*  with a zero id, the chunks and a checksum. Chunks used here:
+*    OIDL  the sorted object names of the commits
+*    CDAT  per commit the tree, two parent positions, then 30 bits of
+*          topological level and the 34 bit commit date
+*    GDA2  per commit the 32 bit offset of the corrected commit date from
+*          the commit date, pointing into the 64 bit GDO2 chunk instead
+*          when the top bit is set
+ */
+
+// generationInfinity is the generation of commits missing from the commit
+// graph, which sorts them before every commit found in it.
+const generationInfinity = math.MaxInt64
+
+type commitGraphFile struct {
+	generations map[string]uint64
+	// correctedDates tells whether the generations are corrected commit
+	// dates rather than topological levels, which only holds when every
+	// layer has them.
+	correctedDates bool
+}
+
+// readCommitGraph loads the commit graph of repo, preferring a single
+// commit-graph file over a chain of layers. Repositories without one, or
+// with a corrupt one, have no graph.
+func readCommitGraph(repo string) *commitGraphFile {
+	info := filepath.Join(repo, ".git", "objects", "info")
+	files := []string{filepath.Join(info, "commit-graph")}
+	if _, err := os.Stat(files[0]); err != nil {
+		chain, err := os.ReadFile(filepath.Join(info, "commit-graphs", "commit-graph-chain"))
+		if err != nil {
+			return nil
+		}
+		files = nil
+		for _, name := range strings.Fields(string(chain)) {
+			files = append(files, filepath.Join(info, "commit-graphs", "graph-"+name+".graph"))
+		}
+	}
+	// Every layer has topological levels, which are used instead of the
+	// corrected commit dates when some layer lacks those
+	graph := &commitGraphFile{generations: map[string]uint64{}, correctedDates: true}
+	levels := map[string]uint64{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil || !graph.addLayer(data, levels) {
+			return nil
+		}
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+	if !graph.correctedDates {
+		graph.generations = levels
+	}
+	return graph
+}
+
+func (graph *commitGraphFile) addLayer(data []byte, levels map[string]uint64) bool {
+	if len(data) < 8 || string(data[:4]) != "CGPH" || data[4] != 1 || data[5] != 1 {
+		return false
+	}
+	chunks := map[string][]byte{}
+	for cursor := 8; cursor+24 <= len(data); cursor += 12 {
+		id := string(data[cursor : cursor+4])
+		if id == "\x00\x00\x00\x00" {
+			break
+		}
+		start := binary.BigEndian.Uint64(data[cursor+4:])
+		end := binary.BigEndian.Uint64(data[cursor+16:])
+		if start > end || end > uint64(len(data)) {
+			return false
+		}
+		chunks[id] = data[start:end]
+	}
+	oids, commits := chunks["OIDL"], chunks["CDAT"]
+	count := len(oids) / 20
+	if count*20 != len(oids) || len(commits) != count*36 {
+		return false
+	}
+	offsets, overflow := chunks["GDA2"], chunks["GDO2"]
+	if len(offsets) != count*4 {
+		graph.correctedDates = false
+		offsets = nil
+	}
+	for i := range count {
+		data := commits[i*36+28:]
+		word := binary.BigEndian.Uint32(data)
+		date := uint64(word&3)<<32 | uint64(binary.BigEndian.Uint32(data[4:]))
+		name := hex.EncodeToString(oids[i*20 : i*20+20])
+		levels[name] = uint64(word >> 2)
+		if offsets != nil {
+			offset := uint64(binary.BigEndian.Uint32(offsets[i*4:]))
+			if offset&0x80000000 != 0 {
+				position := int(offset&0x7fffffff) * 8
+				if position+8 > len(overflow) {
+					return false
+				}
+				offset = binary.BigEndian.Uint64(overflow[position:])
+			}
+			graph.generations[name] = date + offset
+		}
+	}
+	return true
+}
+
+// generation returns the generation number of hexHash, or infinity when it
+// is not in the graph.
+func (graph *commitGraphFile) generation(hexHash string) uint64 {
+	if graph == nil {
+		return generationInfinity
+	}
+	if generation, ok := graph.generations[hexHash]; ok {
+		return generation
+	}
+	return generationInfinity
+}
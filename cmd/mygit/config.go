@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// globalConfigFile returns the path of the global config file, which is
+// kept apart from git's own .gitconfig.
+func globalConfigFile() (string, bool) {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(os.Getenv("USERPROFILE"), ".mygitconfig"), true
+	case "linux":
+		return filepath.Join(os.Getenv("HOME"), ".mygitconfig"), true
+	default:
+		return "", false
+	}
+}
+
+// loadGlobalConfig loads the global config file, which is empty when it
+// does not exist.
+func loadGlobalConfig() *ini.File {
+	path, _ := globalConfigFile()
+	config, err := ini.Load(path)
+	if err != nil {
+		return ini.Empty()
+	}
+	return config
+}
+
+// loadRepoConfig loads .git/config of repo, which is empty when the
+// repository has none.
+func loadRepoConfig(repo string) *ini.File {
+	config, err := ini.Load(filepath.Join(repo, ".git", "config"))
+	if err != nil {
+		return ini.Empty()
+	}
+	return config
+}
+
+// configLines is .git/config of a repository as its lines, for editing it
+// the way git does: only the lines of the affected section change, and
+// comments and repeated keys elsewhere in the file are left alone.
+type configLines struct {
+	repo    string
+	lines   []string
+	changed bool
+}
+
+func readConfigLines(repo string) *configLines {
+	content, _ := os.ReadFile(filepath.Join(repo, ".git", "config"))
+	return &configLines{repo: repo, lines: strings.SplitAfter(string(content), "\n")}
+}
+
+// save writes the config back when it was changed.
+func (config *configLines) save() {
+	if !config.changed {
+		return
+	}
+	err := os.WriteFile(filepath.Join(config.repo, ".git", "config"), []byte(strings.Join(config.lines, "")), 0644)
+	exitIfError(err, fmt.Sprintf("fatal: could not write config file .git/config: %s", err))
+}
+
+// removeSection drops every occurrence of section along with its lines,
+// like git config --remove-section.
+func (config *configLines) removeSection(section string) {
+	section = normalizeConfigSection(section)
+	kept, current := []string{}, ""
+	for _, line := range config.lines {
+		if name, _, ok := parseConfigSectionHeader(line); ok {
+			current = name
+		}
+		if current == section {
+			config.changed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	config.lines = kept
+}
+
+// renameSection renames every occurrence of section to newSection, like
+// git config --rename-section.
+func (config *configLines) renameSection(section string, newSection string) {
+	section = normalizeConfigSection(section)
+	for i, line := range config.lines {
+		if name, end, ok := parseConfigSectionHeader(line); ok && name == section {
+			config.lines[i] = line[:strings.Index(line, "[")] + "[" + newSection + "]" + line[end:]
+			config.changed = true
+		}
+	}
+}
+
+// setValue sets section.key to value, replacing the existing value or
+// adding the key to the end of the last occurrence of section, which is
+// appended to the file when there is none.
+func (config *configLines) setValue(section string, key string, value string) {
+	wanted := "\t" + key + " = " + formatConfigValue(value) + "\n"
+	normalized := normalizeConfigSection(section)
+	kept, current, insertAt, found := []string{}, "", -1, false
+	for _, line := range config.lines {
+		if name, _, ok := parseConfigSectionHeader(line); ok {
+			current = name
+			if current == normalized {
+				insertAt = len(kept) + 1
+			}
+		} else if name, ok := parseConfigKey(line); ok && current == normalized {
+			if strings.EqualFold(name, key) {
+				if found {
+					config.changed = true
+					continue
+				}
+				found = true
+				if line != wanted {
+					line = wanted
+					config.changed = true
+				}
+			}
+			insertAt = len(kept) + 1
+		}
+		kept = append(kept, line)
+	}
+	config.lines = kept
+	if found {
+		return
+	}
+	config.changed = true
+	if insertAt >= 0 {
+		config.lines = append(config.lines[:insertAt], append([]string{wanted}, config.lines[insertAt:]...)...)
+		return
+	}
+	if last := config.lines[len(config.lines)-1]; last != "" && !strings.HasSuffix(last, "\n") {
+		config.lines[len(config.lines)-1] += "\n"
+	}
+	config.lines = append(config.lines, "["+section+"]\n", wanted)
+}
+
+// unsetValue removes every value of section.key. Like in git, the section
+// header stays even when no keys are left.
+func (config *configLines) unsetValue(section string, key string) {
+	section = normalizeConfigSection(section)
+	kept, current := []string{}, ""
+	for _, line := range config.lines {
+		if name, _, ok := parseConfigSectionHeader(line); ok {
+			current = name
+		} else if name, ok := parseConfigKey(line); ok && current == section && strings.EqualFold(name, key) {
+			config.changed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	config.lines = kept
+}
+
+// normalizeConfigSection lowercases the section part of a name such as
+// branch "Topic", leaving the case sensitive subsection as it is.
+func normalizeConfigSection(section string) string {
+	name, subsection, found := strings.Cut(section, " ")
+	if !found {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + " " + subsection
+}
+
+// parseConfigSectionHeader parses a [section "subsection"] header line,
+// returning the section in the form normalizeConfigSection produces along
+// with the offset just past the closing bracket. The deprecated
+// [section.subsection] form is understood as well.
+func parseConfigSectionHeader(line string) (string, int, bool) {
+	start := strings.Index(line, "[")
+	if start < 0 || strings.TrimSpace(line[:start]) != "" {
+		return "", 0, false
+	}
+	i := start + 1
+	for i < len(line) && (isAlphanumeric(line[i]) || line[i] == '-') {
+		i++
+	}
+	name := strings.ToLower(line[start+1 : i])
+	if i < len(line) && line[i] == ']' {
+		return name, i + 1, name != ""
+	}
+	if i < len(line) && line[i] == '.' {
+		end := strings.Index(line[i:], "]")
+		if end < 0 {
+			return "", 0, false
+		}
+		return name + ` "` + strings.ToLower(line[i+1:i+end]) + `"`, i + end + 1, true
+	}
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	if i >= len(line) || line[i] != '"' {
+		return "", 0, false
+	}
+	var subsection strings.Builder
+	for i++; i < len(line) && line[i] != '"'; i++ {
+		if line[i] == '\\' && i+1 < len(line) {
+			i++
+		}
+		subsection.WriteByte(line[i])
+	}
+	if i+1 >= len(line) || line[i+1] != ']' {
+		return "", 0, false
+	}
+	return name + ` "` + subsection.String() + `"`, i + 2, true
+}
+
+// parseConfigKey returns the name of the key set on line, if any.
+func parseConfigKey(line string) (string, bool) {
+	line = strings.TrimLeft(line, " \t")
+	end := 0
+	for end < len(line) && (isAlphanumeric(line[end]) || line[end] == '-') {
+		end++
+	}
+	return line[:end], end > 0
+}
+
+// formatConfigValue quotes value as git does when writing it to a config
+// file.
+func formatConfigValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if strings.TrimSpace(value) != value || strings.ContainsAny(value, "#;") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+// configValue looks section.key up in the repository config first and in
+// the global config after that, the same precedence git uses. Like in git,
+// names are matched case insensitively, so user.signingkey finds
+// user.signingKey.
+func configValue(repo string, global *ini.File, section string, key string) string {
+	for _, config := range []*ini.File{loadRepoConfig(repo), global} {
+		for _, candidate := range config.Sections() {
+			if !strings.EqualFold(candidate.Name(), section) {
+				continue
+			}
+			for _, candidateKey := range candidate.Keys() {
+				if strings.EqualFold(candidateKey.Name(), key) {
+					return candidateKey.String()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// configBool interprets section.key as a git boolean.
+func configBool(repo string, global *ini.File, section string, key string) bool {
+	switch strings.ToLower(configValue(repo, global, section, key)) {
+	case "true", "yes", "on", "1":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,845 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+/*
+*  ###################### DIFF ##############################
+*
+*  Files are compared between two of a tree, the index and the working
+*  tree, and every differing file is printed as a git style patch:
+*
+*  diff --git a/<path> b/<path>
+*  [old mode/new mode, new file mode or deleted file mode lines]
+*  index <old>..<new>[ <mode>]
+*  --- a/<path>
+*  +++ b/<path>
+*  @@ -<start>,<count> +<start>,<count> @@ <function line>
+*  ...
+ */
+
+const (
+	colorReset      = "\x1b[m"
+	colorMeta       = "\x1b[1m"
+	colorFrag       = "\x1b[36m"
+	colorOld        = "\x1b[31m"
+	colorNew        = "\x1b[32m"
+	colorWhitespace = "\x1b[41m"
+)
+
+// binaryCheckSize is how far into a file git looks for a NUL byte to
+// decide whether it is binary.
+const binaryCheckSize = 8000
+
+// diffOutput is the set of formats changes are printed in.
+type diffOutput int
+
+const (
+	outputPatch diffOutput = 1 << iota
+	outputRaw
+	outputNameOnly
+	outputNameStatus
+	outputNumstat
+	outputStat
+	outputShortstat
+	outputDirstat
+	outputSummary
+	outputNone
+)
+
+// diffFlags are the options diff, diff-tree and log share for picking the
+// output formats and detecting renames.
+type diffFlags struct {
+	Patch            bool   `short:"p" long:"patch" description:"Show patches"`
+	PatchU           bool   `short:"u" description:"Same as -p"`
+	NoPatch          bool   `short:"s" long:"no-patch" description:"Show no changes"`
+	Raw              bool   `long:"raw" description:"List changes in the raw format"`
+	NameOnly         bool   `long:"name-only" description:"Only list the names of changed files"`
+	NameStatus       bool   `long:"name-status" description:"List the names and status of changed files"`
+	Stat             string `long:"stat" optional:"yes" optional-value:"default" description:"Show a histogram of changes per file, limited to <width>[,<name-width>[,<count>]]"`
+	StatWidth        int    `long:"stat-width" description:"Limit the width of --stat"`
+	StatNameWidth    int    `long:"stat-name-width" description:"Limit the width of file names in --stat"`
+	StatGraphWidth   int    `long:"stat-graph-width" description:"Limit the width of the bars in --stat"`
+	StatCount        int    `long:"stat-count" description:"Limit --stat to the first <n> files"`
+	Numstat          bool   `long:"numstat" description:"Show lines added and deleted per file, tab separated"`
+	Shortstat        bool   `long:"shortstat" description:"Only show the totals of --stat"`
+	Dirstat          string `short:"X" long:"dirstat" optional:"yes" optional-value:"default" description:"Show the share of changes per directory, with changes, lines, files, cumulative or a limit in percent"`
+	Summary          bool   `long:"summary" description:"List created, deleted, renamed and mode changed files"`
+	PatchWithStat    bool   `long:"patch-with-stat" description:"Same as -p --stat"`
+	FindRenames      string `short:"M" long:"find-renames" optional:"yes" optional-value:"default" description:"Detect renames, optionally with a minimum similarity"`
+	FindCopies       string `short:"C" long:"find-copies" optional:"yes" optional-value:"default" description:"Detect copies as well as renames"`
+	FindCopiesHarder bool   `long:"find-copies-harder" description:"Also look for copies of unmodified files"`
+	NoRenames        bool   `long:"no-renames" description:"Do not detect renames"`
+}
+
+// output picks the formats asked for, fallback when there are none. The
+// name lists and -s replace all other formats.
+func (flags diffFlags) output(fallback diffOutput) (diffOutput, error) {
+	output := diffOutput(0)
+	for format, on := range map[diffOutput]bool{
+		outputPatch:      flags.Patch || flags.PatchU || flags.PatchWithStat,
+		outputRaw:        flags.Raw,
+		outputNameOnly:   flags.NameOnly,
+		outputNameStatus: flags.NameStatus,
+		outputNumstat:    flags.Numstat,
+		outputStat:       flags.Stat != "" || flags.PatchWithStat || flags.StatWidth > 0 || flags.StatNameWidth > 0 || flags.StatGraphWidth > 0 || flags.StatCount > 0,
+		outputShortstat:  flags.Shortstat,
+		outputDirstat:    flags.Dirstat != "",
+		outputSummary:    flags.Summary,
+		outputNone:       flags.NoPatch,
+	} {
+		if on {
+			output |= format
+		}
+	}
+	exclusive := output & (outputNameOnly | outputNameStatus | outputNone)
+	switch {
+	case exclusive&(exclusive-1) != 0:
+		return 0, fmt.Errorf("options '--name-only', '--name-status' and '-s' cannot be used together")
+	case exclusive != 0:
+		return exclusive, nil
+	case output == 0:
+		return fallback, nil
+	}
+	return output, nil
+}
+
+// renames reads the rename options, detecting renames as detect says when
+// none are given.
+func (flags diffFlags) renames(detect renameDetection) (renameOptions, error) {
+	if flags.NoRenames {
+		detect = noRenames
+	}
+	return renameFlags(detect, flags.FindRenames, flags.FindCopies, flags.FindCopiesHarder)
+}
+
+// statOptions reads the limits of --stat and the parameters of --dirstat,
+// on top of the diff.statGraphWidth and diff.dirstat settings.
+func (flags diffFlags) statOptions(repo string, global *ini.File) (statOptions, error) {
+	options := statOptions{dirstat: defaultDirstatOptions}
+	if width, err := strconv.Atoi(configValue(repo, global, "diff", "statGraphWidth")); err == nil {
+		options.graphWidth = width
+	}
+	if err := parseDirstatParams(configValue(repo, global, "diff", "dirstat"), &options.dirstat); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: Found errors in 'diff.dirstat' config variable:\n  %s\n\n", err)
+	}
+	if flags.Stat != "" && flags.Stat != "default" {
+		if err := parseStatWidths(flags.Stat, &options); err != nil {
+			return options, err
+		}
+	}
+	for _, limit := range []struct{ flag, option *int }{
+		{&flags.StatWidth, &options.width},
+		{&flags.StatNameWidth, &options.nameWidth},
+		{&flags.StatGraphWidth, &options.graphWidth},
+		{&flags.StatCount, &options.count},
+	} {
+		if *limit.flag > 0 {
+			*limit.option = *limit.flag
+		}
+	}
+	if flags.Dirstat != "" && flags.Dirstat != "default" {
+		if err := parseDirstatParams(flags.Dirstat, &options.dirstat); err != nil {
+			return options, fmt.Errorf("Failed to parse --dirstat/-X option parameter:\n  %s\n", err)
+		}
+	}
+	return options, nil
+}
+
+type diffOptions struct {
+	lineDiffOptions
+	context int
+	color   bool
+	output  diffOutput
+	stat    statOptions
+	// fullIndex prints whole object names in the raw output.
+	fullIndex bool
+}
+
+// diffSide is one version of a file. perm is empty when the file does not
+// exist on that side, and worktree is set when the content has to be read
+// from the working tree rather than the object store.
+type diffSide struct {
+	perm     ObjectPerm
+	sha      [20]byte
+	worktree bool
+}
+
+func (side diffSide) exists() bool {
+	return side.perm != ""
+}
+
+func (side diffSide) content(repo string, name string) []byte {
+	switch {
+	case !side.exists():
+		return nil
+	case side.perm == GITLINK:
+		return []byte("Subproject commit " + hex.EncodeToString(side.sha[:]) + "\n")
+	case side.worktree && side.perm == SYMLINK:
+		target, err := os.Readlink(worktreePath(repo, name))
+		exitIfError(err, fmt.Sprintf("fatal: unable to read '%s': %s", name, err))
+		return []byte(target)
+	case side.worktree:
+		content, err := os.ReadFile(worktreePath(repo, name))
+		exitIfError(err, fmt.Sprintf("fatal: unable to read '%s': %s", name, err))
+		return content
+	}
+	return readBlob(repo, hex.EncodeToString(side.sha[:]))
+}
+
+// filePair is a file that differs between the two sides of a comparison.
+// status is git's letter for the change: A, D, M, T for a change of file
+// type, R and C for renames and copies from oldName, and U for unmerged
+// files of the index, which only have the sides found outside the index.
+type filePair struct {
+	name     string
+	oldName  string
+	old, new diffSide
+	status   byte
+	score    int
+}
+
+// sourceName is the path of the old side of the pair.
+func (pair filePair) sourceName() string {
+	if pair.oldName != "" {
+		return pair.oldName
+	}
+	return pair.name
+}
+
+// pairStatus returns the status of a file going from old to new, zero when
+// it is unchanged.
+func pairStatus(old diffSide, new diffSide) byte {
+	switch {
+	case !old.exists():
+		return 'A'
+	case !new.exists():
+		return 'D'
+	case old.perm == new.perm && old.sha == new.sha:
+		return 0
+	case fileKind(old.perm) != fileKind(new.perm):
+		return 'T'
+	}
+	return 'M'
+}
+
+// treeSides describes the files of a flattened tree.
+func treeSides(files map[string]treeFile) map[string]diffSide {
+	sides := map[string]diffSide{}
+	for name, file := range files {
+		sides[name] = diffSide{perm: file.perm, sha: file.sha}
+	}
+	return sides
+}
+
+// indexSides describes the stage zero entries of the index and returns the
+// paths that are unmerged separately.
+func indexSides(entries []indexEntry) (map[string]diffSide, []string) {
+	sides := map[string]diffSide{}
+	unmerged := []string{}
+	for _, entry := range entries {
+		if entry.stage != 0 {
+			if len(unmerged) == 0 || unmerged[len(unmerged)-1] != entry.name {
+				unmerged = append(unmerged, entry.name)
+			}
+			continue
+		}
+		sides[entry.name] = diffSide{perm: permFromIndexMode(entry.mode), sha: entry.sha}
+	}
+	return sides, unmerged
+}
+
+// worktreeSides describes the working tree files of the index entries.
+// Files whose stat data matches the index keep the object name it records,
+// the others are hashed.
+func worktreeSides(repo string, entries []indexEntry) map[string]diffSide {
+	sides := map[string]diffSide{}
+	for _, entry := range entries {
+		if _, ok := sides[entry.name]; ok {
+			continue
+		}
+		if entry.stage == 0 && !worktreeChanged(repo, entry) {
+			sides[entry.name] = diffSide{perm: permFromIndexMode(entry.mode), sha: entry.sha}
+			continue
+		}
+		info, err := os.Lstat(worktreePath(repo, entry.name))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		perm, sha := hashWorktreeFile(repo, entry.name, info)
+		sides[entry.name] = diffSide{perm: perm, sha: sha, worktree: true}
+	}
+	return sides
+}
+
+// fileKind groups modes that can change into each other; anything else is
+// shown as a deletion followed by an addition.
+func fileKind(perm ObjectPerm) ObjectPerm {
+	if perm == EXE {
+		return FILE
+	}
+	return perm
+}
+
+// pairFiles lists the files selected by pathspecs that differ between old
+// and new, sorted by path, along with the unchanged ones when unmodified is
+// set. The unmerged paths of the index are listed in their place when the
+// index is one of the sides.
+func pairFiles(old map[string]diffSide, new map[string]diffSide, unmerged []string, pathspecs []string, unmodified bool) []filePair {
+	names := append([]string{}, unmerged...)
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pairs := []filePair{}
+	for _, name := range names {
+		if len(pathspecs) > 0 && !matchesAnyPathspec(name, pathspecs) {
+			continue
+		}
+		if slices.Contains(unmerged, name) {
+			if len(pairs) == 0 || pairs[len(pairs)-1].name != name {
+				pairs = append(pairs, filePair{name: name, old: old[name], new: new[name], status: 'U'})
+			}
+			continue
+		}
+		pair := filePair{name: name, old: old[name], new: new[name]}
+		if pair.status = pairStatus(pair.old, pair.new); pair.status != 0 || unmodified {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func matchesAnyPathspec(name string, pathspecs []string) bool {
+	for _, spec := range pathspecs {
+		if pathspecMatches(name, spec) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binaryCheckSize)], 0) >= 0
+}
+
+// quotePath quotes name the way git does for paths with control
+// characters, quotes, backslashes or bytes outside ASCII.
+func quotePath(name string) string {
+	needsQuoting := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x7f {
+			needsQuoting = true
+			break
+		}
+	}
+	if !needsQuoting {
+		return name
+	}
+	escapes := map[byte]string{'\a': `\a`, '\b': `\b`, '\t': `\t`, '\n': `\n`, '\v': `\v`, '\f': `\f`, '\r': `\r`, '"': `\"`, '\\': `\\`}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if escape, ok := escapes[c]; ok {
+			quoted.WriteString(escape)
+		} else if c < 0x20 || c >= 0x7f {
+			fmt.Fprintf(&quoted, "\\%03o", c)
+		} else {
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// wantColor decides whether to color output for --color=<when>, falling
+// back to color.diff and color.ui. Like in git, true and auto only color
+// output to a terminal.
+func wantColor(repo string, global *ini.File, when string) bool {
+	for _, key := range [][2]string{{"color", "diff"}, {"color", "ui"}} {
+		if when == "" {
+			when = configValue(repo, global, key[0], key[1])
+		}
+	}
+	switch strings.ToLower(when) {
+	case "always":
+		return true
+	case "never", "false", "no", "off", "0":
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+func abbreviatedSide(side diffSide) string {
+	return hex.EncodeToString(side.sha[:])[:7]
+}
+
+// patchWriter prints patches, coloring them when asked to.
+type patchWriter struct {
+	out     *bufio.Writer
+	options diffOptions
+}
+
+func (writer *patchWriter) paint(color string) (string, string) {
+	if !writer.options.color {
+		return "", ""
+	}
+	return color, colorReset
+}
+
+func (writer *patchWriter) meta(line string) {
+	set, reset := writer.paint(colorMeta)
+	fmt.Fprintf(writer.out, "%s%s%s\n", set, line, reset)
+}
+
+// writeDiff prints pairs in every output format of the options, in the
+// order git uses, and reports whether there were changes. Patches are set
+// apart from the formats before them by an empty line.
+func (writer *patchWriter) writeDiff(repo string, pairs []filePair) bool {
+	output := writer.options.output
+	if len(pairs) == 0 || output&outputNone != 0 {
+		return len(pairs) > 0
+	}
+	changed, separate := false, false
+	if output&(outputRaw|outputNameOnly|outputNameStatus) != 0 {
+		for _, pair := range pairs {
+			writer.writeListing(pair)
+		}
+		changed, separate = true, true
+	}
+	dirstatByLines := output&outputDirstat != 0 && writer.options.stat.dirstat.by == "lines"
+	var stats []fileStat
+	if output&(outputNumstat|outputStat|outputShortstat) != 0 || dirstatByLines {
+		stats = writer.fileStats(repo, pairs)
+		if output&outputNumstat != 0 {
+			writer.writeNumstat(stats)
+		}
+		if output&outputStat != 0 {
+			writer.writeStat(stats)
+		}
+		if output&outputShortstat != 0 {
+			writer.writeShortstat(stats)
+		}
+		changed = changed || len(stats) > 0
+		separate = true
+	}
+	if output&outputDirstat != 0 {
+		writer.writeDirstat(repo, pairs, stats)
+		changed = true
+	}
+	if output&outputSummary != 0 {
+		for _, pair := range pairs {
+			writer.writeSummary(pair)
+		}
+		changed, separate = true, true
+	}
+	if output&outputPatch != 0 {
+		if separate {
+			fmt.Fprint(writer.out, "\n")
+		}
+		for _, pair := range pairs {
+			if writer.writePatch(repo, pair) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// writeListing prints pair in the raw, name-only or name-status format.
+func (writer *patchWriter) writeListing(pair filePair) {
+	switch {
+	case writer.options.output&outputNameOnly != 0:
+		fmt.Fprintln(writer.out, quotePath(pair.name))
+	case writer.options.output&outputNameStatus != 0:
+		fmt.Fprintf(writer.out, "%s\t%s\n", statusField(pair), pairPaths(pair))
+	default:
+		fmt.Fprintf(writer.out, ":%s %s %s %s %s\t%s\n", rawMode(pair.old), rawMode(pair.new),
+			writer.rawObjectName(pair.old), writer.rawObjectName(pair.new), statusField(pair), pairPaths(pair))
+	}
+}
+
+func rawMode(side diffSide) string {
+	if !side.exists() {
+		return "000000"
+	}
+	return strings.Repeat("0", max(6-len(side.perm), 0)) + string(side.perm)
+}
+
+// rawObjectName names the object of side, or zeros when it is missing or
+// a working tree file that was not hashed into the object store.
+func (writer *patchWriter) rawObjectName(side diffSide) string {
+	name := hex.EncodeToString(side.sha[:])
+	if !side.exists() || side.worktree {
+		name = strings.Repeat("0", 40)
+	}
+	if !writer.options.fullIndex {
+		return name[:7]
+	}
+	return name
+}
+
+// statusField is the status letter of pair, followed by the similarity of
+// renames and copies.
+func statusField(pair filePair) string {
+	if pair.status == 'R' || pair.status == 'C' {
+		return fmt.Sprintf("%c%03d", pair.status, similarityPercent(pair.score))
+	}
+	return string(pair.status)
+}
+
+func pairPaths(pair filePair) string {
+	if pair.oldName != "" {
+		return quotePath(pair.oldName) + "\t" + quotePath(pair.name)
+	}
+	return quotePath(pair.name)
+}
+
+func similarityPercent(score int) int {
+	return score * 100 / maxSimilarityScore
+}
+
+// writePatch prints the patch for one file pair and reports whether it
+// printed anything. The header of a file whose only changes are ignored
+// whitespace is left out, unless it is added, deleted, renamed, copied or
+// changes mode.
+func (writer *patchWriter) writePatch(repo string, pair filePair) bool {
+	switch pair.status {
+	case 'U':
+		fmt.Fprintf(writer.out, "* Unmerged path %s\n", quotePath(pair.name))
+		return true
+	case 'T':
+		writer.writePatch(repo, filePair{name: pair.name, old: pair.old, status: 'D'})
+		writer.writePatch(repo, filePair{name: pair.name, new: pair.new, status: 'A'})
+		return true
+	}
+	from, to := quotePath("a/"+pair.sourceName()), quotePath("b/"+pair.name)
+	header := []string{"diff --git " + from + " " + to}
+	mustShowHeader := true
+	switch {
+	case !pair.old.exists():
+		header = append(header, "new file mode "+string(pair.new.perm))
+		from = "/dev/null"
+	case !pair.new.exists():
+		header = append(header, "deleted file mode "+string(pair.old.perm))
+		to = "/dev/null"
+	case pair.old.perm != pair.new.perm:
+		header = append(header, "old mode "+string(pair.old.perm), "new mode "+string(pair.new.perm))
+	default:
+		mustShowHeader = false
+	}
+	switch pair.status {
+	case 'R', 'C':
+		verb := map[byte]string{'R': "rename", 'C': "copy"}[pair.status]
+		header = append(header,
+			fmt.Sprintf("similarity index %d%%", similarityPercent(pair.score)),
+			verb+" from "+quotePath(pair.oldName),
+			verb+" to "+quotePath(pair.name))
+		mustShowHeader = true
+	}
+	if pair.old.sha != pair.new.sha {
+		index := "index " + abbreviatedSide(pair.old) + ".." + abbreviatedSide(pair.new)
+		if pair.old.perm == pair.new.perm {
+			index += " " + string(pair.old.perm)
+		}
+		header = append(header, index)
+	}
+	showHeader := func() {
+		for _, line := range header {
+			writer.meta(line)
+		}
+	}
+	if pair.old.sha == pair.new.sha {
+		showHeader()
+		return true
+	}
+
+	oldContent, newContent := pair.old.content(repo, pair.sourceName()), pair.new.content(repo, pair.name)
+	if isBinaryContent(oldContent) || isBinaryContent(newContent) {
+		showHeader()
+		fmt.Fprintf(writer.out, "Binary files %s and %s differ\n", from, to)
+		return true
+	}
+	oldLines, newLines := splitLines(oldContent), splitLines(newContent)
+	changes := diffLines(oldLines, newLines, writer.options.lineDiffOptions)
+	if len(changes) == 0 {
+		if mustShowHeader {
+			showHeader()
+		}
+		return mustShowHeader
+	}
+	showHeader()
+	// Names with spaces end in a tab, so the name can be told apart from
+	// anything following it.
+	nameEnd := func(name string) string {
+		if strings.Contains(name, " ") {
+			return name + "\t"
+		}
+		return name
+	}
+	writer.meta("--- " + nameEnd(from))
+	writer.meta("+++ " + nameEnd(to))
+	writer.writeHunks(oldLines, newLines, changes, newBlankAtEOF(oldContent, newContent))
+	return true
+}
+
+// blankAtEOF gives the first line, counted from one, of the blank lines a
+// change adds at the end of a file, on either side. They are zero when no
+// such lines are added.
+type blankAtEOF struct {
+	preimage, postimage int
+}
+
+func newBlankAtEOF(oldContent []byte, newContent []byte) blankAtEOF {
+	oldBlank, newBlank := countTrailingBlank(oldContent), countTrailingBlank(newContent)
+	if newBlank <= oldBlank {
+		return blankAtEOF{}
+	}
+	return blankAtEOF{
+		preimage:  len(splitLines(oldContent)) - oldBlank + 1,
+		postimage: len(splitLines(newContent)) - newBlank + 1,
+	}
+}
+
+// countTrailingBlank counts the whitespace only lines ending content. Like
+// git, it never counts a first line shorter than two characters.
+func countTrailingBlank(content []byte) int {
+	count := 0
+	if len(content) == 0 {
+		return count
+	}
+	end := len(content) - 1
+	if content[end] == '\n' {
+		end--
+	}
+	for end > 0 {
+		eol := end
+		for eol >= 0 && content[eol] != '\n' {
+			eol--
+		}
+		if !isBlankLine(content[eol+1 : end+1]) {
+			break
+		}
+		count++
+		end = eol - 1
+	}
+	return count
+}
+
+func isBlankLine(line []byte) bool {
+	for _, c := range line {
+		if !isXdiffSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// hunkRange formats one side of a hunk header, where an empty range names
+// the line before it and a single line leaves out the count. It also
+// returns the line number it printed.
+func hunkRange(start int, count int) (string, int) {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return strconv.Itoa(start), start
+	}
+	return strconv.Itoa(start) + "," + strconv.Itoa(count), start
+}
+
+// functionLine returns the part of line git shows after a hunk header: a
+// line starting with a letter, "_" or "$", cut to 80 bytes.
+func functionLine(line string) (string, bool) {
+	if line == "" {
+		return "", false
+	}
+	c := line[0]
+	if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$') {
+		return "", false
+	}
+	line = line[:min(len(line), 80)]
+	for line != "" && isXdiffSpace(line[len(line)-1]) {
+		line = line[:len(line)-1]
+	}
+	return line, true
+}
+
+// writeHunks groups changes that are at most twice the context apart into
+// hunks and prints them.
+func (writer *patchWriter) writeHunks(a []string, b []string, changes []lineChange, blank blankAtEOF) {
+	context := writer.options.context
+	function, functionSearched := "", -1
+	for first := 0; first < len(changes); {
+		last := first
+		for last+1 < len(changes) && changes[last+1].i1-(changes[last].i1+changes[last].chg1) <= 2*context {
+			last++
+		}
+		start, end := changes[first], changes[last]
+		s1, s2 := max(start.i1-context, 0), max(start.i2-context, 0)
+		trailing := min(context, len(a)-(end.i1+end.chg1), len(b)-(end.i2+end.chg2))
+		e1, e2 := end.i1+end.chg1+trailing, end.i2+end.chg2+trailing
+
+		// The function line is looked for upwards from the line before the
+		// hunk down to where the previous hunk's search started, and is
+		// kept when none is found
+		for l := s1 - 1; l > functionSearched && l >= 0; l-- {
+			if line, ok := functionLine(a[l]); ok {
+				function = line
+				break
+			}
+		}
+		functionSearched = s1 - 1
+
+		oldRange, preimage := hunkRange(s1+1, e1-s1)
+		newRange, postimage := hunkRange(s2+1, e2-s2)
+		writer.hunkHeader("@@ -"+oldRange+" +"+newRange+" @@", function)
+		contextLine := func(line string) {
+			preimage++
+			postimage++
+			writer.contextLine(line)
+		}
+		for ; s2 < start.i2; s2++ {
+			contextLine(b[s2])
+		}
+		s1, s2 = start.i1, start.i2
+		for _, change := range changes[first : last+1] {
+			for ; s1 < change.i1 && s2 < change.i2; s1, s2 = s1+1, s2+1 {
+				contextLine(b[s2])
+			}
+			for s1 = change.i1; s1 < change.i1+change.chg1; s1++ {
+				preimage++
+				writer.removedLine(a[s1])
+			}
+			for s2 = change.i2; s2 < change.i2+change.chg2; s2++ {
+				postimage++
+				atEOF := blank.preimage != 0 && blank.postimage != 0 &&
+					blank.preimage <= preimage && blank.postimage <= postimage
+				writer.addedLine(b[s2], atEOF && isBlankLine([]byte(b[s2])))
+			}
+		}
+		for s2 = end.i2 + end.chg2; s2 < e2; s2++ {
+			contextLine(b[s2])
+		}
+		first = last + 1
+	}
+}
+
+func (writer *patchWriter) hunkHeader(header string, function string) {
+	set, reset := writer.paint(colorFrag)
+	line := set + header + reset
+	if function != "" {
+		line += " " + reset + function + reset
+	}
+	fmt.Fprintf(writer.out, "%s\n", line)
+}
+
+// splitLineEnd separates the end of line, a newline that may follow a
+// carriage return, from its text.
+func splitLineEnd(line string) (string, string) {
+	text, hasNewline := strings.CutSuffix(line, "\n")
+	if !hasNewline {
+		return text, ""
+	}
+	if text, hasReturn := strings.CutSuffix(text, "\r"); hasReturn {
+		return text, "\r\n"
+	}
+	return text, "\n"
+}
+
+// writeLine prints a line of a hunk the way git does: set colors the sign
+// and the text, and the reset goes before the end of the line. A missing
+// newline is pointed out.
+func (writer *patchWriter) writeLine(set string, sign string, line string) {
+	text, lineEnd := splitLineEnd(line)
+	_, reset := writer.paint("")
+	fmt.Fprintf(writer.out, "%s%s%s%s%s", set, sign, text, reset, lineEnd)
+	writer.noNewlineMarker(lineEnd)
+}
+
+func (writer *patchWriter) noNewlineMarker(lineEnd string) {
+	if lineEnd == "" {
+		_, reset := writer.paint("")
+		fmt.Fprintf(writer.out, "\n\\ No newline at end of file%s\n", reset)
+	}
+}
+
+func (writer *patchWriter) contextLine(line string) {
+	writer.writeLine("", " ", line)
+}
+
+func (writer *patchWriter) removedLine(line string) {
+	set, _ := writer.paint(colorOld)
+	writer.writeLine(set, "-", line)
+}
+
+// addedLine prints an added line with its whitespace errors highlighted.
+// Blank lines added at the end of the file are highlighted whole.
+func (writer *patchWriter) addedLine(line string, blankAtEOF bool) {
+	if !writer.options.color {
+		writer.writeLine("", "+", line)
+		return
+	}
+	if blankAtEOF {
+		writer.writeLine(colorWhitespace, "+", line)
+		return
+	}
+	fmt.Fprintf(writer.out, "%s+%s", colorNew, colorReset)
+	writeWhitespaceErrors(writer.out, line, colorNew)
+	_, lineEnd := splitLineEnd(line)
+	writer.noNewlineMarker(lineEnd)
+}
+
+// writeWhitespaceErrors prints line in color, highlighting the whitespace
+// git's default core.whitespace rules complain about: trailing whitespace
+// and spaces before a tab in the indentation.
+func writeWhitespaceErrors(out io.Writer, line string, color string) {
+	text, hasNewline := strings.CutSuffix(line, "\n")
+	trailing := len(text)
+	for trailing > 0 && isXdiffSpace(text[trailing-1]) {
+		trailing--
+	}
+	written := 0
+	for i := 0; i < trailing; i++ {
+		if text[i] == ' ' {
+			continue
+		}
+		if text[i] != '\t' {
+			break
+		}
+		if written < i {
+			fmt.Fprintf(out, "%s%s%s\t", colorWhitespace, text[written:i], colorReset)
+		} else {
+			fmt.Fprint(out, "\t")
+		}
+		written = i + 1
+	}
+	if trailing > written {
+		fmt.Fprintf(out, "%s%s%s", color, text[written:trailing], colorReset)
+	}
+	if trailing < len(text) {
+		fmt.Fprintf(out, "%s%s%s", colorWhitespace, text[trailing:], colorReset)
+	}
+	if hasNewline {
+		fmt.Fprint(out, "\n")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteHunks(t *testing.T) {
+	a := splitLines([]byte("int main()\n{\n  a;\n  b;\n  c;\n  d;\n  e;\n}\n"))
+	b := splitLines([]byte("int main()\n{\n  a;\n  B;  \n  c;\n  d;\n  e;\n}\nnoeol"))
+	var out bytes.Buffer
+	writer := &patchWriter{out: bufio.NewWriter(&out), options: diffOptions{lineDiffOptions: lineDiffOptions{algorithm: "myers"}, context: 1}}
+	writer.writeHunks(a, b, diffLines(a, b, writer.options.lineDiffOptions), blankAtEOF{})
+	writer.out.Flush()
+	want := "@@ -3,3 +3,3 @@ int main()\n" +
+		"   a;\n" +
+		"-  b;\n" +
+		"+  B;  \n" +
+		"   c;\n" +
+		"@@ -8 +8,2 @@ int main()\n" +
+		" }\n" +
+		"+noeol\n" +
+		"\\ No newline at end of file\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	writer.options.color = true
+	writer.addedLine(" \tx \n", false)
+	writer.out.Flush()
+	if want := "\x1b[32m+\x1b[m\x1b[41m \x1b[m\t\x1b[32mx\x1b[m\x1b[41m \x1b[m\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	cases := map[string]string{
+		"a/plain.txt": "a/plain.txt",
+		"a/tab\tname": `"a/tab\tname"`,
+		"a/é.txt":     `"a/\303\251.txt"`,
+		`a/"q"`:       `"a/\"q\""`,
+	}
+	for name, want := range cases {
+		if got := quotePath(name); got != want {
+			t.Errorf("quotePath(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestDiffWorktreeModeChange(t *testing.T) {
+	repo := newCommitRepo(t)
+	if err := os.Chmod(filepath.Join(repo, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, repo, "b", "b\nc\n")
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, "diff --git a/a b/a\nold mode 100644\nnew mode 100755\n" +
+			"diff --git a/b b/b\nindex 6178079..9ddeb5c 100644\n--- a/b\n+++ b/b\n@@ -1 +1,2 @@\n b\n+c\n"},
+		{[]string{"--stat"}, " a | 0\n b | 1 +\n 2 files changed, 1 insertion(+)\n"},
+		{[]string{"--shortstat"}, " 2 files changed, 1 insertion(+)\n"},
+	}
+	for _, c := range cases {
+		if got := mygit(t, repo, append([]string{"diff"}, c.args...)...); got != c.want {
+			t.Errorf("diff %v =\n%s\nwant\n%s", c.args, got, c.want)
+		}
+	}
+}
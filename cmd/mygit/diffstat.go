@@ -0,0 +1,482 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+*  ###################### DIFFSTAT ##############################
+*
+*  Summaries of the changes to each file:
+*
+*  --stat       " <name> | <changes> <bar of + and ->" per file, the bars
+*               scaled to fit the terminal, then a line of totals
+*  --numstat    "<added>\t<deleted>\t<name>" per file, "-\t-" for binary files
+*  --shortstat  only the line of totals
+*  --dirstat    the share of the changes made below each directory
+*  --summary    the files created, deleted, renamed or copied and the mode
+*               changes
+*
+*  Binary files count bytes instead of lines and show as
+*  "Bin <old size> -> <new size> bytes".
+ */
+
+type statOptions struct {
+	// width, nameWidth and graphWidth limit the --stat lines, zero meaning
+	// the width of the terminal and no limits on the name and graph.
+	width, nameWidth, graphWidth int
+	// count limits the number of files listed, zero meaning all.
+	count int
+	// prefixWidth is taken from the width of the terminal for text printed
+	// in front of every line, like the graph of log.
+	prefixWidth int
+	dirstat     dirstatOptions
+}
+
+type dirstatOptions struct {
+	// by is "changes", "lines" or "files".
+	by         string
+	permille   int
+	cumulative bool
+}
+
+var defaultDirstatOptions = dirstatOptions{by: "changes", permille: 30}
+
+// parseStatWidths reads the <width>[,<name-width>[,<count>]] argument of
+// --stat.
+func parseStatWidths(value string, options *statOptions) error {
+	for i, field := range strings.Split(value, ",") {
+		if i > 2 {
+			return fmt.Errorf("invalid --stat value: %s", value)
+		}
+		if field == "" && i > 0 {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid --stat value: %s", value)
+		}
+		*[]*int{&options.width, &options.nameWidth, &options.count}[i] = n
+	}
+	return nil
+}
+
+// parseDirstatParams applies the comma separated parameters of --dirstat
+// and diff.dirstat: changes, lines, files, cumulative, noncumulative and a
+// limit in percent with at most one decimal.
+func parseDirstatParams(value string, options *dirstatOptions) error {
+	for _, param := range strings.Split(value, ",") {
+		switch {
+		case param == "":
+		case param == "changes" || param == "lines" || param == "files":
+			options.by = param
+		case param == "cumulative":
+			options.cumulative = true
+		case param == "noncumulative":
+			options.cumulative = false
+		case param[0] >= '0' && param[0] <= '9':
+			whole, decimals, _ := strings.Cut(param, ".")
+			permille, err := strconv.Atoi(whole)
+			if err != nil || strings.Trim(decimals, "0123456789") != "" {
+				return fmt.Errorf("Failed to parse dirstat cut-off percentage '%s'", param)
+			}
+			permille *= 10
+			if decimals != "" {
+				permille += int(decimals[0] - '0')
+			}
+			options.permille = permille
+		default:
+			return fmt.Errorf("Unknown dirstat parameter '%s'", param)
+		}
+	}
+	return nil
+}
+
+// terminalColumns is the width of the terminal the way git finds it: from
+// COLUMNS, then from standard output, defaulting to 80.
+func terminalColumns() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	if columns := terminalWidth(); columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// fileStat counts the lines, or bytes of binary files, a file pair adds
+// and deletes.
+type fileStat struct {
+	name, oldName  string
+	added, deleted int
+	binary         bool
+	unmerged       bool
+}
+
+// printName is the name shown for the file, "<old> => <new>" with the
+// common leading directories and trailing path taken out for renames.
+func (stat fileStat) printName() string {
+	if stat.oldName == "" || stat.oldName == stat.name {
+		return quotePath(stat.name)
+	}
+	a, b := stat.oldName, stat.name
+	if quotePath(a) != a || quotePath(b) != b {
+		return quotePath(a) + " => " + quotePath(b)
+	}
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	// The common suffix starts at a slash, and may share the slash ending
+	// the common prefix.
+	suffix := 0
+	limit := prefix
+	if prefix > 0 {
+		limit--
+	}
+	for i, j := len(a), len(b); i >= limit && j >= limit; i, j = i-1, j-1 {
+		ca, cb := byte(0), byte(0)
+		if i < len(a) {
+			ca = a[i]
+		}
+		if j < len(b) {
+			cb = b[j]
+		}
+		if ca != cb {
+			break
+		}
+		if ca == '/' {
+			suffix = len(a) - i
+		}
+	}
+	aMiddle, bMiddle := max(len(a)-prefix-suffix, 0), max(len(b)-prefix-suffix, 0)
+	if prefix+suffix == 0 {
+		return a[prefix:prefix+aMiddle] + " => " + b[prefix:prefix+bMiddle]
+	}
+	return a[:prefix] + "{" + a[prefix:prefix+aMiddle] + " => " + b[prefix:prefix+bMiddle] + "}" + a[len(a)-suffix:]
+}
+
+// fileStats counts the changes of pairs. Files with both sides whose
+// differences are all ignored, like whitespace with -w, are left out.
+func (writer *patchWriter) fileStats(repo string, pairs []filePair) []fileStat {
+	stats := []fileStat{}
+	for _, pair := range pairs {
+		stat := fileStat{name: pair.name, oldName: pair.oldName}
+		if pair.status == 'U' {
+			stat.unmerged = true
+			stats = append(stats, stat)
+			continue
+		}
+		mayDiffer := !pair.old.exists() || !pair.new.exists() || pair.old.sha != pair.new.sha
+		oldContent, newContent := pair.old.content(repo, pair.sourceName()), pair.new.content(repo, pair.name)
+		switch {
+		case isBinaryContent(oldContent) || isBinaryContent(newContent):
+			stat.binary = true
+			if mayDiffer {
+				stat.added, stat.deleted = len(newContent), len(oldContent)
+			}
+		case mayDiffer:
+			for _, change := range diffLines(splitLines(oldContent), splitLines(newContent), writer.options.lineDiffOptions) {
+				stat.added += change.chg2
+				stat.deleted += change.chg1
+			}
+			if pair.old.exists() && pair.new.exists() && stat.added == 0 && stat.deleted == 0 {
+				continue
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// scaleLinear scales a count of changes to a bar of at most width
+// characters, keeping at least one character for any change.
+func scaleLinear(changes int, width int, maxChange int) int {
+	if changes == 0 {
+		return 0
+	}
+	return 1 + changes*(width-1)/maxChange
+}
+
+// writeStat prints the --stat histogram, following git's rules for sharing
+// the width between the names and the bars.
+func (writer *patchWriter) writeStat(stats []fileStat) {
+	if len(stats) == 0 {
+		return
+	}
+	options := writer.options.stat
+	count := len(stats)
+	if options.count > 0 {
+		count = options.count
+	}
+	maxChange, maxLen, numberWidth, binWidth := 0, 0, 0, 0
+	i := 0
+	for ; i < count && i < len(stats); i++ {
+		stat := stats[i]
+		maxLen = max(maxLen, len(stat.printName()))
+		switch {
+		case stat.unmerged:
+			binWidth = max(binWidth, len("Unmerged"))
+		case stat.binary:
+			binWidth = max(binWidth, 14+len(strconv.Itoa(stat.added))+len(strconv.Itoa(stat.deleted)))
+			numberWidth = len("Bin")
+		default:
+			maxChange = max(maxChange, stat.added+stat.deleted)
+		}
+	}
+	count = i
+
+	width := options.width
+	if width == 0 {
+		width = terminalColumns() - options.prefixWidth
+	}
+	numberWidth = max(numberWidth, len(strconv.Itoa(maxChange)))
+	// Leave at least 6 columns for the graph and 10 for the name.
+	width = max(width, 16+6+numberWidth)
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	if options.graphWidth > 0 && options.graphWidth < graphWidth {
+		graphWidth = options.graphWidth
+	}
+	nameWidth := maxLen
+	if options.nameWidth > 0 && options.nameWidth < maxLen {
+		nameWidth = options.nameWidth
+	}
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+		if options.graphWidth > 0 && graphWidth > options.graphWidth {
+			graphWidth = options.graphWidth
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	addSet, addReset := writer.paint(colorNew)
+	delSet, delReset := writer.paint(colorOld)
+	for _, stat := range stats[:count] {
+		name, prefix := stat.printName(), ""
+		length := nameWidth
+		if nameWidth < len(name) {
+			prefix = "..."
+			length = max(length-3, 0)
+			name = name[len(name)-length:]
+			if slash := strings.IndexByte(name, '/'); slash >= 0 {
+				name = name[slash:]
+			}
+		}
+		padding := max(length-len(name), 0)
+		switch {
+		case stat.binary:
+			fmt.Fprintf(writer.out, " %s%s%*s | %*s", prefix, name, padding, "", numberWidth, "Bin")
+			if stat.added != 0 || stat.deleted != 0 {
+				fmt.Fprintf(writer.out, " %s%d%s -> %s%d%s bytes", delSet, stat.deleted, delReset, addSet, stat.added, addReset)
+			}
+			fmt.Fprint(writer.out, "\n")
+			continue
+		case stat.unmerged:
+			// git ends this line without a newline.
+			fmt.Fprintf(writer.out, " %s%s%*s | %*s", prefix, name, padding, "", numberWidth, "Unmerged")
+			continue
+		}
+		add, del := stat.added, stat.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		space := ""
+		if stat.added+stat.deleted > 0 {
+			space = " "
+		}
+		fmt.Fprintf(writer.out, " %s%s%*s | %*d%s", prefix, name, padding, "", numberWidth, stat.added+stat.deleted, space)
+		if add > 0 {
+			fmt.Fprint(writer.out, addSet+strings.Repeat("+", add)+addReset)
+		}
+		if del > 0 {
+			fmt.Fprint(writer.out, delSet+strings.Repeat("-", del)+delReset)
+		}
+		fmt.Fprint(writer.out, "\n")
+	}
+	if count < len(stats) {
+		fmt.Fprint(writer.out, " ...\n")
+	}
+	writer.writeShortstat(stats)
+}
+
+// writeSummary prints the line --summary has for pair, if any.
+func (writer *patchWriter) writeSummary(pair filePair) {
+	switch pair.status {
+	case 'A':
+		fmt.Fprintf(writer.out, " create mode %06s %s\n", pair.new.perm, quotePath(pair.name))
+	case 'D':
+		fmt.Fprintf(writer.out, " delete mode %06s %s\n", pair.old.perm, quotePath(pair.name))
+	case 'R', 'C':
+		kind := "rename"
+		if pair.status == 'C' {
+			kind = "copy"
+		}
+		name := fileStat{name: pair.name, oldName: pair.oldName}.printName()
+		fmt.Fprintf(writer.out, " %s %s (%d%%)\n", kind, name, similarityPercent(pair.score))
+		if pair.old.perm != pair.new.perm {
+			fmt.Fprintf(writer.out, " mode change %06s => %06s\n", pair.old.perm, pair.new.perm)
+		}
+	default:
+		if pair.old.exists() && pair.new.exists() && pair.old.perm != pair.new.perm {
+			fmt.Fprintf(writer.out, " mode change %06s => %06s %s\n", pair.old.perm, pair.new.perm, quotePath(pair.name))
+		}
+	}
+}
+
+// writeShortstat prints the number of files changed and of lines added
+// and deleted, leaving out unmerged files and the bytes of binary ones.
+func (writer *patchWriter) writeShortstat(stats []fileStat) {
+	if len(stats) == 0 {
+		return
+	}
+	files, insertions, deletions := 0, 0, 0
+	for _, stat := range stats {
+		if stat.unmerged {
+			continue
+		}
+		files++
+		if !stat.binary {
+			insertions += stat.added
+			deletions += stat.deleted
+		}
+	}
+	if files == 0 {
+		fmt.Fprint(writer.out, " 0 files changed\n")
+		return
+	}
+	plural := func(n int, one string, many string) string {
+		if n == 1 {
+			return fmt.Sprintf(one, n)
+		}
+		return fmt.Sprintf(many, n)
+	}
+	summary := plural(files, " %d file changed", " %d files changed")
+	if insertions > 0 || deletions == 0 {
+		summary += plural(insertions, ", %d insertion(+)", ", %d insertions(+)")
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += plural(deletions, ", %d deletion(-)", ", %d deletions(-)")
+	}
+	fmt.Fprintln(writer.out, summary)
+}
+
+func (writer *patchWriter) writeNumstat(stats []fileStat) {
+	for _, stat := range stats {
+		if stat.binary {
+			fmt.Fprint(writer.out, "-\t-\t")
+		} else {
+			fmt.Fprintf(writer.out, "%d\t%d\t", stat.added, stat.deleted)
+		}
+		fmt.Fprintln(writer.out, stat.printName())
+	}
+}
+
+// dirstatFile is the damage a change did to one file.
+type dirstatFile struct {
+	name    string
+	changed int
+}
+
+// writeDirstat prints the share of the changes below each directory.
+// Changes are counted from the content that was removed or added, by
+// changed lines with "lines", or as one per file with "files".
+func (writer *patchWriter) writeDirstat(repo string, pairs []filePair, stats []fileStat) {
+	files := []dirstatFile{}
+	if writer.options.stat.dirstat.by == "lines" {
+		for _, stat := range stats {
+			damage := stat.added + stat.deleted
+			if stat.binary {
+				// Binary files count bytes, taken as 64 to a line.
+				damage = (damage + 63) / 64
+			}
+			files = append(files, dirstatFile{stat.name, damage})
+		}
+	} else {
+		for _, pair := range pairs {
+			damage := 0
+			switch {
+			case pair.status == 'U':
+				continue
+			case pair.old.exists() && pair.new.exists() && pair.old.sha == pair.new.sha:
+			case writer.options.stat.dirstat.by == "files":
+				damage = 1
+			default:
+				oldContent, newContent := pair.old.content(repo, pair.sourceName()), pair.new.content(repo, pair.name)
+				copied, added := 0, len(newContent)
+				if pair.old.exists() && pair.new.exists() {
+					copied, added = spanChanges(countSpans(oldContent), countSpans(newContent))
+				} else if !pair.new.exists() {
+					added = 0
+				}
+				damage = max(len(oldContent)-copied+added, 1)
+			}
+			files = append(files, dirstatFile{pair.name, damage})
+		}
+	}
+	changed := 0
+	for _, file := range files {
+		changed += file.changed
+	}
+	if changed == 0 {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].name < files[j].name })
+	writer.gatherDirstat(&files, changed, "")
+}
+
+// gatherDirstat sums the changes below base, consuming the files from the
+// front of the sorted list, and prints directories above the limit. A
+// directory whose changes all come from a single subdirectory is left to
+// that subdirectory.
+func (writer *patchWriter) gatherDirstat(files *[]dirstatFile, changed int, base string) int {
+	sum, sources := 0, 0
+	for len(*files) > 0 {
+		file := (*files)[0]
+		if !strings.HasPrefix(file.name, base) {
+			break
+		}
+		if slash := strings.IndexByte(file.name[len(base):], '/'); slash >= 0 {
+			sum += writer.gatherDirstat(files, changed, file.name[:len(base)+slash+1])
+			sources++
+		} else {
+			sum += file.changed
+			*files = (*files)[1:]
+			sources += 2
+		}
+	}
+	options := writer.options.stat.dirstat
+	if base != "" && sources != 1 && sum > 0 {
+		if permille := sum * 1000 / changed; permille >= options.permille {
+			fmt.Fprintf(writer.out, "%4d.%01d%% %s\n", permille/10, permille%10, base)
+			if !options.cumulative {
+				return 0
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestStatPrintName(t *testing.T) {
+	cases := map[[2]string]string{
+		{"a/b/c", "a/c"}:       "a/{b => }/c",
+		{"d/e", "d/x/e"}:       "d/{ => x}/e",
+		{"f/g/h", "i/g/h"}:     "{f => i}/g/h",
+		{"jk", "jl"}:           "jk => jl",
+		{"r/s", "r/s2"}:        "r/{s => s2}",
+		{"aa/bb", "aa/bb2/cc"}: "aa/{bb => bb2/cc}",
+		{"", "plain"}:          "plain",
+	}
+	for names, want := range cases {
+		if got := (fileStat{oldName: names[0], name: names[1]}).printName(); got != want {
+			t.Errorf("printName(%q, %q) = %q, want %q", names[0], names[1], got, want)
+		}
+	}
+}
+
+func TestWriteStat(t *testing.T) {
+	var out bytes.Buffer
+	writer := &patchWriter{out: bufio.NewWriter(&out), options: diffOptions{stat: statOptions{width: 40}}}
+	writer.writeStat([]fileStat{
+		{name: "a", added: 100, deleted: 20},
+		{name: "some/rather/long/path/name.txt", added: 1},
+		{name: "image.png", added: 300, deleted: 120, binary: true},
+	})
+	writer.out.Flush()
+	// Names are cut at a slash to fit and the bars get what is left, git
+	// printing the same for these files at --stat=40.
+	want := " a                         | 120 +++++-\n" +
+		" .../long/path/name.txt    |   1 +\n" +
+		" image.png                 | Bin 120 -> 300 bytes\n" +
+		" 3 files changed, 101 insertions(+), 20 deletions(-)\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestParseDirstatParams(t *testing.T) {
+	options := defaultDirstatOptions
+	if err := parseDirstatParams("lines,cumulative,10.25", &options); err != nil {
+		t.Fatal(err)
+	}
+	if want := (dirstatOptions{by: "lines", permille: 102, cumulative: true}); options != want {
+		t.Errorf("got %+v, want %+v", options, want)
+	}
+	for _, bad := range []string{"bogus", "1x", "1.x"} {
+		if err := parseDirstatParams(bad, &options); err == nil {
+			t.Errorf("parseDirstatParams(%q) succeeded", bad)
+		}
+	}
+}
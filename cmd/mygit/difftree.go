@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+*  ###################### DIFF-TREE ##############################
+*
+*  Two trees are compared entry by entry in tree order. Subtrees recorded
+*  with the same object name on both sides are skipped without being read,
+*  and differing subtrees are either listed as such or, when recursing,
+*  compared in turn so that only the files below them are listed.
+ */
+
+type treeDiffOptions struct {
+	recursive bool
+	// showTrees also lists the subtrees recursed into.
+	showTrees bool
+	// unmodified also lists unchanged files, as copy sources, which means
+	// reading identical subtrees too.
+	unmodified bool
+}
+
+// diffTrees compares the trees oldTree and newTree, either of which may
+// be empty, and lists the entries selected by pathspecs that differ.
+func diffTrees(repo string, oldTree string, newTree string, options treeDiffOptions, pathspecs []string) []filePair {
+	pairs := []filePair{}
+	compareTrees(repo, oldTree, newTree, "", options, pathspecs, &pairs)
+	return pairs
+}
+
+func diffTreeEntries(repo string, treeHex string) []tree {
+	if treeHex == "" || treeHex == emptyTreeHex {
+		return nil
+	}
+	return readTreeEntries(repo, treeHex)
+}
+
+func compareTrees(repo string, oldTree string, newTree string, dir string, options treeDiffOptions, pathspecs []string, pairs *[]filePair) {
+	oldEntries, newEntries := diffTreeEntries(repo, oldTree), diffTreeEntries(repo, newTree)
+	for i, j := 0, 0; i < len(oldEntries) || j < len(newEntries); {
+		var old, new diffSide
+		name := ""
+		switch {
+		case j == len(newEntries) || i < len(oldEntries) && treeEntrySortKey(oldEntries[i]) < treeEntrySortKey(newEntries[j]):
+			old, name = diffSide{perm: oldEntries[i].perm, sha: oldEntries[i].sha}, oldEntries[i].name
+			i++
+		case i == len(oldEntries) || treeEntrySortKey(newEntries[j]) < treeEntrySortKey(oldEntries[i]):
+			new, name = diffSide{perm: newEntries[j].perm, sha: newEntries[j].sha}, newEntries[j].name
+			j++
+		default:
+			old, new = diffSide{perm: oldEntries[i].perm, sha: oldEntries[i].sha}, diffSide{perm: newEntries[j].perm, sha: newEntries[j].sha}
+			name = newEntries[j].name
+			i++
+			j++
+		}
+		name = path.Join(dir, name)
+		status := pairStatus(old, new)
+		if status == 0 && !options.unmodified {
+			continue
+		}
+		isTree := isTreePerm(old.perm) || isTreePerm(new.perm)
+		if len(pathspecs) > 0 && !matchesAnyPathspec(name, pathspecs) && !(isTree && pathspecReachesInto(name, pathspecs)) {
+			continue
+		}
+		if !isTree {
+			*pairs = append(*pairs, filePair{name: name, old: old, new: new, status: status})
+			continue
+		}
+		if !options.recursive || options.showTrees && status != 0 {
+			*pairs = append(*pairs, filePair{name: name, old: old, new: new, status: status})
+		}
+		if options.recursive {
+			compareTrees(repo, sideTree(old), sideTree(new), name, options, pathspecs, pairs)
+		}
+	}
+}
+
+func sideTree(side diffSide) string {
+	if !side.exists() {
+		return ""
+	}
+	return hex.EncodeToString(side.sha[:])
+}
+
+// pathspecReachesInto reports whether a pathspec selects something below
+// the directory dir.
+func pathspecReachesInto(dir string, pathspecs []string) bool {
+	for _, spec := range pathspecs {
+		if strings.HasPrefix(path.Clean(filepath.ToSlash(spec)), dir+"/") {
+			return true
+		}
+	}
+	return false
+}
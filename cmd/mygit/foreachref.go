@@ -0,0 +1,645 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+*  ###################### FOR-EACH-REF ##############################
+*
+*  A format is literal text with %(atom) placeholders, %% and %xx escapes
+*  and %(if)...%(then)...%(else)...%(end) blocks. Atoms prefixed with *
+*  describe the object an annotated tag points to.
+ */
+
+const defaultRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+// refAtoms are the atoms for-each-ref knows, modifiers excluded.
+var refAtoms = []string{
+	"refname", "objectname", "objecttype", "objectsize", "tree", "parent", "numparent",
+	"object", "type", "tag", "author", "authorname", "authoremail", "authordate",
+	"committer", "committername", "committeremail", "committerdate",
+	"tagger", "taggername", "taggeremail", "taggerdate", "creator", "creatordate",
+	"subject", "body", "contents", "HEAD", "symref", "upstream",
+	"if", "then", "else", "end",
+}
+
+// refFilter selects the refs listed by for-each-ref.
+type refFilter struct {
+	patterns   []string
+	pointsAt   string
+	merged     string
+	noMerged   string
+	contains   []string
+	noContains []string
+}
+
+// refItem is a ref along with the object it points to.
+type refItem struct {
+	name       string
+	hexHash    string
+	objectType Object
+	content    []byte
+	peeled     *refItem
+}
+
+type formatToken struct {
+	literal string
+	atom    string
+}
+
+// parseRefFormat splits format into literal text and atoms, checking that
+// every atom is known.
+func parseRefFormat(format string) ([]formatToken, error) {
+	tokens := []formatToken{}
+	var literal strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "%"):
+			literal.WriteByte('%')
+			i++
+		case strings.HasPrefix(rest, "("):
+			closing := strings.IndexByte(rest, ')')
+			if closing < 0 {
+				return nil, fmt.Errorf("malformed format string %s", format[i:])
+			}
+			atom := rest[1:closing]
+			name, _, _ := strings.Cut(strings.TrimPrefix(atom, "*"), ":")
+			if !slices.Contains(refAtoms, name) {
+				return nil, fmt.Errorf("unknown field name: %s", name)
+			}
+			if literal.Len() > 0 {
+				tokens = append(tokens, formatToken{literal: literal.String()})
+				literal.Reset()
+			}
+			tokens = append(tokens, formatToken{atom: atom})
+			i += closing + 1
+		default:
+			if value, err := strconv.ParseUint(rest[:min(2, len(rest))], 16, 8); err == nil && len(rest) >= 2 {
+				literal.WriteByte(byte(value))
+				i += 2
+			} else {
+				literal.WriteByte('%')
+			}
+		}
+	}
+	if literal.Len() > 0 {
+		tokens = append(tokens, formatToken{literal: literal.String()})
+	}
+	return tokens, nil
+}
+
+// formatRef expands the parsed format for item. Atom values are passed
+// through quote, which is used for --shell.
+func formatRef(repo string, tokens []formatToken, item *refItem, quote func(string) string) (string, error) {
+	type ifBlock struct {
+		condition string
+		state     string
+		satisfied bool
+		outputs   map[string]*strings.Builder
+	}
+	var root strings.Builder
+	blocks := []*ifBlock{}
+	current := func() *strings.Builder {
+		if len(blocks) == 0 {
+			return &root
+		}
+		block := blocks[len(blocks)-1]
+		return block.outputs[block.state]
+	}
+	for _, token := range tokens {
+		if token.atom == "" {
+			current().WriteString(token.literal)
+			continue
+		}
+		name, modifier, _ := strings.Cut(token.atom, ":")
+		switch name {
+		case "if":
+			blocks = append(blocks, &ifBlock{condition: modifier, state: "if", outputs: map[string]*strings.Builder{
+				"if": {}, "then": {}, "else": {},
+			}})
+			continue
+		case "then", "else", "end":
+			if len(blocks) == 0 {
+				return "", fmt.Errorf("format: %%(%s) atom used without an %%(if) atom", name)
+			}
+		}
+		switch name {
+		case "then":
+			block := blocks[len(blocks)-1]
+			value := block.outputs["if"].String()
+			switch {
+			case strings.HasPrefix(block.condition, "equals="):
+				block.satisfied = value == strings.TrimPrefix(block.condition, "equals=")
+			case strings.HasPrefix(block.condition, "notequals="):
+				block.satisfied = value != strings.TrimPrefix(block.condition, "notequals=")
+			default:
+				block.satisfied = strings.TrimSpace(value) != ""
+			}
+			block.state = "then"
+		case "else":
+			blocks[len(blocks)-1].state = "else"
+		case "end":
+			block := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			if block.satisfied {
+				current().WriteString(block.outputs["then"].String())
+			} else {
+				current().WriteString(block.outputs["else"].String())
+			}
+		default:
+			value, err := refAtomValue(repo, item, token.atom)
+			if err != nil {
+				return "", err
+			}
+			if len(blocks) == 0 || blocks[len(blocks)-1].state != "if" {
+				value = quote(value)
+			}
+			current().WriteString(value)
+		}
+	}
+	if len(blocks) > 0 {
+		return "", fmt.Errorf("format: %%(end) atom missing")
+	}
+	return root.String(), nil
+}
+
+// loadRefItem reads the object name points to, peeling annotated tags for
+// the * atoms.
+func loadRefItem(repo string, name string, hexHash string) *refItem {
+	item := &refItem{name: name, hexHash: hexHash}
+	item.objectType, item.content = readObject(repo, hexHash)
+	if item.objectType == Tag {
+		if peeled, err := peelRevision(repo, hexHash, ""); err == nil {
+			item.peeled = loadRefItem(repo, name, peeled)
+		}
+	}
+	return item
+}
+
+// refObjectFields returns the headers and the message of a commit or tag.
+func refObjectFields(item *refItem) (map[string][]string, string) {
+	fields := map[string][]string{}
+	if item.objectType != Commit && item.objectType != Tag {
+		return fields, ""
+	}
+	parsed := parseCommit(item.content)
+	if parsed.tree != "" {
+		fields["tree"] = []string{parsed.tree}
+	}
+	fields["parent"] = parsed.parents
+	if parsed.author != "" {
+		fields["author"] = []string{parsed.author}
+	}
+	if parsed.committer != "" {
+		fields["committer"] = []string{parsed.committer}
+	}
+	for _, header := range parsed.headers {
+		fields[header.key] = append(fields[header.key], header.value)
+	}
+	return fields, parsed.message
+}
+
+// splitRefMessage splits a message into its subject paragraph, body and
+// the signature of a signed tag.
+func splitRefMessage(message string) (string, string, string) {
+	message = strings.TrimLeft(message, "\n")
+	signature := ""
+	if sig, payload, ok := splitTagSignature([]byte(message)); ok {
+		signature, message = sig, string(payload)
+	}
+	subject, body, _ := strings.Cut(message, "\n\n")
+	return strings.TrimSuffix(subject, "\n"), strings.TrimLeft(body, "\n"), signature
+}
+
+// refAtomValue computes the value of atom, with its modifier, for item.
+func refAtomValue(repo string, item *refItem, atom string) (string, error) {
+	if strings.HasPrefix(atom, "*") {
+		if item.peeled == nil {
+			return "", nil
+		}
+		item, atom = item.peeled, atom[1:]
+	}
+	name, modifier, _ := strings.Cut(atom, ":")
+	fields, message := refObjectFields(item)
+	first := func(key string) string {
+		if values := fields[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	badModifier := fmt.Errorf("unrecognized %%(%s) argument: %s", name, modifier)
+
+	switch name {
+	case "refname":
+		return formatRefName(item.name, modifier)
+	case "objectname":
+		switch {
+		case modifier == "":
+			return item.hexHash, nil
+		case modifier == "short":
+			return item.hexHash[:7], nil
+		case strings.HasPrefix(modifier, "short="):
+			length, err := strconv.Atoi(strings.TrimPrefix(modifier, "short="))
+			if err != nil {
+				return "", badModifier
+			}
+			return item.hexHash[:min(max(length, 4), len(item.hexHash))], nil
+		}
+		return "", badModifier
+	case "objecttype":
+		return objectTypeName(item.objectType), nil
+	case "objectsize":
+		return strconv.Itoa(len(item.content)), nil
+	case "tree", "object", "type", "tag":
+		value := first(name)
+		if modifier == "short" && isHexHash(value) {
+			value = value[:7]
+		}
+		return value, nil
+	case "parent":
+		parents := append([]string{}, fields["parent"]...)
+		if modifier == "short" {
+			for i := range parents {
+				parents[i] = parents[i][:7]
+			}
+		}
+		return strings.Join(parents, " "), nil
+	case "numparent":
+		if item.objectType != Commit {
+			return "", nil
+		}
+		return strconv.Itoa(len(fields["parent"])), nil
+	case "HEAD":
+		if current, ok := headBranch(repo); ok && current == item.name {
+			return "*", nil
+		}
+		return " ", nil
+	case "symref":
+		target, ok := readSymbolicRef(repo, item.name)
+		if !ok {
+			return "", nil
+		}
+		return formatRefName(target, modifier)
+	case "upstream":
+		return formatUpstream(repo, item, modifier)
+	case "subject", "body", "contents":
+		subject, body, signature := splitRefMessage(message)
+		if name != "contents" {
+			modifier, name = name, "contents"
+		}
+		switch modifier {
+		case "":
+			return message, nil
+		case "subject":
+			return strings.ReplaceAll(subject, "\n", " "), nil
+		case "body":
+			return body, nil
+		case "signature":
+			return signature, nil
+		}
+		return "", badModifier
+	}
+
+	// The person atoms: author, authorname, authoremail, authordate...
+	role := name
+	for _, suffix := range []string{"name", "email", "date"} {
+		role = strings.TrimSuffix(role, suffix)
+	}
+	if role == "creator" {
+		role = "committer"
+		if item.objectType == Tag {
+			role = "tagger"
+		}
+	}
+	line := first(role)
+	if line == "" {
+		return "", nil
+	}
+	person := parseIdentity(line)
+	switch strings.TrimPrefix(strings.TrimPrefix(name, role), "creator") {
+	case "":
+		return line, nil
+	case "name":
+		return person.name, nil
+	case "email":
+		switch modifier {
+		case "":
+			return "<" + person.email + ">", nil
+		case "trim":
+			return person.email, nil
+		case "localpart":
+			localPart, _, _ := strings.Cut(person.email, "@")
+			return localPart, nil
+		}
+		return "", badModifier
+	case "date":
+		return formatDate(person.date, modifier, time.Now())
+	}
+	return "", fmt.Errorf("unknown field name: %s", name)
+}
+
+// formatRefName applies the :short, :lstrip=<n> and :rstrip=<n> modifiers
+// of %(refname) and the atoms naming refs. Negative counts keep that many
+// components instead.
+func formatRefName(name string, modifier string) (string, error) {
+	if modifier == "" {
+		return name, nil
+	}
+	if modifier == "short" {
+		return shortRefName(name), nil
+	}
+	option, value, _ := strings.Cut(modifier, "=")
+	count, err := strconv.Atoi(value)
+	if err != nil || (option != "lstrip" && option != "strip" && option != "rstrip") {
+		return "", fmt.Errorf("unrecognized %%(refname) argument: %s", modifier)
+	}
+	components := strings.Split(name, "/")
+	if count < 0 {
+		count = max(len(components)+count, 0)
+	}
+	count = min(count, len(components))
+	if option == "rstrip" {
+		return strings.Join(components[:len(components)-count], "/"), nil
+	}
+	return strings.Join(components[count:], "/"), nil
+}
+
+// formatUpstream implements %(upstream) and its :short, :track,
+// :trackshort, :remotename and :remoteref modifiers for local branches.
+func formatUpstream(repo string, item *refItem, modifier string) (string, error) {
+	branch, isBranch := strings.CutPrefix(item.name, "refs/heads/")
+	if !isBranch {
+		return "", nil
+	}
+	upstream, ok := branchUpstream(repo, branch)
+	if !ok {
+		return "", nil
+	}
+	option, _, _ := strings.Cut(modifier, ",")
+	switch option {
+	case "", "lstrip", "rstrip", "strip", "short":
+		return formatRefName(upstream, modifier)
+	case "remotename", "remoteref":
+		section, _ := loadRepoConfig(repo).GetSection(`branch "` + branch + `"`)
+		if option == "remotename" {
+			return section.Key("remote").String(), nil
+		}
+		return section.Key("merge").String(), nil
+	case "track", "trackshort":
+		upstreamHex, exists := resolveRef(repo, upstream)
+		if !exists {
+			if option == "track" {
+				return bracketTracking("gone", modifier), nil
+			}
+			return "", nil
+		}
+		ahead, behind := aheadBehind(repo, item.hexHash, upstreamHex)
+		if option == "trackshort" {
+			switch {
+			case ahead > 0 && behind > 0:
+				return "<>", nil
+			case ahead > 0:
+				return ">", nil
+			case behind > 0:
+				return "<", nil
+			}
+			return "=", nil
+		}
+		parts := []string{}
+		if ahead > 0 {
+			parts = append(parts, fmt.Sprintf("ahead %d", ahead))
+		}
+		if behind > 0 {
+			parts = append(parts, fmt.Sprintf("behind %d", behind))
+		}
+		if len(parts) == 0 {
+			return "", nil
+		}
+		return bracketTracking(strings.Join(parts, ", "), modifier), nil
+	}
+	return "", fmt.Errorf("unrecognized %%(upstream) argument: %s", modifier)
+}
+
+func bracketTracking(summary string, modifier string) string {
+	if strings.HasSuffix(modifier, ",nobracket") {
+		return summary
+	}
+	return "[" + summary + "]"
+}
+
+// matchesRefPatterns follows git for-each-ref: a pattern matches refs
+// below it as well as refs it matches as a glob.
+func matchesRefPatterns(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasPrefix(name, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRefs returns the refs below refs/ that pass filter, sorted by
+// name.
+func filterRefs(repo string, filter refFilter) []*refItem {
+	resolveFilterCommit := func(rev string) string {
+		return resolveCommit(repo, rev)
+	}
+	pointsAt := ""
+	if filter.pointsAt != "" {
+		hexHash, err := resolveRevision(repo, filter.pointsAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: malformed object name %s\n", filter.pointsAt)
+			os.Exit(129)
+		}
+		pointsAt = hexHash
+	}
+	var merged, notMerged map[string]bool
+	if filter.merged != "" {
+		merged = reachableCommits(repo, resolveFilterCommit(filter.merged))
+	}
+	if filter.noMerged != "" {
+		notMerged = reachableCommits(repo, resolveFilterCommit(filter.noMerged))
+	}
+	contains, noContains := []string{}, []string{}
+	for _, rev := range filter.contains {
+		contains = append(contains, resolveFilterCommit(rev))
+	}
+	for _, rev := range filter.noContains {
+		noContains = append(noContains, resolveFilterCommit(rev))
+	}
+
+	items := []*refItem{}
+	for _, name := range listRefs(repo, "refs/") {
+		if !matchesRefPatterns(name, filter.patterns) {
+			continue
+		}
+		hexHash, ok := resolveRef(repo, name)
+		if !ok || !objectExists(repo, hexHash) {
+			continue
+		}
+		item := loadRefItem(repo, name, hexHash)
+		if pointsAt != "" && hexHash != pointsAt && (item.peeled == nil || item.peeled.hexHash != pointsAt) {
+			continue
+		}
+		if merged != nil || notMerged != nil || len(contains) > 0 || len(noContains) > 0 {
+			commit, err := peelToType(repo, hexHash, Commit)
+			if err != nil {
+				continue
+			}
+			if merged != nil && !merged[commit] || notMerged != nil && notMerged[commit] {
+				continue
+			}
+			if len(contains) > 0 && !slices.ContainsFunc(contains, func(rev string) bool { return isAncestor(repo, rev, commit) }) {
+				continue
+			}
+			if slices.ContainsFunc(noContains, func(rev string) bool { return isAncestor(repo, rev, commit) }) {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// sortRefItems sorts items by keys such as "refname", "-committerdate" or
+// "version:refname". The last key is the primary one and ties keep the
+// order by name.
+func sortRefItems(repo string, items []*refItem, keys []string) error {
+	type sortKey struct {
+		atom    string
+		reverse bool
+		version bool
+	}
+	parsed := []sortKey{}
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := sortKey{atom: keys[i]}
+		key.atom, key.reverse = strings.CutPrefix(key.atom, "-")
+		for _, prefix := range []string{"version:", "v:"} {
+			if atom, ok := strings.CutPrefix(key.atom, prefix); ok {
+				key.atom, key.version = atom, true
+			}
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(key.atom, "*"), ":")
+		if !slices.Contains(refAtoms, name) {
+			return fmt.Errorf("unknown field name: %s", name)
+		}
+		parsed = append(parsed, key)
+	}
+
+	values := make([][]string, len(items))
+	for i, item := range items {
+		for _, key := range parsed {
+			atom := key.atom
+			// Dates compare as numbers
+			if name, _, _ := strings.Cut(atom, ":"); strings.HasSuffix(name, "date") {
+				atom = name + ":unix"
+			}
+			value, err := refAtomValue(repo, item, atom)
+			if err != nil {
+				return err
+			}
+			values[i] = append(values[i], value)
+		}
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		for k, key := range parsed {
+			left, right := values[order[a]][k], values[order[b]][k]
+			var cmp int
+			name, _, _ := strings.Cut(strings.TrimPrefix(key.atom, "*"), ":")
+			switch {
+			case key.version:
+				cmp = compareVersions(left, right)
+			case strings.HasSuffix(name, "date") || name == "objectsize" || name == "numparent":
+				leftNumber, _ := strconv.ParseInt(left, 10, 64)
+				rightNumber, _ := strconv.ParseInt(right, 10, 64)
+				cmp = compareInts(leftNumber, rightNumber)
+			default:
+				cmp = strings.Compare(left, right)
+			}
+			if key.reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]*refItem, len(items))
+	for i, index := range order {
+		sorted[i] = items[index]
+	}
+	copy(items, sorted)
+	return nil
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares strings the way version sort does, with runs
+// of digits compared by their numeric value.
+func compareVersions(a string, b string) int {
+	for a != "" && b != "" {
+		if unicode.IsDigit(rune(a[0])) && unicode.IsDigit(rune(b[0])) {
+			aEnd, bEnd := digitRun(a), digitRun(b)
+			aNumber, bNumber := strings.TrimLeft(a[:aEnd], "0"), strings.TrimLeft(b[:bEnd], "0")
+			if len(aNumber) != len(bNumber) {
+				return compareInts(int64(len(aNumber)), int64(len(bNumber)))
+			}
+			if cmp := strings.Compare(aNumber, bNumber); cmp != 0 {
+				return cmp
+			}
+			a, b = a[aEnd:], b[bEnd:]
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int64(a[0]), int64(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(int64(len(a)), int64(len(b)))
+}
+
+func digitRun(s string) int {
+	end := 0
+	for end < len(s) && unicode.IsDigit(rune(s[end])) {
+		end++
+	}
+	return end
+}
+
+// shellQuote quotes value for --shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestFormatRefName(t *testing.T) {
+	cases := []struct {
+		modifier string
+		want     string
+	}{
+		{"", "refs/remotes/origin/main"},
+		{"short", "origin/main"},
+		{"lstrip=2", "origin/main"},
+		{"lstrip=-1", "main"},
+		{"rstrip=1", "refs/remotes/origin"},
+		{"rstrip=-2", "refs/remotes"},
+		{"strip=9", ""},
+	}
+	for _, c := range cases {
+		got, err := formatRefName("refs/remotes/origin/main", c.modifier)
+		if err != nil || got != c.want {
+			t.Errorf("formatRefName(%q) = %q, %v, want %q", c.modifier, got, err, c.want)
+		}
+	}
+	if _, err := formatRefName("refs/heads/main", "lstrip"); err == nil {
+		t.Errorf("lstrip without a count accepted")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"v1.2", "v1.9", "v1.10", "v1.10.1", "v2.0", "v10.0"}
+	for i := 1; i < len(ordered); i++ {
+		if compareVersions(ordered[i-1], ordered[i]) >= 0 || compareVersions(ordered[i], ordered[i-1]) <= 0 {
+			t.Errorf("%s should sort before %s", ordered[i-1], ordered[i])
+		}
+	}
+	if compareVersions("v01.2", "v1.2") != 0 {
+		t.Errorf("leading zeros should not matter")
+	}
+}
+
+func TestParseRefFormat(t *testing.T) {
+	tokens, err := parseRefFormat("%(refname:short)%%%41\t%(*objectname)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []formatToken{{atom: "refname:short"}, {literal: "%A\t"}, {atom: "*objectname"}}
+	if len(tokens) != len(want) {
+		t.Fatalf("parseRefFormat() = %+v", tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+	for _, format := range []string{"%(refname", "%(bogus)"} {
+		if _, err := parseRefFormat(format); err == nil {
+			t.Errorf("parseRefFormat(%q) succeeded", format)
+		}
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -21,6 +23,7 @@ const (
 	FILE    ObjectPerm = "100644"
 	EXE     ObjectPerm = "100755"
 	SYMLINK ObjectPerm = "120000"
+	GITLINK ObjectPerm = "160000"
 	DIR     ObjectPerm = "40000"
 )
 
@@ -85,36 +88,161 @@ func createCommitObject(data []byte) string {
 	return commitHex
 }
 
+// createTreeObject writes the tree for dirpath and every tree below it and
+// returns its hash. Entries are stored the way git stores them, so the same
+// content always produces the same SHA as `git write-tree`.
 func createTreeObject(dirpath string) (hash []byte) {
+	hash, _ = writeDirectoryTree(dirpath)
+	return
+}
+
+// writeDirectoryTree returns the hash of the tree written for dirpath along
+// with the number of entries it holds. Directories with nothing to track are
+// left out of their parent, just like git never stores empty subtrees.
+func writeDirectoryTree(dirpath string) ([]byte, int) {
 	files, err := os.ReadDir(dirpath)
 	exitIfError(err, "READ_DIR")
-	// fmt.Println(files)
-	content := ""
-	zeroByte := byte(0)
+	entries := []tree{}
 	for _, file := range files {
-		if file.Name() != ".git" {
-			if file.IsDir() {
-				// fmt.Println(path.Join(dirpath, file.Name()) + "_DIR")
-				hash := createTreeObject(path.Join(dirpath, file.Name()))
-				content += string(DIR) + " " + file.Name() + string(zeroByte) + string(hash)
-				// Calc Hash Rec
-			} else {
-				// fmt.Println(file.Name() + "_FILE")
-				buff, err := os.ReadFile(path.Join(dirpath, file.Name()))
-				exitIfError(err, "File Read")
-				hexhash := createBlobObject(buff)
-				hash, err := hex.DecodeString(hexhash)
-				exitIfError(err, "HEXTOHASH CONV")
-				content += string(FILE) + " " + file.Name() + string(zeroByte) + string(hash)
-				// fmt.Println(content, "C")
+		if file.Name() == ".git" {
+			continue
+		}
+		filePath := path.Join(dirpath, file.Name())
+		entry := tree{name: file.Name()}
+		var hexhash string
+		switch {
+		case file.Type()&os.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			exitIfError(err, "READ_LINK")
+			hexhash = createBlobObject([]byte(target))
+			entry.perm = SYMLINK
+		case file.IsDir():
+			hash, count := writeDirectoryTree(filePath)
+			if count == 0 {
+				continue
+			}
+			entry.perm = DIR
+			entry.sha = [20]byte(hash)
+		default:
+			info, err := file.Info()
+			exitIfError(err, "FILE_STAT")
+			buff, err := os.ReadFile(filePath)
+			exitIfError(err, "File Read")
+			hexhash = createBlobObject(buff)
+			entry.perm = FILE
+			if info.Mode()&0111 != 0 {
+				entry.perm = EXE
 			}
 		}
+		if hexhash != "" {
+			hash, err := hex.DecodeString(hexhash)
+			exitIfError(err, "HEXTOHASH CONV")
+			entry.sha = [20]byte(hash)
+		}
+		entries = append(entries, entry)
+	}
+	return writeTreeObject(entries), len(entries)
+}
+
+// writeTreeObject stores entries as a tree object and returns its hash.
+func writeTreeObject(entries []tree) []byte {
+	treeContent := writeHeaderToContent(encodeTreeObject(entries), Tree)
+	hash := hashContent(treeContent)
+	writeObjectToDisk(treeContent, hex.EncodeToString(hash), true, ".")
+	return hash
+}
+
+// encodeTreeObject serializes entries into the body of a tree object. Git
+// orders entries by name, comparing directory names as if they ended with a
+// slash, and writes directory modes without the leading zero.
+func encodeTreeObject(entries []tree) []byte {
+	sorted := make([]tree, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return treeEntrySortKey(sorted[i]) < treeEntrySortKey(sorted[j])
+	})
+	var content bytes.Buffer
+	for _, entry := range sorted {
+		content.WriteString(strings.TrimLeft(string(entry.perm), "0"))
+		content.WriteByte(' ')
+		content.WriteString(entry.name)
+		content.WriteByte(0)
+		content.Write(entry.sha[:])
+	}
+	return content.Bytes()
+}
+
+func treeEntrySortKey(entry tree) string {
+	if isTreePerm(entry.perm) {
+		return entry.name + "/"
+	}
+	return entry.name
+}
+
+// isTreePerm reports whether perm is the mode of a subtree. Modes decoded
+// from tree objects are zero padded while DIR is not.
+func isTreePerm(perm ObjectPerm) bool {
+	return strings.TrimLeft(string(perm), "0") == string(DIR)
+}
+
+// readObject loads the object hexHash from the object store of repo and
+// returns its type along with its content without the header.
+func readObject(repo string, hexHash string) (Object, []byte) {
+	if len(hexHash) != 40 {
+		fmt.Fprintf(os.Stderr, "fatal: not a valid object name %s\n", hexHash)
+		os.Exit(1)
+	}
+	objectPath := filepath.Join(repo, ".git", "objects", hexHash[:2], hexHash[2:])
+	buff, err := os.ReadFile(objectPath)
+	exitIfError(err, fmt.Sprintf("fatal: unable to read object %s", hexHash))
+	data, _ := decompressContent(buff)
+	spaceIndex := bytes.IndexByte(data, ' ')
+	zeroIndex := bytes.IndexByte(data, 0)
+	if spaceIndex < 0 || zeroIndex < spaceIndex {
+		fmt.Fprintf(os.Stderr, "fatal: object %s is corrupt\n", hexHash)
+		os.Exit(1)
+	}
+	return objectTypeFromName(string(data[:spaceIndex])), data[zeroIndex+1:]
+}
+
+// readTreeEntries returns the entries of the tree hexHash in repo.
+func readTreeEntries(repo string, hexHash string) []tree {
+	objectType, content := readObject(repo, hexHash)
+	if objectType != Tree {
+		fmt.Fprintf(os.Stderr, "fatal: %s is not a tree object\n", hexHash)
+		os.Exit(1)
+	}
+	if len(content) == 0 {
+		return []tree{}
+	}
+	return decodeTreeObject(writeHeaderToContent(content, Tree), false)
+}
+
+// objectTypeOfPerm returns the type of object a tree entry with perm points to.
+func objectTypeOfPerm(perm ObjectPerm) string {
+	switch {
+	case isTreePerm(perm):
+		return "tree"
+	case perm == GITLINK:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+func objectTypeFromName(name string) Object {
+	switch name {
+	case "commit":
+		return Commit
+	case "tree":
+		return Tree
+	case "blob":
+		return Blob
+	case "tag":
+		return Tag
+	default:
+		return Unsepcified
 	}
-	treeContent := writeHeaderToContent([]byte(content), Tree)
-	hash = hashContent(treeContent)
-	hexHash := hex.EncodeToString(hash)
-	writeObjectToDisk(treeContent, hexHash, true, ".")
-	return
 }
 
 func discoverRefs(repoUrl string) string {
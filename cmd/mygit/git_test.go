@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fixtureFile struct {
+	path    string
+	content string
+	mode    os.FileMode
+	link    string
+}
+
+// Expected hashes were produced by running `git add -A && git write-tree`
+// on the same layouts.
+var treeFixtures = []struct {
+	name  string
+	files []fixtureFile
+	dirs  []string
+	want  string
+}{
+	{
+		name: "empty repository",
+		want: "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+	},
+	{
+		name: "directories sort as if they had a trailing slash",
+		files: []fixtureFile{
+			{path: "a.txt", content: "a\n"},
+			{path: "a-b", content: "b\n"},
+			{path: "a0", content: "c\n"},
+			{path: "a/x", content: "x\n"},
+			{path: "a.b/y", content: "y\n"},
+			{path: "Z", content: "z\n"},
+		},
+		want: "41e59846ba881d0ec1891940b57106ce77b1777e",
+	},
+	{
+		name: "empty directories are omitted",
+		files: []fixtureFile{
+			{path: "file", content: "f\n"},
+		},
+		dirs: []string{"e", "nested/inner"},
+		want: "3e89b2d9c96f27f829697308e27afa3e09e76b14",
+	},
+	{
+		name: "executables and symlinks keep their modes",
+		files: []fixtureFile{
+			{path: "run.sh", content: "#!/bin/sh\necho hi\n", mode: 0755},
+			{path: "target", content: "t\n"},
+			{path: "link", link: "target"},
+			{path: "bin/tool", content: "x", mode: 0755},
+		},
+		want: "ffa0e8f7c969696884dab7dbd6a950d320dc4714",
+	},
+}
+
+func TestCreateTreeObjectMatchesGit(t *testing.T) {
+	for _, fixture := range treeFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			chdirTemp(t)
+			for _, dir := range append([]string{".git/objects"}, fixture.dirs...) {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range fixture.files {
+				writeFixtureFile(t, file)
+			}
+			got := hex.EncodeToString(createTreeObject("."))
+			if got != fixture.want {
+				t.Errorf("createTreeObject() = %s, want %s", got, fixture.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTreeObjectIsOrderIndependent(t *testing.T) {
+	entries := []tree{
+		{perm: FILE, name: "a0"},
+		{perm: "040000", name: "a"},
+		{perm: FILE, name: "a.b"},
+	}
+	reversed := []tree{entries[2], entries[1], entries[0]}
+	if string(encodeTreeObject(entries)) != string(encodeTreeObject(reversed)) {
+		t.Fatal("encodeTreeObject output depends on input order")
+	}
+	decoded := decodeTreeObject(writeHeaderToContent(encodeTreeObject(entries), Tree), false)
+	want := []string{"a.b", "a", "a0"}
+	for i, entry := range decoded {
+		if entry.name != want[i] {
+			t.Errorf("entry %d = %s, want %s", i, entry.name, want[i])
+		}
+	}
+	if decoded[1].perm != "040000" {
+		t.Errorf("directory perm = %s, want 040000", decoded[1].perm)
+	}
+}
+
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeFixtureFile(t *testing.T, file fixtureFile) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if file.link != "" {
+		if err := os.Symlink(file.link, file.path); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	mode := file.mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.WriteFile(file.path, []byte(file.content), mode); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile is subject to the umask, so set the mode explicitly.
+	if err := os.Chmod(file.path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import "strings"
+
+/*
+*  ###################### COMMIT GRAPH ##############################
+*
+*  The lines drawn by --graph follow git's graph.c. Every line of history
+*  waiting for its next commit has a column. A commit's line is followed by
+*  a line splitting it into its parents when it is a merge, and by lines
+*  moving the columns that reach the same commit into the leftmost one,
+*  one step at a time.
+ */
+
+type graphState int
+
+const (
+	graphPadding graphState = iota
+	graphSkip
+	graphPreCommit
+	graphCommit
+	graphPostMerge
+	graphCollapsing
+)
+
+// commitGraph draws the history lines shown by --graph to the left of the
+// commits.
+type commitGraph struct {
+	commit string
+	// parents are the parents of commit that are shown
+	parents []string
+	// mark is drawn for the commit, normally "*"
+	mark            string
+	width           int
+	expansionRow    int
+	state           graphState
+	prevState       graphState
+	commitIndex     int
+	prevCommitIndex int
+	// mergeLayout is 0 when the first parent of a merge is in the column
+	// left of it, and 1 otherwise
+	mergeLayout    int
+	edgesAdded     int
+	prevEdgesAdded int
+	columns        []string
+	newColumns     []string
+	// mapping tells, for each screen position, which new column the line
+	// drawn there leads to
+	mapping     []int
+	oldMapping  []int
+	mappingSize int
+}
+
+func newCommitGraph() *commitGraph {
+	return &commitGraph{}
+}
+
+// update moves the graph to the commit hexHash, whose shown parents are
+// given. The lines up to the commit's own come from nextLine.
+func (graph *commitGraph) update(hexHash string, parents []string, mark string) {
+	graph.commit = hexHash
+	graph.parents = parents
+	graph.mark = mark
+	graph.prevCommitIndex = graph.commitIndex
+	graph.updateColumns()
+	graph.expansionRow = 0
+	switch {
+	case graph.state != graphPadding:
+		graph.state = graphSkip
+	case graph.needsPreCommitLine():
+		graph.state = graphPreCommit
+	default:
+		graph.state = graphCommit
+	}
+}
+
+func (graph *commitGraph) setState(state graphState) {
+	graph.prevState = graph.state
+	graph.state = state
+}
+
+func (graph *commitGraph) dashedParents() int {
+	return len(graph.parents) + graph.mergeLayout - 3
+}
+
+func (graph *commitGraph) needsPreCommitLine() bool {
+	return len(graph.parents) >= 3 &&
+		graph.commitIndex < len(graph.columns)-1 &&
+		graph.expansionRow < 2*graph.dashedParents()
+}
+
+func (graph *commitGraph) findNewColumn(hexHash string) int {
+	for i, column := range graph.newColumns {
+		if column == hexHash {
+			return i
+		}
+	}
+	return -1
+}
+
+func (graph *commitGraph) ensureMapping(size int) {
+	for len(graph.mapping) < size {
+		graph.mapping = append(graph.mapping, -1)
+		graph.oldMapping = append(graph.oldMapping, -1)
+	}
+}
+
+// updateColumns computes the columns after the commit, and where the
+// lines of the current columns are headed.
+func (graph *commitGraph) updateColumns() {
+	graph.columns, graph.newColumns = graph.newColumns, nil
+	graph.mappingSize = 2 * (len(graph.columns) + len(graph.parents))
+	graph.ensureMapping(graph.mappingSize)
+	for i := range graph.mappingSize {
+		graph.mapping[i] = -1
+	}
+	graph.width = 0
+	graph.prevEdgesAdded, graph.edgesAdded = graph.edgesAdded, 0
+
+	seenThis := false
+	for i := 0; i <= len(graph.columns); i++ {
+		var column string
+		if i == len(graph.columns) {
+			if seenThis {
+				break
+			}
+			column = graph.commit
+		} else {
+			column = graph.columns[i]
+		}
+		if column != graph.commit {
+			graph.insertIntoNewColumns(column, -1)
+			continue
+		}
+		seenThis = true
+		graph.commitIndex = i
+		graph.mergeLayout = -1
+		for _, parent := range graph.parents {
+			graph.insertIntoNewColumns(parent, i)
+		}
+		// The commit takes up its column even without parents
+		if len(graph.parents) == 0 {
+			graph.width += 2
+		}
+	}
+	for graph.mappingSize > 1 && graph.mapping[graph.mappingSize-1] < 0 {
+		graph.mappingSize--
+	}
+}
+
+// insertIntoNewColumns adds hexHash to the new columns unless it is there
+// already, and maps the next screen position to it. index is the column
+// of the merge whose parent this is, or -1.
+func (graph *commitGraph) insertIntoNewColumns(hexHash string, index int) {
+	i := graph.findNewColumn(hexHash)
+	if i < 0 {
+		i = len(graph.newColumns)
+		graph.newColumns = append(graph.newColumns, hexHash)
+	}
+	var mappingIndex int
+	switch {
+	case len(graph.parents) > 1 && index > -1 && graph.mergeLayout == -1:
+		// The first parent of a merge decides whether the merge line
+		// leans left or right
+		distance := index - i
+		shift := 1
+		if distance > 1 {
+			shift = 2*distance - 3
+		}
+		graph.mergeLayout = 1
+		if distance > 0 {
+			graph.mergeLayout = 0
+		}
+		graph.edgesAdded = len(graph.parents) + graph.mergeLayout - 2
+		mappingIndex = graph.width + (graph.mergeLayout-1)*shift
+		graph.width += 2 * graph.mergeLayout
+	case graph.edgesAdded > 0 && i == graph.mapping[graph.width-2]:
+		// A parent found in the last existing column joins it at once
+		mappingIndex = graph.width - 2
+		graph.edgesAdded = -1
+	default:
+		mappingIndex = graph.width
+		graph.width += 2
+	}
+	graph.ensureMapping(mappingIndex + 1)
+	graph.mapping[mappingIndex] = i
+}
+
+func (graph *commitGraph) isMappingCorrect() bool {
+	for i := range graph.mappingSize {
+		if target := graph.mapping[i]; target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// isCommitFinished reports whether every line of the commit was drawn.
+func (graph *commitGraph) isCommitFinished() bool {
+	return graph.state == graphPadding
+}
+
+// nextLine returns the next line of the graph, and whether it is the one
+// showing the commit.
+func (graph *commitGraph) nextLine() (string, bool) {
+	line := &strings.Builder{}
+	shownCommit := false
+	switch graph.state {
+	case graphPadding:
+		for range graph.newColumns {
+			line.WriteString("| ")
+		}
+	case graphSkip:
+		line.WriteString("...")
+		if graph.needsPreCommitLine() {
+			graph.setState(graphPreCommit)
+		} else {
+			graph.setState(graphCommit)
+		}
+	case graphPreCommit:
+		graph.preCommitLine(line)
+	case graphCommit:
+		graph.commitLine(line)
+		shownCommit = true
+	case graphPostMerge:
+		graph.postMergeLine(line)
+	case graphCollapsing:
+		graph.collapsingLine(line)
+	}
+	return graph.pad(line.String()), shownCommit
+}
+
+// pad widens line so that everything right of the graph stays aligned.
+func (graph *commitGraph) pad(line string) string {
+	if len(line) < graph.width {
+		line += strings.Repeat(" ", graph.width-len(line))
+	}
+	return line
+}
+
+// paddingLine is the graph in front of a line that is not part of any
+// commit, like the blank line between commits.
+func (graph *commitGraph) paddingLine() string {
+	if graph.state != graphCommit {
+		line, _ := graph.nextLine()
+		return line
+	}
+	line := &strings.Builder{}
+	for _, column := range graph.columns {
+		line.WriteString("|")
+		if column == graph.commit && len(graph.parents) > 2 {
+			line.WriteString(strings.Repeat(" ", (len(graph.parents)-2)*2))
+		} else {
+			line.WriteString(" ")
+		}
+	}
+	graph.prevState = graphPadding
+	return graph.pad(line.String())
+}
+
+// preCommitLine makes room around an octopus merge for its parents.
+func (graph *commitGraph) preCommitLine(line *strings.Builder) {
+	seenThis := false
+	for i, column := range graph.columns {
+		switch {
+		case column == graph.commit:
+			seenThis = true
+			line.WriteString("|" + strings.Repeat(" ", graph.expansionRow))
+		case seenThis && graph.expansionRow == 0:
+			if graph.prevState == graphPostMerge && graph.prevCommitIndex < i {
+				line.WriteString("\\")
+			} else {
+				line.WriteString("|")
+			}
+		case seenThis:
+			line.WriteString("\\")
+		default:
+			line.WriteString("|")
+		}
+		line.WriteString(" ")
+	}
+	graph.expansionRow++
+	if !graph.needsPreCommitLine() {
+		graph.setState(graphCommit)
+	}
+}
+
+func (graph *commitGraph) commitLine(line *strings.Builder) {
+	seenThis := false
+	for i := 0; i <= len(graph.columns); i++ {
+		var column string
+		if i == len(graph.columns) {
+			if seenThis {
+				break
+			}
+			column = graph.commit
+		} else {
+			column = graph.columns[i]
+		}
+		switch {
+		case column == graph.commit:
+			seenThis = true
+			line.WriteString(graph.mark)
+			if len(graph.parents) > 2 {
+				dashed := graph.dashedParents()
+				line.WriteString(strings.Repeat("-", 2*dashed-1) + ".")
+			}
+		case seenThis && graph.edgesAdded > 1:
+			line.WriteString("\\")
+		case seenThis && graph.edgesAdded == 1:
+			// A line that left the previous merge as "\" keeps going
+			if graph.prevState == graphPostMerge && graph.prevEdgesAdded > 0 && graph.prevCommitIndex < i {
+				line.WriteString("\\")
+			} else {
+				line.WriteString("|")
+			}
+		case graph.prevState == graphCollapsing && graph.oldMapping[2*i+1] == i && graph.mapping[2*i] < i:
+			line.WriteString("/")
+		default:
+			line.WriteString("|")
+		}
+		line.WriteString(" ")
+	}
+	switch {
+	case len(graph.parents) > 1:
+		graph.setState(graphPostMerge)
+	case graph.isMappingCorrect():
+		graph.setState(graphPadding)
+	default:
+		graph.setState(graphCollapsing)
+	}
+}
+
+// postMergeLine splits a merge into the lines of its parents.
+func (graph *commitGraph) postMergeLine(line *strings.Builder) {
+	mergeChars := []string{"/", "|", "\\"}
+	seenThis := false
+	parentColumn := false
+	for i := 0; i <= len(graph.columns); i++ {
+		var column string
+		if i == len(graph.columns) {
+			if seenThis {
+				break
+			}
+			column = graph.commit
+		} else {
+			column = graph.columns[i]
+		}
+		switch {
+		case column == graph.commit:
+			seenThis = true
+			index := graph.mergeLayout
+			for j := range graph.parents {
+				line.WriteString(mergeChars[index])
+				if index == 2 {
+					if graph.edgesAdded > 0 || j < len(graph.parents)-1 {
+						line.WriteString(" ")
+					}
+				} else {
+					index++
+				}
+			}
+			if graph.edgesAdded == 0 {
+				line.WriteString(" ")
+			}
+		case seenThis:
+			if graph.edgesAdded > 0 {
+				line.WriteString("\\ ")
+			} else {
+				line.WriteString("| ")
+			}
+		default:
+			line.WriteString("|")
+			if graph.mergeLayout != 0 || i != graph.commitIndex-1 {
+				if parentColumn {
+					line.WriteString("_")
+				} else {
+					line.WriteString(" ")
+				}
+			}
+		}
+		if column == graph.parents[0] {
+			parentColumn = true
+		}
+	}
+	if graph.isMappingCorrect() {
+		graph.setState(graphPadding)
+	} else {
+		graph.setState(graphCollapsing)
+	}
+}
+
+// collapsingLine moves every line one step closer to its column, crossing
+// other lines with "_" where needed.
+func (graph *commitGraph) collapsingLine(line *strings.Builder) {
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+	graph.mapping, graph.oldMapping = graph.oldMapping, graph.mapping
+	for i := range graph.mappingSize {
+		graph.mapping[i] = -1
+	}
+	for i := range graph.mappingSize {
+		target := graph.oldMapping[i]
+		switch {
+		case target < 0:
+		case target*2 == i:
+			graph.mapping[i] = target
+		case graph.mapping[i-1] < 0:
+			graph.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					graph.mapping[j] = target
+				}
+			}
+		case graph.mapping[i-1] == target:
+			// Joins the line to its left, which leads to the same commit
+		default:
+			graph.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+			}
+		}
+	}
+	copy(graph.oldMapping, graph.mapping[:graph.mappingSize])
+	if graph.mapping[graph.mappingSize-1] < 0 {
+		graph.mappingSize--
+	}
+	for i := range graph.mappingSize {
+		target := graph.mapping[i]
+		switch {
+		case target < 0:
+			line.WriteString(" ")
+		case target*2 == i:
+			line.WriteString("|")
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// Only the first segment of the edge continues on the next
+			// line
+			if i != target*2+3 {
+				graph.mapping[i] = -1
+			}
+			usedHorizontal = true
+			line.WriteString("_")
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				graph.mapping[i] = -1
+			}
+			line.WriteString("/")
+		}
+	}
+	if graph.isMappingCorrect() {
+		graph.setState(graphPadding)
+	}
+}
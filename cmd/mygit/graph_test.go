@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func drawGraph(commits [][]string) string {
+	graph := newCommitGraph()
+	var out strings.Builder
+	for _, commit := range commits {
+		graph.update(commit[0], commit[1:], "*")
+		for {
+			line, shownCommit := graph.nextLine()
+			if shownCommit {
+				out.WriteString(line + commit[0] + "\n")
+				break
+			}
+			out.WriteString(line + "\n")
+		}
+		for !graph.isCommitFinished() {
+			line, _ := graph.nextLine()
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.String()
+}
+
+func TestGraphMerge(t *testing.T) {
+	got := drawGraph([][]string{{"m", "a", "b"}, {"b", "base"}, {"a", "base"}, {"base"}})
+	want := "*   m\n" +
+		"|\\  \n" +
+		"| * b\n" +
+		"* | a\n" +
+		"|/  \n" +
+		"* base\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGraphOctopusAndCrossing(t *testing.T) {
+	got := drawGraph([][]string{{"o", "a", "b", "c"}, {"c", "x"}, {"b", "x"}, {"a", "x"}, {"x"}})
+	want := "*-.   o\n" +
+		"|\\ \\  \n" +
+		"| | * c\n" +
+		"| * | b\n" +
+		"| |/  \n" +
+		"* / a\n" +
+		"|/  \n" +
+		"* x\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// identity is the person line of a commit or tag: "Name <email> <unix> <tz>".
+type identity struct {
+	name  string
+	email string
+	date  string
+}
+
+func (id identity) String() string {
+	return id.name + " <" + id.email + "> " + id.date
+}
+
+// parseIdentity splits a person line as stored in commits and tags.
+func parseIdentity(line string) identity {
+	id := identity{}
+	emailStart := strings.IndexByte(line, '<')
+	emailEnd := strings.LastIndexByte(line, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		id.name = line
+		return id
+	}
+	id.name = strings.TrimSpace(line[:emailStart])
+	id.email = line[emailStart+1 : emailEnd]
+	id.date = strings.TrimSpace(line[emailEnd+1:])
+	return id
+}
+
+// parseNameEmail parses "Name <email>" as given to --author.
+func parseNameEmail(value string) (identity, bool) {
+	id := parseIdentity(value)
+	if id.email == "" || id.date != "" {
+		return identity{}, false
+	}
+	return id, true
+}
+
+// formatGitDate formats t the way dates are stored in objects, keeping the
+// offset of its location.
+func formatGitDate(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + " " + t.Format("-0700")
+}
+
+// Layouts of RFC 2822 and ISO 8601 dates accepted by git, where the seconds
+// of ISO 8601 times are optional. Layouts without a zone are interpreted in
+// the local timezone.
+var dateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006.01.02 15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04-0700",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02 15:04 -0700",
+	"2006-01-02 15:04 Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006.01.02 15:04",
+}
+
+// parseDate accepts the date formats git does for --date and the
+// GIT_*_DATE variables: its internal "<unix> <tz>" format optionally
+// prefixed with @, RFC 2822 and ISO 8601. The result is in the internal
+// format.
+func parseDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return formatGitDate(time.Now()), nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(value, "@"))
+	if len(fields) >= 1 && len(fields) <= 2 {
+		if _, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			if len(fields) == 1 && strings.HasPrefix(value, "@") {
+				return fields[0] + " +0000", nil
+			}
+			if len(fields) == 2 && isTimezone(fields[1]) {
+				return fields[0] + " " + fields[1], nil
+			}
+		}
+	}
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, stripFractionalSeconds(value), time.Local)
+		if err == nil {
+			return formatGitDate(t), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date format: %s", value)
+}
+
+// stripFractionalSeconds drops the fraction ISO 8601 allows after the
+// seconds, which git ignores as well.
+func stripFractionalSeconds(value string) string {
+	dot := strings.IndexByte(value, '.')
+	if dot < 0 || dot < len("2006-01-02T15:04:05") {
+		return value
+	}
+	end := dot + 1
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	return value[:dot] + value[end:]
+}
+
+func isTimezone(tz string) bool {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return false
+	}
+	_, err := strconv.Atoi(tz[1:])
+	return err == nil
+}
+
+// authorIdentity returns the author for new commits from GIT_AUTHOR_NAME,
+// GIT_AUTHOR_EMAIL and GIT_AUTHOR_DATE, falling back to the configuration.
+func authorIdentity(config *ini.File) identity {
+	return environmentIdentity(config, "AUTHOR", "author")
+}
+
+// committerIdentity is authorIdentity for the GIT_COMMITTER_* variables.
+func committerIdentity(config *ini.File) identity {
+	return environmentIdentity(config, "COMMITTER", "committer")
+}
+
+// environmentIdentity looks the name and email up in the environment, then
+// in <role>.name and user.name of the repository and global config.
+func environmentIdentity(config *ini.File, variable string, role string) identity {
+	id, ok := lookupIdentity(config, variable, role)
+	if !ok {
+		fmt.Println(`
+You haven't set any value for name and email for commit. To create commits, set name and email to global config file first. To do so you can execute below command
+
+	mygit config --global --add user.name "Your Name"
+	mygit config --global --add user.email "Your email address"`)
+		os.Exit(1)
+	}
+	return id
+}
+
+// lookupIdentity is environmentIdentity without the requirement that a
+// name and email are configured.
+func lookupIdentity(config *ini.File, variable string, role string) (identity, bool) {
+	id := identity{
+		name:  os.Getenv("GIT_" + variable + "_NAME"),
+		email: os.Getenv("GIT_" + variable + "_EMAIL"),
+	}
+	for _, section := range []string{role, "user"} {
+		if id.name == "" {
+			id.name = configValue(".", config, section, "name")
+		}
+		if id.email == "" {
+			id.email = configValue(".", config, section, "email")
+		}
+	}
+	if id.email == "" {
+		id.email = os.Getenv("EMAIL")
+	}
+	id.date = formatGitDate(time.Now())
+	if date := os.Getenv("GIT_" + variable + "_DATE"); date != "" {
+		parsed, err := parseDate(date)
+		exitIfError(err, fmt.Sprintf("fatal: invalid date format: %s", date))
+		id.date = parsed
+	}
+	return id, id.name != "" && id.email != ""
+}
+
+// dateTime converts a date in the internal "<unix> <tz>" format to a time.
+func dateTime(date string) time.Time {
+	seconds, _, _ := strings.Cut(date, " ")
+	unix, _ := strconv.ParseInt(seconds, 10, 64)
+	return time.Unix(unix, 0)
+}
+
+// dateInZone converts a date in the internal format to a time in the
+// timezone recorded with it.
+func dateInZone(date string) time.Time {
+	t := dateTime(date)
+	if _, tz, ok := strings.Cut(date, " "); ok {
+		if zone, err := time.Parse("-0700", tz); err == nil {
+			t = t.In(zone.Location())
+		}
+	}
+	return t
+}
+
+// formatDate shows a date in the internal format in one of the formats of
+// git's --date option.
+func formatDate(date string, format string, now time.Time) (string, error) {
+	t := dateInZone(date)
+	switch format {
+	case "", "default":
+		return t.Format("Mon Jan 2 15:04:05 2006 -0700"), nil
+	case "local":
+		return t.Local().Format("Mon Jan 2 15:04:05 2006"), nil
+	case "short":
+		return t.Format("2006-01-02"), nil
+	case "iso", "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700"), nil
+	case "iso-strict", "iso8601-strict":
+		return t.Format("2006-01-02T15:04:05-07:00"), nil
+	case "rfc", "rfc2822":
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700"), nil
+	case "raw":
+		return date, nil
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "relative":
+		return relativeDate(t, now), nil
+	}
+	return "", fmt.Errorf("unknown date format %s", format)
+}
+
+// relativeDate describes how long before now t is, rounding like git.
+func relativeDate(t time.Time, now time.Time) string {
+	plural := func(count int64, unit string) string {
+		if count == 1 {
+			return fmt.Sprintf("%d %s", count, unit)
+		}
+		return fmt.Sprintf("%d %ss", count, unit)
+	}
+	diff := now.Unix() - t.Unix()
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	diff = (diff + 12) / 24
+	switch {
+	case diff < 14:
+		return plural(diff, "day") + " ago"
+	case diff < 70:
+		return plural((diff+3)/7, "week") + " ago"
+	case diff < 365:
+		return plural((diff+15)/30, "month") + " ago"
+	case diff < 1825:
+		totalMonths := (diff*12*2 + 365) / (365 * 2)
+		years, months := totalMonths/12, totalMonths%12
+		if months > 0 {
+			return plural(years, "year") + ", " + plural(months, "month") + " ago"
+		}
+		return plural(years, "year") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+var relativeDateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// parseApproxidate accepts what parseDate does along with the relative
+// dates git understands in @{<date>} and --expire, like "yesterday",
+// "2.weeks.ago" or "3 days 4 hours ago".
+func parseApproxidate(value string, now time.Time) (time.Time, error) {
+	if date, err := parseDate(value); err == nil {
+		return dateTime(date), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local); err == nil {
+			return t, nil
+		}
+	}
+	// Like git, take long enough numbers as seconds since the epoch
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil && seconds >= 100000000 {
+		return time.Unix(seconds, 0), nil
+	}
+	fields := strings.Fields(strings.NewReplacer(".", " ", "_", " ").Replace(strings.ToLower(value)))
+	if len(fields) == 1 && fields[0] == "yesterday" {
+		return now.Add(-24 * time.Hour), nil
+	}
+	if len(fields) > 0 && fields[len(fields)-1] == "ago" {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+	}
+	t := now
+	for i := 0; i < len(fields); i += 2 {
+		count, err := strconv.Atoi(fields[i])
+		unit, known := relativeDateUnits[strings.TrimSuffix(fields[i+1], "s")]
+		if err != nil || !known {
+			return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+		}
+		t = t.Add(-time.Duration(count) * unit)
+	}
+	return t, nil
+}
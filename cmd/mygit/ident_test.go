@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"1112911993 +0200", "1112911993 +0200"},
+		{"@1112911993 -0700", "1112911993 -0700"},
+		{"@1112911993", "1112911993 +0000"},
+		{"Thu, 07 Apr 2005 22:13:13 +0200", "1112904793 +0200"},
+		{"7 Apr 2005 22:13:13 +0200", "1112904793 +0200"},
+		{"Thu Apr 7 22:13:13 2005 +0200", "1112904793 +0200"},
+		{"2005-04-07T22:13:13+02:00", "1112904793 +0200"},
+		{"2005-04-07T22:13:13Z", "1112911993 +0000"},
+		{"2005-04-07 22:13:13 +0200", "1112904793 +0200"},
+		{"2005-04-07T22:13:13.019+02:00", "1112904793 +0200"},
+		{"2020-07-01 12:00 +0200", "1593597600 +0200"},
+		{"2020-07-01T12:00+02:00", "1593597600 +0200"},
+		{"2020-07-01T12:00Z", "1593604800 +0000"},
+	}
+	for _, c := range cases {
+		got, err := parseDate(c.input)
+		if err != nil {
+			t.Errorf("parseDate(%q) failed: %s", c.input, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseDate(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+	for _, input := range []string{"yesterday-ish", "1112911993 0200", ""} {
+		if _, err := parseDate(input); err == nil {
+			t.Errorf("parseDate(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestParseDateWithoutZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+	for _, input := range []string{"2020-07-01 12:00", "2020-07-01T12:00", "2020-07-01 12:00:00"} {
+		if got, err := parseDate(input); err != nil || got != "1593604800 +0000" {
+			t.Errorf("parseDate(%q) = %q, %v, want 1593604800 +0000", input, got, err)
+		}
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	id := parseIdentity("A U Thor <author@example.com> 1112911993 +0200")
+	if id.name != "A U Thor" || id.email != "author@example.com" || id.date != "1112911993 +0200" {
+		t.Errorf("parseIdentity() = %+v", id)
+	}
+	if id.String() != "A U Thor <author@example.com> 1112911993 +0200" {
+		t.Errorf("String() = %q", id.String())
+	}
+}
+
+func TestParseApproxidate(t *testing.T) {
+	now := time.Unix(1112911993, 0)
+	cases := []struct {
+		input string
+		want  int64
+	}{
+		{"1112904793 +0200", 1112904793},
+		{"1112000000", 1112000000},
+		{"yesterday", 1112911993 - 24*3600},
+		{"2.weeks.ago", 1112911993 - 14*24*3600},
+		{"3 days 4 hours ago", 1112911993 - 3*24*3600 - 4*3600},
+	}
+	for _, c := range cases {
+		got, err := parseApproxidate(c.input, now)
+		if err != nil {
+			t.Errorf("parseApproxidate(%q) failed: %s", c.input, err)
+			continue
+		}
+		if got.Unix() != c.want {
+			t.Errorf("parseApproxidate(%q) = %d, want %d", c.input, got.Unix(), c.want)
+		}
+	}
+	for _, input := range []string{"2.fortnights.ago", "days ago", "12"} {
+		if _, err := parseApproxidate(input, now); err == nil {
+			t.Errorf("parseApproxidate(%q) succeeded, want error", input)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+*  ###################### INDEX FILE ##########################
+*
+*   D  I  R  C |   Version   | Entries Nos
+*  44 49 52 43 | 00 00 00 02 | 00 00 00 03 | Entries... | Extensions... | SHA1
+*
+*  Entry:
+*    ctime (sec, nsec) | mtime (sec, nsec) | dev | ino | mode | uid | gid | size
+*    all 32 bit, followed by the 20 byte object name, 16 bit flags and the path.
+*    Entries are NUL padded so that their length is a multiple of 8.
+*
+*  Flags: 1 bit assume-valid | 1 bit extended | 2 bits stage | 12 bits name length
+ */
+
+const indexEntryFixedSize = 62
+
+type indexEntry struct {
+	ctimeSec  uint32
+	ctimeNsec uint32
+	mtimeSec  uint32
+	mtimeNsec uint32
+	dev       uint32
+	ino       uint32
+	mode      uint32
+	uid       uint32
+	gid       uint32
+	size      uint32
+	sha       [20]byte
+	stage     uint8
+	name      string
+}
+
+func indexPath(repo string) string {
+	return filepath.Join(repo, ".git", "index")
+}
+
+// readIndex returns the entries of the index in repo. A repository without
+// an index has no entries.
+func readIndex(repo string) []indexEntry {
+	data, err := os.ReadFile(indexPath(repo))
+	if os.IsNotExist(err) {
+		return []indexEntry{}
+	}
+	exitIfError(err, fmt.Sprintf("fatal: unable to read index: %s", err))
+	entries, err := decodeIndex(data)
+	exitIfError(err, fmt.Sprintf("fatal: index file corrupt: %s", err))
+	return entries
+}
+
+func decodeIndex(data []byte) ([]indexEntry, error) {
+	if len(data) < 12+20 || string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("bad signature")
+	}
+	checksum := data[len(data)-20:]
+	if !bytes.Equal(hashContent(data[:len(data)-20]), checksum) {
+		return nil, fmt.Errorf("bad index file sha1 signature")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("index version %d is not supported", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+	entries := make([]indexEntry, 0, count)
+	cursor := 12
+	for range count {
+		if cursor+indexEntryFixedSize > len(data)-20 {
+			return nil, fmt.Errorf("truncated entry")
+		}
+		fields := [10]uint32{}
+		for i := range fields {
+			fields[i] = binary.BigEndian.Uint32(data[cursor+i*4:])
+		}
+		entry := indexEntry{
+			ctimeSec: fields[0], ctimeNsec: fields[1],
+			mtimeSec: fields[2], mtimeNsec: fields[3],
+			dev: fields[4], ino: fields[5], mode: fields[6],
+			uid: fields[7], gid: fields[8], size: fields[9],
+		}
+		copy(entry.sha[:], data[cursor+40:cursor+60])
+		flags := binary.BigEndian.Uint16(data[cursor+60:])
+		entry.stage = uint8(flags>>12) & 0x3
+		headerSize := indexEntryFixedSize
+		if flags&0x4000 != 0 {
+			// Version 3 extended flags, only used by sparse checkouts
+			headerSize += 2
+		}
+		nameEnd := bytes.IndexByte(data[cursor+headerSize:], 0)
+		if nameEnd < 0 {
+			return nil, fmt.Errorf("unterminated path")
+		}
+		entry.name = string(data[cursor+headerSize : cursor+headerSize+nameEnd])
+		entries = append(entries, entry)
+		entryLength := headerSize + nameEnd
+		cursor += entryLength + 8 - entryLength%8
+	}
+	return entries, nil
+}
+
+// writeIndex replaces the index in repo with entries, which are sorted the
+// way git expects them.
+func writeIndex(repo string, entries []indexEntry) {
+	sortIndexEntries(entries)
+	var buff bytes.Buffer
+	buff.WriteString("DIRC")
+	binary.Write(&buff, binary.BigEndian, uint32(2))
+	binary.Write(&buff, binary.BigEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		for _, field := range []uint32{
+			entry.ctimeSec, entry.ctimeNsec, entry.mtimeSec, entry.mtimeNsec,
+			entry.dev, entry.ino, entry.mode, entry.uid, entry.gid, entry.size,
+		} {
+			binary.Write(&buff, binary.BigEndian, field)
+		}
+		buff.Write(entry.sha[:])
+		nameLength := len(entry.name)
+		if nameLength > 0xFFF {
+			nameLength = 0xFFF
+		}
+		binary.Write(&buff, binary.BigEndian, uint16(entry.stage)<<12|uint16(nameLength))
+		buff.WriteString(entry.name)
+		entryLength := indexEntryFixedSize + len(entry.name)
+		buff.Write(make([]byte, 8-entryLength%8))
+	}
+	buff.Write(hashContent(buff.Bytes()))
+	lockPath := indexPath(repo) + ".lock"
+	err := os.WriteFile(lockPath, buff.Bytes(), 0644)
+	exitIfError(err, fmt.Sprintf("fatal: unable to write new index file: %s", err))
+	err = os.Rename(lockPath, indexPath(repo))
+	exitIfError(err, fmt.Sprintf("fatal: unable to write new index file: %s", err))
+}
+
+func sortIndexEntries(entries []indexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].stage < entries[j].stage
+	})
+}
+
+// newIndexEntry builds the entry for the file at name (relative to repo)
+// which is stored as object sha with the given tree mode.
+func newIndexEntry(repo string, name string, perm ObjectPerm, sha [20]byte) indexEntry {
+	entry := indexEntry{name: name, sha: sha, mode: indexModeFromPerm(perm)}
+	if perm == GITLINK {
+		// Submodules are not checked out, so there is nothing to stat
+		return entry
+	}
+	info, err := os.Lstat(filepath.Join(repo, name))
+	exitIfError(err, fmt.Sprintf("fatal: unable to stat '%s': %s", name, err))
+	fillIndexStat(&entry, info)
+	return entry
+}
+
+func indexModeFromPerm(perm ObjectPerm) uint32 {
+	switch perm {
+	case EXE:
+		return 0100755
+	case SYMLINK:
+		return 0120000
+	case GITLINK:
+		return 0160000
+	default:
+		return 0100644
+	}
+}
+
+func permFromIndexMode(mode uint32) ObjectPerm {
+	switch mode {
+	case 0100755:
+		return EXE
+	case 0120000:
+		return SYMLINK
+	case 0160000:
+		return GITLINK
+	default:
+		return FILE
+	}
+}
+
+func (entry indexEntry) hexSha() string {
+	return hex.EncodeToString(entry.sha[:])
+}
+
+// writeTreeFromIndex writes the trees for the stage zero entries and
+// returns the hash of the top level tree.
+func writeTreeFromIndex(entries []indexEntry) []byte {
+	return writeIndexTree(entries, "")
+}
+
+func writeIndexTree(entries []indexEntry, dir string) []byte {
+	treeEntries := []tree{}
+	subtrees := map[string][]indexEntry{}
+	subtreeNames := []string{}
+	for _, entry := range entries {
+		name := entry.name
+		if dir != "" {
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		child, _, nested := strings.Cut(name, "/")
+		if nested {
+			if _, seen := subtrees[child]; !seen {
+				subtreeNames = append(subtreeNames, child)
+			}
+			subtrees[child] = append(subtrees[child], entry)
+			continue
+		}
+		treeEntries = append(treeEntries, tree{perm: permFromIndexMode(entry.mode), name: name, sha: entry.sha})
+	}
+	for _, child := range subtreeNames {
+		hash := writeIndexTree(subtrees[child], path.Join(dir, child))
+		treeEntries = append(treeEntries, tree{perm: DIR, name: child, sha: [20]byte(hash)})
+	}
+	return writeTreeObject(treeEntries)
+}
+
+// stageFile writes the blob for the working tree file name and returns its
+// index entry.
+func stageFile(repo string, name string) indexEntry {
+	info, err := os.Lstat(worktreePath(repo, name))
+	exitIfError(err, fmt.Sprintf("fatal: unable to stat '%s': %s", name, err))
+	perm, _ := hashWorktreeFile(repo, name, info)
+	var content []byte
+	if perm == SYMLINK {
+		target, err := os.Readlink(worktreePath(repo, name))
+		exitIfError(err, fmt.Sprintf("fatal: unable to read '%s': %s", name, err))
+		content = []byte(target)
+	} else {
+		content, err = os.ReadFile(worktreePath(repo, name))
+		exitIfError(err, fmt.Sprintf("fatal: unable to read '%s': %s", name, err))
+	}
+	hexHash := createBlobObject(content)
+	sha, _ := hex.DecodeString(hexHash)
+	return newIndexEntry(repo, name, perm, [20]byte(sha))
+}
+
+// stageTrackedChanges updates the entries of modified tracked files and
+// drops the ones deleted from the working tree, like `git add -u`. Only
+// entries selected by filter are considered.
+func stageTrackedChanges(repo string, entries []indexEntry, filter func(string) bool) []indexEntry {
+	staged := []indexEntry{}
+	for _, entry := range entries {
+		if entry.stage != 0 || entry.mode == 0160000 || !filter(entry.name) || !worktreeChanged(repo, entry) {
+			staged = append(staged, entry)
+			continue
+		}
+		if info, err := os.Lstat(worktreePath(repo, entry.name)); err != nil || info.IsDir() {
+			continue
+		}
+		staged = append(staged, stageFile(repo, entry.name))
+	}
+	return staged
+}
+
+// addPaths stages every file below the given pathspecs along with the
+// removal of tracked files that no longer exist.
+func addPaths(repo string, pathspecs []string) {
+	// Entries are grouped by path so that unmerged paths keep their stages
+	index := map[string][]indexEntry{}
+	entries := readIndex(repo)
+	matchesAny := func(name string) bool {
+		for _, spec := range pathspecs {
+			if pathspecMatches(name, spec) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, entry := range stageTrackedChanges(repo, entries, matchesAny) {
+		index[entry.name] = append(index[entry.name], entry)
+	}
+	for _, spec := range pathspecs {
+		root := path.Clean(filepath.ToSlash(spec))
+		info, err := os.Lstat(worktreePath(repo, root))
+		if err != nil {
+			if !matchedAnyEntry(entries, spec) {
+				fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", spec)
+				os.Exit(128)
+			}
+			// Unmerged paths deleted from the working tree are resolved
+			// as deleted
+			delete(index, root)
+			continue
+		}
+		if !info.IsDir() {
+			index[root] = []indexEntry{stageFile(repo, root)}
+			continue
+		}
+		err = filepath.WalkDir(worktreePath(repo, root), func(fullPath string, file os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if file.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if file.IsDir() {
+				return nil
+			}
+			name, err := filepath.Rel(repo, fullPath)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+			if tracked := index[name]; len(tracked) == 1 && tracked[0].stage == 0 && !worktreeChanged(repo, tracked[0]) {
+				return nil
+			}
+			index[name] = []indexEntry{stageFile(repo, name)}
+			return nil
+		})
+		exitIfError(err, fmt.Sprintf("fatal: unable to add '%s': %s", spec, err))
+	}
+	staged := make([]indexEntry, 0, len(index))
+	for _, entries := range index {
+		staged = append(staged, entries...)
+	}
+	writeIndex(repo, staged)
+}
+
+func matchedAnyEntry(entries []indexEntry, spec string) bool {
+	for _, entry := range entries {
+		if pathspecMatches(entry.name, spec) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	dir := chdirTemp(t)
+	treeHex := writeModesTree(t)
+	entries := checkoutTree(".", treeHex, "")
+	unmerged := append([]indexEntry{{name: "conflict", mode: 0100644, stage: 2}}, entries...)
+	writeIndex(".", unmerged)
+	if got := readIndex("."); !reflect.DeepEqual(got, unmerged) {
+		t.Fatalf("readIndex() = %v, want %v", got, unmerged)
+	}
+
+	// Git itself must agree that the checkout matches the index and HEAD
+	writeIndex(".", entries)
+	commit := "tree " + treeHex + "\nauthor A <a@x> 1600000000 +0000\ncommitter A <a@x> 1600000000 +0000\n\nbase\n"
+	if err := os.MkdirAll(".git/refs/heads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeRef(".", "refs/heads/main", createCommitObject([]byte(commit)), "")
+	writeSymbolicRef(".", "HEAD", "refs/heads/main", "")
+	if status := realGit(t, dir, "status", "--porcelain"); status != "" {
+		t.Errorf("git status reports changes:\n%s", status)
+	}
+}
+
+func TestAddResolvesOnlyTheGivenConflicts(t *testing.T) {
+	repo := newTestRepo(t)
+	commitAll := func(message string) {
+		mygit(t, repo, "add", ".")
+		mygit(t, repo, "commit", "-a", "-m", message)
+	}
+	for _, name := range []string{"c", "d", "e"} {
+		writeTestFile(t, repo, name, "base\n")
+	}
+	commitAll("base")
+	mygit(t, repo, "switch", "-c", "side")
+	writeTestFile(t, repo, "c", "side\n")
+	writeTestFile(t, repo, "d", "side\n")
+	if err := os.Remove(worktreePath(repo, "e")); err != nil {
+		t.Fatal(err)
+	}
+	commitAll("side")
+	mygit(t, repo, "switch", "main")
+	for _, name := range []string{"c", "d", "e"} {
+		writeTestFile(t, repo, name, "main\n")
+	}
+	commitAll("main")
+	if result := runMygit(t, repo, "", "merge", "side"); result.code != 1 {
+		t.Fatalf("merge = %d, want conflicts\n%s", result.code, result.stderr)
+	}
+	writeTestFile(t, repo, "c", "resolved\n")
+	if err := os.Remove(worktreePath(repo, "e")); err != nil {
+		t.Fatal(err)
+	}
+	mygit(t, repo, "add", "c", "e")
+	stages := map[string][]uint8{}
+	for _, entry := range readIndex(repo) {
+		stages[entry.name] = append(stages[entry.name], entry.stage)
+	}
+	want := map[string][]uint8{"c": {0}, "d": {1, 2, 3}}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("index stages = %v, want %v", stages, want)
+	}
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// logOptions control how log prints the commits of a walk.
+type logOptions struct {
+	// format is a pretty format name like "medium" or "oneline", or a
+	// "format:" or "tformat:" string with placeholders.
+	format       string
+	abbrevCommit bool
+	graph        bool
+	// decorate is "short" or "full" to show the refs pointing at commits.
+	decorate string
+	date     string
+	// diff holds the formats the changes of each commit are printed in,
+	// none when its output is zero, and paths limits them to some files.
+	diff        diffOptions
+	renames     renameOptions
+	paths       []string
+	firstParent bool
+	// combined shows the changes of merges as a combined diff against all
+	// their parents.
+	combined bool
+}
+
+var prettyFormats = []string{"oneline", "short", "medium", "full", "fuller", "raw", "reference"}
+
+// prettyFlags are the command line options shared by log and show to
+// format commits.
+type prettyFlags struct {
+	Oneline      bool    `long:"oneline" description:"Shorthand for --pretty=oneline --abbrev-commit"`
+	Pretty       *string `long:"pretty" optional:"yes" optional-value:"medium" description:"Pretty-print the commits in the given format"`
+	Format       *string `long:"format" description:"Pretty-print the commits in the given format"`
+	AbbrevCommit bool    `long:"abbrev-commit" description:"Show abbreviated commit object names"`
+	Decorate     string  `long:"decorate" optional:"yes" optional-value:"short" description:"Print the ref names of shown commits"`
+	NoDecorate   bool    `long:"no-decorate" description:"Do not print ref names"`
+	Date         string  `long:"date" description:"Format of the dates shown"`
+}
+
+// logOptions converts the flags to the options of a log.
+func (flags prettyFlags) logOptions() (logOptions, error) {
+	options := logOptions{format: "medium", abbrevCommit: flags.AbbrevCommit, date: flags.Date}
+	if flags.Oneline {
+		options.format, options.abbrevCommit = "oneline", true
+	}
+	for _, flag := range []*string{flags.Pretty, flags.Format} {
+		if flag == nil {
+			continue
+		}
+		switch format := *flag; {
+		case slices.Contains(prettyFormats, format):
+			options.format = format
+		case strings.HasPrefix(format, "format:") || strings.HasPrefix(format, "tformat:"):
+			options.format = format
+		case format == "" || strings.Contains(format, "%") || flag == flags.Format:
+			options.format = "tformat:" + format
+		default:
+			return options, fmt.Errorf("invalid --pretty format: %s", format)
+		}
+	}
+	switch {
+	case flags.NoDecorate || flags.Decorate == "no":
+	case flags.Decorate == "short" || flags.Decorate == "full":
+		options.decorate = flags.Decorate
+	case flags.Decorate != "":
+		return options, fmt.Errorf("invalid --decorate option: %s", flags.Decorate)
+	}
+	if _, err := formatDate("0 +0000", flags.Date, time.Now()); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// printLog prints the commits of walk the way `git log` does.
+func printLog(repo string, walk *revWalk, options logOptions) {
+	printer := newLogPrinter(repo, options)
+	for _, hexHash := range walk.commits {
+		printer.printCommit(hexHash, walk.commit(hexHash), walk.shownParents(hexHash))
+	}
+}
+
+// logPrinter prints commits one after the other, separating each from
+// what was printed before it.
+type logPrinter struct {
+	repo        string
+	options     logOptions
+	decorations map[string][]string
+	graph       *commitGraph
+	userFormat  string
+	// isUserFormat is set for format: and tformat: strings, and terminated
+	// for formats that end each commit with a newline instead of putting
+	// one between commits
+	isUserFormat, terminated bool
+	// Empty formats print nothing for commits, not even the terminators
+	// of tformat:, so that only their changes are listed
+	emptyFormat bool
+	now         time.Time
+	// shownOne tells whether anything was printed yet, and missingNewline
+	// whether the previous commit ended without a newline, which the graph
+	// does not draw in front of
+	shownOne, missingNewline bool
+}
+
+func newLogPrinter(repo string, options logOptions) *logPrinter {
+	printer := &logPrinter{
+		repo:         repo,
+		decorations:  refDecorations(repo, options.decorate == "full"),
+		isUserFormat: true,
+		terminated:   options.format == "oneline",
+		now:          time.Now(),
+	}
+	if options.graph {
+		printer.graph = newCommitGraph()
+	}
+	switch {
+	case strings.HasPrefix(options.format, "format:"):
+		printer.userFormat = strings.TrimPrefix(options.format, "format:")
+	case strings.HasPrefix(options.format, "tformat:"):
+		printer.userFormat, printer.terminated = strings.TrimPrefix(options.format, "tformat:"), true
+	case options.format == "reference":
+		printer.userFormat, printer.terminated = "%h (%s, %ad)", true
+		if options.date == "" {
+			options.date = "short"
+		}
+	default:
+		printer.isUserFormat = false
+	}
+	printer.emptyFormat = printer.isUserFormat && printer.userFormat == ""
+	printer.options = options
+	return printer
+}
+
+// printCommit prints a commit, drawn on the graph with shownParents as its
+// parents, followed by its changes when they are asked for.
+func (printer *logPrinter) printCommit(hexHash string, commit commitObject, shownParents []string) {
+	options, graph := printer.options, printer.graph
+	text := ""
+	if printer.isUserFormat {
+		text = expandCommitFormat(printer.userFormat, hexHash, commit, printer.decorations[hexHash], options, printer.now)
+	} else {
+		var decoration []string
+		if options.decorate != "" {
+			decoration = printer.decorations[hexHash]
+		}
+		text = prettyCommit(hexHash, commit, decoration, options, printer.now)
+	}
+	if graph != nil {
+		graph.update(hexHash, shownParents, "*")
+	}
+
+	// Built-in formats and format: strings separate commits, while
+	// oneline and tformat: strings terminate each of them
+	if printer.shownOne && !printer.terminated {
+		if graph != nil && !printer.missingNewline {
+			fmt.Print(graph.paddingLine())
+		}
+		fmt.Print("\n")
+	}
+	printer.shownOne = true
+	printer.missingNewline = !strings.HasSuffix(text, "\n")
+	if graph == nil {
+		fmt.Print(text)
+	} else {
+		printGraphText(graph, text)
+	}
+	if printer.terminated && !printer.emptyFormat {
+		if graph != nil && !printer.missingNewline {
+			fmt.Print(graph.paddingLine())
+		}
+		fmt.Print("\n")
+	}
+	if options.diff.output&^outputNone != 0 {
+		printer.printCommitDiff(commit)
+	}
+}
+
+// printCommitDiff prints the changes of a commit from its first parent,
+// or of all its files for root commits. Merges only show changes when
+// following first parents, or as a combined diff. The changes are set
+// apart from a commit message by an empty line, or by "---" between a
+// message and a stat followed by patches.
+func (printer *logPrinter) printCommitDiff(commit commitObject) {
+	repo, options := printer.repo, printer.options
+	combined := len(commit.parents) > 1 && !options.firstParent
+	if combined && !options.combined {
+		return
+	}
+	var pairs []filePair
+	if !combined {
+		parentTree := ""
+		if len(commit.parents) > 0 {
+			parentTree = readCommit(repo, commit.parents[0]).tree
+		}
+		treeOptions := treeDiffOptions{recursive: true, unmodified: options.renames.copiesHarder}
+		pairs = detectRenames(repo, diffTrees(repo, parentTree, commit.tree, treeOptions, options.paths), options.renames)
+		if len(pairs) == 0 {
+			return
+		}
+	}
+	prefix := func() string {
+		if printer.graph == nil {
+			return ""
+		}
+		return printer.graph.paddingLine()
+	}
+	diffOptions := options.diff
+	diffOptions.stat.prefixWidth = len(prefix())
+	var diff bytes.Buffer
+	out := bufio.NewWriter(&diff)
+	writer := &patchWriter{out: out, options: diffOptions}
+	separator := ""
+	switch {
+	case combined:
+		// Merges always set their changes apart, even when there are none
+		// or the commit took a single line
+		writer.writeCombinedDiff(repo, commit, options.renames, options.paths)
+		if printer.emptyFormat {
+			separator = "none"
+		}
+	case options.format == "oneline" || printer.emptyFormat:
+		separator = "none"
+		writer.writeDiff(repo, pairs)
+	case options.diff.output&(outputStat|outputPatch) == outputStat|outputPatch:
+		separator = "---"
+		writer.writeDiff(repo, pairs)
+	default:
+		writer.writeDiff(repo, pairs)
+	}
+	out.Flush()
+	if separator != "none" {
+		fmt.Print(prefix() + separator + "\n")
+	}
+	for _, line := range strings.SplitAfter(diff.String(), "\n") {
+		if line != "" {
+			fmt.Print(prefix() + line)
+		}
+	}
+}
+
+// printGraphText prints the text of a commit with the graph in front of
+// each of its lines, followed by the graph lines the commit still needs.
+func printGraphText(graph *commitGraph, text string) {
+	for {
+		line, shownCommit := graph.nextLine()
+		fmt.Print(line)
+		if shownCommit {
+			break
+		}
+		fmt.Print("\n")
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		fmt.Print(line)
+		if i < len(lines)-1 && lines[i+1] != "" {
+			next, _ := graph.nextLine()
+			fmt.Print(next)
+		}
+	}
+	if graph.isCommitFinished() {
+		return
+	}
+	terminated := strings.HasSuffix(text, "\n")
+	if !terminated {
+		fmt.Print("\n")
+	}
+	for {
+		line, _ := graph.nextLine()
+		fmt.Print(line)
+		if graph.isCommitFinished() {
+			break
+		}
+		fmt.Print("\n")
+	}
+	if terminated {
+		fmt.Print("\n")
+	}
+}
+
+// prettyCommit formats a commit in one of the built-in pretty formats.
+func prettyCommit(hexHash string, commit commitObject, decoration []string, options logOptions, now time.Time) string {
+	name := hexHash
+	if options.abbrevCommit {
+		name = hexHash[:7]
+	}
+	decorated := ""
+	if len(decoration) > 0 {
+		decorated = " (" + strings.Join(decoration, ", ") + ")"
+	}
+	if options.format == "oneline" {
+		return name + decorated + " " + messageSubject(commit.message)
+	}
+
+	var buff strings.Builder
+	buff.WriteString("commit " + name + decorated + "\n")
+	author, committer := parseIdentity(commit.author), parseIdentity(commit.committer)
+	date := func(person identity) string {
+		formatted, err := formatDate(person.date, options.date, now)
+		if err != nil {
+			return person.date
+		}
+		return formatted
+	}
+	if options.format == "raw" {
+		buff.WriteString("tree " + commit.tree + "\n")
+		for _, parent := range commit.parents {
+			buff.WriteString("parent " + parent + "\n")
+		}
+		buff.WriteString("author " + commit.author + "\n")
+		buff.WriteString("committer " + commit.committer + "\n")
+	} else {
+		if len(commit.parents) > 1 {
+			abbreviated := []string{}
+			for _, parent := range commit.parents {
+				abbreviated = append(abbreviated, parent[:7])
+			}
+			buff.WriteString("Merge: " + strings.Join(abbreviated, " ") + "\n")
+		}
+		switch options.format {
+		case "short":
+			buff.WriteString("Author: " + author.name + " <" + author.email + ">\n")
+		case "full":
+			buff.WriteString("Author: " + author.name + " <" + author.email + ">\n")
+			buff.WriteString("Commit: " + committer.name + " <" + committer.email + ">\n")
+		case "fuller":
+			buff.WriteString("Author:     " + author.name + " <" + author.email + ">\n")
+			buff.WriteString("AuthorDate: " + date(author) + "\n")
+			buff.WriteString("Commit:     " + committer.name + " <" + committer.email + ">\n")
+			buff.WriteString("CommitDate: " + date(committer) + "\n")
+		default:
+			buff.WriteString("Author: " + author.name + " <" + author.email + ">\n")
+			buff.WriteString("Date:   " + date(author) + "\n")
+		}
+	}
+	message := strings.Trim(commit.message, "\n")
+	if options.format == "short" {
+		message, _, _ = strings.Cut(message, "\n\n")
+	}
+	if message != "" {
+		buff.WriteString("\n")
+		for _, line := range strings.Split(message, "\n") {
+			buff.WriteString("    " + line + "\n")
+		}
+	}
+	return buff.String()
+}
+
+// messageSubject returns the first paragraph of a message on one line.
+func messageSubject(message string) string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	return strings.Join(strings.Fields(strings.ReplaceAll(subject, "\n", " ")), " ")
+}
+
+// messageBody returns what follows the first paragraph of a message.
+func messageBody(message string) string {
+	_, body, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	return strings.TrimLeft(body, "\n")
+}
+
+// sanitizedSubject turns the subject into something usable as a file name,
+// like the %f placeholder.
+func sanitizedSubject(subject string) string {
+	var buff strings.Builder
+	dash := false
+	for _, r := range subject {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_') {
+			if dash && buff.Len() > 0 {
+				buff.WriteByte('-')
+			}
+			dash = false
+			buff.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+	return strings.TrimRight(buff.String(), ".")
+}
+
+var formatColors = map[string]string{
+	"red": "\033[31m", "green": "\033[32m", "yellow": "\033[33m", "blue": "\033[34m",
+	"magenta": "\033[35m", "cyan": "\033[36m", "white": "\033[37m", "bold": "\033[1m",
+	"reset": "\033[m",
+}
+
+// expandCommitFormat expands the placeholders of a --format string for a
+// commit. A + after the % adds a newline before a non-empty expansion, a -
+// removes the newlines before an empty one and a space adds a space before
+// a non-empty one.
+func expandCommitFormat(format string, hexHash string, commit commitObject, decoration []string, options logOptions, now time.Time) string {
+	var buff strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			buff.WriteByte(format[i])
+			continue
+		}
+		modifier := byte(0)
+		if strings.IndexByte("+- ", format[i+1]) >= 0 && i+2 < len(format) {
+			modifier = format[i+1]
+			i++
+		}
+		value, consumed := commitPlaceholder(format[i+1:], hexHash, commit, decoration, options, now)
+		if consumed == 0 {
+			buff.WriteByte('%')
+			if modifier != 0 {
+				buff.WriteByte(modifier)
+			}
+			continue
+		}
+		i += consumed
+		switch {
+		case modifier == '+' && value != "":
+			value = "\n" + value
+		case modifier == ' ' && value != "":
+			value = " " + value
+		case modifier == '-' && value == "":
+			trimmed := strings.TrimRight(buff.String(), "\n")
+			buff.Reset()
+			buff.WriteString(trimmed)
+		}
+		buff.WriteString(value)
+	}
+	return buff.String()
+}
+
+// commitPlaceholder expands the placeholder at the start of spec and
+// returns how many bytes it used, 0 for unknown placeholders.
+func commitPlaceholder(spec string, hexHash string, commit commitObject, decoration []string, options logOptions, now time.Time) (string, int) {
+	if strings.HasPrefix(spec, "C(") {
+		end := strings.IndexByte(spec, ')')
+		if end < 0 {
+			return "", 0
+		}
+		codes := ""
+		for _, name := range strings.Fields(spec[2:end]) {
+			codes += formatColors[name]
+		}
+		return codes, end + 1
+	}
+	for _, name := range []string{"red", "green", "blue", "reset"} {
+		if strings.HasPrefix(spec, "C"+name) {
+			return formatColors[name], len(name) + 1
+		}
+	}
+	if strings.HasPrefix(spec, "x") && len(spec) >= 3 {
+		if value, err := strconv.ParseUint(spec[1:3], 16, 8); err == nil {
+			return string([]byte{byte(value)}), 3
+		}
+	}
+	if (spec[0] == 'a' || spec[0] == 'c') && len(spec) >= 2 {
+		person := parseIdentity(commit.author)
+		if spec[0] == 'c' {
+			person = parseIdentity(commit.committer)
+		}
+		if value, ok := personPlaceholder(spec[1], person, options, now); ok {
+			return value, 2
+		}
+	}
+	abbreviate := func(hashes []string) string {
+		short := []string{}
+		for _, hexHash := range hashes {
+			short = append(short, hexHash[:7])
+		}
+		return strings.Join(short, " ")
+	}
+	switch spec[0] {
+	case 'H':
+		return hexHash, 1
+	case 'h':
+		return hexHash[:7], 1
+	case 'T':
+		return commit.tree, 1
+	case 't':
+		return commit.tree[:7], 1
+	case 'P':
+		return strings.Join(commit.parents, " "), 1
+	case 'p':
+		return abbreviate(commit.parents), 1
+	case 'd':
+		if len(decoration) == 0 {
+			return "", 1
+		}
+		return " (" + strings.Join(decoration, ", ") + ")", 1
+	case 'D':
+		return strings.Join(decoration, ", "), 1
+	case 's':
+		return messageSubject(commit.message), 1
+	case 'f':
+		return sanitizedSubject(messageSubject(commit.message)), 1
+	case 'b':
+		return messageBody(commit.message), 1
+	case 'B':
+		return commit.message, 1
+	case 'n':
+		return "\n", 1
+	case '%':
+		return "%", 1
+	}
+	return "", 0
+}
+
+// personPlaceholder expands the second letter of the %a and %c
+// placeholders.
+func personPlaceholder(letter byte, person identity, options logOptions, now time.Time) (string, bool) {
+	date := func(format string) string {
+		formatted, err := formatDate(person.date, format, now)
+		if err != nil {
+			return person.date
+		}
+		return formatted
+	}
+	switch letter {
+	case 'n', 'N':
+		return person.name, true
+	case 'e', 'E':
+		return person.email, true
+	case 'l', 'L':
+		localPart, _, _ := strings.Cut(person.email, "@")
+		return localPart, true
+	case 'd':
+		return date(options.date), true
+	case 'D':
+		return date("rfc2822"), true
+	case 'r':
+		return date("relative"), true
+	case 't':
+		return date("unix"), true
+	case 'i':
+		return date("iso"), true
+	case 'I':
+		return date("iso-strict"), true
+	case 's':
+		return date("short"), true
+	}
+	return "", false
+}
+
+// refDecorations lists the refs pointing at each commit the way --decorate
+// shows them: HEAD first, then the refs in reverse order of their names.
+func refDecorations(repo string, full bool) map[string][]string {
+	decorations := map[string][]string{}
+	names := listRefs(repo, "refs/")
+	for i := len(names) - 1; i >= 0; i-- {
+		hexHash, ok := resolveRef(repo, names[i])
+		if !ok {
+			continue
+		}
+		if peeled, err := peelRevision(repo, hexHash, ""); err == nil {
+			hexHash = peeled
+		}
+		display := names[i]
+		if !full {
+			display = shortRefName(display)
+		}
+		if strings.HasPrefix(names[i], "refs/tags/") {
+			display = "tag: " + display
+		}
+		decorations[hexHash] = append(decorations[hexHash], display)
+	}
+	head := headCommit(repo)
+	if head == "" {
+		return decorations
+	}
+	label := "HEAD"
+	if branch, ok := headBranch(repo); ok {
+		display := branch
+		if !full {
+			display = shortRefName(branch)
+		}
+		for i, name := range decorations[head] {
+			if name == display {
+				decorations[head] = append(decorations[head][:i], decorations[head][i+1:]...)
+				label = "HEAD -> " + display
+				break
+			}
+		}
+	}
+	decorations[head] = append([]string{label}, decorations[head]...)
+	return decorations
+}
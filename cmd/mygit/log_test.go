@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandCommitFormat(t *testing.T) {
+	commit := commitObject{
+		tree:      "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		parents:   []string{"17ff6ac4416a7f42cbaf557098ffb337bec1fab6"},
+		author:    "A U Thor <author@example.com> 1600000000 +0200",
+		committer: "C O Mitter <committer@example.com> 1600000100 +0200",
+		message:   "Fix the thing\n\nBecause it was broken.\n",
+	}
+	hexHash := "7d957f899fff3ecb7cad90c8d3883155585cd5e3"
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{"%h %p %t", "7d957f8 17ff6ac 4b825dc"},
+		{"%an <%ae> %ad", "A U Thor <author@example.com> Sun Sep 13 14:26:40 2020 +0200"},
+		{"%cn %ct %ci", "C O Mitter 1600000100 2020-09-13 14:28:20 +0200"},
+		{"%s|%f", "Fix the thing|Fix-the-thing"},
+		{"%b", "Because it was broken.\n"},
+		{"%s%+b", "Fix the thing\nBecause it was broken.\n"},
+		{"%d%x41%%", "A%"},
+	}
+	for _, c := range cases {
+		got := expandCommitFormat(c.format, hexHash, commit, nil, logOptions{}, time.Now())
+		if got != c.want {
+			t.Errorf("expandCommitFormat(%q) = %q, want %q", c.format, got, c.want)
+		}
+	}
+}
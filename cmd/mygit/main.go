@@ -1,15 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -21,13 +23,8 @@ import (
 func main() {
 	// GlobalconfigFileName := ".gitconfig"
 	// localConfigFileName := "config"
-	globalConfigPath := ""
-	currentOs := runtime.GOOS
-	if currentOs == "windows" {
-		globalConfigPath = filepath.Join(os.Getenv("USERPROFILE"), ".mygitconfig")
-	} else if currentOs == "linux" {
-		globalConfigPath = filepath.Join(os.Getenv("HOME"), ".mygitconfig")
-	} else {
+	globalConfigPath, supported := globalConfigFile()
+	if !supported {
 		fmt.Fprintf(os.Stderr, "fatal: unsupported platform\n")
 		os.Exit(1)
 	}
@@ -49,217 +46,2108 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Only these commands work without a repository in the current directory
+	switch os.Args[1] {
+	case "init", "clone", "config", "hash-object", "interpret-trailers", "merge-file":
+	default:
+		requireRepository(CWD)
+	}
+
 	switch command := os.Args[1]; command {
 	case "init":
-		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
+		for _, dir := range []string{".git", ".git/objects", ".git/refs/heads", ".git/refs/tags"} {
 			err := os.MkdirAll(filepath.Join(CWD, dir), 0755)
 			exitIfError(err, fmt.Sprintf("Error creating directory: %s\n", err))
 		}
-
-		headFileContents := []byte("ref: refs/heads/main\n")
-		err := os.WriteFile(filepath.Join(CWD, ".git", "HEAD"), headFileContents, 0644)
-		exitIfError(err, fmt.Sprintf("Error writing file: %s\n", err))
-		fmt.Println("Initialized git directory")
-
-	case "config":
-		type Option struct {
-			Global bool `short:"g" long:"global" description:"Do operations on global config file"`
-			Add    bool `short:"a" long:"add" description:"add new value to config file"`
-			Get    bool `short:"b" long:"get" description:"get value from config file"`
+		writeSymbolicRef(CWD, "HEAD", "refs/heads/main", "")
+		fmt.Println("Initialized git directory")
+
+	case "config":
+		type Option struct {
+			Global bool `short:"g" long:"global" description:"Do operations on global config file"`
+			Add    bool `short:"a" long:"add" description:"add new value to config file"`
+			Get    bool `short:"b" long:"get" description:"get value from config file"`
+		}
+		opts := Option{}
+		nFlagArgs, err := flags.Parse(&opts)
+		if err != nil {
+			panic(err)
+		}
+		if (opts.Add && opts.Get) || (!opts.Add && !opts.Get) {
+			// flag.Usage()
+			fmt.Fprintf(os.Stderr, "fatal: mygit config: invalid flags passed\n")
+			os.Exit(1)
+		}
+		if !opts.Global {
+			fmt.Fprintf(os.Stderr, "fatal: mygit config: only command with global flag supported as of now\n")
+			os.Exit(1)
+		}
+		configName := nFlagArgs[1]
+		sectionName := ""
+		keyName := ""
+		nameWithSection := strings.Split(configName, ".")
+		if len(nameWithSection) < 2 {
+			keyName = nameWithSection[0]
+		} else {
+			sectionName = nameWithSection[0]
+			keyName = nameWithSection[1]
+		}
+		if opts.Add {
+			if len(nFlagArgs) < 3 {
+				fmt.Fprintf(os.Stderr, "fatal: mygit config --global --add: value for key is invalid\n")
+				os.Exit(1)
+			}
+			value := nFlagArgs[2]
+			config.Section(sectionName).Key(keyName).SetValue(value)
+			config.SaveTo(globalConfigPath)
+		} else {
+			value := config.Section(sectionName).Key(keyName).String()
+			os.Stdout.Write([]byte(value))
+		}
+
+	case "cat-file":
+		type Options struct {
+			PrettyPrint bool `short:"p" description:"Pretty print content of objects"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		if !opts.PrettyPrint {
+			fmt.Fprintf(os.Stderr, "fatal: mygit cat-file: only -p mode is supported as of now\n")
+			os.Exit(1)
+		}
+		if len(args) != 2 {
+			fmt.Println(args)
+			// flag.Usage()
+			os.Exit(1)
+		}
+		// allowedTypes := []string{"blob", "tree", "commit"}
+		// objectType := flag.Arg(1)
+		sha := args[1]
+		objectPath := filepath.Join(CWD, ".git", "objects", sha[:2], sha[2:])
+		buff, err := os.ReadFile(objectPath)
+		exitIfError(err, fmt.Sprintf("Error reading file: %s\n", err))
+		data, _ := decompressContent(buff)
+		// Pretty print
+		spaceIndex := bytes.Index(data, []byte(" "))
+		objectType := string(data[:spaceIndex])
+
+		switch objectType {
+		case "blob":
+			data = decodeBlobObject(data, false)
+			os.Stdout.Write(data)
+		case "tree":
+			trees := decodeTreeObject(data, false)
+			for _, tree := range trees {
+				oType := objectTypeOfPerm(tree.perm)
+				os.Stdout.Write([]byte(fmt.Sprintf("%s %s %s\t%s\n", tree.perm, oType, hex.EncodeToString(tree.sha[:]), tree.name)))
+			}
+		default:
+			zeroIndex := bytes.Index(data, []byte{0x0})
+			os.Stdout.Write(data[zeroIndex+1:])
+		}
+
+	case "hash-object":
+		type Options struct {
+			WriteToStore bool `short:"w" long:"write" description:"Write object to store"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			panic(err)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "fatal: mygit hash-object: invalid filename passed\n")
+			os.Exit(1)
+		}
+		fileName := args[1]
+		buff, err := os.ReadFile(filepath.Join(CWD, fileName))
+		exitIfError(err, fmt.Sprintf("Error reading file: %s", err))
+		blob := writeHeaderToContent(buff, Blob)
+		hexHash := hex.EncodeToString(hashContent(blob))
+		if opts.WriteToStore {
+			createBlobObject(buff)
+		}
+		os.Stdout.Write([]byte(hexHash))
+
+	case "ls-tree":
+		type Options struct {
+			NameOnly   bool `short:"n" long:"name-only" description:"Only print name of the objects"`
+			ObjectOnly bool `short:"o" long:"object-only" description:"Only print hash of the objects"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			panic(err)
+		}
+		if opts.NameOnly && opts.ObjectOnly {
+			fmt.Fprintf(os.Stderr, "fatal: mygit ls-tree: --name-only and --object-only can't be combined!\n")
+			os.Exit(1)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "fatal: mygit ls-tree: insufficient arguments passed\n")
+			os.Exit(1)
+		}
+		treeSha := args[1]
+		treePath := ".git/objects/" + treeSha[:2] + "/" + treeSha[2:]
+		data, err := os.ReadFile(treePath)
+		exitIfError(err, fmt.Sprintf("Error reading file: %s", err))
+		trees := decodeTreeObject(data, true)
+		if opts.NameOnly {
+			for _, t := range trees {
+				fmt.Println(t.name)
+			}
+		} else if opts.ObjectOnly {
+			for _, t := range trees {
+				fmt.Println(hex.EncodeToString(t.sha[:]))
+			}
+		} else {
+			for _, t := range trees {
+				oType := objectTypeOfPerm(t.perm)
+				os.Stdout.Write([]byte(fmt.Sprintf("%s %s %s\t%s\n", t.perm, oType, hex.EncodeToString(t.sha[:]), t.name)))
+			}
+		}
+
+	case "write-tree":
+		hash := createTreeObject(".")
+		hexHash := hex.EncodeToString(hash)
+		os.Stdout.Write([]byte(hexHash))
+
+	case "commit-tree":
+		type Option struct {
+			Parents []string `short:"p" long:"parent" description:"hash of parent commit, repeat for merge commits"`
+			Message []string `short:"m" long:"message" description:"message of commit, each one is a paragraph"`
+			File    string   `short:"F" long:"file" description:"read the message from the given file, - for stdin"`
+			Cleanup string   `long:"cleanup" description:"how to clean up the message: strip, whitespace, verbatim or scissors" default:"verbatim"`
+			// A bare -S gets a blank key id, meaning the configured key
+			GpgSign   string `short:"S" long:"gpg-sign" optional:"yes" optional-value:" " description:"sign the commit, optionally with the given key id"`
+			NoGpgSign bool   `long:"no-gpg-sign" description:"do not sign the commit, overriding commit.gpgSign"`
+		}
+		opts := Option{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			panic(err)
+		}
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit commit-tree <tree> [(-p <parent>)...] [(-m <message>)...] [(-F <file>)...]\n")
+			os.Exit(129)
+		}
+		commit := commitObject{tree: resolveObjectOfType(".", args[1], Tree)}
+		for _, parent := range opts.Parents {
+			parentHex := resolveObjectOfType(".", parent, Commit)
+			if slices.Contains(commit.parents, parentHex) {
+				fmt.Fprintf(os.Stderr, "error: duplicate parent %s ignored\n", parentHex)
+				continue
+			}
+			commit.parents = append(commit.parents, parentHex)
+		}
+		commit.author = authorIdentity(config).String()
+		commit.committer = committerIdentity(config).String()
+		message := joinMessageParagraphs(opts.Message)
+		if opts.File != "" || len(opts.Message) == 0 {
+			// Like git, the message is read from stdin when none is given
+			file := opts.File
+			if file == "" {
+				file = "-"
+			}
+			if message != "" {
+				message += "\n"
+			}
+			message += readMessageFile(file)
+		}
+		commit.message, err = cleanupCommitMessage(message, opts.Cleanup)
+		exitIfError(err, fmt.Sprintf("fatal: %s", err))
+		if (opts.GpgSign != "" || configBool(".", config, "commit", "gpgSign")) && !opts.NoGpgSign {
+			signCommit(config, &commit, strings.TrimSpace(opts.GpgSign))
+		}
+		commitHex := createCommitObject(commit.encode())
+		os.Stdout.Write([]byte(commitHex))
+
+	case "verify-commit", "verify-tag":
+		type Options struct {
+			Verbose bool `short:"v" long:"verbose" description:"Print the contents of the object before verifying it"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit %s [-v] <object>...\n", command)
+			os.Exit(129)
+		}
+		failed := false
+		for _, name := range args[1:] {
+			hexHash, err := resolveRevision(".", name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s: unable to resolve\n", name)
+				failed = true
+				continue
+			}
+			objectType, content := readObject(".", hexHash)
+			if command == "verify-commit" && objectType == Tag {
+				hexHash, _ = peelToType(".", hexHash, Commit)
+				objectType, content = readObject(".", hexHash)
+			}
+			want := Commit
+			if command == "verify-tag" {
+				want = Tag
+			}
+			if objectType != want {
+				fmt.Fprintf(os.Stderr, "error: %s: cannot verify a non-%s object of type %s.\n", name, objectTypeName(want), objectTypeName(objectType))
+				failed = true
+				continue
+			}
+			var signature string
+			var payload []byte
+			var signed bool
+			if objectType == Commit {
+				signature, payload, signed = splitCommitSignature(parseCommit(content))
+			} else {
+				signature, payload, signed = splitTagSignature(content)
+			}
+			if opts.Verbose {
+				os.Stdout.Write(payload)
+			}
+			if !signed {
+				fmt.Fprintf(os.Stderr, "error: no signature found\n")
+				failed = true
+				continue
+			}
+			output, err := verifySignature(config, payload, signature)
+			fmt.Fprint(os.Stderr, output)
+			if err != nil {
+				if !strings.HasSuffix(output, "\n") || output == "" {
+					fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				}
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	case "interpret-trailers":
+		// Placement options only apply to the --trailer options after them,
+		// which go-flags cannot express, so the arguments are parsed here
+		placement := trailerPlacementFromConfig(func(key string) string {
+			return configValue(".", config, "trailer", key)
+		})
+		trailers := []placedTrailer{}
+		files := []string{}
+		onlyTrailers, onlyInput, unfold, inPlace := false, false, false, false
+		args := os.Args[2:]
+		for i := 0; i < len(args); i++ {
+			name, value, hasValue := strings.Cut(args[i], "=")
+			if !strings.HasPrefix(name, "--") {
+				files = append(files, args[i])
+				continue
+			}
+			switch name {
+			case "--trailer", "--where", "--if-exists", "--if-missing":
+				if !hasValue {
+					if i+1 == len(args) {
+						fmt.Fprintf(os.Stderr, "error: option '%s' requires a value\n", name[2:])
+						os.Exit(129)
+					}
+					i++
+					value = args[i]
+				}
+			}
+			switch name {
+			case "--trailer":
+				trailer, err := parseTrailerArgument(value)
+				exitIfError(err, fmt.Sprintf("error: %s", err))
+				trailers = append(trailers, placedTrailer{trailer: trailer, placement: placement})
+			case "--where":
+				placement.where = value
+			case "--if-exists":
+				placement.ifExists = value
+			case "--if-missing":
+				placement.ifMissing = value
+			case "--parse":
+				onlyTrailers, onlyInput, unfold = true, true, true
+			case "--only-trailers":
+				onlyTrailers = true
+			case "--only-input":
+				onlyInput = true
+			case "--unfold":
+				unfold = true
+			case "--in-place":
+				inPlace = true
+			case "--":
+				files = append(files, args[i+1:]...)
+				i = len(args)
+			default:
+				fmt.Fprintf(os.Stderr, "error: unknown option '%s'\n", name[2:])
+				os.Exit(129)
+			}
+		}
+		if onlyInput && len(trailers) > 0 {
+			fmt.Fprintf(os.Stderr, "fatal: --trailer with --only-input does not make sense\n")
+			os.Exit(128)
+		}
+		if len(files) == 0 {
+			if inPlace {
+				fmt.Fprintf(os.Stderr, "fatal: no input file given for in-place editing\n")
+				os.Exit(128)
+			}
+			files = []string{"-"}
+		}
+		for _, file := range files {
+			output, err := interpretTrailers(readMessageFile(file), trailers, onlyTrailers, unfold)
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+			if inPlace {
+				err = os.WriteFile(file, []byte(output), 0644)
+				exitIfError(err, fmt.Sprintf("fatal: could not write to '%s': %s", file, err))
+			} else {
+				os.Stdout.Write([]byte(output))
+			}
+		}
+
+	case "add":
+		options, pathspecs, _ := splitPathspecs(os.Args[1:])
+		args, err := flags.ParseArgs(&struct{}{}, options)
+		if err != nil {
+			os.Exit(129)
+		}
+		pathspecs = append(args[1:], pathspecs...)
+		if len(pathspecs) == 0 {
+			fmt.Fprintf(os.Stderr, "Nothing specified, nothing added.\n")
+			os.Exit(0)
+		}
+		addPaths(".", pathspecs)
+
+	case "commit":
+		type Options struct {
+			Message    []string `short:"m" long:"message" description:"Use the given message as the commit message"`
+			File       string   `short:"F" long:"file" description:"Take the commit message from the given file, - for stdin"`
+			All        bool     `short:"a" long:"all" description:"Stage modified and deleted tracked files first"`
+			Amend      bool     `long:"amend" description:"Replace the tip of the current branch"`
+			AllowEmpty bool     `long:"allow-empty" description:"Allow a commit with the same tree as its parent"`
+			Cleanup    string   `long:"cleanup" description:"How to clean up the message: strip, whitespace, verbatim, scissors or default"`
+			Author     string   `long:"author" description:"Override the commit author, as 'Name <email>'"`
+			Date       string   `long:"date" description:"Override the author date"`
+			// A bare -S gets a blank key id, meaning the configured key
+			GpgSign   string   `short:"S" long:"gpg-sign" optional:"yes" optional-value:" " description:"Sign the commit, optionally with the given key id"`
+			NoGpgSign bool     `long:"no-gpg-sign" description:"Do not sign the commit, overriding commit.gpgSign"`
+			Trailers  []string `long:"trailer" description:"Add a trailer, as <token>[(=|:)<value>]"`
+			Signoff   bool     `short:"s" long:"signoff" description:"Add a Signed-off-by trailer for the committer"`
+		}
+		opts := Options{}
+		_, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		if len(opts.Message) > 0 && opts.File != "" {
+			fmt.Fprintf(os.Stderr, "fatal: options '-m' and '-F' cannot be used together\n")
+			os.Exit(128)
+		}
+		request := commitRequest{
+			message:    strings.Join(opts.Message, "\n\n"),
+			edit:       len(opts.Message) == 0 && opts.File == "",
+			cleanup:    opts.Cleanup,
+			sign:       (opts.GpgSign != "" || configBool(".", config, "commit", "gpgSign")) && !opts.NoGpgSign,
+			signingKey: strings.TrimSpace(opts.GpgSign),
+			signoff:    opts.Signoff,
+			all:        opts.All,
+			amend:      opts.Amend,
+			allowEmpty: opts.AllowEmpty,
+			author:     opts.Author,
+			date:       opts.Date,
+		}
+		if opts.File != "" {
+			request.message = readMessageFile(opts.File)
+		}
+		for _, argument := range opts.Trailers {
+			trailer, err := parseTrailerArgument(argument)
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+			request.trailers = append(request.trailers, trailer)
+		}
+		createCommit(".", config, request)
+
+	case "update-ref":
+		type Options struct {
+			Delete  bool   `short:"d" description:"Delete the ref"`
+			NoDeref bool   `long:"no-deref" description:"Update the symbolic ref itself instead of its target"`
+			Message string `short:"m" description:"Reason of the update"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		if (opts.Delete && (len(args) < 1 || len(args) > 2)) || (!opts.Delete && (len(args) < 2 || len(args) > 3)) {
+			fmt.Fprintf(os.Stderr, "usage: mygit update-ref [-m <reason>] [--no-deref] (-d <refname> [<old-val>] | <refname> <new-val> [<old-val>])\n")
+			os.Exit(129)
+		}
+		refName := args[0]
+		// An empty or all zero old value requires the ref not to exist
+		oldHex := ""
+		if len(args) == 3 || (opts.Delete && len(args) == 2) {
+			oldArgument := args[len(args)-1]
+			oldHex = zeroHex
+			if oldArgument != "" && oldArgument != zeroHex {
+				oldHex, err = resolveRevision(".", oldArgument)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: %s: not a valid old SHA1\n", oldArgument)
+					os.Exit(128)
+				}
+			}
+		}
+		if !opts.NoDeref {
+			refName, err = followSymrefs(".", refName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: update_ref failed for ref '%s': %s\n", args[0], err)
+				os.Exit(128)
+			}
+		}
+		if opts.Delete {
+			err := deleteRefChecked(".", refName, oldHex, opts.Message)
+			exitIfError(err, fmt.Sprintf("error: %s", err))
+			break
+		}
+		newHex, err := resolveRevision(".", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s: not a valid SHA1\n", args[1])
+			os.Exit(128)
+		}
+		err = updateRef(".", refName, refValue{hexHash: newHex}, oldHex, opts.Message)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: update_ref failed for ref '%s': %s\n", refName, err)
+			os.Exit(128)
+		}
+
+	case "symbolic-ref":
+		type Options struct {
+			Quiet   bool   `short:"q" long:"quiet" description:"Do not complain about a non symbolic ref"`
+			Short   bool   `long:"short" description:"Shorten the ref name"`
+			Delete  bool   `short:"d" long:"delete" description:"Delete the symbolic ref"`
+			Message string `short:"m" description:"Reason of the update"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		switch {
+		case opts.Delete && len(args) == 1:
+			if _, ok := readSymbolicRef(".", args[0]); !ok {
+				if opts.Quiet {
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stderr, "fatal: Cannot delete %s, not a symbolic ref\n", args[0])
+				os.Exit(128)
+			}
+			if args[0] == "HEAD" {
+				fmt.Fprintf(os.Stderr, "fatal: deleting '%s' is not allowed\n", args[0])
+				os.Exit(128)
+			}
+			err := deleteRef(".", args[0])
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+		case !opts.Delete && len(args) == 1:
+			if _, ok := readSymbolicRef(".", args[0]); !ok {
+				if opts.Quiet {
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stderr, "fatal: ref %s is not a symbolic ref\n", args[0])
+				os.Exit(128)
+			}
+			target, err := followSymrefs(".", args[0])
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+			if opts.Short {
+				target = shortRefName(target)
+			}
+			fmt.Println(target)
+		case !opts.Delete && len(args) == 2:
+			if args[0] == "HEAD" && !strings.HasPrefix(args[1], "refs/") {
+				fmt.Fprintf(os.Stderr, "fatal: Refusing to point HEAD outside of refs/\n")
+				os.Exit(128)
+			}
+			if !isValidRefName(args[1]) {
+				fmt.Fprintf(os.Stderr, "fatal: Refusing to set '%s' to invalid ref '%s'\n", args[0], args[1])
+				os.Exit(128)
+			}
+			err := updateRef(".", args[0], refValue{symref: args[1]}, "", opts.Message)
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit symbolic-ref [-m <reason>] <name> <ref>\n   or: mygit symbolic-ref [-q] [--short] <name>\n   or: mygit symbolic-ref --delete [-q] <name>\n")
+			os.Exit(129)
+		}
+
+	case "show-ref":
+		type Options struct {
+			Head        bool   `long:"head" description:"Show the HEAD reference"`
+			Heads       bool   `long:"heads" description:"Only show branches"`
+			Tags        bool   `long:"tags" description:"Only show tags"`
+			Dereference bool   `short:"d" long:"dereference" description:"Dereference tags into object IDs"`
+			Hash        string `short:"s" long:"hash" optional:"yes" optional-value:"40" description:"Only show the object ID"`
+			Verify      bool   `long:"verify" description:"Only accept exact ref names"`
+			Quiet       bool   `short:"q" long:"quiet" description:"Do not print results"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		hashLength := 0
+		if opts.Hash != "" {
+			hashLength, err = strconv.Atoi(opts.Hash)
+			if err != nil || hashLength < 4 || hashLength > 40 {
+				hashLength = 40
+			}
+		}
+		show := func(refName string, hexHash string) {
+			if opts.Quiet {
+				return
+			}
+			if hashLength > 0 {
+				fmt.Println(hexHash[:hashLength])
+			} else {
+				fmt.Printf("%s %s\n", hexHash, refName)
+			}
+			if opts.Dereference {
+				if objectType, _ := readObject(".", hexHash); objectType == Tag {
+					peeled, _ := peelRevision(".", hexHash, "")
+					if hashLength > 0 {
+						fmt.Println(peeled[:hashLength])
+					} else {
+						fmt.Printf("%s %s^{}\n", peeled, refName)
+					}
+				}
+			}
+		}
+		if opts.Verify {
+			for _, refName := range args {
+				hexHash, ok := resolveRef(".", refName)
+				if !ok || (refName != "HEAD" && !strings.HasPrefix(refName, "refs/")) {
+					if opts.Quiet {
+						os.Exit(1)
+					}
+					fmt.Fprintf(os.Stderr, "fatal: '%s' - not a valid ref\n", refName)
+					os.Exit(128)
+				}
+				show(refName, hexHash)
+			}
+			break
+		}
+		found := false
+		if hexHash, ok := resolveRef(".", "HEAD"); ok && opts.Head {
+			show("HEAD", hexHash)
+			found = true
+		}
+		for _, refName := range listRefs(".", "refs/") {
+			if (opts.Heads || opts.Tags) && !(opts.Heads && strings.HasPrefix(refName, "refs/heads/")) &&
+				!(opts.Tags && strings.HasPrefix(refName, "refs/tags/")) {
+				continue
+			}
+			if len(args) > 0 && !slices.ContainsFunc(args, func(pattern string) bool {
+				return refName == pattern || strings.HasSuffix(refName, "/"+pattern)
+			}) {
+				continue
+			}
+			hexHash, ok := resolveRef(".", refName)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "warning: ignoring broken ref %s\n", refName)
+				continue
+			}
+			show(refName, hexHash)
+			found = true
+		}
+		if !found {
+			os.Exit(1)
+		}
+
+	case "for-each-ref":
+		type Options struct {
+			Format     string   `long:"format" description:"Format to use for the output"`
+			Sort       []string `long:"sort" description:"Field name to sort on, the last one being the primary key"`
+			Count      int      `long:"count" description:"Show only the first n matching refs"`
+			Shell      bool     `short:"s" long:"shell" description:"Quote placeholders suitably for shells"`
+			PointsAt   string   `long:"points-at" description:"Show only refs pointing at the object"`
+			Merged     string   `long:"merged" optional:"yes" optional-value:"HEAD" description:"Show only refs merged into the commit"`
+			NoMerged   string   `long:"no-merged" optional:"yes" optional-value:"HEAD" description:"Show only refs not merged into the commit"`
+			Contains   []string `long:"contains" optional:"yes" optional-value:"HEAD" description:"Show only refs containing the commit"`
+			NoContains []string `long:"no-contains" optional:"yes" optional-value:"HEAD" description:"Show only refs not containing the commit"`
+		}
+		opts := Options{}
+		args, err := flags.ParseArgs(&opts, attachOptionalArguments(os.Args[1:], "--merged", "--no-merged", "--contains", "--no-contains"))
+		if err != nil {
+			os.Exit(129)
+		}
+		if opts.Count < 0 {
+			fmt.Fprintf(os.Stderr, "error: invalid --count argument: `%d'\n", opts.Count)
+			os.Exit(129)
+		}
+		format := defaultRefFormat
+		if opts.Format != "" {
+			format = opts.Format
+		}
+		tokens, err := parseRefFormat(format)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		quote := func(value string) string { return value }
+		if opts.Shell {
+			quote = shellQuote
+		}
+		items := filterRefs(".", refFilter{
+			patterns:   args[1:],
+			pointsAt:   opts.PointsAt,
+			merged:     opts.Merged,
+			noMerged:   opts.NoMerged,
+			contains:   opts.Contains,
+			noContains: opts.NoContains,
+		})
+		if err := sortRefItems(".", items, opts.Sort); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		if opts.Count > 0 && opts.Count < len(items) {
+			items = items[:opts.Count]
+		}
+		for _, item := range items {
+			line, err := formatRef(".", tokens, item, quote)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			fmt.Println(line)
+		}
+
+	case "pack-refs":
+		type Options struct {
+			All     bool `long:"all" description:"Pack all refs, not only tags"`
+			NoPrune bool `long:"no-prune" description:"Keep the loose refs after packing them"`
+		}
+		opts := Options{}
+		_, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		err = packRefs(".", func(name string) bool {
+			return opts.All || strings.HasPrefix(name, "refs/tags/")
+		}, !opts.NoPrune)
+		exitIfError(err, fmt.Sprintf("fatal: %s", err))
+
+	case "reflog":
+		type Options struct {
+			Expire            string `long:"expire" description:"Prune entries older than the time"`
+			ExpireUnreachable string `long:"expire-unreachable" description:"Prune unreachable entries older than the time"`
+			All               bool   `long:"all" description:"Process the reflogs of all refs"`
+			DryRun            bool   `short:"n" long:"dry-run" description:"Do not prune anything"`
+			Rewrite           bool   `long:"rewrite" description:"Adjust the old value of entries after pruned ones"`
+			UpdateRef         bool   `long:"updateref" description:"Set the ref to the newest remaining entry"`
+		}
+		// Without a subcommand, reflog shows the log of its argument
+		subcommand, arguments := "show", os.Args[2:]
+		if len(arguments) > 0 && slices.Contains([]string{"show", "expire", "delete", "exists"}, arguments[0]) {
+			subcommand, arguments = arguments[0], arguments[1:]
+		}
+		opts := Options{}
+		args, err := flags.ParseArgs(&opts, arguments)
+		if err != nil {
+			os.Exit(129)
+		}
+		pruneOptions := reflogPruneOptions{rewrite: opts.Rewrite, updateRef: opts.UpdateRef, dryRun: opts.DryRun}
+		switch subcommand {
+		case "show":
+			ref := "HEAD"
+			if len(args) > 0 {
+				ref = args[0]
+			}
+			showReflog(".", ref)
+
+		case "expire":
+			global := loadGlobalConfig()
+			times := []time.Time{}
+			for _, setting := range []struct{ value, key, fallback string }{
+				{opts.Expire, "reflogExpire", "90.days.ago"},
+				{opts.ExpireUnreachable, "reflogExpireUnreachable", "30.days.ago"},
+			} {
+				value := setting.value
+				if value == "" {
+					value = configValue(".", global, "gc", setting.key)
+				}
+				if value == "" {
+					value = setting.fallback
+				}
+				at, err := parseExpireTime(value, time.Now())
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: malformed expiration date '%s'\n", value)
+					os.Exit(128)
+				}
+				times = append(times, at)
+			}
+			refNames := args
+			if opts.All {
+				refNames = listReflogs(".")
+			}
+			for _, ref := range refNames {
+				refName, err := reflogRefName(".", ref)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %s points nowhere!\n", ref)
+					continue
+				}
+				err = expireReflog(".", refName, times[0], times[1], pruneOptions)
+				exitIfError(err, fmt.Sprintf("error: %s", err))
+			}
+
+		case "delete":
+			if len(args) == 0 {
+				fmt.Fprintf(os.Stderr, "error: no reflog specified to delete\n")
+				os.Exit(255)
+			}
+			for _, arg := range args {
+				at := strings.Index(arg, "@{")
+				if at < 0 || !strings.HasSuffix(arg, "}") {
+					fmt.Fprintf(os.Stderr, "error: not a reflog: %s\n", arg)
+					os.Exit(255)
+				}
+				index, err := strconv.Atoi(arg[at+2 : len(arg)-1])
+				if err != nil || index < 0 {
+					fmt.Fprintf(os.Stderr, "error: invalid reflog: %s\n", arg)
+					os.Exit(255)
+				}
+				refName, err := reflogRefName(".", arg[:at])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %s points nowhere!\n", arg[:at])
+					os.Exit(255)
+				}
+				// Entries are numbered newest first
+				entries := readReflog(".", refName)
+				err = pruneReflog(".", refName, func(i int, _ reflogEntry) bool {
+					return i != len(entries)-1-index
+				}, pruneOptions)
+				exitIfError(err, fmt.Sprintf("error: %s", err))
+			}
+
+		case "exists":
+			if len(args) != 1 {
+				fmt.Fprintf(os.Stderr, "usage: mygit reflog exists <ref>\n")
+				os.Exit(129)
+			}
+			if _, err := os.Stat(reflogPath(".", args[0])); err != nil {
+				os.Exit(1)
+			}
+		}
+
+	case "log":
+		type Options struct {
+			walkFlags
+			diffFlags
+			prettyFlags
+			Graph bool `long:"graph" description:"Draw a text-based graph of the history"`
+		}
+		arguments, paths, hasPaths := splitDoubleDash(os.Args[1:])
+		opts := Options{}
+		// Unknown options stay in place so that --not applies to the
+		// revisions following it
+		args, err := flags.NewParser(&opts, flags.Default|flags.IgnoreUnknown).ParseArgs(expandCountArguments(arguments))
+		if err != nil {
+			os.Exit(129)
+		}
+		if hasPaths {
+			args = append(append(args, "--"), paths...)
+		}
+		options, err := opts.logOptions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		options.graph = opts.Graph
+		revs, paths, err := parseRevisionArguments(".", args[1:])
+		if err != nil {
+			exitWithRevisionError(err)
+		}
+		if len(revs.include) == 0 && len(revs.exclude) == 0 {
+			head := headCommit(".")
+			if head == "" {
+				branch, _ := headBranch(".")
+				fmt.Fprintf(os.Stderr, "fatal: your current branch '%s' does not have any commits yet\n", strings.TrimPrefix(branch, "refs/heads/"))
+				os.Exit(128)
+			}
+			revs.include = append(revs.include, head)
+		}
+		walkOptions, err := opts.walkOptions(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		if opts.Graph && opts.Reverse {
+			fmt.Fprintf(os.Stderr, "fatal: options '--reverse' and '--graph' cannot be used together\n")
+			os.Exit(128)
+		}
+		if opts.Graph && walkOptions.order == "" {
+			walkOptions.order = "topo"
+		}
+		output, err := opts.output(0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		stat, err := opts.statOptions(".", config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		options.renames, err = opts.renames(configRenames(".", config))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(129)
+		}
+		options.diff = diffOptions{lineDiffOptions: lineDiffOptions{algorithm: "myers"}, context: 3, output: output, stat: stat}
+		options.paths, options.firstParent = walkOptions.paths, walkOptions.firstParent
+		printLog(".", walkRevisions(".", revs, walkOptions), options)
+
+	case "show":
+		type Options struct {
+			diffFlags
+			prettyFlags
+			Unified int `short:"U" long:"unified" default:"-1" description:"Show <n> lines of context, implies -p"`
+		}
+		arguments, paths, _ := splitDoubleDash(os.Args[1:])
+		opts := Options{}
+		args, err := flags.NewParser(&opts, flags.Default).ParseArgs(arguments)
+		if err != nil {
+			os.Exit(129)
+		}
+		options, err := opts.logOptions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		opts.Patch = opts.Patch || opts.Unified >= 0
+		output, err := opts.output(outputPatch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		stat, err := opts.statOptions(".", config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		options.renames, err = opts.renames(configRenames(".", config))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(129)
+		}
+		if opts.Unified < 0 {
+			opts.Unified = 3
+		}
+		options.diff = diffOptions{lineDiffOptions: lineDiffOptions{algorithm: "myers"}, context: opts.Unified, output: output, stat: stat}
+		options.combined = true
+		names := args[1:]
+		if slices.ContainsFunc(names, isRangeArgument) {
+			revs, revPaths, err := parseRevisionArguments(".", append(append(names, "--"), paths...))
+			if err != nil {
+				exitWithRevisionError(err)
+			}
+			options.paths = revPaths
+			printLog(".", walkRevisions(".", revs, revWalkOptions{paths: revPaths, maxCount: -1, maxParents: -1}), options)
+			break
+		}
+		// Like for log, names that are not objects but files select paths
+		objects := []shownObject{}
+		for i, name := range names {
+			hexHash, err := resolveRevision(".", name)
+			if err == nil {
+				objects = append(objects, shownObject{name: name, hexHash: hexHash})
+				continue
+			}
+			if _, statErr := os.Stat(worktreePath(".", name)); statErr == nil {
+				paths = append(slices.Clone(names[i:]), paths...)
+				break
+			}
+			if pathColon(name) < 0 {
+				err = fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", name)
+			}
+			exitWithRevisionError(err)
+		}
+		if len(objects) == 0 {
+			head := headCommit(".")
+			if head == "" {
+				branch, _ := headBranch(".")
+				fmt.Fprintf(os.Stderr, "fatal: your current branch '%s' does not have any commits yet\n", strings.TrimPrefix(branch, "refs/heads/"))
+				os.Exit(128)
+			}
+			objects = append(objects, shownObject{name: "HEAD", hexHash: head})
+		}
+		options.paths = paths
+		showObjects(".", objects, options)
+
+	case "rev-list":
+		type Options struct {
+			walkFlags
+			Count     bool `long:"count" description:"Print the number of commits instead of listing them"`
+			Objects   bool `long:"objects" description:"Also list the trees and blobs of the commits"`
+			LeftRight bool `long:"left-right" description:"Mark which side of a symmetric range commits are on"`
+			Parents   bool `long:"parents" description:"Print the parents of each commit"`
+		}
+		arguments, paths, hasPaths := splitDoubleDash(os.Args[1:])
+		opts := Options{}
+		args, err := flags.NewParser(&opts, flags.Default|flags.IgnoreUnknown).ParseArgs(expandCountArguments(arguments))
+		if err != nil {
+			os.Exit(129)
+		}
+		if hasPaths {
+			args = append(append(args, "--"), paths...)
+		}
+		revs, paths, err := parseRevisionArguments(".", args[1:])
+		if err != nil {
+			exitWithRevisionError(err)
+		}
+		refOptionGiven := slices.ContainsFunc(args[1:], func(arg string) bool {
+			_, ok := refOptionPrefixes[arg]
+			return ok
+		})
+		if len(revs.include) == 0 && len(revs.exclude) == 0 && !refOptionGiven {
+			fmt.Fprintf(os.Stderr, "usage: mygit rev-list [<options>] <commit>... [--] [<path>...]\n")
+			os.Exit(129)
+		}
+		walkOptions, err := opts.walkOptions(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		walk := walkRevisions(".", revs, walkOptions)
+		if opts.Count {
+			if !opts.LeftRight {
+				fmt.Println(len(walk.commits))
+				break
+			}
+			left := 0
+			for _, hexHash := range walk.commits {
+				if walk.left[hexHash] {
+					left++
+				}
+			}
+			fmt.Printf("%d\t%d\n", left, len(walk.commits)-left)
+			break
+		}
+		for _, hexHash := range walk.commits {
+			line := hexHash
+			if opts.LeftRight {
+				line = walk.revisionMark(hexHash) + line
+			}
+			if opts.Parents {
+				for _, parent := range walk.shownParents(hexHash) {
+					line += " " + parent
+				}
+			}
+			fmt.Println(line)
+		}
+		if opts.Objects {
+			for _, line := range walkObjects(".", walk, revs.tags) {
+				fmt.Println(line)
+			}
+		}
+
+	case "merge-base":
+		type Options struct {
+			All         bool `short:"a" long:"all" description:"Output all common ancestors"`
+			Octopus     bool `long:"octopus" description:"Find ancestors for a single n-way merge"`
+			Independent bool `long:"independent" description:"List revs not reachable from others"`
+			IsAncestor  bool `long:"is-ancestor" description:"Tell whether the first commit is an ancestor of the other"`
+			ForkPoint   bool `long:"fork-point" description:"Find where <commit> forked from the reflog of <ref>"`
+		}
+		usage := func() {
+			fmt.Fprintf(os.Stderr, "usage: mygit merge-base [-a | --all] <commit> <commit>...\n   or: mygit merge-base [-a | --all] --octopus <commit>...\n   or: mygit merge-base --is-ancestor <commit> <commit>\n   or: mygit merge-base --independent <commit>...\n   or: mygit merge-base --fork-point <ref> [<commit>]\n")
+			os.Exit(129)
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		modes := []string{}
+		for _, arg := range os.Args[2:] {
+			if arg == "--" {
+				break
+			}
+			if slices.Contains([]string{"--octopus", "--independent", "--is-ancestor", "--fork-point"}, arg) && !slices.Contains(modes, arg) {
+				modes = append(modes, arg)
+			}
+		}
+		if len(modes) > 1 {
+			fmt.Fprintf(os.Stderr, "error: option `%s' is incompatible with %s\n", strings.TrimPrefix(modes[1], "--"), modes[0])
+			os.Exit(129)
+		}
+		walk := newAncestryWalk(".")
+		commitReference := func(rev string) string {
+			hexHash, err := resolveRevision(".", rev)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: Not a valid object name %s\n", rev)
+				os.Exit(128)
+			}
+			hexHash, _ = peelRevision(".", hexHash, "")
+			if objectType, _ := readObject(".", hexHash); objectType != Commit {
+				fmt.Fprintf(os.Stderr, "error: object %s is a %s, not a commit\n", hexHash, objectTypeName(objectType))
+				fmt.Fprintf(os.Stderr, "fatal: Not a valid commit name %s\n", rev)
+				os.Exit(128)
+			}
+			return hexHash
+		}
+		printBases := func(bases []string) {
+			if len(bases) == 0 {
+				os.Exit(1)
+			}
+			if !opts.All {
+				bases = bases[:1]
+			}
+			for _, hexHash := range bases {
+				fmt.Println(hexHash)
+			}
+		}
+		switch {
+		case opts.IsAncestor:
+			if len(args) < 2 {
+				usage()
+			}
+			if opts.All {
+				fmt.Fprintf(os.Stderr, "fatal: options '--is-ancestor' and '--all' cannot be used together\n")
+				os.Exit(128)
+			}
+			if len(args) != 2 {
+				fmt.Fprintf(os.Stderr, "fatal: --is-ancestor takes exactly two commits\n")
+				os.Exit(128)
+			}
+			if !walk.inMergeBases(commitReference(args[0]), commitReference(args[1])) {
+				os.Exit(1)
+			}
+		case opts.Independent:
+			if opts.All {
+				fmt.Fprintf(os.Stderr, "fatal: options '--independent' and '--all' cannot be used together\n")
+				os.Exit(128)
+			}
+			commits := []string{}
+			for _, arg := range args {
+				commits = append(commits, commitReference(arg))
+			}
+			opts.All = true
+			printBases(walk.reduceHeads(commits))
+		case opts.Octopus:
+			commits := []string{}
+			for _, arg := range args {
+				commits = append(commits, commitReference(arg))
+			}
+			printBases(walk.reduceHeads(walk.octopusMergeBases(commits)))
+		case opts.ForkPoint:
+			if len(args) < 1 || len(args) > 2 {
+				usage()
+			}
+			commitName := "HEAD"
+			if len(args) == 2 {
+				commitName = args[1]
+			}
+			hexHash, err := resolveRevision(".", commitName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: Not a valid object name: '%s'\n", commitName)
+				os.Exit(128)
+			}
+			hexHash, _ = peelRevision(".", hexHash, "")
+			if objectType, _ := readObject(".", hexHash); objectType != Commit {
+				fmt.Fprintf(os.Stderr, "error: object %s is a %s, not a commit\n", hexHash, objectTypeName(objectType))
+				os.Exit(1)
+			}
+			refName, count := dwimRefName(".", args[0])
+			if count == 0 {
+				fmt.Fprintf(os.Stderr, "fatal: No such ref: '%s'\n", args[0])
+				os.Exit(128)
+			}
+			if count > 1 {
+				fmt.Fprintf(os.Stderr, "fatal: Ambiguous refname: '%s'\n", args[0])
+				os.Exit(128)
+			}
+			tip, _ := resolveRef(".", refName)
+			forkPoint, ok := walk.forkPoint(refName, tip, hexHash)
+			if !ok {
+				os.Exit(1)
+			}
+			fmt.Println(forkPoint)
+		default:
+			if len(args) < 2 {
+				usage()
+			}
+			commits := []string{}
+			for _, arg := range args {
+				commits = append(commits, commitReference(arg))
+			}
+			printBases(walk.mergeBases(commits[0], commits[1:]))
+		}
+
+	case "merge":
+		type Options struct {
+			Message        []string `short:"m" description:"Use the given message for the merge commit"`
+			FF             bool     `long:"ff" description:"Fast-forward when possible"`
+			NoFF           bool     `long:"no-ff" description:"Always create a merge commit"`
+			FFOnly         bool     `long:"ff-only" description:"Refuse to merge unless it fast-forwards"`
+			Squash         bool     `long:"squash" description:"Merge into the working tree and index only"`
+			Commit         bool     `long:"commit" description:"Commit the result of the merge"`
+			NoCommit       bool     `long:"no-commit" description:"Stop before committing the merge"`
+			Edit           bool     `short:"e" long:"edit" description:"Edit the message of the merge commit"`
+			NoEdit         bool     `long:"no-edit" description:"Accept the default merge message"`
+			Stat           bool     `long:"stat" description:"Show a diffstat at the end of the merge"`
+			NoStat         bool     `short:"n" long:"no-stat" description:"Do not show a diffstat at the end of the merge"`
+			AllowUnrelated bool     `long:"allow-unrelated-histories" description:"Allow merging histories without a common ancestor"`
+			Abort          bool     `long:"abort" description:"Abort the merge in progress"`
+			Continue       bool     `long:"continue" description:"Conclude the merge in progress"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		if opts.Abort || opts.Continue {
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, "fatal: --abort or --continue expects no arguments\n")
+				os.Exit(129)
+			}
+			if opts.Abort {
+				abortMerge(".")
+				return
+			}
+			if readMergeHeads(".") == nil {
+				fmt.Fprintf(os.Stderr, "fatal: There is no merge in progress (MERGE_HEAD missing).\n")
+				os.Exit(128)
+			}
+			createCommit(".", config, commitRequest{edit: true})
+			return
+		}
+		request := mergeRequest{
+			message:        strings.Join(opts.Message, "\n\n"),
+			hasMessage:     len(opts.Message) > 0,
+			squash:         opts.Squash,
+			commit:         true,
+			allowUnrelated: opts.AllowUnrelated,
+			stat:           configValue(".", config, "merge", "stat") == "" || configBool(".", config, "merge", "stat"),
+		}
+		switch strings.ToLower(configValue(".", config, "merge", "ff")) {
+		case "false", "no", "off", "0":
+			request.fastForward = fastForwardNever
+		case "only":
+			request.fastForward = fastForwardOnly
+		}
+		stdin, _ := os.Stdin.Stat()
+		stdout, _ := os.Stdout.Stat()
+		request.edit = !request.hasMessage && stdin != nil && stdin.Mode()&os.ModeCharDevice != 0 && stdout != nil && stdout.Mode()&os.ModeCharDevice != 0
+		// Later options override earlier ones
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--ff":
+				request.fastForward = fastForwardAllowed
+			case "--no-ff":
+				request.fastForward = fastForwardNever
+			case "--ff-only":
+				request.fastForward = fastForwardOnly
+			case "--commit":
+				request.commit = true
+			case "--no-commit":
+				request.commit = false
+			case "-e", "--edit":
+				request.edit = true
+			case "--no-edit":
+				request.edit = false
+			case "--stat":
+				request.stat = true
+			case "-n", "--no-stat":
+				request.stat = false
+			}
+		}
+		if request.squash {
+			if request.fastForward == fastForwardNever {
+				fmt.Fprintf(os.Stderr, "fatal: options '--squash' and '--no-ff.' cannot be used together\n")
+				os.Exit(128)
+			}
+			if opts.Commit {
+				fmt.Fprintf(os.Stderr, "fatal: options '--squash' and '--commit.' cannot be used together\n")
+				os.Exit(128)
+			}
+			request.commit = false
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "fatal: No remote for the current branch.\n")
+			os.Exit(128)
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "fatal: merging more than one commit is not supported\n")
+			os.Exit(128)
+		}
+		mergeIntoHead(".", config, args[0], request)
+
+	case "merge-file":
+		type Options struct {
+			Stdout     bool     `short:"p" long:"stdout" description:"Send the result to standard output"`
+			Diff3      bool     `long:"diff3" description:"Show the base version in conflicts"`
+			Zdiff3     bool     `long:"zdiff3" description:"Show the base version in conflicts, moving common lines out"`
+			Ours       bool     `long:"ours" description:"Resolve conflicts with our version"`
+			Theirs     bool     `long:"theirs" description:"Resolve conflicts with their version"`
+			Union      bool     `long:"union" description:"Resolve conflicts with both versions"`
+			MarkerSize int      `long:"marker-size" default:"7" description:"Use markers of this size"`
+			Quiet      bool     `short:"q" long:"quiet" description:"Do not warn about conflicts"`
+			Labels     []string `short:"L" description:"Label the current, base and other versions"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		if len(opts.Labels) > 3 {
+			fmt.Fprintf(os.Stderr, "error: too many labels on the command line\n")
+			os.Exit(129)
+		}
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit merge-file [<options>] [-L <name1> [-L <orig> [-L <name2>]]] <file1> <orig-file> <file2>\n")
+			os.Exit(129)
+		}
+		// -q silences errors as well
+		warn := func(format string, a ...any) {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, format, a...)
+			}
+		}
+		options := lineMergeOptions{
+			lineDiffOptions: lineDiffOptions{algorithm: "myers"},
+			level:           mergeZealousAlnum,
+			markerSize:      opts.MarkerSize,
+		}
+		// Later options override earlier ones
+		for i, arg := range os.Args[2:] {
+			if i > 0 && os.Args[i+1] == "-L" {
+				continue
+			}
+			switch arg {
+			case "--diff3":
+				options.style = mergeStyleDiff3
+			case "--zdiff3":
+				options.style = mergeStyleZdiff3
+			case "--ours":
+				options.favor = favorOurs
+			case "--theirs":
+				options.favor = favorTheirs
+			case "--union":
+				options.favor = favorUnion
+			}
+		}
+		labels := append(opts.Labels, args[len(opts.Labels):]...)
+		options.ours, options.ancestor, options.theirs = labels[0], labels[1], labels[2]
+		contents := [3][]byte{}
+		for i, name := range args {
+			info, err := os.Stat(name)
+			if err != nil {
+				message := errors.Unwrap(err).Error()
+				warn("error: Could not stat %s: %s\n", name, strings.ToUpper(message[:1])+message[1:])
+				os.Exit(255)
+			}
+			if info.IsDir() {
+				warn("error: Could not open %s: Is a directory\n", name)
+				os.Exit(255)
+			}
+			contents[i], err = os.ReadFile(name)
+			if err != nil {
+				warn("error: Could not open %s: %s\n", name, err)
+				os.Exit(255)
+			}
+		}
+		for i, content := range contents {
+			if isBinaryContent(content) {
+				warn("error: Cannot merge binary files: %s\n", args[i])
+				os.Exit(255)
+			}
+		}
+		merged, conflicts := mergeLines(contents[1], contents[0], contents[2], options)
+		if opts.Stdout {
+			os.Stdout.Write(merged)
+		} else {
+			err := os.WriteFile(args[0], merged, 0644)
+			if err != nil {
+				warn("error: Could not open %s for writing\n", args[0])
+				os.Exit(255)
+			}
+		}
+		os.Exit(min(conflicts, 127))
+
+	case "merge-tree":
+		type Options struct {
+			WriteTree      bool `long:"write-tree" description:"Do a real merge"`
+			TrivialMerge   bool `long:"trivial-merge" description:"Do a trivial merge only"`
+			Messages       bool `long:"messages" description:"Also show informational and conflict messages"`
+			NoMessages     bool `long:"no-messages" description:"Do not show messages"`
+			NameOnly       bool `long:"name-only" description:"List the conflicted paths only"`
+			AllowUnrelated bool `long:"allow-unrelated-histories" description:"Allow merging unrelated histories"`
+		}
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		if opts.TrivialMerge || len(args) == 3 && !opts.WriteTree {
+			fmt.Fprintf(os.Stderr, "fatal: trivial merges are not supported, use --write-tree\n")
+			os.Exit(128)
+		}
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit merge-tree [--write-tree] [<options>] <branch1> <branch2>\n")
+			os.Exit(129)
+		}
+		commits := [2]string{}
+		for i, name := range args {
+			hexHash, err := resolveRevision(".", name)
+			if err == nil {
+				peeled, _ := peelRevision(".", hexHash, "")
+				if objectType, _ := readObject(".", peeled); objectType != Commit {
+					fmt.Fprintf(os.Stderr, "error: %s: expected commit type, but the object dereferences to %s type\n", name, objectTypeName(objectType))
+				}
+				hexHash, err = peelToType(".", hexHash, Commit)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "merge-tree: %s - not something we can merge\n", name)
+				os.Exit(1)
+			}
+			commits[i] = hexHash
+		}
+		if !opts.AllowUnrelated && len(newAncestryWalk(".").mergeBases(commits[0], commits[1:])) == 0 {
+			fmt.Fprintf(os.Stderr, "fatal: refusing to merge unrelated histories\n")
+			os.Exit(128)
+		}
+		style, _ := mergeStyleFromConfig(configValue(".", config, "merge", "conflictStyle"))
+		result := mergeCommits(".", commits[0], commits[1], args[0], args[1], style)
+		showMessages := !result.clean
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--messages":
+				showMessages = true
+			case "--no-messages":
+				showMessages = false
+			}
 		}
-		opts := Option{}
-		nFlagArgs, err := flags.Parse(&opts)
-		if err != nil {
-			panic(err)
+		fmt.Println(result.tree)
+		names := make([]string, 0, len(result.conflicted))
+		for name := range result.conflicted {
+			names = append(names, name)
 		}
-		if (opts.Add && opts.Get) || (!opts.Add && !opts.Get) {
-			// flag.Usage()
-			fmt.Fprintf(os.Stderr, "fatal: mygit config: invalid flags passed\n")
-			os.Exit(1)
+		slices.Sort(names)
+		for _, name := range names {
+			if opts.NameOnly {
+				fmt.Println(name)
+				continue
+			}
+			conflict := result.conflicted[name]
+			for i, stage := range conflict.stages {
+				if conflict.filemask&(1<<i) != 0 {
+					fmt.Printf("%06o %s %d\t%s\n", indexModeFromPerm(stage.perm), hex.EncodeToString(stage.sha[:]), i+1, name)
+				}
+			}
 		}
-		if !opts.Global {
-			fmt.Fprintf(os.Stderr, "fatal: mygit config: only command with global flag supported as of now\n")
+		if showMessages {
+			fmt.Println()
+			printMergeMessages(result.messages)
+		}
+		if !result.clean {
 			os.Exit(1)
 		}
-		configName := nFlagArgs[1]
-		sectionName := ""
-		keyName := ""
-		nameWithSection := strings.Split(configName, ".")
-		if len(nameWithSection) < 2 {
-			keyName = nameWithSection[0]
-		} else {
-			sectionName = nameWithSection[0]
-			keyName = nameWithSection[1]
+
+	case "cherry-pick", "revert":
+		type Options struct {
+			NoCommit     bool   `short:"n" long:"no-commit" description:"Apply the changes without committing"`
+			Edit         bool   `short:"e" long:"edit" description:"Edit the commit message"`
+			NoEdit       bool   `long:"no-edit" description:"Keep the commit message as it is"`
+			RecordOrigin bool   `short:"x" description:"Append the name of the picked commit to the message"`
+			Mainline     string `short:"m" long:"mainline" description:"Parent number of merges to apply the changes relative to"`
+			Continue     bool   `long:"continue" description:"Resume after resolving conflicts"`
+			Abort        bool   `long:"abort" description:"Cancel the operation"`
+			Skip         bool   `long:"skip" description:"Skip the current commit and continue"`
 		}
-		if opts.Add {
-			if len(nFlagArgs) < 3 {
-				fmt.Fprintf(os.Stderr, "fatal: mygit config --global --add: value for key is invalid\n")
-				os.Exit(1)
+		opts := Options{}
+		args, err := flags.Parse(&opts)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		modes := []string{}
+		for _, arg := range os.Args[2:] {
+			if slices.Contains([]string{"--continue", "--abort", "--skip"}, arg) && !slices.Contains(modes, arg) {
+				modes = append(modes, arg)
 			}
-			value := nFlagArgs[2]
-			config.Section(sectionName).Key(keyName).SetValue(value)
-			config.SaveTo(globalConfigPath)
-		} else {
-			value := config.Section(sectionName).Key(keyName).String()
-			os.Stdout.Write([]byte(value))
+		}
+		if len(modes) > 1 {
+			fmt.Fprintf(os.Stderr, "error: option `%s' is incompatible with %s\n", strings.TrimPrefix(modes[1], "--"), modes[0])
+			os.Exit(129)
+		}
+		options := replayOptions{
+			noCommit:     opts.NoCommit,
+			recordOrigin: opts.RecordOrigin && command == "cherry-pick",
+			explicitEdit: opts.Edit || opts.NoEdit,
+		}
+		if command == "revert" {
+			options.action = replayRevert
+			stdin, _ := os.Stdin.Stat()
+			options.edit = stdin != nil && stdin.Mode()&os.ModeCharDevice != 0
+		}
+		options.edit = (options.edit || opts.Edit) && !opts.NoEdit
+		if opts.Mainline != "" {
+			options.mainline = parseMainline(opts.Mainline)
+		}
+		switch {
+		case opts.Continue:
+			stdin, _ := os.Stdin.Stat()
+			continueReplay(".", config, options.action, opts.Edit || stdin != nil && stdin.Mode()&os.ModeCharDevice != 0 && !opts.NoEdit)
+		case opts.Skip:
+			skipReplay(".", config, options.action)
+		case opts.Abort:
+			abortReplay(".", options.action)
+		case len(args) == 0:
+			fmt.Fprintf(os.Stderr, "usage: mygit %s [<options>] <commit-ish>...\n   or: mygit %s (--continue | --skip | --abort)\n", command, command)
+			os.Exit(129)
+		default:
+			startReplay(".", config, args, options)
 		}
 
-	case "cat-file":
+	case "rebase":
 		type Options struct {
-			PrettyPrint bool `short:"p" description:"Pretty print content of objects"`
+			Interactive  bool   `short:"i" long:"interactive" description:"Edit the list of commits to rebase"`
+			Onto         string `long:"onto" description:"Rebase onto the given commit instead of upstream"`
+			Autosquash   bool   `long:"autosquash" description:"Move fixup! and squash! commits after their targets"`
+			NoAutosquash bool   `long:"no-autosquash" description:"Keep fixup! and squash! commits in place"`
+			Continue     bool   `long:"continue" description:"Resume after resolving conflicts"`
+			Abort        bool   `long:"abort" description:"Go back to the branch as it was before the rebase"`
+			Skip         bool   `long:"skip" description:"Skip the current commit and continue"`
+			EditTodo     bool   `long:"edit-todo" description:"Edit the commands left to run"`
 		}
 		opts := Options{}
 		args, err := flags.Parse(&opts)
 		if err != nil {
-			fmt.Println(err)
-			panic(err)
+			os.Exit(129)
 		}
-		if !opts.PrettyPrint {
-			fmt.Fprintf(os.Stderr, "fatal: mygit cat-file: only -p mode is supported as of now\n")
-			os.Exit(1)
+		args = args[1:]
+		modes := []string{}
+		for _, arg := range os.Args[2:] {
+			if slices.Contains([]string{"--continue", "--abort", "--skip", "--edit-todo"}, arg) && !slices.Contains(modes, arg) {
+				modes = append(modes, arg)
+			}
 		}
-		if len(args) != 2 {
-			fmt.Println(args)
-			// flag.Usage()
-			os.Exit(1)
+		if len(modes) > 1 {
+			fmt.Fprintf(os.Stderr, "error: option `%s' is incompatible with %s\n", strings.TrimPrefix(modes[1], "--"), modes[0])
+			os.Exit(129)
+		}
+		switch {
+		case opts.Continue:
+			continueRebase(".", config)
+		case opts.Skip:
+			skipRebase(".", config)
+		case opts.Abort:
+			abortRebase(".")
+		case opts.EditTodo:
+			editRebaseTodo(".", config)
+		case len(args) > 2:
+			fmt.Fprintf(os.Stderr, "usage: mygit rebase [-i] [--onto <newbase>] [<upstream> [<branch>]]\n"+
+				"   or: mygit rebase --continue | --abort | --skip | --edit-todo\n")
+			os.Exit(129)
+		default:
+			request := rebaseRequest{
+				onto:        opts.Onto,
+				interactive: opts.Interactive,
+				autosquash:  (opts.Autosquash || configBool(".", config, "rebase", "autoSquash")) && !opts.NoAutosquash,
+			}
+			if len(args) > 0 {
+				request.upstream = args[0]
+			}
+			if len(args) > 1 {
+				request.branch = args[1]
+			}
+			startRebase(".", config, request)
 		}
-		// allowedTypes := []string{"blob", "tree", "commit"}
-		// objectType := flag.Arg(1)
-		sha := args[1]
-		objectPath := filepath.Join(CWD, ".git", "objects", sha[:2], sha[2:])
-		buff, err := os.ReadFile(objectPath)
-		exitIfError(err, fmt.Sprintf("Error reading file: %s\n", err))
-		data, _ := decompressContent(buff)
-		// Pretty print
-		spaceIndex := bytes.Index(data, []byte(" "))
-		objectType := string(data[:spaceIndex])
 
-		switch objectType {
-		case "blob":
-			data = decodeBlobObject(data, false)
-			os.Stdout.Write(data)
-		case "tree":
-			trees := decodeTreeObject(data, false)
-			for _, tree := range trees {
-				var oType string
-				switch tree.perm {
-				case DIR:
-					oType = "tree"
-				default:
-					oType = "blob"
-				}
-				os.Stdout.Write([]byte(fmt.Sprintf("%s %s %s\t%s\n", tree.perm, oType, hex.EncodeToString(tree.sha[:]), tree.name)))
+	case "diff-tree":
+		type Options struct {
+			diffFlags
+			Recursive  bool `short:"r" description:"Recurse into subtrees"`
+			ShowTrees  bool `short:"t" description:"Also show the subtrees recursed into, implies -r"`
+			Unified    int  `short:"U" long:"unified" default:"-1" description:"Show patches with <n> lines of context"`
+			Root       bool `long:"root" description:"Show a root commit as adding all its files"`
+			NoCommitID bool `long:"no-commit-id" description:"Do not print the commit id before its changes"`
+		}
+		arguments, paths, _ := splitDoubleDash(os.Args[1:])
+		opts := Options{}
+		args, err := flags.ParseArgs(&opts, arguments)
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		objects := []string{}
+		for len(args) > 0 && len(objects) < 2 {
+			hexHash, err := resolveRevision(".", args[0])
+			if err != nil {
+				break
+			}
+			objects = append(objects, hexHash)
+			args = args[1:]
+		}
+		for _, arg := range args {
+			if _, err := os.Stat(worktreePath(".", arg)); err != nil {
+				exitWithRevisionError(fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", arg))
+			}
+		}
+		paths = append(args, paths...)
+		renames, err := opts.renames(noRenames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(129)
+		}
+		opts.Patch = opts.Patch || opts.Unified >= 0
+		output, err := opts.output(outputRaw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		stat, err := opts.statOptions(".", config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		treeOptions := treeDiffOptions{
+			recursive:  opts.Recursive || opts.ShowTrees || output&(outputPatch|outputNumstat|outputStat|outputShortstat|outputDirstat) != 0,
+			showTrees:  opts.ShowTrees,
+			unmodified: renames.copiesHarder,
+		}
+		treeOf := func(hexHash string) string {
+			treeHex, err := peelToType(".", hexHash, Tree)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			return treeHex
+		}
+		header := ""
+		var pairs []filePair
+		switch len(objects) {
+		case 2:
+			pairs = diffTrees(".", treeOf(objects[0]), treeOf(objects[1]), treeOptions, paths)
+		case 1:
+			commitHex, err := peelToType(".", objects[0], Commit)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			commit := readCommit(".", commitHex)
+			switch {
+			case len(commit.parents) == 1:
+				pairs = diffTrees(".", treeOf(commit.parents[0]), commit.tree, treeOptions, paths)
+			case len(commit.parents) == 0 && opts.Root:
+				pairs = diffTrees(".", "", commit.tree, treeOptions, paths)
+			}
+			if !opts.NoCommitID {
+				header = commitHex + "\n"
 			}
 		default:
-			zeroIndex := bytes.Index(data, []byte{0x0})
-			os.Stdout.Write(data[zeroIndex+1:])
+			fmt.Fprintf(os.Stderr, "usage: mygit diff-tree [<options>] <tree-ish> [<tree-ish>] [<path>...]\n")
+			os.Exit(129)
 		}
+		pairs = detectRenames(".", pairs, renames)
+		out := bufio.NewWriter(os.Stdout)
+		if len(pairs) > 0 {
+			out.WriteString(header)
+		}
+		writer := &patchWriter{out: out, options: diffOptions{
+			lineDiffOptions: lineDiffOptions{algorithm: "myers"},
+			context:         3,
+			output:          output,
+			stat:            stat,
+			fullIndex:       true,
+		}}
+		if opts.Unified >= 0 {
+			writer.options.context = opts.Unified
+		}
+		writer.writeDiff(".", pairs)
+		out.Flush()
 
-	case "hash-object":
+	case "diff":
 		type Options struct {
-			WriteToStore bool `short:"w" long:"write" description:"Write object to store"`
+			diffFlags
+			Cached            bool   `long:"cached" description:"Compare the index with HEAD or the given commit"`
+			Staged            bool   `long:"staged" description:"Same as --cached"`
+			Unified           int    `short:"U" long:"unified" default:"-1" description:"Show <n> lines of context, implies -p"`
+			Color             string `long:"color" optional:"yes" optional-value:"always" description:"Color the output: always, never or auto"`
+			NoColor           bool   `long:"no-color" description:"Do not color the output"`
+			Algorithm         string `long:"diff-algorithm" description:"Use the myers, minimal, patience or histogram algorithm"`
+			Minimal           bool   `long:"minimal" description:"Spend extra time to find the smallest diff"`
+			Patience          bool   `long:"patience" description:"Use the patience algorithm"`
+			Histogram         bool   `long:"histogram" description:"Use the histogram algorithm"`
+			IgnoreAllSpace    bool   `short:"w" long:"ignore-all-space" description:"Ignore whitespace when comparing lines"`
+			IgnoreSpaceChange bool   `short:"b" long:"ignore-space-change" description:"Ignore changes in the amount of whitespace"`
+			IgnoreSpaceAtEOL  bool   `long:"ignore-space-at-eol" description:"Ignore whitespace changes at the end of lines"`
+			ExitCode          bool   `long:"exit-code" description:"Exit with 1 when there are differences"`
+			Quiet             bool   `long:"quiet" description:"Print nothing, implies --exit-code"`
 		}
+		arguments, paths, hasPaths := splitDoubleDash(os.Args[1:])
 		opts := Options{}
-		args, err := flags.Parse(&opts)
+		args, err := flags.ParseArgs(&opts, arguments)
 		if err != nil {
-			panic(err)
+			os.Exit(129)
 		}
-		if len(args) < 2 {
-			fmt.Fprintf(os.Stderr, "fatal: mygit hash-object: invalid filename passed\n")
-			os.Exit(1)
+		if hasPaths {
+			args = append(append(args, "--"), paths...)
 		}
-		fileName := args[1]
-		buff, err := os.ReadFile(filepath.Join(CWD, fileName))
-		exitIfError(err, fmt.Sprintf("Error reading file: %s", err))
-		blob := writeHeaderToContent(buff, Blob)
-		hexHash := hex.EncodeToString(hashContent(blob))
-		if opts.WriteToStore {
-			createBlobObject(buff)
+		revs, paths, err := parseRevisionArguments(".", args[1:])
+		if err != nil {
+			exitWithRevisionError(err)
+		}
+		algorithm := opts.Algorithm
+		switch {
+		case opts.Minimal:
+			algorithm = "minimal"
+		case opts.Patience:
+			algorithm = "patience"
+		case opts.Histogram:
+			algorithm = "histogram"
+		case algorithm == "":
+			algorithm = configValue(".", config, "diff", "algorithm")
+		}
+		switch algorithm {
+		case "", "default":
+			algorithm = "myers"
+		case "myers", "minimal", "patience", "histogram":
+		default:
+			fmt.Fprintf(os.Stderr, "error: option diff-algorithm accepts \"myers\", \"minimal\", \"patience\" and \"histogram\"\n")
+			os.Exit(129)
+		}
+		if opts.NoColor {
+			opts.Color = "never"
+		}
+		opts.Patch = opts.Patch || opts.Unified >= 0
+		output, err := opts.output(outputPatch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		stat, err := opts.statOptions(".", config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		if opts.Unified < 0 {
+			opts.Unified = 3
+		}
+		options := diffOptions{
+			lineDiffOptions: lineDiffOptions{
+				algorithm:         algorithm,
+				ignoreAllSpace:    opts.IgnoreAllSpace,
+				ignoreSpaceChange: opts.IgnoreSpaceChange,
+				ignoreSpaceAtEOL:  opts.IgnoreSpaceAtEOL,
+			},
+			context: max(opts.Unified, 0),
+			color:   wantColor(".", config, opts.Color),
+			output:  output,
+			stat:    stat,
+		}
+		renames, err := opts.renames(configRenames(".", config))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(129)
 		}
-		os.Stdout.Write([]byte(hexHash))
 
-	case "ls-tree":
+		cached := opts.Cached || opts.Staged
+		entries := readIndex(".")
+		index, unmerged := indexSides(entries)
+		treeOptions := treeDiffOptions{recursive: true, unmodified: renames.copiesHarder}
+		var oldSides, newSides map[string]diffSide
+		var pairs []filePair
+		switch {
+		case len(revs.symmetric) == 1 && len(revs.include) == 2 && len(revs.exclude) == 0:
+			bases := mergeBases(".", revs.symmetric[0][0], revs.symmetric[0][1])
+			if len(bases) == 0 {
+				fmt.Fprintf(os.Stderr, "fatal: %s...%s: no merge base\n", revs.symmetric[0][0], revs.symmetric[0][1])
+				os.Exit(128)
+			}
+			pairs = diffTrees(".", readCommit(".", bases[0]).tree, readCommit(".", revs.symmetric[0][1]).tree, treeOptions, paths)
+		case len(revs.include) == 1 && len(revs.exclude) == 1:
+			pairs = diffTrees(".", readCommit(".", revs.exclude[0]).tree, readCommit(".", revs.include[0]).tree, treeOptions, paths)
+		case len(revs.include) == 2 && len(revs.exclude) == 0:
+			pairs = diffTrees(".", readCommit(".", revs.include[0]).tree, readCommit(".", revs.include[1]).tree, treeOptions, paths)
+		case len(revs.include) == 1 && len(revs.exclude) == 0:
+			oldSides = treeSides(commitFiles(".", revs.include[0]))
+			if cached {
+				newSides = index
+			} else {
+				newSides = worktreeSides(".", entries)
+				unmerged = nil
+			}
+		case len(revs.include) == 0 && len(revs.exclude) == 0:
+			if cached {
+				oldSides, newSides = treeSides(commitFiles(".", headCommit("."))), index
+			} else {
+				oldSides, newSides = index, worktreeSides(".", entries)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit diff [<options>] [<commit> [<commit>]] [--] [<path>...]\n")
+			os.Exit(129)
+		}
+		if pairs == nil {
+			pairs = pairFiles(oldSides, newSides, unmerged, paths, renames.copiesHarder)
+		}
+		out := bufio.NewWriter(os.Stdout)
+		if opts.Quiet {
+			out = bufio.NewWriter(io.Discard)
+		}
+		writer := &patchWriter{out: out, options: options}
+		changed := writer.writeDiff(".", detectRenames(".", pairs, renames))
+		out.Flush()
+		if (opts.ExitCode || opts.Quiet) && changed {
+			os.Exit(1)
+		}
+
+	case "blame":
 		type Options struct {
-			NameOnly   bool `short:"n" long:"name-only" description:"Only print name of the objects"`
-			ObjectOnly bool `short:"o" long:"object-only" description:"Only print hash of the objects"`
+			Lines           []string `short:"L" description:"Blame only the lines in <start>,<end> or the function :<funcname>"`
+			IgnoreSpace     bool     `short:"w" description:"Ignore whitespace when comparing lines"`
+			Porcelain       bool     `short:"p" long:"porcelain" description:"Show the output in a format meant for scripts"`
+			LinePorcelain   bool     `long:"line-porcelain" description:"Show the porcelain format with the commit details on every line"`
+			IgnoreRevs      []string `long:"ignore-rev" description:"Ignore the changes of <rev>"`
+			IgnoreRevsFiles []string `long:"ignore-revs-file" description:"Ignore the commits listed in <file>"`
+			Root            bool     `long:"root" description:"Do not treat root commits as boundaries"`
+			ShowName        bool     `short:"f" long:"show-name" description:"Show the file name of the origin"`
+			ShowNumber      bool     `short:"n" long:"show-number" description:"Show the line number in the origin"`
+			ShowEmail       bool     `short:"e" long:"show-email" description:"Show the author email instead of the name"`
+			Long            bool     `short:"l" description:"Show long commit names"`
+			SuppressAuthor  bool     `short:"s" description:"Suppress the author name and date"`
+			RawTimestamp    bool     `short:"t" description:"Show raw timestamps"`
+			BlankBoundary   bool     `short:"b" description:"Show blank names for boundary commits"`
+			Date            string   `long:"date" description:"Format of the dates shown"`
 		}
+		arguments, paths, hasPaths := splitDoubleDash(os.Args[1:])
 		opts := Options{}
-		args, err := flags.Parse(&opts)
+		args, err := flags.NewParser(&opts, flags.Default).ParseArgs(arguments)
 		if err != nil {
-			panic(err)
+			os.Exit(129)
 		}
-		if opts.NameOnly && opts.ObjectOnly {
-			fmt.Fprintf(os.Stderr, "fatal: mygit ls-tree: --name-only and --object-only can't be combined!\n")
-			os.Exit(1)
+		// Like git, accept "<rev> [--] <file>" as well as "<file> <rev>"
+		// and "-- <file> <rev>"
+		names, name := args[1:], ""
+		isRevision := func(arg string) bool {
+			_, err := resolveRevision(".", arg)
+			return err == nil
 		}
-		if len(args) < 2 {
-			fmt.Fprintf(os.Stderr, "fatal: mygit ls-tree: insufficient arguments passed\n")
-			os.Exit(1)
+		switch {
+		case hasPaths && len(paths) == 2 && len(names) == 0:
+			names, name = paths[1:], paths[0]
+		case hasPaths && len(paths) == 1:
+			name = paths[0]
+		case !hasPaths && len(names) == 2 && isRevision(names[1]):
+			names, name = names[1:], names[0]
+		case !hasPaths && len(names) > 0:
+			names, name = names[:len(names)-1], names[len(names)-1]
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit blame [<options>] [<rev>] [--] <file>\n")
+			os.Exit(129)
 		}
-		treeSha := args[1]
-		treePath := ".git/objects/" + treeSha[:2] + "/" + treeSha[2:]
-		data, err := os.ReadFile(treePath)
-		exitIfError(err, fmt.Sprintf("Error reading file: %s", err))
-		trees := decodeTreeObject(data, true)
-		if opts.NameOnly {
-			for _, t := range trees {
-				fmt.Println(t.name)
+		name = filepath.ToSlash(filepath.Clean(name))
+		hexHash := ""
+		for _, rev := range names {
+			commit, err := resolveRevision(".", rev)
+			if err == nil {
+				commit, err = peelToType(".", commit, Commit)
 			}
-		} else if opts.ObjectOnly {
-			for _, t := range trees {
-				fmt.Println(hex.EncodeToString(t.sha[:]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", rev)
+				os.Exit(128)
 			}
-		} else {
-			for _, t := range trees {
-				var oType string
-				switch t.perm {
-				case DIR:
-					oType = "tree"
-				default:
-					oType = "blob"
-				}
-				os.Stdout.Write([]byte(fmt.Sprintf("%s %s %s\t%s\n", t.perm, oType, hex.EncodeToString(t.sha[:]), t.name)))
+			if hexHash != "" {
+				fmt.Fprintf(os.Stderr, "fatal: More than one commit to dig from %s and %s?\n", names[0], rev)
+				os.Exit(128)
+			}
+			hexHash = commit
+		}
+		options := blameOptions{
+			lineDiff: lineDiffOptions{algorithm: "myers", ignoreAllSpace: opts.IgnoreSpace},
+			showRoot: opts.Root || configBool(".", config, "blame", "showRoot"),
+			ignored:  map[string]bool{},
+		}
+		ignoreFiles := opts.IgnoreRevsFiles
+		if file := configValue(".", config, "blame", "ignoreRevsFile"); file != "" {
+			ignoreFiles = append([]string{file}, ignoreFiles...)
+		}
+		for _, file := range ignoreFiles {
+			if file == "" {
+				clear(options.ignored)
+				continue
+			}
+			if err := readIgnoreRevs(".", file, options.ignored); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
 			}
 		}
+		for _, rev := range opts.IgnoreRevs {
+			commit, err := resolveRevision(".", rev)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: cannot find revision %s to ignore\n", rev)
+				os.Exit(128)
+			}
+			options.ignored[commit] = true
+		}
+		output := blameOutput{
+			porcelain:      opts.Porcelain || opts.LinePorcelain,
+			linePorcelain:  opts.LinePorcelain,
+			showName:       opts.ShowName,
+			showNumber:     opts.ShowNumber,
+			showEmail:      opts.ShowEmail || configBool(".", config, "blame", "showEmail"),
+			longNames:      opts.Long,
+			noAuthor:       opts.SuppressAuthor,
+			rawTimestamp:   opts.RawTimestamp,
+			blankBoundary:  opts.BlankBoundary || configBool(".", config, "blame", "blankBoundary"),
+			markUnblamable: configBool(".", config, "blame", "markUnblamableLines"),
+			markIgnored:    configBool(".", config, "blame", "markIgnoredLines"),
+			date:           opts.Date,
+		}
+		if output.date == "" {
+			output.date = configValue(".", config, "blame", "date")
+		}
+		if output.date == "" {
+			output.date = "iso"
+		}
+		if _, err := formatDate("0 +0000", output.date, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		walk, err := newBlameWalk(".", hexHash, strings.Join(names, " "), name, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		ranges, err := parseLineRanges(opts.Lines, walk.final, name)
+		if err == errLineRangeUsage {
+			fmt.Fprintf(os.Stderr, "usage: mygit blame [<options>] [<rev>] [--] <file>\n")
+			os.Exit(129)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		walk.blame(ranges)
+		walk.printBlame(output)
 
-	case "write-tree":
-		hash := createTreeObject(".")
-		hexHash := hex.EncodeToString(hash)
-		os.Stdout.Write([]byte(hexHash))
+	case "branch":
+		type Options struct {
+			Delete        bool   `short:"d" long:"delete" description:"Delete a fully merged branch"`
+			ForceDelete   bool   `short:"D" description:"Delete a branch even if it is not merged"`
+			Move          bool   `short:"m" long:"move" description:"Rename a branch"`
+			ForceMove     bool   `short:"M" description:"Rename a branch even if the new name exists"`
+			Force         bool   `short:"f" long:"force" description:"Reset an existing branch"`
+			Remotes       bool   `short:"r" long:"remotes" description:"Act on remote-tracking branches"`
+			All           bool   `short:"a" long:"all" description:"List both local and remote-tracking branches"`
+			Verbose       []bool `short:"v" long:"verbose" description:"Show commit and upstream of each branch"`
+			List          bool   `short:"l" long:"list" description:"List branches matching the patterns"`
+			Merged        string `long:"merged" optional:"yes" optional-value:"HEAD" description:"List branches merged into the commit"`
+			Contains      string `long:"contains" optional:"yes" optional-value:"HEAD" description:"List branches containing the commit"`
+			SetUpstreamTo string `short:"u" long:"set-upstream-to" description:"Set the upstream of a branch"`
+			UnsetUpstream bool   `long:"unset-upstream" description:"Remove the upstream of a branch"`
+		}
+		opts := Options{}
+		args, err := flags.ParseArgs(&opts, attachOptionalArguments(os.Args[1:], "--merged", "--contains"))
+		if err != nil {
+			os.Exit(129)
+		}
+		args = args[1:]
+		switch {
+		case opts.Delete || opts.ForceDelete:
+			if len(args) == 0 {
+				fmt.Fprintf(os.Stderr, "fatal: branch name required\n")
+				os.Exit(128)
+			}
+			os.Exit(deleteBranches(".", args, opts.Remotes, opts.ForceDelete || opts.Force))
+		case opts.Move || opts.ForceMove:
+			force := opts.ForceMove || opts.Force
+			switch len(args) {
+			case 1:
+				renameBranch(".", currentBranchName(".", "cannot rename the current branch while not on any."), args[0], force)
+			case 2:
+				renameBranch(".", args[0], args[1], force)
+			default:
+				fmt.Fprintf(os.Stderr, "fatal: too many arguments for a rename operation\n")
+				os.Exit(128)
+			}
+		case opts.SetUpstreamTo != "":
+			if len(args) > 1 {
+				fmt.Fprintf(os.Stderr, "fatal: too many arguments to set new upstream\n")
+				os.Exit(128)
+			}
+			branch := ""
+			if len(args) == 1 {
+				branch = args[0]
+			} else {
+				branch = currentBranchName(".", fmt.Sprintf("could not set upstream of HEAD to %s when it does not point to any branch.", opts.SetUpstreamTo))
+			}
+			if !refExists(".", "refs/heads/"+branch) {
+				fmt.Fprintf(os.Stderr, "fatal: branch '%s' does not exist\n", branch)
+				os.Exit(128)
+			}
+			upstream := ""
+			for _, candidate := range []string{opts.SetUpstreamTo, "refs/heads/" + opts.SetUpstreamTo, "refs/remotes/" + opts.SetUpstreamTo} {
+				if strings.HasPrefix(candidate, "refs/") && refExists(".", candidate) {
+					upstream = candidate
+					break
+				}
+			}
+			if upstream == "" {
+				fmt.Fprintf(os.Stderr, "fatal: the requested upstream branch '%s' does not exist\n", opts.SetUpstreamTo)
+				os.Exit(128)
+			}
+			err := setBranchUpstream(".", branch, upstream)
+			exitIfError(err, fmt.Sprintf("fatal: %s", err))
+			fmt.Printf("branch '%s' set up to track '%s'.\n", branch, shortRefName(upstream))
+		case opts.UnsetUpstream:
+			if len(args) > 1 {
+				fmt.Fprintf(os.Stderr, "fatal: too many arguments to unset upstream\n")
+				os.Exit(128)
+			}
+			if len(args) == 1 {
+				unsetBranchUpstream(".", args[0])
+			} else {
+				unsetBranchUpstream(".", currentBranchName(".", "could not unset upstream of HEAD when it does not point to any branch."))
+			}
+		case len(args) == 0 || opts.List || opts.Merged != "" || opts.Contains != "":
+			listBranches(".", branchListOptions{
+				all:      opts.All,
+				remotes:  opts.Remotes,
+				verbose:  len(opts.Verbose),
+				merged:   opts.Merged,
+				contains: opts.Contains,
+				patterns: args,
+			})
+		case len(args) <= 2:
+			start := "HEAD"
+			if len(args) == 2 {
+				start = args[1]
+			}
+			createBranch(".", args[0], start, opts.Force)
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit branch [<options>] [<branch-name> [<start-point>]]\n")
+			os.Exit(129)
+		}
 
-	case "commit-tree":
-		type Option struct {
-			Parent  string `short:"p" long:"parent" description:"hash of parent commit tree"`
-			Message string `short:"m" long:"message" description:"message of commit"`
+	case "switch":
+		type Options struct {
+			Create         string `short:"c" long:"create" description:"Create a new branch and switch to it"`
+			Detach         bool   `short:"d" long:"detach" description:"Switch to a commit in detached HEAD state"`
+			DiscardChanges bool   `short:"f" long:"discard-changes" description:"Discard local changes"`
 		}
-		opts := Option{}
+		opts := Options{}
 		args, err := flags.Parse(&opts)
 		if err != nil {
-			panic(err)
+			os.Exit(129)
+		}
+		if len(args) > 3 || (opts.Create == "" && len(args) != 2) {
+			fmt.Fprintf(os.Stderr, "usage: mygit switch [-c <new-branch>] [--detach] <branch>\n")
+			os.Exit(129)
+		}
+		if opts.Create != "" {
+			startName, start := "HEAD", headCommit(".")
+			if len(args) == 2 {
+				startName, start = args[1], resolveCommit(".", args[1])
+			}
+			createAndSwitchToBranch(".", opts.Create, startName, start, opts.DiscardChanges)
+		} else if opts.Detach {
+			detachHead(".", args[1], resolveCommit(".", args[1]), opts.DiscardChanges)
+		} else {
+			refName := "refs/heads/" + args[1]
+			if _, ok := resolveRef(".", refName); !ok {
+				fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", args[1])
+				os.Exit(128)
+			}
+			switchToBranch(".", refName, opts.DiscardChanges)
 		}
-		hash := args[1]
-		author_time := time.Now().Unix()
-		tz := "+0530"
-		authorName := config.Section("user").Key("name").String()
-		email := config.Section("user").Key("email").String()
-
-		if authorName == "" || email == "" {
-			fmt.Println(`
-You haven't set any value for name and email for commit. To execute commit-tree command, set name and email to global config file first. To do so you can execute below command
 
-	mygit config --global --add user.name "Your Name"
-	mygit config --global --add user.email "Your email address"`)
-			os.Exit(1)
+	case "checkout":
+		type Options struct {
+			Branch string `short:"b" description:"Create a new branch and check it out"`
+			Detach bool   `long:"detach" description:"Check out a commit in detached HEAD state"`
+			Force  bool   `short:"f" long:"force" description:"Throw away local changes"`
+		}
+		opts := Options{}
+		options, pathspecs, hasSeparator := splitPathspecs(os.Args[1:])
+		args, err := flags.ParseArgs(&opts, options)
+		if err != nil {
+			os.Exit(129)
+		}
+		if hasSeparator || len(args) > 2 {
+			// checkout [<commit>] -- <paths>
+			if !hasSeparator {
+				pathspecs = args[2:]
+				args = args[:2]
+			}
+			if len(pathspecs) == 0 {
+				fmt.Fprintf(os.Stderr, "fatal: you must specify path(s) to restore\n")
+				os.Exit(128)
+			}
+			if len(args) == 2 {
+				restorePaths(".", pathspecs, commitFiles(".", resolveCommit(".", args[1])), true, true)
+			} else {
+				restorePaths(".", pathspecs, nil, false, true)
+			}
+			break
+		}
+		if opts.Branch != "" {
+			startName, start := "HEAD", headCommit(".")
+			if len(args) == 2 {
+				startName, start = args[1], resolveCommit(".", args[1])
+			}
+			createAndSwitchToBranch(".", opts.Branch, startName, start, opts.Force)
+			break
+		}
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit checkout [--detach] <branch>|<commit>\n")
+			os.Exit(129)
+		}
+		refName := "refs/heads/" + args[1]
+		if _, ok := resolveRef(".", refName); ok && !opts.Detach {
+			switchToBranch(".", refName, opts.Force)
+		} else if _, err := resolveRevision(".", args[1]); err == nil {
+			detachHead(".", args[1], resolveCommit(".", args[1]), opts.Force)
+		} else if opts.Detach {
+			fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", args[1])
+			os.Exit(128)
+		} else {
+			// A single argument that is no revision names a path
+			restorePaths(".", args[1:], nil, false, true)
 		}
 
-		commitContent := "tree " + hash + "\n" +
-			"parent " + opts.Parent + "\n" +
-			"author " + authorName + " " + "<" + email + ">" + " " + strconv.Itoa(int(author_time)) + " " + tz + "\n" +
-			"committer " + authorName + " " + "<" + email + ">" + " " + strconv.Itoa(int(author_time)) + " " + tz + "\n" +
-			"\n" +
-			opts.Message + "\n"
-		commitHex := createCommitObject([]byte(commitContent))
-		os.Stdout.Write([]byte(commitHex))
+	case "restore":
+		type Options struct {
+			Source   string `short:"s" long:"source" description:"Restore from the given tree-ish"`
+			Staged   bool   `short:"S" long:"staged" description:"Restore the index"`
+			Worktree bool   `short:"W" long:"worktree" description:"Restore the working tree (default)"`
+		}
+		opts := Options{}
+		options, pathspecs, _ := splitPathspecs(os.Args[1:])
+		args, err := flags.ParseArgs(&opts, options)
+		if err != nil {
+			os.Exit(129)
+		}
+		pathspecs = append(args[1:], pathspecs...)
+		if len(pathspecs) == 0 {
+			fmt.Fprintf(os.Stderr, "fatal: you must specify path(s) to restore\n")
+			os.Exit(128)
+		}
+		if !opts.Staged {
+			opts.Worktree = true
+		}
+		var source map[string]treeFile
+		if opts.Source != "" {
+			source = commitFiles(".", resolveCommit(".", opts.Source))
+		} else if opts.Staged {
+			source = commitFiles(".", headCommit("."))
+		}
+		restorePaths(".", pathspecs, source, opts.Staged, opts.Worktree)
 
 	case "clone":
 		gitUrl := os.Args[2]
@@ -332,7 +2220,6 @@ You haven't set any value for name and email for commit. To execute commit-tree
 
 			CurrentObjectType := Unsepcified
 			CurrentNegativeOffsetToBO := 0
-			latestCommitHex := ""
 			for {
 				b := packData[cursor]
 				if CurrentProccessingStatus == HeaderProcessingStart {
@@ -375,9 +2262,6 @@ You haven't set any value for name and email for commit. To execute commit-tree
 						Hash:       hexHash,
 						ObjectType: CurrentObjectType,
 					}
-					if CurrentObjectType == Commit && latestCommitHex == "" {
-						latestCommitHex = hexHash
-					}
 					CurrentProccessingStatus = HeaderProcessingStart
 					cursor += len(packData[cursor:]) - unreadBuffLen
 					CurrentObjectStartIndex = 0
@@ -442,57 +2326,31 @@ You haven't set any value for name and email for commit. To execute commit-tree
 					}
 					proccessedObjectLength := len(objects)
 					if proccessedObjectLength == int(objectsLength) {
-						latestCommit := string(objects[latestCommitHex].content)
-						latestTree := objects[latestCommit[5:45]].content
+						latestCommit := string(objects[defaultBranchSha].content)
+						repoPath := filepath.Join(CWD, dest)
 						splits := strings.Split(symRef, "/")
 						branchName := splits[len(splits)-1]
-						localConfigPath := filepath.Join(CWD, dest, ".git", "config")
+						localConfigPath := filepath.Join(repoPath, ".git", "config")
 						localConfig := ini.Empty()
-						section := localConfig.Section(`remote "origin`)
+						section := localConfig.Section(`remote "origin"`)
 						section.Key("url").SetValue(gitUrl)
 						section.Key("fetch").SetValue("+refs/heads/*:refs/remotes/origin/*")
 						section = localConfig.Section(fmt.Sprintf(`branch "%v"`, branchName))
 						section.Key("remote").SetValue("origin")
 						section.Key("merge").SetValue("refs/heads/" + branchName)
-						os.MkdirAll(filepath.Join(CWD, dest, ".git", "objects"), 0644)
-						os.MkdirAll(filepath.Join(CWD, dest, ".git", "refs", "heads"), 0644)
-						os.WriteFile(filepath.Join(CWD, dest, ".git", "HEAD"), []byte("ref:"+symRef), 0755)
-						os.WriteFile(filepath.Join(CWD, dest, ".git", "refs", "heads", branchName), []byte(latestCommitHex), 0755)
+						os.MkdirAll(filepath.Join(repoPath, ".git", "objects"), 0755)
+						os.MkdirAll(filepath.Join(repoPath, ".git", "refs", "heads"), 0755)
+						writeRef(repoPath, symRef, defaultBranchSha, "clone: from "+gitUrl)
+						writeSymbolicRef(repoPath, "HEAD", symRef, "clone: from "+gitUrl)
 						err := localConfig.SaveTo(localConfigPath)
 						if err != nil {
 							panic(err)
 						}
-						// os.WriteFile(filepath.Join(CWD, dest, ".git", "config"), []byte(data), 0755)
-						treeContent := writeHeaderToContent(latestTree, Tree)
-						trees := decodeTreeObject(treeContent, false)
-						var writeTree func(string, []tree)
 						for hexHash, obj := range objects {
-							writeObjectToDisk(writeHeaderToContent(obj.content, obj.objectType), hexHash, true, filepath.Join(CWD, dest))
-						}
-						writeTree = func(destination string, trees []tree) {
-							for _, tree := range trees {
-								hexHash := hex.EncodeToString(tree.sha[:])
-								rootPath := destination
-								if tree.perm == "100644" {
-									err := os.MkdirAll(rootPath, 0644)
-									if err != nil {
-										panic(err)
-									}
-									err = os.WriteFile(filepath.Join(".", rootPath, tree.name), objects[hexHash].content, 0755)
-									if err != nil {
-										panic(err)
-									}
-								} else if tree.perm == "040000" {
-									treeObject := objects[hexHash].content
-									treeContent := writeHeaderToContent(treeObject, Tree)
-									latestTrees := decodeTreeObject(treeContent, false)
-									writeTree(filepath.Join(".", rootPath, tree.name), latestTrees)
-								} else {
-									fmt.Println("Unhandled tree:", tree)
-								}
-							}
+							writeObjectToDisk(writeHeaderToContent(obj.content, obj.objectType), hexHash, true, repoPath)
 						}
-						writeTree(dest, trees)
+						fmt.Println("Checking out files...")
+						writeIndex(repoPath, checkoutTree(repoPath, latestCommit[5:45], ""))
 						fmt.Println("Done!")
 					} else {
 						log.Fatal("Length mismatch detected!", proccessedObjectLength, objectsLength)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain lets tests run commands end to end: when MYGIT_TEST_MAIN is set
+// the test binary behaves as mygit itself.
+func TestMain(m *testing.M) {
+	if os.Getenv("MYGIT_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type commandResult struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// testEnv is the environment commands run with, which pins identities and
+// dates and keeps the user's own configuration out of the way.
+func testEnv(home string) []string {
+	return append(os.Environ(),
+		"HOME="+home,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=A U Thor", "GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE=1600000000 +0000",
+		"GIT_COMMITTER_NAME=C O Mitter", "GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_COMMITTER_DATE=1600000000 +0000",
+		"GIT_EDITOR=true",
+	)
+}
+
+// runMygit runs mygit with args in repo, feeding it stdin.
+func runMygit(t *testing.T, repo string, stdin string, args ...string) commandResult {
+	t.Helper()
+	return runMygitWithEnv(t, repo, nil, stdin, args...)
+}
+
+// runMygitWithEnv runs mygit like runMygit, with env overriding the
+// variables testEnv sets.
+func runMygitWithEnv(t *testing.T, repo string, env []string, stdin string, args ...string) commandResult {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = repo
+	cmd.Env = append(append(testEnv(t.TempDir()), "MYGIT_TEST_MAIN=1"), env...)
+	return runCommand(t, cmd, stdin)
+}
+
+// mygit runs mygit with args in repo and fails the test if it does not
+// succeed.
+func mygit(t *testing.T, repo string, args ...string) string {
+	t.Helper()
+	result := runMygit(t, repo, "", args...)
+	if result.code != 0 {
+		t.Fatalf("mygit %s: exit %d\n%s", strings.Join(args, " "), result.code, result.stderr)
+	}
+	return result.stdout
+}
+
+// realGit runs git itself with args in repo, skipping the test when it is
+// not installed.
+func realGit(t *testing.T, repo string, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo
+	cmd.Env = testEnv(t.TempDir())
+	result := runCommand(t, cmd, "")
+	if result.code != 0 {
+		t.Fatalf("git %s: exit %d\n%s", strings.Join(args, " "), result.code, result.stderr)
+	}
+	return result.stdout
+}
+
+func runCommand(t *testing.T, cmd *exec.Cmd, stdin string) commandResult {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return commandResult{stdout: stdout.String(), stderr: stderr.String(), code: cmd.ProcessState.ExitCode()}
+}
+
+// newTestRepo creates an empty repository with mygit init.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	mygit(t, repo, "init")
+	return repo
+}
+
+// writeTestFile writes content to name below repo, creating directories.
+func writeTestFile(t *testing.T, repo string, name string, content string) {
+	t.Helper()
+	writeFixtureFile(t, fixtureFile{path: worktreePath(repo, name), content: content})
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+/*
+*  ###################### MERGE ##############################
+*
+*  merge joins the history of another commit into the current branch. When
+*  the branch is an ancestor of the commit it is fast-forwarded, otherwise
+*  the trees are merged and the result committed with both commits as
+*  parents. A merge that stops, on conflicts or because it was asked to,
+*  leaves its state in .git for commit to pick up:
+*
+*    MERGE_HEAD   the commit being merged
+*    MERGE_MSG    the message of the merge commit
+*    MERGE_MODE   "no-ff" when a fast-forward was refused
+*    AUTO_MERGE   the merged tree, conflict markers included
+*    SQUASH_MSG   the message of a --squash merge, which commits nothing
+*
+*  Conflicted paths get the versions of the base, ours and theirs in the
+*  index as stages 1, 2 and 3, and the working tree file the merged
+*  content with conflict markers.
+ */
+
+type fastForward int
+
+const (
+	fastForwardAllowed fastForward = iota
+	fastForwardNever
+	fastForwardOnly
+)
+
+// mergeRequest holds the options of the merge command. The message is
+// written in the editor when edit is set.
+type mergeRequest struct {
+	message        string
+	hasMessage     bool
+	fastForward    fastForward
+	squash         bool
+	commit         bool
+	edit           bool
+	allowUnrelated bool
+	stat           bool
+}
+
+// mergeStateFiles are the files a stopped merge leaves behind, other than
+// SQUASH_MSG.
+var mergeStateFiles = []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "AUTO_MERGE"}
+
+func gitPath(repo string, name string) string {
+	return filepath.Join(repo, ".git", name)
+}
+
+func writeGitFile(repo string, name string, content string) {
+	err := os.WriteFile(gitPath(repo, name), []byte(content), 0644)
+	exitIfError(err, fmt.Sprintf("fatal: could not write '%s': %s", gitPath(repo, name), err))
+}
+
+func removeMergeState(repo string) {
+	for _, name := range mergeStateFiles {
+		os.Remove(gitPath(repo, name))
+	}
+}
+
+// readMergeHeads returns the commits of MERGE_HEAD, none when no merge is
+// in progress.
+func readMergeHeads(repo string) []string {
+	content, err := os.ReadFile(gitPath(repo, "MERGE_HEAD"))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(content))
+}
+
+// pendingMergeMessage is the message a stopped merge, cherry-pick or
+// revert prepared for the commit concluding it.
+func pendingMergeMessage(repo string) string {
+	message := ""
+	for _, name := range []string{"SQUASH_MSG", "MERGE_MSG"} {
+		if content, err := os.ReadFile(gitPath(repo, name)); err == nil {
+			message += string(content)
+		}
+	}
+	return message
+}
+
+// defaultMergeMessage describes merging name, which may name a branch, a
+// tag, a remote-tracking branch or any commit, into the current branch.
+// Ancestors of a branch written as <branch>~<n> or <branch>^ are its early
+// part. Merges into main or master do not say so.
+func defaultMergeMessage(repo string, name string) string {
+	kind, shown := "commit", name
+	base := strings.TrimRight(name, "^")
+	if base == name {
+		if digits := strings.TrimRight(name, "0123456789"); digits != name && strings.HasSuffix(digits, "~") {
+			base = strings.TrimSuffix(digits, "~")
+		}
+	}
+	suffix := ""
+	if _, ok := resolveRef(repo, "refs/heads/"+base); base != name && ok {
+		kind, shown, suffix = "branch", base, " (early part)"
+	} else if refName, count := dwimRefName(repo, name); count > 0 {
+		switch {
+		case strings.HasPrefix(refName, "refs/heads/"):
+			kind, shown = "branch", strings.TrimPrefix(refName, "refs/heads/")
+		case strings.HasPrefix(refName, "refs/tags/"):
+			kind, shown = "tag", strings.TrimPrefix(refName, "refs/tags/")
+		case strings.HasPrefix(refName, "refs/remotes/"):
+			kind, shown = "remote-tracking branch", strings.TrimPrefix(refName, "refs/remotes/")
+		}
+	}
+	message := fmt.Sprintf("Merge %s '%s'%s", kind, shown, suffix)
+	into := "HEAD"
+	if branch, ok := headBranch(repo); ok {
+		into = shortRefName(branch)
+	}
+	if into != "main" && into != "master" {
+		message += " into " + into
+	}
+	return message
+}
+
+// mergeIntoHead merges the commit name stands for into HEAD.
+func mergeIntoHead(repo string, config *ini.File, name string, request mergeRequest) {
+	if _, ok := stageZeroEntries(readIndex(repo)); !ok {
+		fmt.Fprintf(os.Stderr, "error: Merging is not possible because you have unmerged files.\n"+
+			"hint: Fix them up in the work tree, and then use 'mygit add <file>'\n"+
+			"hint: as appropriate to mark resolution and make a commit.\n"+
+			"fatal: Exiting because of an unresolved conflict.\n")
+		os.Exit(128)
+	}
+	if readMergeHeads(repo) != nil {
+		fmt.Fprintf(os.Stderr, "fatal: You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.\n")
+		os.Exit(128)
+	}
+	other, err := resolveRevision(repo, name)
+	if err == nil {
+		other, err = peelToType(repo, other, Commit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "merge: %s - not something we can merge\n", name)
+		os.Exit(1)
+	}
+	reflogAction := "merge " + name
+	head := headCommit(repo)
+	if head == "" {
+		// Merging into an unborn branch just checks the commit out
+		if !updateWorktree(repo, map[string]treeFile{}, commitFiles(repo, other), false, "merge") {
+			os.Exit(1)
+		}
+		updateHead(repo, other, "initial pull")
+		return
+	}
+	writeRef(repo, "ORIG_HEAD", head, "updating ORIG_HEAD")
+
+	walk := newAncestryWalk(repo)
+	bases := walk.mergeBases(head, []string{other})
+	switch {
+	case len(bases) == 0 && !request.allowUnrelated:
+		fmt.Fprintf(os.Stderr, "fatal: refusing to merge unrelated histories\n")
+		os.Exit(128)
+	case len(bases) == 1 && bases[0] == other:
+		if request.squash {
+			fmt.Println("Already up to date. (nothing to squash)")
+		} else {
+			fmt.Println("Already up to date.")
+		}
+		removeMergeState(repo)
+		return
+	case len(bases) == 1 && bases[0] == head && request.fastForward != fastForwardNever:
+		fmt.Printf("Updating %s..%s\n", head[:7], other[:7])
+		if !updateWorktree(repo, commitFiles(repo, head), commitFiles(repo, other), false, "merge") {
+			os.Exit(1)
+		}
+		message := "Fast-forward"
+		if request.hasMessage {
+			message += " (no commit created; -m option ignored)"
+		}
+		finishMerge(repo, config, head, other, other, message, reflogAction, request)
+		removeMergeState(repo)
+		return
+	case request.fastForward == fastForwardOnly:
+		fmt.Fprintf(os.Stderr, "fatal: Not possible to fast-forward, aborting.\n")
+		os.Exit(128)
+	}
+
+	if changed := indexChanges(repo, head); len(changed) > 0 {
+		fmt.Fprintf(os.Stderr, "error: Your local changes to the following files would be overwritten by merge:\n  %s\n", strings.Join(changed, " "))
+		fmt.Fprintf(os.Stderr, "Merge with strategy ort failed.\n")
+		os.Exit(2)
+	}
+	style, _ := mergeStyleFromConfig(configValue(repo, config, "merge", "conflictStyle"))
+	result := mergeCommits(repo, head, other, "HEAD", name, style)
+	if !updateWorktree(repo, commitFiles(repo, head), result.files, false, "merge") {
+		fmt.Fprintf(os.Stderr, "Merge with strategy ort failed.\n")
+		os.Exit(2)
+	}
+	recordConflicts(repo, result)
+	writeGitFile(repo, "AUTO_MERGE", result.tree+"\n")
+	printMergeMessages(result.messages)
+
+	message := request.message
+	if !request.hasMessage {
+		message = defaultMergeMessage(repo, name)
+	}
+	if result.clean && request.commit && !request.squash {
+		commitHex := createMergeCommit(repo, config, result.tree, head, other, message, request)
+		finishMerge(repo, config, head, other, commitHex, "Merge made by the 'ort' strategy.", reflogAction, request)
+		removeMergeState(repo)
+		return
+	}
+	if request.squash {
+		finishMerge(repo, config, head, other, "", "", reflogAction, request)
+	} else {
+		writeMergeState(repo, other, message, request)
+	}
+	if result.clean {
+		fmt.Fprintf(os.Stderr, "Automatic merge went well; stopped before committing as requested\n")
+		return
+	}
+	appendConflictsHint(repo)
+	fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+	os.Exit(1)
+}
+
+// indexChanges lists the paths whose staged version differs from commit.
+func indexChanges(repo string, commit string) []string {
+	files := commitFiles(repo, commit)
+	changed := []string{}
+	staged := map[string]bool{}
+	for _, entry := range readIndex(repo) {
+		staged[entry.name] = true
+		if file, ok := files[entry.name]; !ok || file.perm != permFromIndexMode(entry.mode) || file.sha != entry.sha {
+			changed = append(changed, entry.name)
+		}
+	}
+	for name := range files {
+		if !staged[name] {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// recordConflicts replaces the index entries of the conflicted paths by
+// the stages of the base, ours and theirs that have a file there.
+func recordConflicts(repo string, result treeMergeResult) {
+	entries := slices.DeleteFunc(readIndex(repo), func(entry indexEntry) bool {
+		return result.conflicted[entry.name] != nil
+	})
+	for name, conflict := range result.conflicted {
+		for i, stage := range conflict.stages {
+			if conflict.filemask&(1<<i) != 0 {
+				entries = append(entries, indexEntry{name: name, mode: indexModeFromPerm(stage.perm), sha: stage.sha, stage: uint8(i + 1)})
+			}
+		}
+	}
+	writeIndex(repo, entries)
+}
+
+// printMergeMessages prints the messages of a merge, ordered by path.
+func printMergeMessages(messages map[string][]string) {
+	names := make([]string, 0, len(messages))
+	for name := range messages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, message := range messages[name] {
+			fmt.Println(message)
+		}
+	}
+}
+
+// appendConflictsHint lists the unmerged paths at the end of MERGE_MSG,
+// as comments the commit will strip.
+func appendConflictsHint(repo string) {
+	hint := "\n# Conflicts:\n"
+	previous := ""
+	for _, entry := range readIndex(repo) {
+		if entry.stage != 0 && entry.name != previous {
+			hint += "#\t" + entry.name + "\n"
+			previous = entry.name
+		}
+	}
+	file, err := os.OpenFile(gitPath(repo, "MERGE_MSG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	exitIfError(err, fmt.Sprintf("fatal: could not open '%s': %s", gitPath(repo, "MERGE_MSG"), err))
+	defer file.Close()
+	_, err = file.WriteString(hint)
+	exitIfError(err, fmt.Sprintf("fatal: could not write '%s': %s", gitPath(repo, "MERGE_MSG"), err))
+}
+
+// writeMergeState leaves what commit needs to conclude the merge of
+// other.
+func writeMergeState(repo string, other string, message string, request mergeRequest) {
+	writeGitFile(repo, "MERGE_HEAD", other+"\n")
+	writeGitFile(repo, "MERGE_MSG", message+"\n")
+	mode := ""
+	if request.fastForward == fastForwardNever {
+		mode = "no-ff"
+	}
+	writeGitFile(repo, "MERGE_MODE", mode)
+}
+
+// createMergeCommit records the merged tree as a commit on top of head with
+// other as second parent, letting the user edit the message first when
+// asked to.
+func createMergeCommit(repo string, config *ini.File, treeHex string, head string, other string, message string, request mergeRequest) string {
+	edited := message
+	cleanup := "whitespace"
+	if request.edit {
+		cleanup = "strip"
+		template := message + "\n\n" +
+			"# Please enter a commit message to explain why this merge is necessary,\n" +
+			"# especially if it merges an updated upstream into a topic branch.\n" +
+			"#\n" +
+			"# Lines starting with '#' will be ignored, and an empty message aborts\n" +
+			"# the commit.\n"
+		writeGitFile(repo, "MERGE_HEAD", other+"\n")
+		writeGitFile(repo, "MERGE_MSG", template)
+		launchEditor(gitPath(repo, "MERGE_MSG"), config)
+		content, err := os.ReadFile(gitPath(repo, "MERGE_MSG"))
+		exitIfError(err, fmt.Sprintf("fatal: could not read '%s': %s", gitPath(repo, "MERGE_MSG"), err))
+		edited = string(content)
+	}
+	cleaned, err := cleanupCommitMessage(edited, cleanup)
+	exitIfError(err, fmt.Sprintf("fatal: %s", err))
+	if cleaned == "" {
+		fmt.Fprintf(os.Stderr, "error: Empty commit message.\n")
+		fmt.Fprintf(os.Stderr, "Not committing merge; use 'mygit commit' to complete the merge.\n")
+		writeMergeState(repo, other, message, request)
+		os.Exit(1)
+	}
+	commit := commitObject{
+		tree:      treeHex,
+		parents:   []string{head, other},
+		author:    authorIdentity(config).String(),
+		committer: committerIdentity(config).String(),
+		message:   cleaned,
+	}
+	return createCommitObject(commit.encode())
+}
+
+// finishMerge reports the result of merging other and moves HEAD to
+// newHead, unless squashing, then shows what changed since head.
+func finishMerge(repo string, config *ini.File, head string, other string, newHead string, message string, reflogAction string, request mergeRequest) {
+	if message != "" {
+		fmt.Println(message)
+	}
+	if request.squash {
+		fmt.Println("Squash commit -- not updating HEAD")
+		writeSquashMessage(repo, head, other)
+	} else {
+		updateHead(repo, newHead, reflogAction+": "+message)
+	}
+	if newHead == "" || !request.stat {
+		return
+	}
+	stat, _ := diffFlags{}.statOptions(repo, config)
+	pairs := detectRenames(repo, diffTrees(repo, readCommit(repo, head).tree, readCommit(repo, newHead).tree, treeDiffOptions{recursive: true}, nil), renameOptions{detect: findRenames, minimumScore: defaultSimilarityScore})
+	out := bufio.NewWriter(os.Stdout)
+	writer := &patchWriter{out: out, options: diffOptions{
+		lineDiffOptions: lineDiffOptions{algorithm: "myers"},
+		output:          outputStat | outputSummary,
+		stat:            stat,
+	}}
+	writer.writeDiff(repo, pairs)
+	out.Flush()
+}
+
+// updateHead points the current branch, or a detached HEAD, at commit.
+func updateHead(repo string, commit string, reflogMessage string) {
+	if branch, ok := headBranch(repo); ok {
+		writeRef(repo, branch, commit, reflogMessage)
+	} else {
+		writeRef(repo, "HEAD", commit, reflogMessage)
+	}
+}
+
+// writeSquashMessage lists the commits a squash merge brought in, for the
+// commit that records it.
+func writeSquashMessage(repo string, head string, other string) {
+	walk := walkRevisions(repo, revisionSet{include: []string{other}, exclude: []string{head}}, revWalkOptions{maxCount: -1, maxParents: -1})
+	message := "Squashed commit of the following:\n"
+	for _, hexHash := range walk.commits {
+		message += "\n" + prettyCommit(hexHash, walk.commit(hexHash), nil, logOptions{format: "medium"}, time.Now())
+	}
+	writeGitFile(repo, "SQUASH_MSG", message)
+}
+
+// abortMerge goes back to the state before the merge: the index and the
+// files the merge changed are reset to HEAD, other local changes stay.
+func abortMerge(repo string) {
+	if readMergeHeads(repo) == nil {
+		fmt.Fprintf(os.Stderr, "fatal: There is no merge to abort (MERGE_HEAD missing).\n")
+		os.Exit(128)
+	}
+	resetMerge(repo, headCommit(repo), "reset: moving to HEAD")
+	removeMergeState(repo)
+	os.Remove(gitPath(repo, "SQUASH_MSG"))
+}
+
+// resetMerge moves HEAD to commit and resets the index and the files that
+// differ from it, like reset --merge: local changes to other files stay.
+func resetMerge(repo string, commit string, reflogMessage string) {
+	resetIndexTo(repo, commit)
+	if head := headCommit(repo); head != "" {
+		writeRef(repo, "ORIG_HEAD", head, "updating ORIG_HEAD")
+	}
+	updateHead(repo, commit, reflogMessage)
+}
+
+// resetIndexTo resets the index, and the files whose entries change, to
+// the files of commit without moving HEAD.
+func resetIndexTo(repo string, commit string) {
+	files := commitFiles(repo, commit)
+	entries := []indexEntry{}
+	reset := map[string]bool{}
+	for _, entry := range readIndex(repo) {
+		file, ok := files[entry.name]
+		switch {
+		case reset[entry.name]:
+		case entry.stage == 0 && ok && file.perm == permFromIndexMode(entry.mode) && file.sha == entry.sha:
+			entries = append(entries, entry)
+		case ok:
+			checkoutFile(repo, entry.name, file.perm, hex.EncodeToString(file.sha[:]))
+			entries = append(entries, newIndexEntry(repo, entry.name, file.perm, file.sha))
+			reset[entry.name] = true
+		default:
+			removeWorktreeFile(repo, entry.name)
+			reset[entry.name] = true
+		}
+	}
+	staged := map[string]bool{}
+	for _, entry := range entries {
+		staged[entry.name] = true
+	}
+	for name, file := range files {
+		if !staged[name] {
+			checkoutFile(repo, name, file.perm, hex.EncodeToString(file.sha[:]))
+			entries = append(entries, newIndexEntry(repo, name, file.perm, file.sha))
+		}
+	}
+	writeIndex(repo, entries)
+}
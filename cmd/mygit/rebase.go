@@ -0,0 +1,1107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gopkg.in/ini.v1"
+)
+
+/*
+*  ###################### REBASE ##############################
+*
+*  rebase replays the commits of a branch that upstream does not have on
+*  top of another commit, onto, and moves the branch to the result. The
+*  commits form a todo list of commands which rebase -i lets the user edit
+*  first: picks can be reordered, dropped, reworded, stopped at or melded
+*  into the commit before them, and shell commands run in between.
+*
+*  The rebase keeps its state in .git/rebase-merge so that a stop can be
+*  continued, skipped or aborted:
+*
+*    head-name        the branch being rebased, or "detached HEAD"
+*    onto             the commit the commits are replayed on
+*    orig-head        the commit rebased, where --abort goes back to
+*    git-rebase-todo  the commands left to run
+*    done             the commands run so far, the stopped one last
+*    msgnum, end      the number of commands run so far and of all
+*    stopped-sha      the commit whose pick stopped, along with its
+*                     message, author-script and patch
+*    amend            HEAD when the stop is at a commit the rebase made
+*    current-fixups   the squashes and fixups melded into HEAD so far,
+*    message-squash   and the message combining theirs
+*
+*  Without -i the file drop_redundant_commits is also there, and picks that
+*  end up changing nothing are dropped.
+ */
+
+// rebaseCommand is a line of the todo list. Commands taking a commit keep
+// the rest of the line, the subject, in arg; exec keeps its shell command.
+type rebaseCommand struct {
+	name   string
+	commit string
+	arg    string
+}
+
+// rebaseCommandNames maps the abbreviations of the todo commands to their
+// names.
+var rebaseCommandNames = map[string]string{
+	"p": "pick",
+	"r": "reword",
+	"e": "edit",
+	"s": "squash",
+	"f": "fixup",
+	"x": "exec",
+	"b": "break",
+	"d": "drop",
+}
+
+func (command rebaseCommand) format(abbreviate bool) string {
+	switch {
+	case command.name == "exec":
+		return "exec " + command.arg
+	case command.commit == "":
+		return command.name
+	}
+	commit := command.commit
+	if abbreviate {
+		commit = commit[:7]
+	}
+	if command.arg == "" {
+		return command.name + " " + commit
+	}
+	return command.name + " " + commit + " " + command.arg
+}
+
+// meld tells whether the command melds its commit into HEAD.
+func (command rebaseCommand) meld() bool {
+	return command.name == "squash" || command.name == "fixup"
+}
+
+func formatRebaseTodo(todo []rebaseCommand, abbreviate bool) string {
+	content := ""
+	for _, command := range todo {
+		content += command.format(abbreviate) + "\n"
+	}
+	return content
+}
+
+// parseRebaseTodo reads the commands of a todo list, skipping comments and
+// empty lines.
+func parseRebaseTodo(repo string, content string) ([]rebaseCommand, error) {
+	todo := []rebaseCommand{}
+	for number, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		invalid := fmt.Errorf("invalid line %d: %s", number+1, line)
+		name, rest, _ := strings.Cut(line, " ")
+		if full, ok := rebaseCommandNames[name]; ok {
+			name = full
+		} else if !slices.Contains([]string{"pick", "reword", "edit", "squash", "fixup", "exec", "break", "drop"}, name) {
+			return nil, invalid
+		}
+		rest = strings.TrimSpace(rest)
+		command := rebaseCommand{name: name}
+		switch name {
+		case "break":
+			if rest != "" {
+				return nil, invalid
+			}
+		case "exec":
+			if rest == "" {
+				return nil, invalid
+			}
+			command.arg = rest
+		default:
+			revision, subject, _ := strings.Cut(rest, " ")
+			hexHash, err := resolveRevision(repo, revision)
+			if err == nil {
+				hexHash, err = peelToType(repo, hexHash, Commit)
+			}
+			if revision == "" || err != nil {
+				return nil, invalid
+			}
+			command.commit, command.arg = hexHash, strings.TrimSpace(subject)
+		}
+		todo = append(todo, command)
+	}
+	return todo, nil
+}
+
+// checkRebaseTodo refuses a squash or fixup with no commit to meld into.
+func checkRebaseTodo(todo []rebaseCommand) error {
+	for _, command := range todo {
+		switch {
+		case command.meld():
+			return fmt.Errorf("cannot '%s' without a previous commit", command.name)
+		case command.name != "drop":
+			return nil
+		}
+	}
+	return nil
+}
+
+// rebaseTodoHelp explains the commands below the todo list of rebase -i,
+// or of --edit-todo when editing is set.
+func rebaseTodoHelp(summary string, editing bool) string {
+	help := "Commands:\n" +
+		"p, pick <commit> = use commit\n" +
+		"r, reword <commit> = use commit, but edit the commit message\n" +
+		"e, edit <commit> = use commit, but stop for amending\n" +
+		"s, squash <commit> = use commit, but meld into previous commit\n" +
+		"f, fixup <commit> = like \"squash\" but keep only the previous\n" +
+		"                   commit's log message\n" +
+		"x, exec <command> = run command (the rest of the line) using shell\n" +
+		"b, break = stop here (continue rebase later with 'mygit rebase --continue')\n" +
+		"d, drop <commit> = remove commit\n" +
+		"\n" +
+		"These lines can be re-ordered; they are executed from top to bottom.\n" +
+		"\n" +
+		"If you remove a line here THAT COMMIT WILL BE LOST.\n" +
+		"\n"
+	if editing {
+		help += "You are editing the todo file of an ongoing interactive rebase.\n" +
+			"To continue rebase after editing, run:\n" +
+			"    mygit rebase --continue\n" +
+			"\n"
+		return commentLines("\n" + help)
+	}
+	help += "However, if you remove everything, the rebase will be aborted.\n" +
+		"\n"
+	return "\n" + commentLines(summary+"\n\n"+help)
+}
+
+// commentLines turns every line of text into a comment.
+func commentLines(text string) string {
+	commented := ""
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if line == "" {
+			commented += "#\n"
+		} else {
+			commented += "# " + line + "\n"
+		}
+	}
+	return commented
+}
+
+// autosquashTodo moves the picks of commits whose subject starts with
+// "fixup! " or "squash! " right after the commit they name, by subject,
+// object name or subject prefix, turning them into fixups and squashes.
+func autosquashTodo(todo []rebaseCommand) []rebaseCommand {
+	next := make([]int, len(todo))
+	tail := make([]int, len(todo))
+	subjects := map[string]int{}
+	for i, command := range todo {
+		next[i], tail[i] = -1, -1
+		if command.commit == "" || command.name == "drop" {
+			continue
+		}
+		target := -1
+		if name, ok := cutFixupPrefix(command.arg); ok {
+			for ok {
+				name, ok = cutFixupPrefix(strings.TrimLeftFunc(name, unicode.IsSpace))
+			}
+			if j, found := subjects[name]; found {
+				target = j
+			} else if len(name) >= 4 && isHexPrefix(name) {
+				target = slices.IndexFunc(todo, func(other rebaseCommand) bool {
+					return other.commit != "" && strings.HasPrefix(other.commit, name)
+				})
+			}
+			if target < 0 {
+				target = slices.IndexFunc(todo[:i], func(other rebaseCommand) bool {
+					return other.commit != "" && other.name != "drop" && strings.HasPrefix(other.arg, name)
+				})
+			}
+		}
+		if target < 0 || target == i {
+			if _, found := subjects[command.arg]; !found {
+				subjects[command.arg] = i
+			}
+			continue
+		}
+		todo[i].name = "squash"
+		if strings.HasPrefix(command.arg, "fixup!") {
+			todo[i].name = "fixup"
+		}
+		after := target
+		if tail[target] >= 0 {
+			after = tail[target]
+		}
+		next[i], next[after] = next[after], i
+		tail[target] = i
+	}
+	arranged := []rebaseCommand{}
+	for i, command := range todo {
+		if command.meld() {
+			continue
+		}
+		for current := i; current >= 0; current = next[current] {
+			arranged = append(arranged, todo[current])
+		}
+	}
+	return arranged
+}
+
+func cutFixupPrefix(subject string) (string, bool) {
+	for _, prefix := range []string{"fixup!", "squash!"} {
+		if rest, ok := strings.CutPrefix(subject, prefix); ok {
+			return rest, true
+		}
+	}
+	return subject, false
+}
+
+// squashMessage adds the message of the count-th commit of a squash, or
+// comments it out for a fixup, to the message combining the ones before.
+// The count in the first line of combined is updated, and the subject of
+// a "squash! ..." commit is commented out.
+func squashMessage(combined string, count int, message string, skip bool) string {
+	_, rest, _ := strings.Cut(combined, "\n")
+	combined = fmt.Sprintf("# This is a combination of %d commits.\n", count) + rest
+	if skip {
+		return combined + fmt.Sprintf("\n# The commit message #%d will be skipped:\n\n", count) + commentLines(message)
+	}
+	combined += fmt.Sprintf("\n# This is the commit message #%d:\n\n", count)
+	if strings.HasPrefix(message, "squash!") || strings.HasPrefix(message, "fixup!") {
+		// The subject only named the commit to squash into
+		subject, body, found := strings.Cut(message, "\n\n")
+		if found {
+			subject, body = subject+"\n", "\n"+body
+		}
+		return combined + commentLines(subject) + body
+	}
+	return combined + message
+}
+
+// patchID identifies the change a commit makes regardless of where it
+// applies: it hashes its patch without line numbers, object names and
+// whitespace.
+func patchID(repo string, commit commitObject) string {
+	hash := sha1.New()
+	for _, line := range strings.Split(commitPatch(repo, commit), "\n") {
+		if strings.HasPrefix(line, "index ") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		hash.Write([]byte(strings.Join(strings.Fields(line), "")))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// commitPatch is the patch of the changes commit makes to its first
+// parent.
+func commitPatch(repo string, commit commitObject) string {
+	parentTree := emptyTreeHex
+	if len(commit.parents) > 0 {
+		parentTree = readCommit(repo, commit.parents[0]).tree
+	}
+	var buffer bytes.Buffer
+	out := bufio.NewWriter(&buffer)
+	writer := patchWriter{out: out, options: diffOptions{lineDiffOptions: lineDiffOptions{algorithm: "myers"}, context: 3, output: outputPatch}}
+	writer.writeDiff(repo, diffTrees(repo, parentTree, commit.tree, treeDiffOptions{recursive: true}, nil))
+	out.Flush()
+	return buffer.String()
+}
+
+// rebaseState is the rebase in progress.
+type rebaseState struct {
+	headName    string
+	onto        string
+	origHead    string
+	interactive bool
+	done        []rebaseCommand
+	todo        []rebaseCommand
+}
+
+// rebaseRequest holds the options of a rebase being started. upstream and
+// onto are as given, onto being empty without --onto.
+type rebaseRequest struct {
+	upstream    string
+	onto        string
+	branch      string
+	interactive bool
+	autosquash  bool
+}
+
+func rebasePath(repo string, name string) string {
+	return gitPath(repo, "rebase-merge/"+name)
+}
+
+func readRebaseFile(repo string, name string) string {
+	content, _ := os.ReadFile(rebasePath(repo, name))
+	return strings.TrimSpace(string(content))
+}
+
+// readRebase loads the rebase in progress, exiting when there is none.
+// An error is returned for a todo list that does not parse.
+func readRebase(repo string) (rebaseState, error) {
+	if _, err := os.Stat(gitPath(repo, "rebase-merge")); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: No rebase in progress?\n")
+		os.Exit(128)
+	}
+	state := rebaseState{
+		headName: readRebaseFile(repo, "head-name"),
+		onto:     readRebaseFile(repo, "onto"),
+		origHead: readRebaseFile(repo, "orig-head"),
+	}
+	_, err := os.Stat(rebasePath(repo, "drop_redundant_commits"))
+	state.interactive = err != nil
+	state.done, _ = parseRebaseTodo(repo, readRebaseFile(repo, "done"))
+	state.todo, err = parseRebaseTodo(repo, readRebaseFile(repo, "git-rebase-todo"))
+	return state, err
+}
+
+func writeRebaseTodo(repo string, state rebaseState) {
+	writeGitFile(repo, "rebase-merge/git-rebase-todo", formatRebaseTodo(state.todo, false))
+	writeGitFile(repo, "rebase-merge/done", formatRebaseTodo(state.done, false))
+	writeGitFile(repo, "rebase-merge/msgnum", fmt.Sprintf("%d\n", len(state.done)))
+	writeGitFile(repo, "rebase-merge/end", fmt.Sprintf("%d\n", len(state.done)+len(state.todo)))
+}
+
+// rebaseTodoFailed explains how to get out of a todo list that does not
+// parse.
+func rebaseTodoFailed(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	fmt.Fprintf(os.Stderr, "You can fix this with 'mygit rebase --edit-todo' and then run 'mygit rebase --continue'.\n"+
+		"Or you can abort the rebase with 'mygit rebase --abort'.\n")
+	os.Exit(1)
+}
+
+// clearRebaseProgress erases the progress line.
+func clearRebaseProgress() {
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", terminalColumns()))
+	} else {
+		fmt.Fprintf(os.Stderr, "\r\033[K")
+	}
+}
+
+// localChanges tells whether tracked files differ from the index, and the
+// index from HEAD.
+func localChanges(repo string) (bool, bool) {
+	unstaged := false
+	for _, entry := range readIndex(repo) {
+		if entry.stage != 0 || worktreeChanged(repo, entry) {
+			unstaged = true
+			break
+		}
+	}
+	return unstaged, len(indexChanges(repo, headCommit(repo))) > 0
+}
+
+// printLocalChanges names the local changes keeping a rebase from going
+// on.
+func printLocalChanges(unstaged bool, staged bool) {
+	if unstaged {
+		fmt.Fprintf(os.Stderr, "error: cannot rebase: You have unstaged changes.\n")
+	}
+	if staged && unstaged {
+		fmt.Fprintf(os.Stderr, "error: additionally, your index contains uncommitted changes.\n")
+	} else if staged {
+		fmt.Fprintf(os.Stderr, "error: cannot rebase: Your index contains uncommitted changes.\n")
+	}
+}
+
+// rebaseUpstream is the branch the current branch tracks, for a rebase
+// given no upstream.
+func rebaseUpstream(repo string) string {
+	branch, onBranch := headBranch(repo)
+	if onBranch {
+		if upstream, ok := branchUpstream(repo, shortRefName(branch)); ok {
+			return upstream
+		}
+		fmt.Printf("There is no tracking information for the current branch.\n")
+	} else {
+		fmt.Printf("You are not currently on a branch.\n")
+	}
+	fmt.Printf("Please specify which branch you want to rebase against.\n" +
+		"See git-rebase(1) for details.\n" +
+		"\n" +
+		"    mygit rebase '<branch>'\n" +
+		"\n")
+	if onBranch {
+		fmt.Printf("If you wish to set tracking information for this branch you can do so with:\n"+
+			"\n"+
+			"    mygit branch --set-upstream-to=<remote>/<branch> %s\n"+
+			"\n", shortRefName(branch))
+	}
+	os.Exit(1)
+	return ""
+}
+
+// startRebase works out the commits to replay and replays them, unless the
+// branch is already based on onto.
+func startRebase(repo string, config *ini.File, request rebaseRequest) {
+	if _, err := os.Stat(gitPath(repo, "rebase-merge")); err == nil {
+		fmt.Fprintf(os.Stderr, "fatal: It seems that there is already a rebase-merge directory, and\n"+
+			"I wonder if you are in the middle of another rebase.  If that is the\n"+
+			"case, please try\n"+
+			"\tmygit rebase (--continue | --abort | --skip)\n"+
+			"If that is not the case, please\n"+
+			"\trm -fr \"%s\"\n"+
+			"and run me again.  I am stopping in case you still have something\n"+
+			"valuable there.\n\n", gitPath(repo, "rebase-merge"))
+		os.Exit(128)
+	}
+	upstreamName := request.upstream
+	if upstreamName == "" {
+		upstreamName = rebaseUpstream(repo)
+	}
+	upstream, err := resolveRevision(repo, upstreamName)
+	if err == nil {
+		upstream, err = peelToType(repo, upstream, Commit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: invalid upstream '%s'\n", upstreamName)
+		os.Exit(128)
+	}
+	onto, ontoName := upstream, upstreamName
+	if request.onto != "" {
+		onto, err = resolveRevision(repo, request.onto)
+		if err == nil {
+			onto, err = peelToType(repo, onto, Commit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: Does not point to a valid commit '%s'\n", request.onto)
+			os.Exit(128)
+		}
+		ontoName = request.onto
+	}
+
+	headName := "detached HEAD"
+	if branch, ok := headBranch(repo); ok {
+		headName = branch
+	}
+	head := headCommit(repo)
+	if request.branch != "" {
+		if hexHash, ok := resolveRef(repo, "refs/heads/"+request.branch); ok {
+			headName, head = "refs/heads/"+request.branch, hexHash
+		} else if hexHash, err := resolveRevision(repo, request.branch); err == nil {
+			headName, head = "detached HEAD", hexHash
+		} else {
+			fmt.Fprintf(os.Stderr, "fatal: no such branch/commit '%s'\n", request.branch)
+			os.Exit(128)
+		}
+	}
+	if unstaged, staged := localChanges(repo); unstaged || staged {
+		printLocalChanges(unstaged, staged)
+		fmt.Fprintf(os.Stderr, "error: Please commit or stash them.\n")
+		os.Exit(1)
+	}
+
+	walk := newAncestryWalk(repo)
+	if !request.interactive && slices.Equal(walk.mergeBases(onto, []string{head}), []string{onto}) &&
+		slices.Equal(newAncestryWalk(repo).mergeBases(upstream, []string{head}), []string{onto}) &&
+		linearHistory(repo, onto, head) {
+		if request.branch != "" {
+			switchRebaseBranch(repo, headName, head, request.branch)
+		}
+		if headName == "detached HEAD" {
+			fmt.Printf("HEAD is up to date.\n")
+		} else {
+			fmt.Printf("Current branch %s is up to date.\n", shortRefName(headName))
+		}
+		return
+	}
+
+	picks := walkRevisions(repo, revisionSet{include: []string{head}, exclude: []string{upstream}}, revWalkOptions{order: "topo", maxCount: -1, maxParents: 1, reverse: true}).commits
+	applied := map[string]bool{}
+	if len(picks) > 0 {
+		for _, hexHash := range walkRevisions(repo, revisionSet{include: []string{upstream}, exclude: []string{head}}, revWalkOptions{maxCount: -1, maxParents: 1}).commits {
+			applied[patchID(repo, readCommit(repo, hexHash))] = true
+		}
+	}
+	todo := []rebaseCommand{}
+	for _, hexHash := range picks {
+		commit := readCommit(repo, hexHash)
+		if len(applied) > 0 && applied[patchID(repo, commit)] {
+			fmt.Fprintf(os.Stderr, "warning: skipped previously applied commit %s\n", hexHash[:7])
+			continue
+		}
+		todo = append(todo, rebaseCommand{name: "pick", commit: hexHash, arg: commit.subject()})
+	}
+	if request.interactive && request.autosquash {
+		todo = autosquashTodo(todo)
+	}
+
+	err = os.MkdirAll(gitPath(repo, "rebase-merge"), 0755)
+	exitIfError(err, fmt.Sprintf("fatal: could not create temporary %s", gitPath(repo, "rebase-merge")))
+	writeGitFile(repo, "rebase-merge/head-name", headName+"\n")
+	writeGitFile(repo, "rebase-merge/onto", onto+"\n")
+	writeGitFile(repo, "rebase-merge/orig-head", head+"\n")
+	writeGitFile(repo, "rebase-merge/interactive", "")
+	writeGitFile(repo, "rebase-merge/no-reschedule-failed-exec", "")
+	if !request.interactive {
+		writeGitFile(repo, "rebase-merge/drop_redundant_commits", "")
+	}
+	summary := fmt.Sprintf("Rebase %s..%s onto %s (%d commands)", upstream[:7], head[:7], onto[:7], len(todo))
+	if len(todo) == 1 {
+		summary = strings.Replace(summary, "commands", "command", 1)
+	}
+	listing := formatRebaseTodo(todo, true) + rebaseTodoHelp(summary, false)
+	writeGitFile(repo, "rebase-merge/git-rebase-todo.backup", listing)
+	state := rebaseState{headName: headName, onto: onto, origHead: head, interactive: request.interactive, todo: todo}
+	if request.interactive {
+		writeGitFile(repo, "rebase-merge/git-rebase-todo", listing)
+		launchSequenceEditor(rebasePath(repo, "git-rebase-todo"), config)
+		content, _ := os.ReadFile(rebasePath(repo, "git-rebase-todo"))
+		state.todo, err = parseRebaseTodo(repo, string(content))
+		if err == nil && len(state.todo) == 0 {
+			fmt.Fprintf(os.Stderr, "error: nothing to do\n")
+			os.RemoveAll(gitPath(repo, "rebase-merge"))
+			os.Exit(1)
+		}
+		if err == nil {
+			err = checkRebaseTodo(state.todo)
+		}
+		if err != nil {
+			// Like git, check out onto all the same, for --continue to
+			// start from once the todo list is fixed
+			detachRebaseHead(repo, state, state.onto, ontoName)
+			rebaseTodoFailed(err)
+		}
+	}
+	beginRebase(repo, config, state, ontoName)
+}
+
+// linearHistory tells whether the first parent chain from to down to from
+// has no merges.
+func linearHistory(repo string, from string, to string) bool {
+	for to != from {
+		parents := readCommit(repo, to).parents
+		if len(parents) != 1 {
+			return len(parents) == 0
+		}
+		to = parents[0]
+	}
+	return true
+}
+
+// switchRebaseBranch checks out the branch named on the command line of a
+// rebase with nothing to do.
+func switchRebaseBranch(repo string, headName string, head string, name string) {
+	if current := headCommit(repo); current != head {
+		if !updateWorktree(repo, commitFiles(repo, current), commitFiles(repo, head), false, "checkout") {
+			fmt.Fprintf(os.Stderr, "error: could not switch to %s\n", name)
+			os.Exit(1)
+		}
+	}
+	if headName == "detached HEAD" {
+		writeRef(repo, "HEAD", head, "rebase: checkout "+name)
+	} else {
+		writeSymbolicRef(repo, "HEAD", headName, "rebase: checkout "+name)
+	}
+}
+
+// beginRebase detaches HEAD at onto and runs the todo list. Picks at its
+// start of commits already based on onto are kept as they are.
+func beginRebase(repo string, config *ini.File, state rebaseState, ontoName string) {
+	target := state.onto
+	for len(state.todo) > 0 && state.todo[0].name == "pick" {
+		parents := readCommit(repo, state.todo[0].commit).parents
+		if len(parents) != 1 || parents[0] != target {
+			break
+		}
+		target = state.todo[0].commit
+		state.done = append(state.done, state.todo[0])
+		state.todo = state.todo[1:]
+	}
+	detachRebaseHead(repo, state, target, ontoName)
+	writeRebaseTodo(repo, state)
+	runRebase(repo, config, state)
+}
+
+// detachRebaseHead checks out target, the commit the todo list starts
+// from, detaching HEAD.
+func detachRebaseHead(repo string, state rebaseState, target string, ontoName string) {
+	if !updateWorktree(repo, commitFiles(repo, headCommit(repo)), commitFiles(repo, target), false, "checkout") {
+		fmt.Fprintf(os.Stderr, "error: could not detach HEAD\n")
+		os.RemoveAll(gitPath(repo, "rebase-merge"))
+		os.Exit(1)
+	}
+	writeRef(repo, "ORIG_HEAD", state.origHead, "updating ORIG_HEAD")
+	writeRef(repo, "HEAD", target, "rebase (start): checkout "+ontoName)
+}
+
+// runRebase runs the commands left in the todo list, exiting when one of
+// them stops, and moves the branch over to the result.
+func runRebase(repo string, config *ini.File, state rebaseState) {
+	total := len(state.done) + len(state.todo)
+	for len(state.todo) > 0 {
+		command := state.todo[0]
+		state.done = append(state.done, command)
+		state.todo = state.todo[1:]
+		writeRebaseTodo(repo, state)
+		removeRebaseStop(repo)
+		fmt.Fprintf(os.Stderr, "Rebasing (%d/%d)\r", len(state.done), total)
+		switch command.name {
+		case "exec":
+			execRebaseCommand(repo, command)
+		case "break":
+			clearRebaseProgress()
+			head := headCommit(repo)
+			fmt.Fprintf(os.Stderr, "Stopped at %s (%s)\n", head[:7], readCommit(repo, head).subject())
+			os.Exit(0)
+		case "drop":
+		default:
+			pickRebaseCommit(repo, config, state, command)
+		}
+	}
+
+	head := headCommit(repo)
+	if state.headName != "detached HEAD" {
+		writeRef(repo, state.headName, head, fmt.Sprintf("rebase (finish): %s onto %s", state.headName, state.onto))
+		writeSymbolicRef(repo, "HEAD", state.headName, "rebase (finish): returning to "+state.headName)
+	}
+	os.RemoveAll(gitPath(repo, "rebase-merge"))
+	clearRebaseProgress()
+	fmt.Fprintf(os.Stderr, "Successfully rebased and updated %s.\n", state.headName)
+}
+
+// execRebaseCommand runs the shell command of an exec line. It stops the
+// rebase when the command fails or leaves changes behind.
+func execRebaseCommand(repo string, command rebaseCommand) {
+	clearRebaseProgress()
+	fmt.Fprintf(os.Stderr, "Executing: %s\n", command.arg)
+	shell := exec.Command("sh", "-c", command.arg)
+	shell.Stdin, shell.Stdout, shell.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := shell.Run()
+	unstaged, staged := localChanges(repo)
+	printLocalChanges(unstaged, staged)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: execution failed: %s\n", command.arg)
+		if unstaged || staged {
+			fmt.Fprintf(os.Stderr, "and made changes to the index and/or the working tree\n")
+		}
+		fmt.Fprintf(os.Stderr, "You can fix the problem, and then run\n\n  mygit rebase --continue\n\n\n")
+		os.Exit(1)
+	}
+	if unstaged || staged {
+		fmt.Fprintf(os.Stderr, "warning: execution succeeded: %s\n"+
+			"but left changes to the index and/or the working tree\n"+
+			"Commit or stash your changes, and then run\n\n  mygit rebase --continue\n\n\n", command.arg)
+		os.Exit(1)
+	}
+}
+
+// pickRebaseCommit applies the commit of a pick, reword, edit, squash or
+// fixup on top of HEAD and commits it, exiting when the command stops the
+// rebase.
+func pickRebaseCommit(repo string, config *ini.File, state rebaseState, command rebaseCommand) {
+	picked := readCommit(repo, command.commit)
+	head := headCommit(repo)
+	headTree := readCommit(repo, head).tree
+	parent, parentTree := "", emptyTreeHex
+	if len(picked.parents) > 0 {
+		parent = picked.parents[0]
+		parentTree = readCommit(repo, parent).tree
+	}
+
+	if parent == head && !command.meld() {
+		if !updateWorktree(repo, commitFiles(repo, head), commitFiles(repo, command.commit), false, "merge") {
+			rescheduleRebaseCommand(repo, state)
+		}
+		updateHead(repo, command.commit, "rebase: fast-forward")
+		finishRebaseCommand(repo, config, command, picked)
+		return
+	}
+
+	label := fmt.Sprintf("%s (%s)", command.commit[:7], picked.subject())
+	style, _ := mergeStyleFromConfig(configValue(repo, config, "merge", "conflictStyle"))
+	result := newTreeMerge(repo, 0, "parent of "+label, "HEAD", label, style).mergeTrees(parentTree, headTree, picked.tree)
+	ours := map[string]treeFile{}
+	flattenTree(repo, headTree, "", ours)
+	if !updateWorktree(repo, ours, result.files, false, "merge") {
+		rescheduleRebaseCommand(repo, state)
+	}
+	recordConflicts(repo, result)
+	writeGitFile(repo, "AUTO_MERGE", result.tree+"\n")
+	if !result.clean {
+		printMergeMessages(result.messages)
+	}
+
+	message := picked.message
+	final := true
+	if command.meld() {
+		message = meldRebaseMessage(repo, head, command, picked)
+		final = len(state.todo) == 0 || !state.todo[0].meld()
+	}
+	if !result.clean {
+		writeGitFile(repo, "MERGE_MSG", message)
+		if command.meld() {
+			writeRebaseStop(repo, command.commit, message)
+			writeGitFile(repo, "rebase-merge/amend", head+"\n")
+			if _, err := os.Stat(rebasePath(repo, "message-fixup")); final && err != nil {
+				writeGitFile(repo, "SQUASH_MSG", message)
+			}
+		} else {
+			appendConflictsHint(repo)
+			writeRebaseStop(repo, command.commit, message+"\n")
+		}
+		subject := fmt.Sprintf("%s... %s", command.commit[:7], picked.subject())
+		fmt.Fprintf(os.Stderr, "error: could not apply %s\n", subject)
+		fmt.Fprintf(os.Stderr, "hint: Resolve all conflicts manually, mark them as resolved with\n"+
+			"hint: \"mygit add <conflicted_files>\", then run \"mygit rebase --continue\".\n"+
+			"hint: You can instead skip this commit: run \"mygit rebase --skip\".\n"+
+			"hint: To abort and get back to the state before \"mygit rebase\", run \"mygit rebase --abort\".\n")
+		fmt.Fprintf(os.Stderr, "Could not apply %s\n", subject)
+		os.Exit(1)
+	}
+	if result.tree == headTree && !command.meld() {
+		if !state.interactive {
+			fmt.Fprintf(os.Stderr, "dropping %s %s -- patch contents already upstream\n", command.commit, picked.subject())
+			return
+		}
+		writeGitFile(repo, "MERGE_MSG", message)
+		writeGitFile(repo, "CHERRY_PICK_HEAD", command.commit+"\n")
+		writeRebaseStop(repo, command.commit, message+"\n")
+		printEmptyRebasePick(repo, state)
+		fmt.Fprintf(os.Stderr, "Could not apply %s... %s\n", command.commit[:7], picked.subject())
+		os.Exit(1)
+	}
+
+	newCommit := commitObject{
+		tree:      result.tree,
+		parents:   []string{head},
+		author:    picked.author,
+		committer: committerIdentity(config).String(),
+		message:   message,
+	}
+	edited := false
+	if command.meld() {
+		previous := readCommit(repo, head)
+		newCommit.parents, newCommit.author = previous.parents, previous.author
+		if final {
+			newCommit.message, edited = finishSquashMessage(repo, config, message)
+		}
+	}
+	commitHex := createCommitObject(newCommit.encode())
+	updateHead(repo, commitHex, fmt.Sprintf("rebase (%s): %s", command.name, newCommit.subject()))
+	if edited {
+		// Like git, which leaves the commit it edits the message of
+		// to a commit process
+		os.Remove(gitPath(repo, "AUTO_MERGE"))
+		writeGitFile(repo, "REBASE_HEAD", command.commit+"\n")
+		printCommitSummary(repo, config, commitHex, true, false)
+	}
+	if command.meld() {
+		return
+	}
+	finishRebaseCommand(repo, config, command, picked)
+}
+
+// printEmptyRebasePick explains that a pick stopped because it changes
+// nothing on top of HEAD, and where the rebase is at.
+func printEmptyRebasePick(repo string, state rebaseState) {
+	plural := func(count int, one string, many string) string {
+		if count == 1 {
+			return one
+		}
+		return many
+	}
+	fmt.Fprintf(os.Stderr, "The previous cherry-pick is now empty, possibly due to conflict resolution.\n"+
+		"If you wish to commit it anyway, use:\n"+
+		"\n"+
+		"    mygit commit --allow-empty\n"+
+		"\n"+
+		"Otherwise, please use 'mygit rebase --skip'\n")
+	fmt.Fprintf(os.Stderr, "interactive rebase in progress; onto %s\n", state.onto[:7])
+	done := len(state.done)
+	fmt.Fprintf(os.Stderr, "%s (%d %s done):\n", plural(done, "Last command done", "Last commands done"), done, plural(done, "command", "commands"))
+	for _, command := range state.done[max(done-2, 0):] {
+		fmt.Fprintf(os.Stderr, "   %s\n", command.format(true))
+	}
+	if done > 2 {
+		fmt.Fprintf(os.Stderr, "  (see more in file %s)\n", rebasePath(repo, "done"))
+	}
+	if left := len(state.todo); left == 0 {
+		fmt.Fprintf(os.Stderr, "No commands remaining.\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "%s (%d %s):\n", plural(left, "Next command to do", "Next commands to do"), left, plural(left, "remaining command", "remaining commands"))
+		for _, command := range state.todo[:min(left, 2)] {
+			fmt.Fprintf(os.Stderr, "   %s\n", command.format(true))
+		}
+		fmt.Fprintf(os.Stderr, "  (use \"mygit rebase --edit-todo\" to view and edit)\n")
+	}
+	if state.headName == "detached HEAD" {
+		fmt.Fprintf(os.Stderr, "You are currently rebasing.\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "You are currently rebasing branch '%s' on '%s'.\n", shortRefName(state.headName), state.onto[:7])
+	}
+	fmt.Fprintf(os.Stderr, "  (all conflicts fixed: run \"mygit rebase --continue\")\n"+
+		"\n"+
+		"nothing to commit, working tree clean\n")
+}
+
+// finishRebaseCommand lets the user reword the commit a reword just made,
+// or stops at the commit of an edit.
+func finishRebaseCommand(repo string, config *ini.File, command rebaseCommand, picked commitObject) {
+	head := headCommit(repo)
+	switch command.name {
+	case "reword":
+		current := readCommit(repo, head)
+		message := editCommitMessage(repo, config, current.message, "strip")
+		message, _ = cleanupCommitMessage(message, "strip")
+		if message == "" {
+			fmt.Fprintf(os.Stderr, "Aborting commit due to empty commit message.\n")
+			writeRebaseStop(repo, command.commit, current.message+"\n")
+			writeGitFile(repo, "rebase-merge/amend", head+"\n")
+			os.Exit(1)
+		}
+		current.message = message
+		current.committer = committerIdentity(config).String()
+		commitHex := createCommitObject(current.encode())
+		if commitHex != head {
+			updateHead(repo, commitHex, "rebase (reword): "+current.subject())
+		}
+		os.Remove(gitPath(repo, "AUTO_MERGE"))
+		printCommitSummary(repo, config, commitHex, true, false)
+	case "edit":
+		writeRebaseStop(repo, command.commit, picked.message+"\n")
+		writeGitFile(repo, "rebase-merge/amend", head+"\n")
+		clearRebaseProgress()
+		fmt.Fprintf(os.Stderr, "Stopped at %s...  %s\n", command.commit[:7], picked.subject())
+		fmt.Fprintf(os.Stderr, "You can amend the commit now, with\n\n  mygit commit --amend \n\n"+
+			"Once you are satisfied with your changes, run\n\n  mygit rebase --continue\n")
+		os.Exit(0)
+	}
+}
+
+// rescheduleRebaseCommand puts back the command that could not start
+// because of files in the way, and stops.
+func rescheduleRebaseCommand(repo string, state rebaseState) {
+	command := state.done[len(state.done)-1]
+	state.todo = append([]rebaseCommand{command}, state.todo...)
+	state.done = state.done[:len(state.done)-1]
+	writeRebaseTodo(repo, state)
+	fmt.Fprintf(os.Stderr, "hint: Could not execute the todo command\n"+
+		"hint:\n"+
+		"hint:     %s\n"+
+		"hint:\n"+
+		"hint: It has been rescheduled; To edit the command before continuing, please\n"+
+		"hint: edit the todo list first:\n"+
+		"hint:\n"+
+		"hint:     mygit rebase --edit-todo\n"+
+		"hint:     mygit rebase --continue\n", command.format(true))
+	os.Exit(1)
+}
+
+// meldRebaseMessage records a squash or fixup of picked into head and
+// returns the message combining the commits melded so far.
+func meldRebaseMessage(repo string, head string, command rebaseCommand, picked commitObject) string {
+	fixups := []string{}
+	combined := readRebaseFile(repo, "current-fixups")
+	if combined != "" {
+		fixups = strings.Split(combined, "\n")
+		content, _ := os.ReadFile(rebasePath(repo, "message-squash"))
+		combined = string(content)
+	} else {
+		message := readCommit(repo, head).message
+		combined = "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n" + message
+		if command.name == "fixup" {
+			writeGitFile(repo, "rebase-merge/message-fixup", message)
+		}
+	}
+	if command.name == "squash" {
+		os.Remove(rebasePath(repo, "message-fixup"))
+	}
+	combined = squashMessage(combined, len(fixups)+2, picked.message, command.name == "fixup")
+	fixups = append(fixups, command.name+" "+command.commit)
+	writeGitFile(repo, "rebase-merge/current-fixups", strings.Join(fixups, "\n"))
+	writeGitFile(repo, "rebase-merge/message-squash", combined)
+	return combined
+}
+
+// finishSquashMessage is the message of the commit ending a chain of
+// squashes and fixups. A chain of fixups keeps the message of the commit
+// they meld into, otherwise the user gets to edit the combined messages.
+func finishSquashMessage(repo string, config *ini.File, combined string) (string, bool) {
+	message, err := os.ReadFile(rebasePath(repo, "message-fixup"))
+	edit := err != nil
+	if edit {
+		edited, _ := cleanupCommitMessage(editCommitMessage(repo, config, combined, "strip"), "strip")
+		message = []byte(edited)
+	}
+	for _, name := range []string{"current-fixups", "message-squash", "message-fixup"} {
+		os.Remove(rebasePath(repo, name))
+	}
+	return string(message), edit
+}
+
+// writeRebaseStop records the commit whose pick the rebase stopped at.
+func writeRebaseStop(repo string, commitHex string, message string) {
+	commit := readCommit(repo, commitHex)
+	author := parseIdentity(commit.author)
+	writeGitFile(repo, "rebase-merge/author-script", fmt.Sprintf("GIT_AUTHOR_NAME='%s'\nGIT_AUTHOR_EMAIL='%s'\nGIT_AUTHOR_DATE='@%s'\n",
+		strings.ReplaceAll(author.name, "'", `'\''`), strings.ReplaceAll(author.email, "'", `'\''`), author.date))
+	writeGitFile(repo, "rebase-merge/message", message)
+	writeGitFile(repo, "rebase-merge/patch", commitPatch(repo, commit))
+	writeGitFile(repo, "rebase-merge/stopped-sha", commitHex+"\n")
+	writeGitFile(repo, "REBASE_HEAD", commitHex+"\n")
+}
+
+// removeRebaseStop forgets the commit the rebase stopped at.
+func removeRebaseStop(repo string) {
+	for _, name := range []string{"author-script", "message", "patch", "stopped-sha", "amend"} {
+		os.Remove(rebasePath(repo, name))
+	}
+	for _, name := range []string{"REBASE_HEAD", "CHERRY_PICK_HEAD", "MERGE_MSG", "SQUASH_MSG", "AUTO_MERGE"} {
+		os.Remove(gitPath(repo, name))
+	}
+}
+
+// continueRebase commits the changes staged after a stop and runs the rest
+// of the todo list.
+func continueRebase(repo string, config *ini.File) {
+	state, err := readRebase(repo)
+	if err == nil && len(state.done) == 0 {
+		err = checkRebaseTodo(state.todo)
+	}
+	if err != nil {
+		rebaseTodoFailed(err)
+	}
+	if _, err := os.Stat(rebasePath(repo, "done")); err != nil {
+		// The todo list of rebase -i did not parse before anything ran
+		runRebase(repo, config, state)
+		return
+	}
+	entries := readIndex(repo)
+	if _, ok := stageZeroEntries(entries); !ok {
+		previous := ""
+		for _, entry := range entries {
+			if entry.stage != 0 && entry.name != previous {
+				fmt.Printf("%s: needs merge\n", entry.name)
+				previous = entry.name
+			}
+		}
+	}
+	unstaged, staged := localChanges(repo)
+	if unstaged {
+		fmt.Printf("You must edit all merge conflicts and then\nmark them as resolved using git add\n")
+		os.Exit(1)
+	}
+	if staged {
+		commitRebaseStop(repo, config, state)
+	}
+	removeRebaseStop(repo)
+	runRebase(repo, config, state)
+}
+
+// commitRebaseStop commits the changes staged to resolve the stop of the
+// last command run, amending HEAD when it melds into it.
+func commitRebaseStop(repo string, config *ini.File, state rebaseState) {
+	head := headCommit(repo)
+	amend := readRebaseFile(repo, "amend")
+	if amend != "" && amend != head {
+		fmt.Fprintf(os.Stderr, "error: \n"+
+			"You have uncommitted changes in your working tree. Please, commit them\n"+
+			"first and then run 'mygit rebase --continue' again.\n")
+		os.Exit(1)
+	}
+	command := state.done[len(state.done)-1]
+	commit := commitObject{
+		tree:      hex.EncodeToString(writeIndexTree(readIndex(repo), "")),
+		parents:   []string{head},
+		author:    readCommit(repo, command.commit).author,
+		committer: committerIdentity(config).String(),
+	}
+	message := pendingMergeMessage(repo)
+	if message == "" || command.meld() {
+		content, _ := os.ReadFile(rebasePath(repo, "message"))
+		message = string(content)
+	}
+	if amend != "" {
+		// An edit stop, or a squash or fixup that stopped on conflicts
+		previous := readCommit(repo, head)
+		commit.parents, commit.author = previous.parents, previous.author
+	}
+	if command.meld() {
+		if len(state.todo) > 0 && state.todo[0].meld() {
+			if fixup, err := os.ReadFile(rebasePath(repo, "message-fixup")); err == nil {
+				message = string(fixup)
+			}
+		} else {
+			message, _ = finishSquashMessage(repo, config, message)
+		}
+	} else {
+		message = editCommitMessage(repo, config, message, "strip")
+		message, _ = cleanupCommitMessage(message, "strip")
+	}
+	if message == "" {
+		fmt.Fprintf(os.Stderr, "Aborting commit due to empty commit message.\n")
+		os.Exit(1)
+	}
+	commit.message = message
+	commitHex := createCommitObject(commit.encode())
+	updateHead(repo, commitHex, "rebase (continue): "+commit.subject())
+	printCommitSummary(repo, config, commitHex, amend != "", true)
+}
+
+// skipRebase drops the changes of the command that stopped and runs the
+// rest of the todo list.
+func skipRebase(repo string, config *ini.File) {
+	state, err := readRebase(repo)
+	if err != nil {
+		rebaseTodoFailed(err)
+	}
+	head := headCommit(repo)
+	resetIndexTo(repo, head)
+	removeRebaseStop(repo)
+	if command := state.done[len(state.done)-1]; command.meld() {
+		// Take the skipped commit out of the chain melded into HEAD,
+		// finishing the chain when it was the last of it
+		fixups := strings.Split(readRebaseFile(repo, "current-fixups"), "\n")
+		fixups = fixups[:len(fixups)-1]
+		writeGitFile(repo, "rebase-merge/current-fixups", strings.Join(fixups, "\n"))
+		if len(fixups) > 0 && (len(state.todo) == 0 || !state.todo[0].meld()) {
+			combined, _ := os.ReadFile(rebasePath(repo, "message-squash"))
+			skipped := max(strings.LastIndex(string(combined), "\n# This is the commit message #"),
+				strings.LastIndex(string(combined), "\n# The commit message #"))
+			current := readCommit(repo, head)
+			current.message, _ = finishSquashMessage(repo, config, string(combined[:max(skipped, 0)+1]))
+			commitHex := createCommitObject(current.encode())
+			updateHead(repo, commitHex, "rebase (skip): "+current.subject())
+		}
+	}
+	runRebase(repo, config, state)
+}
+
+// abortRebase goes back to the branch as it was before the rebase.
+func abortRebase(repo string) {
+	state, _ := readRebase(repo)
+	resetIndexTo(repo, state.origHead)
+	removeRebaseStop(repo)
+	if state.headName == "detached HEAD" {
+		writeRef(repo, "HEAD", state.origHead, "rebase (abort): returning to "+state.origHead)
+	} else {
+		writeSymbolicRef(repo, "HEAD", state.headName, "rebase (abort): returning to "+state.headName)
+	}
+	os.RemoveAll(gitPath(repo, "rebase-merge"))
+}
+
+// editRebaseTodo lets the user edit the commands left to run.
+func editRebaseTodo(repo string, config *ini.File) {
+	readRebase(repo)
+	content, _ := os.ReadFile(rebasePath(repo, "git-rebase-todo"))
+	todo, err := parseRebaseTodo(repo, string(content))
+	if err == nil {
+		content = []byte(formatRebaseTodo(todo, true))
+	}
+	writeGitFile(repo, "rebase-merge/git-rebase-todo", string(content)+rebaseTodoHelp("", true))
+	launchSequenceEditor(rebasePath(repo, "git-rebase-todo"), config)
+	content, _ = os.ReadFile(rebasePath(repo, "git-rebase-todo"))
+	if todo, err = parseRebaseTodo(repo, string(content)); err != nil {
+		rebaseTodoFailed(err)
+	}
+	writeGitFile(repo, "rebase-merge/git-rebase-todo", formatRebaseTodo(todo, false))
+}
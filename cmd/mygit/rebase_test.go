@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAutosquashTodo(t *testing.T) {
+	todo := []rebaseCommand{
+		{name: "pick", commit: "1111111111111111111111111111111111111111", arg: "add a"},
+		{name: "pick", commit: "2222222222222222222222222222222222222222", arg: "add b"},
+		{name: "pick", commit: "3333333333333333333333333333333333333333", arg: "fixup! add a"},
+		{name: "pick", commit: "4444444444444444444444444444444444444444", arg: "squash! 2222222"},
+		{name: "pick", commit: "5555555555555555555555555555555555555555", arg: "squash! fixup! add a"},
+		{name: "pick", commit: "6666666666666666666666666666666666666666", arg: "fixup! nothing"},
+	}
+	want := "pick 1111111 add a\n" +
+		"fixup 3333333 fixup! add a\n" +
+		"squash 5555555 squash! fixup! add a\n" +
+		"pick 2222222 add b\n" +
+		"squash 4444444 squash! 2222222\n" +
+		"pick 6666666 fixup! nothing\n"
+	if got := formatRebaseTodo(autosquashTodo(todo), true); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSquashMessage(t *testing.T) {
+	combined := "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\nadd a\n"
+	cases := []struct {
+		name    string
+		message string
+		skip    bool
+		want    string
+	}{
+		{
+			name:    "squash",
+			message: "more a\n",
+			want:    "\n# This is the commit message #3:\n\nmore a\n",
+		},
+		{
+			name:    "fixup",
+			message: "more a\n\nbody\n",
+			skip:    true,
+			want:    "\n# The commit message #3 will be skipped:\n\n# more a\n#\n# body\n",
+		},
+		{
+			// Only the subject naming the target is commented out
+			name:    "squash! subject",
+			message: "squash! add a\n\nbody\n",
+			want:    "\n# This is the commit message #3:\n\n# squash! add a\n\nbody\n",
+		},
+	}
+	prefix := "# This is a combination of 3 commits.\n# This is the 1st commit message:\n\nadd a\n"
+	for _, c := range cases {
+		if got := squashMessage(combined, 3, c.message, c.skip); got != prefix+c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, prefix+c.want)
+		}
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	start := func(t *testing.T) (repo string, side string) {
+		repo = newPickRepo(t)
+		mygit(t, repo, "switch", "side")
+		side = headCommit(repo)
+		s1, _ := resolveRevision(repo, "side~2")
+		result := runMygit(t, repo, "", "rebase", "main")
+		want := "error: could not apply " + s1[:7] + "... s1\n" +
+			"hint: Resolve all conflicts manually, mark them as resolved with\n" +
+			"hint: \"mygit add <conflicted_files>\", then run \"mygit rebase --continue\".\n"
+		if result.code != 1 || !strings.Contains(result.stderr, want) {
+			t.Fatalf("rebase = %d %q, want a conflict in s1", result.code, result.stderr)
+		}
+		return repo, side
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		repo, _ := start(t)
+		writeTestFile(t, repo, "f", "resolved\n")
+		mygit(t, repo, "add", "f")
+		mygit(t, repo, "rebase", "--continue")
+		if subjects := logSubjects(t, repo); subjects != "s3 s2 s1 main base" {
+			t.Errorf("log = %s", subjects)
+		}
+		if branch, _ := headBranch(repo); branch != "refs/heads/side" {
+			t.Errorf("HEAD = %s after the rebase, want side", branch)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		repo, _ := start(t)
+		mygit(t, repo, "rebase", "--skip")
+		if subjects := logSubjects(t, repo); subjects != "s3 s2 main base" {
+			t.Errorf("log = %s", subjects)
+		}
+	})
+	t.Run("abort", func(t *testing.T) {
+		repo, side := start(t)
+		mygit(t, repo, "rebase", "--abort")
+		if branch, _ := headBranch(repo); branch != "refs/heads/side" {
+			t.Errorf("HEAD = %s after abort, want side", branch)
+		}
+		if head := headCommit(repo); head != side {
+			t.Errorf("side = %s after abort, want %s", head, side)
+		}
+		if f := readTestFile(t, repo, "f"); f != "side\n" {
+			t.Errorf("f = %q after abort", f)
+		}
+	})
+}
+
+func TestRebaseOnto(t *testing.T) {
+	repo := newPickRepo(t)
+	mygit(t, repo, "rebase", "--onto", "main", "side~2", "side")
+	if branch, _ := headBranch(repo); branch != "refs/heads/side" {
+		t.Errorf("HEAD = %s, want side", branch)
+	}
+	if subjects := logSubjects(t, repo); subjects != "s3 s2 main base" {
+		t.Errorf("log = %s", subjects)
+	}
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	repo := newPickRepo(t)
+	mygit(t, repo, "switch", "side")
+	commits := map[string]string{}
+	for name, rev := range map[string]string{"s1": "side~2", "s2": "side~1", "s3": "side"} {
+		commits[name], _ = resolveRevision(repo, rev)
+	}
+	scripts := t.TempDir()
+	todo := "reword " + commits["s1"] + " s1\n" +
+		"exec echo ran >exec-out\n" +
+		"edit " + commits["s2"] + " s2\n" +
+		"pick " + commits["s3"] + " s3\n"
+	writeTestFile(t, scripts, "todo", todo)
+	env := []string{
+		"GIT_SEQUENCE_EDITOR=cp " + filepath.Join(scripts, "todo"),
+		"GIT_EDITOR=sed -i 1s/s1/reworded/",
+	}
+	result := runMygitWithEnv(t, repo, env, "", "rebase", "-i", "side~3")
+	if result.code != 0 {
+		t.Fatalf("rebase -i = %d\n%s", result.code, result.stderr)
+	}
+	// edit stops after picking s2
+	if subjects := logSubjects(t, repo); subjects != "s2 reworded base" {
+		t.Errorf("log at the edit stop = %s", subjects)
+	}
+	if out := readTestFile(t, repo, "exec-out"); out != "ran\n" {
+		t.Errorf("exec wrote %q", out)
+	}
+	if err := os.Remove(filepath.Join(repo, "exec-out")); err != nil {
+		t.Fatal(err)
+	}
+	mygit(t, repo, "commit", "--amend", "-m", "s2 amended")
+	mygit(t, repo, "rebase", "--continue")
+	if subjects := logSubjects(t, repo); subjects != "s3 s2 amended reworded base" {
+		t.Errorf("log = %s", subjects)
+	}
+	if branch, _ := headBranch(repo); branch != "refs/heads/side" {
+		t.Errorf("HEAD = %s, want side", branch)
+	}
+}
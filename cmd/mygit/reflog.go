@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+*  ###################### REFLOG ##############################
+*
+*  .git/logs/<ref> has one line per update of the ref, oldest first:
+*    <old sha> <new sha> Name <email> <unix> <tz>\t<message>
+*  Updates of the branch HEAD points to are logged for HEAD as well.
+ */
+
+type reflogEntry struct {
+	old     string
+	new     string
+	who     identity
+	message string
+}
+
+func (entry reflogEntry) String() string {
+	line := entry.old + " " + entry.new + " " + entry.who.String()
+	if entry.message != "" {
+		line += "\t" + entry.message
+	}
+	return line + "\n"
+}
+
+// value is what the ref pointed to after the entry, which for deletions is
+// taken to be what it pointed to before.
+func (entry reflogEntry) value() string {
+	if entry.new == zeroHex {
+		return entry.old
+	}
+	return entry.new
+}
+
+func reflogPath(repo string, name string) string {
+	return filepath.Join(repo, ".git", "logs", filepath.FromSlash(name))
+}
+
+// readReflog returns the entries of the reflog of name, oldest first.
+func readReflog(repo string, name string) []reflogEntry {
+	data, err := os.ReadFile(reflogPath(repo, name))
+	if err != nil {
+		return []reflogEntry{}
+	}
+	entries := []reflogEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 82 || !isHexHash(line[:40]) || !isHexHash(line[41:81]) {
+			continue
+		}
+		who, message, _ := strings.Cut(line[82:], "\t")
+		entries = append(entries, reflogEntry{old: line[:40], new: line[41:81], who: parseIdentity(who), message: message})
+	}
+	return entries
+}
+
+func writeReflog(repo string, name string, entries []reflogEntry) error {
+	lock, err := lockFile(reflogPath(repo, name))
+	if err != nil {
+		return err
+	}
+	var buff strings.Builder
+	for _, entry := range entries {
+		buff.WriteString(entry.String())
+	}
+	return lock.commit(buff.String())
+}
+
+func appendReflog(repo string, name string, entry reflogEntry) {
+	logPath := reflogPath(repo, name)
+	err := os.MkdirAll(filepath.Dir(logPath), 0755)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.WriteString(entry.String())
+			file.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: unable to append to '%s': %s\n", logPath, err)
+	}
+}
+
+// shouldLogRef follows core.logAllRefUpdates: refs that already have a
+// reflog are always logged, HEAD, branches, remote-tracking branches and
+// notes by default, and every ref when it is "always".
+func shouldLogRef(repo string, name string) bool {
+	if _, err := os.Stat(reflogPath(repo, name)); err == nil {
+		return true
+	}
+	switch strings.ToLower(configValue(repo, loadGlobalConfig(), "core", "logAllRefUpdates")) {
+	case "false", "no", "off", "0":
+		return false
+	case "always":
+		return true
+	}
+	return name == "HEAD" || strings.HasPrefix(name, "refs/heads/") ||
+		strings.HasPrefix(name, "refs/remotes/") || strings.HasPrefix(name, "refs/notes/")
+}
+
+// reflogIdentity is the committer recorded in reflog entries. Unlike
+// commits, ref updates do not fail without a configured identity.
+func reflogIdentity() identity {
+	id, _ := lookupIdentity(loadGlobalConfig(), "COMMITTER", "committer")
+	if id.name == "" || id.email == "" {
+		username := "unknown"
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+		hostname, _ := os.Hostname()
+		if id.name == "" {
+			id.name = username
+		}
+		if id.email == "" {
+			id.email = username + "@" + hostname
+		}
+	}
+	return id
+}
+
+// logRefUpdate records that name moved from old to new, for HEAD too when
+// name is the branch HEAD points to. Nothing is logged for refs that do not
+// point to anything afterwards, like HEAD on an unborn branch.
+func logRefUpdate(repo string, name string, old string, new string, message string) {
+	if new == "" {
+		return
+	}
+	if old == "" {
+		old = zeroHex
+	}
+	entry := reflogEntry{old: old, new: new, who: reflogIdentity(), message: strings.Join(strings.Fields(message), " ")}
+	if shouldLogRef(repo, name) {
+		appendReflog(repo, name, entry)
+	}
+	if branch, ok := headBranch(repo); ok && branch == name && shouldLogRef(repo, "HEAD") {
+		appendReflog(repo, "HEAD", entry)
+	}
+}
+
+// deleteReflog removes the reflog of name along with the directories that
+// it leaves empty.
+func deleteReflog(repo string, name string) {
+	logPath := reflogPath(repo, name)
+	if os.Remove(logPath) != nil {
+		return
+	}
+	for _, dir := range refParentDirectories(name) {
+		if os.Remove(reflogPath(repo, dir)) != nil {
+			break
+		}
+	}
+}
+
+// listReflogs returns the names of the refs that have a reflog.
+func listReflogs(repo string) []string {
+	names := []string{}
+	logsRoot := filepath.Join(repo, ".git", "logs")
+	filepath.WalkDir(logsRoot, func(fullPath string, file os.DirEntry, err error) error {
+		if err != nil || file.IsDir() || strings.HasSuffix(file.Name(), ".lock") {
+			return nil
+		}
+		if name, err := filepath.Rel(logsRoot, fullPath); err == nil {
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+// reflogRefName finds the ref whose reflog ref@{...} refers to. An empty ref
+// means the current branch, or HEAD when it is detached.
+func reflogRefName(repo string, ref string) (string, error) {
+	if ref == "" {
+		if branch, ok := headBranch(repo); ok {
+			return branch, nil
+		}
+		return "HEAD", nil
+	}
+	if refName, ok := expandRefName(repo, ref); ok {
+		return refName, nil
+	}
+	// Deleted refs can still have a reflog
+	for _, format := range []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s"} {
+		refName := fmt.Sprintf(format, ref)
+		if _, err := os.Stat(reflogPath(repo, refName)); err == nil {
+			return refName, nil
+		}
+	}
+	return "", fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", ref)
+}
+
+// resolveReflogRevision implements ref@{n}, the n-th prior value of ref,
+// and ref@{date}, the value ref had at that date.
+func resolveReflogRevision(repo string, ref string, selector string) (string, error) {
+	refName, err := reflogRefName(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	if ref == "" {
+		ref = shortRefName(refName)
+	}
+	entries := readReflog(repo, refName)
+	// Like git, numbers too large to count entries are timestamps
+	if n, err := strconv.Atoi(selector); err == nil && n < 100000000 {
+		switch {
+		case n < 0:
+			return "", fmt.Errorf("invalid reflog selector '@{%s}'", selector)
+		case n == 0 && len(entries) == 0:
+			if hexHash, ok := resolveRef(repo, refName); ok {
+				return hexHash, nil
+			}
+		case n < len(entries):
+			return entries[len(entries)-1-n].value(), nil
+		case n == len(entries) && entries[0].old != zeroHex:
+			return entries[0].old, nil
+		}
+		return "", fmt.Errorf("log for '%s' only has %d entries", ref, len(entries))
+	}
+	at, err := parseApproxidate(selector, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("invalid reflog selector '@{%s}'", selector)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("log for '%s' is empty", ref)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !dateTime(entries[i].who.date).After(at) {
+			return entries[i].value(), nil
+		}
+	}
+	oldest := entries[0]
+	fmt.Fprintf(os.Stderr, "warning: log for '%s' only goes back to %s\n", ref, dateInZone(oldest.who.date).Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	if oldest.old != zeroHex {
+		return oldest.old, nil
+	}
+	return oldest.new, nil
+}
+
+// showReflog prints the reflog of ref newest first, like `git reflog show`.
+func showReflog(repo string, ref string) {
+	refName, err := reflogRefName(repo, ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	entries := readReflog(repo, refName)
+	display := shortRefName(refName)
+	for i := len(entries) - 1; i >= 0; i-- {
+		// Deletions keep their number but are not shown
+		if entries[i].new == zeroHex {
+			continue
+		}
+		fmt.Printf("%s %s@{%d}: %s\n", entries[i].new[:7], display, len(entries)-1-i, entries[i].message)
+	}
+}
+
+// reflogPruneOptions control how entries removed from a reflog are
+// written back.
+type reflogPruneOptions struct {
+	rewrite   bool
+	updateRef bool
+	dryRun    bool
+}
+
+// pruneReflog drops the entries of the reflog of name that keep rejects.
+// With rewrite the old value of each entry is made to match the new value
+// of the one before it, and with updateRef the ref is set to the newest
+// remaining entry.
+func pruneReflog(repo string, name string, keep func(index int, entry reflogEntry) bool, options reflogPruneOptions) error {
+	entries := readReflog(repo, name)
+	kept := []reflogEntry{}
+	for i, entry := range entries {
+		if keep(i, entry) {
+			kept = append(kept, entry)
+		}
+	}
+	if options.dryRun || len(kept) == len(entries) {
+		return nil
+	}
+	if options.rewrite {
+		for i := 1; i < len(kept); i++ {
+			kept[i].old = kept[i-1].new
+		}
+	}
+	if err := writeReflog(repo, name, kept); err != nil {
+		return err
+	}
+	if options.updateRef && len(kept) > 0 {
+		if _, symbolic := readSymbolicRef(repo, name); !symbolic {
+			_, err := writeRefLocked(repo, name, refValue{hexHash: kept[len(kept)-1].new}, "")
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExpireTime parses the --expire values of reflog expire, where
+// "never" keeps every entry and "all" drops all of them.
+func parseExpireTime(value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "never", "false":
+		return time.Time{}, nil
+	case "all":
+		return now.Add(time.Hour), nil
+	}
+	return parseApproxidate(value, now)
+}
+
+// expireReflog drops the entries of the reflog of name older than expire,
+// and the ones older than expireUnreachable whose old or new commit is no
+// longer reachable from the ref.
+func expireReflog(repo string, name string, expire time.Time, expireUnreachable time.Time, options reflogPruneOptions) error {
+	var reachable map[string]bool
+	if tip, ok := resolveRef(repo, name); ok && objectExists(repo, tip) {
+		if objectType, _ := readObject(repo, tip); objectType == Commit {
+			reachable = reachableCommits(repo, tip)
+		}
+	}
+	return pruneReflog(repo, name, func(_ int, entry reflogEntry) bool {
+		when := dateTime(entry.who.date)
+		if !when.After(expire) {
+			return false
+		}
+		if reachable == nil || when.After(expireUnreachable) {
+			return true
+		}
+		for _, hexHash := range []string{entry.old, entry.new} {
+			if hexHash != zeroHex && !reachable[hexHash] {
+				return false
+			}
+		}
+		return true
+	}, options)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReflogFollowsHead(t *testing.T) {
+	repo := newRefsRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	t.Setenv("GIT_COMMITTER_DATE", "1112911993 +0200")
+	writeSymbolicRef(repo, "HEAD", "refs/heads/main", "")
+	writeRef(repo, "refs/heads/main", testHexA, "commit (initial): one")
+	writeRef(repo, "refs/heads/main", testHexB, "commit:   two\n")
+
+	for _, name := range []string{"HEAD", "refs/heads/main"} {
+		entries := readReflog(repo, name)
+		if len(entries) != 2 {
+			t.Fatalf("%s has %d reflog entries, want 2", name, len(entries))
+		}
+		if entries[0].old != zeroHex || entries[1].old != testHexA || entries[1].new != testHexB {
+			t.Errorf("%s reflog = %+v", name, entries)
+		}
+		if entries[1].message != "commit: two" || entries[1].who.email != "committer@example.com" {
+			t.Errorf("%s reflog entry = %+v", name, entries[1])
+		}
+	}
+	if hexHash, err := resolveRevision(repo, "main@{1}"); err != nil || hexHash != testHexA {
+		t.Errorf("main@{1} = %s, %v", hexHash, err)
+	}
+	if _, err := resolveRevision(repo, "@{3}"); err == nil {
+		t.Errorf("@{3} resolved past the end of the log")
+	}
+
+	if err := deleteRef(repo, "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	if entries := readReflog(repo, "refs/heads/main"); len(entries) != 0 {
+		t.Errorf("reflog of a deleted ref kept %d entries", len(entries))
+	}
+	if entries := readReflog(repo, "HEAD"); len(entries) != 3 || entries[2].new != zeroHex {
+		t.Errorf("deleting the current branch was not logged for HEAD: %+v", entries)
+	}
+}
+
+func TestReflogExpireUnreachableChecksBothEnds(t *testing.T) {
+	repo := newTestRepo(t)
+	empty := strings.TrimSpace(mygit(t, repo, "write-tree"))
+	first := strings.TrimSpace(mygit(t, repo, "commit-tree", empty, "-m", "first"))
+	second := strings.TrimSpace(mygit(t, repo, "commit-tree", empty, "-m", "second"))
+	child := strings.TrimSpace(mygit(t, repo, "commit-tree", empty, "-p", second, "-m", "child"))
+	for _, hexHash := range []string{first, second, child} {
+		mygit(t, repo, "update-ref", "refs/heads/main", hexHash)
+	}
+	// Only the entry from second to child has both ends reachable
+	mygit(t, repo, "reflog", "expire", "--expire=never", "--expire-unreachable=now", "refs/heads/main")
+	entries := readReflog(repo, "refs/heads/main")
+	if len(entries) != 1 || entries[0].old != second || entries[0].new != child {
+		t.Errorf("reflog after expiry = %+v", entries)
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+*  ###################### REFS ################################
+*
+*  Loose refs are files below .git holding an object name, or "ref: <name>"
+*  for symbolic refs. .git/packed-refs holds one "<sha> <name>" line per
+*  ref, followed by "^<sha>" with the peeled object for annotated tags. A
+*  loose ref takes precedence over a packed one of the same name.
+*
+*  Every update takes <ref>.lock with O_EXCL, checks the old value under
+*  the lock and renames the lock into place.
+ */
+
+const maxSymrefDepth = 5
+
+const zeroHex = "0000000000000000000000000000000000000000"
+
+const packedRefsHeader = "# pack-refs with: peeled fully-peeled sorted \n"
+
+// refValue is the content of a ref, either an object name or the name of
+// the ref a symbolic ref points to.
+type refValue struct {
+	hexHash string
+	symref  string
+}
+
+type packedRef struct {
+	name    string
+	hexHash string
+	peeled  string
+}
+
+func refPath(repo string, name string) string {
+	return filepath.Join(repo, ".git", filepath.FromSlash(name))
+}
+
+func packedRefsPath(repo string) string {
+	return filepath.Join(repo, ".git", "packed-refs")
+}
+
+// readLooseRef parses the loose ref file of name.
+func readLooseRef(repo string, name string) (refValue, bool) {
+	data, err := os.ReadFile(refPath(repo, name))
+	if err != nil {
+		return refValue{}, false
+	}
+	content := strings.TrimSpace(string(data))
+	if target, ok := strings.CutPrefix(content, "ref:"); ok {
+		return refValue{symref: strings.TrimSpace(target)}, true
+	}
+	return refValue{hexHash: content}, isHexHash(content)
+}
+
+// readPackedRefs returns the refs of .git/packed-refs in file order.
+func readPackedRefs(repo string) []packedRef {
+	data, err := os.ReadFile(packedRefsPath(repo))
+	if err != nil {
+		return []packedRef{}
+	}
+	refs := []packedRef{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "^"):
+			if len(refs) > 0 {
+				refs[len(refs)-1].peeled = line[1:]
+			}
+		default:
+			hexHash, name, ok := strings.Cut(line, " ")
+			if ok && isHexHash(hexHash) {
+				refs = append(refs, packedRef{name: name, hexHash: hexHash})
+			}
+		}
+	}
+	return refs
+}
+
+func findPackedRef(repo string, name string) (packedRef, bool) {
+	for _, ref := range readPackedRefs(repo) {
+		if ref.name == name {
+			return ref, true
+		}
+	}
+	return packedRef{}, false
+}
+
+// writePackedRefs replaces .git/packed-refs with refs, which must already
+// be locked by the caller through packedLock.
+func writePackedRefs(packedLock *refLock, refs []packedRef) error {
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	var buff bytes.Buffer
+	buff.WriteString(packedRefsHeader)
+	for _, ref := range refs {
+		buff.WriteString(ref.hexHash + " " + ref.name + "\n")
+		if ref.peeled != "" {
+			buff.WriteString("^" + ref.peeled + "\n")
+		}
+	}
+	return packedLock.commit(buff.String())
+}
+
+// readRef returns the value of name, looking at the loose ref first and
+// packed-refs after that.
+func readRef(repo string, name string) (refValue, bool) {
+	if value, ok := readLooseRef(repo, name); ok {
+		return value, true
+	}
+	if ref, ok := findPackedRef(repo, name); ok {
+		return refValue{hexHash: ref.hexHash}, true
+	}
+	return refValue{}, false
+}
+
+// readSymbolicRef returns the ref name points to if it is a symbolic ref.
+func readSymbolicRef(repo string, name string) (string, bool) {
+	value, ok := readRef(repo, name)
+	if !ok || value.symref == "" {
+		return "", false
+	}
+	return value.symref, true
+}
+
+// followSymrefs returns the ref that name ends up at once symbolic refs
+// are followed. The ref found does not need to exist, like the branch of an
+// unborn HEAD.
+func followSymrefs(repo string, name string) (string, error) {
+	seen := map[string]bool{}
+	for {
+		if seen[name] {
+			return "", fmt.Errorf("symbolic ref cycle detected at '%s'", name)
+		}
+		if len(seen) > maxSymrefDepth {
+			return "", fmt.Errorf("symbolic ref '%s' is nested too deeply", name)
+		}
+		seen[name] = true
+		value, ok := readRef(repo, name)
+		if !ok || value.symref == "" {
+			return name, nil
+		}
+		name = value.symref
+	}
+}
+
+// resolveRef follows name through symbolic refs and returns the object it
+// points to. Refs that do not exist, including unborn branches and
+// dangling or cyclic symbolic refs, resolve to nothing.
+func resolveRef(repo string, name string) (string, bool) {
+	target, err := followSymrefs(repo, name)
+	if err != nil {
+		return "", false
+	}
+	value, ok := readRef(repo, target)
+	return value.hexHash, ok && value.symref == ""
+}
+
+// refExists reports whether name is an existing ref, symbolic or not.
+func refExists(repo string, name string) bool {
+	_, ok := readRef(repo, name)
+	return ok
+}
+
+// headBranch returns the branch HEAD points to, like refs/heads/main. A
+// detached HEAD is not on any branch.
+func headBranch(repo string) (string, bool) {
+	return readSymbolicRef(repo, "HEAD")
+}
+
+// headCommit returns the commit HEAD points to, which is empty on an unborn
+// branch.
+func headCommit(repo string) string {
+	sha, _ := resolveRef(repo, "HEAD")
+	return sha
+}
+
+// listRefs returns the names of the loose and packed refs below prefix,
+// like refs/heads/, sorted by name.
+func listRefs(repo string, prefix string) []string {
+	found := map[string]bool{}
+	root := refPath(repo, strings.TrimSuffix(prefix, "/"))
+	filepath.WalkDir(root, func(fullPath string, file os.DirEntry, err error) error {
+		if err != nil || file.IsDir() || strings.HasSuffix(file.Name(), ".lock") {
+			return nil
+		}
+		name, err := filepath.Rel(filepath.Join(repo, ".git"), fullPath)
+		if err == nil && strings.HasPrefix(filepath.ToSlash(name), prefix) {
+			found[filepath.ToSlash(name)] = true
+		}
+		return nil
+	})
+	for _, ref := range readPackedRefs(repo) {
+		if strings.HasPrefix(ref.name, prefix) {
+			found[ref.name] = true
+		}
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refLock is a held <name>.lock file. Committing it renames it over the
+// file it locks.
+type refLock struct {
+	path string
+	file *os.File
+}
+
+func lockFile(target string) (*refLock, error) {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := target + ".lock"
+	if absolute, err := filepath.Abs(lockPath); err == nil {
+		lockPath = absolute
+	}
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("Unable to create '%s': File exists.", lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create '%s': %s", lockPath, err)
+	}
+	return &refLock{path: strings.TrimSuffix(lockPath, ".lock"), file: file}, nil
+}
+
+func (lock *refLock) commit(content string) error {
+	_, err := lock.file.WriteString(content)
+	if closeErr := lock.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lock.path + ".lock")
+		return err
+	}
+	return os.Rename(lock.path+".lock", lock.path)
+}
+
+func (lock *refLock) rollback() {
+	lock.file.Close()
+	os.Remove(lock.path + ".lock")
+}
+
+// checkOldValue compares the current value of name with oldHex. An empty
+// oldHex skips the check and the zero id requires the ref not to exist.
+func checkOldValue(repo string, name string, oldHex string) error {
+	if oldHex == "" {
+		return nil
+	}
+	current, exists := readRef(repo, name)
+	switch {
+	case oldHex == zeroHex && exists:
+		return fmt.Errorf("reference already exists")
+	case oldHex != zeroHex && (!exists || current.symref != ""):
+		return fmt.Errorf("unable to resolve reference '%s'", name)
+	case oldHex != zeroHex && current.hexHash != oldHex:
+		return fmt.Errorf("is at %s but expected %s", current.hexHash, oldHex)
+	}
+	return nil
+}
+
+// checkRefNameConflict makes sure name can be created without clashing
+// with a ref that is one of its parent directories, or the other way.
+func checkRefNameConflict(repo string, name string) error {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "/")
+		if parent != "refs" && refExists(repo, parent) {
+			return fmt.Errorf("'%s' exists; cannot create '%s'", parent, name)
+		}
+	}
+	if nested := listRefs(repo, name+"/"); len(nested) > 0 {
+		return fmt.Errorf("'%s' exists; cannot create '%s'", nested[0], name)
+	}
+	return nil
+}
+
+// updateRef atomically sets the ref name, not following symbolic refs, to
+// value once its current value has been checked against oldHex, and logs
+// the update with message.
+func updateRef(repo string, name string, value refValue, oldHex string, message string) error {
+	previous, err := writeRefLocked(repo, name, value, oldHex)
+	if err != nil {
+		return err
+	}
+	current := value.hexHash
+	if value.symref != "" {
+		current, _ = resolveRef(repo, value.symref)
+	}
+	logRefUpdate(repo, name, previous, current, message)
+	return nil
+}
+
+// writeRefLocked is updateRef without the reflog. It returns the object
+// name was resolving to before the update.
+func writeRefLocked(repo string, name string, value refValue, oldHex string) (string, error) {
+	if name != "HEAD" && !isValidRefName(name) {
+		return "", fmt.Errorf("refusing to update ref with bad name '%s'", name)
+	}
+	if !refExists(repo, name) {
+		if err := checkRefNameConflict(repo, name); err != nil {
+			return "", fmt.Errorf("cannot lock ref '%s': %s", name, err)
+		}
+	}
+	lock, err := lockFile(refPath(repo, name))
+	if err != nil {
+		return "", fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+	if err := checkOldValue(repo, name, oldHex); err != nil {
+		lock.rollback()
+		return "", fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+	previous, _ := resolveRef(repo, name)
+	content := value.hexHash + "\n"
+	if value.symref != "" {
+		content = "ref: " + value.symref + "\n"
+	}
+	if err := lock.commit(content); err != nil {
+		return "", fmt.Errorf("cannot update ref '%s': %s", name, err)
+	}
+	return previous, nil
+}
+
+// renameRef moves the ref oldName, and its reflog, to newName.
+func renameRef(repo string, oldName string, newName string, message string) error {
+	target, ok := resolveRef(repo, oldName)
+	if !ok {
+		return fmt.Errorf("refname %s not found", oldName)
+	}
+	entries := readReflog(repo, oldName)
+	if err := deleteRefChecked(repo, oldName, "", message); err != nil {
+		return err
+	}
+	if _, err := writeRefLocked(repo, newName, refValue{hexHash: target}, zeroHex); err != nil {
+		return err
+	}
+	if len(entries) > 0 || shouldLogRef(repo, newName) {
+		entry := reflogEntry{old: target, new: target, who: reflogIdentity(), message: message}
+		if err := writeReflog(repo, newName, append(entries, entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRefChecked removes name, loose and packed, once its current value
+// has been checked against oldHex. Directories left empty below refs/ are
+// removed as well. Like git, the deletion of the branch HEAD points to is
+// recorded in the reflog of HEAD.
+func deleteRefChecked(repo string, name string, oldHex string, message string) error {
+	lock, err := lockFile(refPath(repo, name))
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+	defer pruneRefDirectories(repo, name)
+	if err := checkOldValue(repo, name, oldHex); err != nil {
+		lock.rollback()
+		return fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+	previous, _ := resolveRef(repo, name)
+	if _, packed := findPackedRef(repo, name); packed {
+		packedLock, err := lockFile(packedRefsPath(repo))
+		if err != nil {
+			lock.rollback()
+			return fmt.Errorf("cannot lock ref '%s': %s", name, err)
+		}
+		remaining := []packedRef{}
+		for _, ref := range readPackedRefs(repo) {
+			if ref.name != name {
+				remaining = append(remaining, ref)
+			}
+		}
+		if err := writePackedRefs(packedLock, remaining); err != nil {
+			lock.rollback()
+			return fmt.Errorf("cannot delete ref '%s': %s", name, err)
+		}
+	}
+	err = os.Remove(refPath(repo, name))
+	lock.rollback()
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot delete ref '%s': %s", name, err)
+	}
+	deleteReflog(repo, name)
+	if branch, ok := headBranch(repo); ok && branch == name && previous != "" && shouldLogRef(repo, "HEAD") {
+		appendReflog(repo, "HEAD", reflogEntry{old: previous, new: zeroHex, who: reflogIdentity(), message: message})
+	}
+	return nil
+}
+
+// deleteRef removes name whatever its value.
+func deleteRef(repo string, name string) error {
+	return deleteRefChecked(repo, name, "", "")
+}
+
+// pruneRefDirectories removes the directories left empty by deleting name.
+// Like git, refs/<category> itself is kept even when empty.
+func pruneRefDirectories(repo string, name string) {
+	for _, dir := range refParentDirectories(name) {
+		if os.Remove(refPath(repo, dir)) != nil {
+			break
+		}
+	}
+}
+
+// refParentDirectories lists the directories of name below refs/<category>,
+// innermost first.
+func refParentDirectories(name string) []string {
+	dirs := []string{}
+	for dir := path.Dir(name); strings.Count(dir, "/") >= 2; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// packRefs moves the loose refs selected by include into packed-refs,
+// recording the peeled object of annotated tags, and deletes the loose
+// files unless prune is unset. Symbolic refs are never packed.
+func packRefs(repo string, include func(name string) bool, prune bool) error {
+	packedLock, err := lockFile(packedRefsPath(repo))
+	if err != nil {
+		return err
+	}
+	refs := map[string]packedRef{}
+	for _, ref := range readPackedRefs(repo) {
+		refs[ref.name] = ref
+	}
+	packed := []string{}
+	for _, name := range listRefs(repo, "refs/") {
+		value, loose := readLooseRef(repo, name)
+		if !loose || value.symref != "" || !include(name) {
+			continue
+		}
+		ref := packedRef{name: name, hexHash: value.hexHash}
+		if objectExists(repo, value.hexHash) {
+			if objectType, _ := readObject(repo, value.hexHash); objectType == Tag {
+				ref.peeled, _ = peelRevision(repo, value.hexHash, "")
+			}
+		}
+		refs[name] = ref
+		packed = append(packed, name)
+	}
+	all := make([]packedRef, 0, len(refs))
+	for _, ref := range refs {
+		all = append(all, ref)
+	}
+	if err := writePackedRefs(packedLock, all); err != nil {
+		return err
+	}
+	if !prune {
+		return nil
+	}
+	for _, name := range packed {
+		lock, err := lockFile(refPath(repo, name))
+		if err != nil {
+			continue
+		}
+		// Only drop the loose file if nobody changed it in the meantime
+		if value, _ := readLooseRef(repo, name); value.hexHash == refs[name].hexHash {
+			os.Remove(refPath(repo, name))
+		}
+		lock.rollback()
+		pruneRefDirectories(repo, name)
+	}
+	return nil
+}
+
+// writeRef points name at hexHash without following symbolic refs. The
+// message is recorded in the reflog.
+func writeRef(repo string, name string, hexHash string, message string) {
+	err := updateRef(repo, name, refValue{hexHash: hexHash}, "", message)
+	exitIfError(err, fmt.Sprintf("fatal: %s", err))
+}
+
+func writeSymbolicRef(repo string, name string, target string, message string) {
+	err := updateRef(repo, name, refValue{symref: target}, "", message)
+	exitIfError(err, fmt.Sprintf("fatal: %s", err))
+}
+
+// shortRefName strips the well known prefixes from a full ref name.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if strings.HasPrefix(name, prefix) {
+			return name[len(prefix):]
+		}
+	}
+	return name
+}
+
+// isValidRefName applies the rules of git check-ref-format to name.
+func isValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.Contains(name, "//") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") {
+			return false
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
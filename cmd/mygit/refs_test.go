@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testHexA = "1111111111111111111111111111111111111111"
+	testHexB = "2222222222222222222222222222222222222222"
+)
+
+func newRefsRepo(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repo, ".git", "refs", "heads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestLooseRefsShadowPackedRefs(t *testing.T) {
+	repo := newRefsRepo(t)
+	packed := packedRefsHeader +
+		testHexA + " refs/heads/main\n" +
+		testHexA + " refs/tags/v1\n" +
+		"^" + testHexB + "\n"
+	if err := os.WriteFile(packedRefsPath(repo), []byte(packed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ref, _ := findPackedRef(repo, "refs/tags/v1"); ref.peeled != testHexB {
+		t.Errorf("peeled line not read, got %+v", ref)
+	}
+	if err := updateRef(repo, "refs/heads/main", refValue{hexHash: testHexB}, testHexA, ""); err != nil {
+		t.Fatal(err)
+	}
+	if hexHash, _ := resolveRef(repo, "refs/heads/main"); hexHash != testHexB {
+		t.Errorf("loose ref should win over packed one, got %s", hexHash)
+	}
+	if err := deleteRef(repo, "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	if refExists(repo, "refs/heads/main") {
+		t.Errorf("deleted ref is still found in packed-refs")
+	}
+	if names := listRefs(repo, "refs/"); len(names) != 1 || names[0] != "refs/tags/v1" {
+		t.Errorf("listRefs = %v", names)
+	}
+}
+
+func TestUpdateRefChecksOldValue(t *testing.T) {
+	repo := newRefsRepo(t)
+	if err := updateRef(repo, "refs/heads/main", refValue{hexHash: testHexA}, zeroHex, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRef(repo, "refs/heads/main", refValue{hexHash: testHexB}, zeroHex, ""); err == nil {
+		t.Errorf("creating an existing ref succeeded")
+	}
+	err := updateRef(repo, "refs/heads/main", refValue{hexHash: testHexB}, testHexB, "")
+	if err == nil || !strings.Contains(err.Error(), "but expected") {
+		t.Errorf("stale old value not rejected: %v", err)
+	}
+	if err := updateRef(repo, "refs/heads/main/topic", refValue{hexHash: testHexA}, "", ""); err == nil {
+		t.Errorf("ref nested below an existing ref was created")
+	}
+	if _, err := os.Stat(refPath(repo, "refs/heads/main.lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind")
+	}
+}
+
+func TestSymbolicRefCycle(t *testing.T) {
+	repo := newRefsRepo(t)
+	writeSymbolicRef(repo, "refs/heads/a", "refs/heads/b", "")
+	writeSymbolicRef(repo, "refs/heads/b", "refs/heads/a", "")
+	if _, err := followSymrefs(repo, "refs/heads/a"); err == nil {
+		t.Errorf("cycle not detected")
+	}
+	if _, ok := resolveRef(repo, "refs/heads/a"); ok {
+		t.Errorf("cyclic ref resolved")
+	}
+}
+
+func TestPruneKeepsCategoryDirectories(t *testing.T) {
+	repo := newRefsRepo(t)
+	for _, name := range []string{"refs/heads/main", "refs/heads/topic/one", "refs/tags/v1"} {
+		if err := updateRef(repo, name, refValue{hexHash: testHexA}, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deleteRef(repo, "refs/heads/topic/one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(refPath(repo, "refs/heads/topic")); !os.IsNotExist(err) {
+		t.Errorf("empty refs/heads/topic was kept")
+	}
+	if err := packRefs(repo, func(string) bool { return true }, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"refs/heads", "refs/tags"} {
+		if info, err := os.Stat(refPath(repo, dir)); err != nil || !info.IsDir() {
+			t.Errorf("%s was pruned after packing every ref", dir)
+		}
+	}
+	if !refExists(repo, "refs/heads/main") || !refExists(repo, "refs/tags/v1") {
+		t.Error("packed refs are missing")
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+/*
+*  ###################### RENAMES ##############################
+*
+*  Added files are paired with deleted ones, or when looking for copies with
+*  any file of the old side, the way git's diffcore-rename does it:
+*
+*  1. Files with identical contents are paired first.
+*  2. When only renames are wanted, files whose basename is unique on both
+*     sides are compared with each other and paired if they are similar
+*     enough.
+*  3. Every remaining destination is compared with every remaining source
+*     and the most similar pairs above the minimum score win.
+*
+*  Similarity is the share of the larger file made of content chunks (lines,
+*  or 64 byte runs) found in both files, scored out of maxSimilarityScore.
+ */
+
+const (
+	maxSimilarityScore     = 60000
+	defaultSimilarityScore = 30000
+	// renameCandidates is how many best sources are remembered for each
+	// destination.
+	renameCandidates = 4
+	// spanHashBase is the prime content chunks are hashed modulo.
+	spanHashBase = 107927
+)
+
+type renameDetection int
+
+const (
+	noRenames renameDetection = iota
+	findRenames
+	findCopies
+)
+
+type renameOptions struct {
+	detect       renameDetection
+	minimumScore int
+	// copiesHarder also considers unmodified files as copy sources. The
+	// pairs then have to include unmodified files, with a zero status.
+	copiesHarder bool
+	// relevantSources, when set, limits the sources compared by content
+	// to these paths. Exact renames are found for all of them.
+	relevantSources map[string]bool
+}
+
+// parseRenameScore reads the argument of -M and -C: a fraction written
+// as digits after an implied decimal point ("5" and "50" are both half),
+// with an optional decimal point, or a percentage.
+func parseRenameScore(value string) int {
+	num, scale := 0, 1
+	dot := false
+	for _, c := range value {
+		switch {
+		case c == '.' && !dot:
+			scale = 1
+			dot = true
+		case c == '%':
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+		case c >= '0' && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			return -1
+		}
+		if c == '%' {
+			break
+		}
+	}
+	if num >= scale {
+		return maxSimilarityScore
+	}
+	return maxSimilarityScore * num / scale
+}
+
+// renameFlags turns -M[<n>] and -C[<n>], empty when not given, and
+// --find-copies-harder into rename options, starting from detect.
+func renameFlags(detect renameDetection, renames string, copies string, copiesHarder bool) (renameOptions, error) {
+	options := renameOptions{detect: detect, minimumScore: defaultSimilarityScore, copiesHarder: copiesHarder}
+	for _, flag := range []struct {
+		name, value string
+		detect      renameDetection
+	}{{"find-renames", renames, findRenames}, {"find-copies", copies, findCopies}} {
+		if flag.value == "" {
+			continue
+		}
+		options.detect = max(options.detect, flag.detect)
+		if flag.value == "default" {
+			continue
+		}
+		if options.minimumScore = parseRenameScore(flag.value); options.minimumScore < 0 {
+			return options, fmt.Errorf("invalid argument to %s", flag.name)
+		}
+	}
+	if copiesHarder {
+		options.detect = findCopies
+	}
+	return options, nil
+}
+
+// configRenames is the rename detection diff.renames asks for, renames
+// when it is not set.
+func configRenames(repo string, global *ini.File) renameDetection {
+	switch strings.ToLower(configValue(repo, global, "diff", "renames")) {
+	case "copies", "copy":
+		return findCopies
+	case "false", "no", "off", "0":
+		return noRenames
+	}
+	return findRenames
+}
+
+// renameMatch records the source a destination was paired with.
+type renameMatch struct {
+	source int
+	score  int
+}
+
+type renameCandidate struct {
+	dst, src  int
+	score     int
+	nameScore int
+}
+
+// renameDetector holds the pairs being matched. Sources and destinations
+// are indexes into pairs; a pair is never both.
+type renameDetector struct {
+	repo     string
+	options  renameOptions
+	pairs    []filePair
+	sources  []int
+	dests    []int
+	used     map[int]int
+	matches  map[int]renameMatch
+	contents map[int][]byte
+	spans    map[int]map[uint32]int
+}
+
+// detectRenames replaces the additions of pairs that were renamed or
+// copied by rename and copy pairs, drops the deletions that were renamed,
+// and drops unmodified pairs.
+func detectRenames(repo string, pairs []filePair, options renameOptions) []filePair {
+	detector := &renameDetector{
+		repo:     repo,
+		options:  options,
+		pairs:    pairs,
+		used:     map[int]int{},
+		matches:  map[int]renameMatch{},
+		contents: map[int][]byte{},
+		spans:    map[int]map[uint32]int{},
+	}
+	if options.detect != noRenames {
+		detector.match()
+	}
+	return detector.result()
+}
+
+func (detector *renameDetector) match() {
+	for i, pair := range detector.pairs {
+		switch {
+		case pair.status == 'U' || isTreePerm(pair.old.perm) || isTreePerm(pair.new.perm):
+		case !pair.old.exists():
+			detector.dests = append(detector.dests, i)
+		case !pair.new.exists():
+			detector.sources = append(detector.sources, i)
+		case detector.options.detect == findCopies:
+			// The file stays, so it counts as used once already.
+			detector.used[i]++
+			detector.sources = append(detector.sources, i)
+		}
+	}
+	if len(detector.dests) == 0 || len(detector.sources) == 0 {
+		return
+	}
+	detector.matchExact()
+	minimumScore := detector.options.minimumScore
+	if minimumScore == maxSimilarityScore {
+		return
+	}
+	copies := detector.options.detect == findCopies
+	if !copies {
+		detector.dropUsedSources()
+		detector.matchBasenames(minimumScore + (maxSimilarityScore-minimumScore)/2)
+		detector.dropUsedSources()
+	}
+
+	candidates := []renameCandidate{}
+	for d, dst := range detector.dests {
+		if _, ok := detector.matches[dst]; ok {
+			continue
+		}
+		best := make([]renameCandidate, renameCandidates)
+		for i := range best {
+			best[i].dst = -1
+		}
+		for s, src := range detector.sources {
+			candidate := renameCandidate{
+				dst:       d,
+				src:       s,
+				score:     detector.similarity(src, dst, minimumScore),
+				nameScore: basenameSame(detector.pairs[src].name, detector.pairs[dst].name),
+			}
+			worst := 0
+			for i := 1; i < renameCandidates; i++ {
+				if compareRenameCandidates(best[i], best[worst]) > 0 {
+					worst = i
+				}
+			}
+			if compareRenameCandidates(best[worst], candidate) > 0 {
+				best[worst] = candidate
+			}
+		}
+		candidates = append(candidates, best...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return compareRenameCandidates(candidates[i], candidates[j]) < 0
+	})
+	detector.matchCandidates(candidates, false)
+	if copies {
+		detector.matchCandidates(candidates, true)
+	}
+}
+
+// compareRenameCandidates orders candidates from the most to the least
+// similar, preferring the same basename, with unused slots last.
+func compareRenameCandidates(a renameCandidate, b renameCandidate) int {
+	switch {
+	case a.dst < 0 && b.dst < 0:
+		return 0
+	case a.dst < 0:
+		return 1
+	case b.dst < 0:
+		return -1
+	case a.score == b.score:
+		return b.nameScore - a.nameScore
+	}
+	return b.score - a.score
+}
+
+func (detector *renameDetector) record(dst int, src int, score int) {
+	detector.used[src]++
+	detector.matches[dst] = renameMatch{source: src, score: score}
+}
+
+// matchExact pairs destinations with a source of identical content,
+// preferring unused sources and ones with the same basename.
+func (detector *renameDetector) matchExact() {
+	for _, dst := range detector.dests {
+		target := detector.pairs[dst]
+		best, bestScore := -1, -1
+		remaining := 100
+		for _, src := range detector.sources {
+			source := detector.pairs[src]
+			if source.old.sha != target.new.sha {
+				continue
+			}
+			if (!isRegularPerm(source.old.perm) || !isRegularPerm(target.new.perm)) && source.old.perm != target.new.perm {
+				continue
+			}
+			if detector.used[src] > 0 && detector.options.detect != findCopies {
+				continue
+			}
+			score := basenameSame(source.name, target.name)
+			if detector.used[src] == 0 {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = src, score
+				if score == 2 {
+					break
+				}
+			}
+			if remaining--; remaining == 0 {
+				break
+			}
+		}
+		if best >= 0 {
+			detector.record(dst, best, maxSimilarityScore)
+		}
+	}
+}
+
+func (detector *renameDetector) dropUsedSources() {
+	relevant := detector.options.relevantSources
+	detector.sources = slices.DeleteFunc(detector.sources, func(src int) bool {
+		return detector.used[src] > 0 || relevant != nil && !relevant[detector.pairs[src].name]
+	})
+}
+
+// matchBasenames compares sources and destinations whose basename is
+// unique among the remaining ones on both sides.
+func (detector *renameDetector) matchBasenames(minimumScore int) {
+	uniqueBasenames := func(indexes []int) map[string]int {
+		unique := map[string]int{}
+		for _, i := range indexes {
+			if _, ok := detector.matches[i]; ok {
+				continue
+			}
+			base := basename(detector.pairs[i].name)
+			if _, ok := unique[base]; ok {
+				unique[base] = -1
+			} else {
+				unique[base] = i
+			}
+		}
+		return unique
+	}
+	sources, dests := uniqueBasenames(detector.sources), uniqueBasenames(detector.dests)
+	for _, src := range detector.sources {
+		base := basename(detector.pairs[src].name)
+		dst, ok := dests[base]
+		if !ok || sources[base] < 0 || dst < 0 {
+			continue
+		}
+		if _, ok := detector.matches[dst]; ok {
+			continue
+		}
+		if score := detector.similarity(src, dst, minimumScore); score >= minimumScore {
+			detector.record(dst, src, score)
+		}
+	}
+}
+
+func (detector *renameDetector) matchCandidates(candidates []renameCandidate, copies bool) {
+	for _, candidate := range candidates {
+		if candidate.dst < 0 || candidate.score < detector.options.minimumScore {
+			break
+		}
+		dst, src := detector.dests[candidate.dst], detector.sources[candidate.src]
+		if _, ok := detector.matches[dst]; ok {
+			continue
+		}
+		if !copies && detector.used[src] > 0 {
+			continue
+		}
+		detector.record(dst, src, candidate.score)
+	}
+}
+
+// content returns the old side of a source or the new side of a
+// destination.
+func (detector *renameDetector) content(i int) []byte {
+	if content, ok := detector.contents[i]; ok {
+		return content
+	}
+	pair := detector.pairs[i]
+	side := pair.new
+	if pair.old.exists() {
+		side = pair.old
+	}
+	content := side.content(detector.repo, pair.name)
+	detector.contents[i] = content
+	return content
+}
+
+// similarity scores how much of dst is made of src. Files whose sizes
+// differ too much to reach minimumScore are not compared at all.
+func (detector *renameDetector) similarity(src int, dst int, minimumScore int) int {
+	if !isRegularPerm(detector.pairs[src].old.perm) || !isRegularPerm(detector.pairs[dst].new.perm) {
+		return 0
+	}
+	srcSize, dstSize := len(detector.content(src)), len(detector.content(dst))
+	maxSize, baseSize := max(srcSize, dstSize), min(srcSize, dstSize)
+	if maxSize*(maxSimilarityScore-minimumScore) < (maxSize-baseSize)*maxSimilarityScore {
+		return 0
+	}
+	if dstSize == 0 {
+		return 0
+	}
+	copied, _ := spanChanges(detector.spanCounts(src), detector.spanCounts(dst))
+	return copied * maxSimilarityScore / maxSize
+}
+
+func (detector *renameDetector) spanCounts(i int) map[uint32]int {
+	if spans, ok := detector.spans[i]; ok {
+		return spans
+	}
+	spans := countSpans(detector.content(i))
+	detector.spans[i] = spans
+	return spans
+}
+
+// countSpans cuts content into chunks ending at a newline or 64 bytes
+// long, whichever comes first, and counts the bytes of the chunks by hash.
+// A CR before a LF is skipped in text.
+func countSpans(content []byte) map[uint32]int {
+	text := !isBinaryContent(content)
+	spans := map[uint32]int{}
+	var accum1, accum2 uint32
+	n := 0
+	for i, b := range content {
+		c := uint32(b)
+		if text && b == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			continue
+		}
+		old := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old >> 25)
+		accum1 += c
+		n++
+		if n < 64 && b != '\n' {
+			continue
+		}
+		spans[(accum1+accum2*0x61)%spanHashBase] += n
+		n = 0
+		accum1, accum2 = 0, 0
+	}
+	return spans
+}
+
+// spanChanges counts the bytes of the destination copied from the source
+// and those added to it, from the chunks counted by countSpans.
+func spanChanges(src map[uint32]int, dst map[uint32]int) (int, int) {
+	copied, added := 0, 0
+	for hash, count := range dst {
+		copied += min(count, src[hash])
+		added += max(count-src[hash], 0)
+	}
+	return copied, added
+}
+
+// result rebuilds the list of pairs, with renames and copies in place of
+// the additions they explain. A source used more than once is copied to
+// all but its last destination, and a source that stays is only copied.
+func (detector *renameDetector) result() []filePair {
+	result := []filePair{}
+	remaining := maps.Clone(detector.used)
+	for i, pair := range detector.pairs {
+		match, matched := detector.matches[i]
+		switch {
+		case matched:
+			source := detector.pairs[match.source]
+			renamed := filePair{name: pair.name, oldName: source.name, old: source.old, new: pair.new, status: 'R', score: match.score}
+			if remaining[match.source]--; remaining[match.source] > 0 {
+				renamed.status = 'C'
+			}
+			result = append(result, renamed)
+		case pair.status == 'D' && detector.used[i] > 0:
+		case pair.status != 0:
+			result = append(result, pair)
+		}
+	}
+	return result
+}
+
+func isRegularPerm(perm ObjectPerm) bool {
+	return perm == FILE || perm == EXE
+}
+
+func basename(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
+
+// basenameSame returns 1 when both paths end in the same file name.
+func basenameSame(a string, b string) int {
+	if basename(a) == basename(b) {
+		return 1
+	}
+	return 0
+}
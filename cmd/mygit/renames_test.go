@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseRenameScore(t *testing.T) {
+	for value, want := range map[string]int{
+		"50%":  30000,
+		"5":    30000,
+		"50":   30000,
+		"0.9":  54000,
+		"90%":  54000,
+		"100":  6000,
+		"100%": 60000,
+		"1":    6000,
+		"x":    -1,
+	} {
+		if got := parseRenameScore(value); got != want {
+			t.Errorf("parseRenameScore(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestDetectRenames(t *testing.T) {
+	same := diffSide{perm: FILE, sha: [20]byte{1}}
+	other := diffSide{perm: FILE, sha: [20]byte{2}}
+	pairs := []filePair{
+		{name: "a/copy", new: same, status: 'A'},
+		{name: "b/copy", new: same, status: 'A'},
+		{name: "gone", old: same, status: 'D'},
+		{name: "kept", old: other, new: other},
+	}
+	got := []string{}
+	for _, pair := range detectRenames(".", pairs, renameOptions{detect: findCopies, minimumScore: maxSimilarityScore}) {
+		got = append(got, statusField(pair)+" "+pairPaths(pair))
+	}
+	// A source used twice is copied to the first destination and renamed to the last.
+	want := []string{"C100 gone\ta/copy", "R100 gone\tb/copy"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	numbers := func(from int, to int) string {
+		var lines strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&lines, "%d\n", i)
+		}
+		return lines.String()
+	}
+	detector := &renameDetector{
+		pairs: []filePair{
+			{name: "one", old: diffSide{perm: FILE}, status: 'D'},
+			{name: "copy", new: diffSide{perm: FILE}, status: 'A'},
+		},
+		contents: map[int][]byte{0: []byte(numbers(1, 50)), 1: []byte(numbers(1, 45) + "z\n")},
+		spans:    map[int]map[uint32]int{},
+	}
+	// git scores this copy at 89%.
+	if score := detector.similarity(0, 1, defaultSimilarityScore); similarityPercent(score) != 89 {
+		t.Errorf("similarity = %d (%d%%), want 89%%", score, similarityPercent(score))
+	}
+	if score := detector.similarity(0, 1, 57000); score != 0 {
+		t.Errorf("similarity with a 95%% minimum = %d, want 0 as sizes differ too much", score)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// resolveRevision turns a revision as accepted by git (object names, refs,
+// HEAD, the @{<n>} and @{<date>} reflog selectors and the ~<n>, ^<n> and
+// ^{<type>} suffixes) into the object it names.
+func resolveRevision(repo string, rev string) (string, error) {
+	if colon := pathColon(rev); colon >= 0 {
+		return resolveRevisionPath(repo, rev[:colon], rev[colon+1:])
+	}
+	baseEnd := strings.IndexAny(rev, "~^")
+	if baseEnd < 0 {
+		baseEnd = len(rev)
+	}
+	hexHash, err := resolveRevisionBase(repo, rev[:baseEnd])
+	if err != nil {
+		return "", err
+	}
+	suffix := rev[baseEnd:]
+	for suffix != "" {
+		operator := suffix[0]
+		suffix = suffix[1:]
+		if operator == '^' && strings.HasPrefix(suffix, "{") {
+			closing := strings.IndexByte(suffix, '}')
+			if closing < 0 {
+				return "", fmt.Errorf("invalid revision '%s'", rev)
+			}
+			hexHash, err = peelRevision(repo, hexHash, suffix[1:closing])
+			if err != nil {
+				return "", err
+			}
+			suffix = suffix[closing+1:]
+			continue
+		}
+		digits := 0
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		count := 1
+		if digits > 0 {
+			count, _ = strconv.Atoi(suffix[:digits])
+		}
+		suffix = suffix[digits:]
+		hexHash, err = peelToType(repo, hexHash, Commit)
+		if err != nil {
+			return "", err
+		}
+		if operator == '^' {
+			if count == 0 {
+				continue
+			}
+			parents := readCommit(repo, hexHash).parents
+			if count > len(parents) {
+				return "", fmt.Errorf("revision '%s' does not exist", rev)
+			}
+			hexHash = parents[count-1]
+			continue
+		}
+		for range count {
+			parents := readCommit(repo, hexHash).parents
+			if len(parents) == 0 {
+				return "", fmt.Errorf("revision '%s' does not exist", rev)
+			}
+			hexHash = parents[0]
+		}
+	}
+	return hexHash, nil
+}
+
+// pathColon finds the colon of <rev>:<path>, skipping those in the dates
+// of reflog entries.
+func pathColon(rev string) int {
+	for i := 0; i < len(rev); i++ {
+		switch {
+		case strings.HasPrefix(rev[i:], "@{"):
+			closing := strings.IndexByte(rev[i:], '}')
+			if closing < 0 {
+				return -1
+			}
+			i += closing
+		case rev[i] == ':':
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveRevisionPath finds the object at path in the tree of rev, or in
+// the index for an empty rev, where path may start with the stage to look
+// at, as in :2:file.
+func resolveRevisionPath(repo string, rev string, path string) (string, error) {
+	path = strings.TrimPrefix(path, "./")
+	if rev != "" {
+		hexHash, err := resolveRevision(repo, rev)
+		if err != nil {
+			return "", fmt.Errorf("invalid object name '%s'.", rev)
+		}
+		treeHex, err := peelToType(repo, hexHash, Tree)
+		if err != nil {
+			return "", err
+		}
+		if entryHex := treeEntryAt(repo, treeHex, path); entryHex != "" {
+			return entryHex, nil
+		}
+		return "", fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+	}
+	stage := uint8(0)
+	if len(path) >= 2 && path[0] >= '0' && path[0] <= '3' && path[1] == ':' {
+		stage, path = path[0]-'0', path[2:]
+	}
+	indexStage := -1
+	for _, entry := range readIndex(repo) {
+		if entry.name == path && entry.stage == stage {
+			return hex.EncodeToString(entry.sha[:]), nil
+		}
+		if entry.name == path && indexStage < 0 {
+			indexStage = int(entry.stage)
+		}
+	}
+	switch _, err := os.Lstat(worktreePath(repo, path)); {
+	case indexStage >= 0:
+		return "", fmt.Errorf("path '%s' is in the index, but not at stage %d\nhint: Did you mean ':%d:%s'?", path, stage, indexStage, path)
+	case err == nil:
+		return "", fmt.Errorf("path '%s' exists on disk, but not in the index", path)
+	}
+	return "", fmt.Errorf("path '%s' does not exist (neither on disk nor in the index)", path)
+}
+
+func resolveRevisionBase(repo string, name string) (string, error) {
+	if at := strings.Index(name, "@{"); at >= 0 && strings.HasSuffix(name, "}") {
+		return resolveReflogRevision(repo, name[:at], name[at+2:len(name)-1])
+	}
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+	if isHexHash(name) && objectExists(repo, name) {
+		return name, nil
+	}
+	if hexHash, ok := resolveRefName(repo, name); ok {
+		return hexHash, nil
+	}
+	if len(name) >= 4 && len(name) < 40 && isHexPrefix(name) {
+		matches := findObjectsByPrefix(repo, name)
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("short object ID %s is ambiguous", name)
+		}
+	}
+	return "", fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", name)
+}
+
+// resolveRefName looks name up in the same order as git: as given, then
+// below refs/, refs/tags/, refs/heads/ and refs/remotes/.
+func resolveRefName(repo string, name string) (string, bool) {
+	refName, ok := expandRefName(repo, name)
+	if !ok {
+		return "", false
+	}
+	return resolveRef(repo, refName)
+}
+
+// refNameRules are the ways a ref name can be abbreviated, in the order
+// they are tried.
+var refNameRules = []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"}
+
+// expandRefName returns the full name of the ref that name abbreviates.
+func expandRefName(repo string, name string) (string, bool) {
+	for _, format := range refNameRules {
+		refName := fmt.Sprintf(format, name)
+		if refName != "HEAD" && !strings.HasPrefix(refName, "refs/") {
+			continue
+		}
+		if _, ok := resolveRef(repo, refName); ok {
+			return refName, true
+		}
+	}
+	return "", false
+}
+
+// dwimRefName is expandRefName for commands refusing ambiguous names. It
+// returns the ref the first match leads to once symbolic refs are followed,
+// and how many refs name could stand for.
+func dwimRefName(repo string, name string) (string, int) {
+	found, count := "", 0
+	for _, format := range refNameRules {
+		refName := fmt.Sprintf(format, name)
+		if refName != "HEAD" && !strings.HasPrefix(refName, "refs/") {
+			continue
+		}
+		if _, ok := resolveRef(repo, refName); ok {
+			if count == 0 {
+				found, _ = followSymrefs(repo, refName)
+			}
+			count++
+		}
+	}
+	return found, count
+}
+
+// peelRevision implements the ^{<type>} suffix, where an empty type peels
+// tags until something else is found.
+func peelRevision(repo string, hexHash string, typeName string) (string, error) {
+	switch typeName {
+	case "":
+		for {
+			objectType, content := readObject(repo, hexHash)
+			if objectType != Tag {
+				return hexHash, nil
+			}
+			hexHash = tagTarget(content)
+		}
+	case "object":
+		return hexHash, nil
+	case "commit", "tree", "blob", "tag":
+		return peelToType(repo, hexHash, objectTypeFromName(typeName))
+	default:
+		return "", fmt.Errorf("invalid object type '%s'", typeName)
+	}
+}
+
+// peelToType follows tags, and commits to their tree, until an object of the
+// wanted type is found.
+func peelToType(repo string, hexHash string, want Object) (string, error) {
+	for {
+		objectType, content := readObject(repo, hexHash)
+		switch {
+		case objectType == want:
+			return hexHash, nil
+		case objectType == Tag:
+			hexHash = tagTarget(content)
+		case objectType == Commit && want == Tree:
+			hexHash = parseCommit(content).tree
+		default:
+			return "", fmt.Errorf("%s is not a %s", hexHash, objectTypeName(want))
+		}
+	}
+}
+
+// resolveCommit resolves rev and requires it to name a commit, exiting with
+// git's message otherwise.
+func resolveCommit(repo string, rev string) string {
+	hexHash, err := resolveRevision(repo, rev)
+	if err == nil {
+		hexHash, err = peelToType(repo, hexHash, Commit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	return hexHash
+}
+
+// resolveObjectOfType resolves rev, peeling tags, and requires the result
+// to be of the wanted type the way plumbing commands validate their
+// arguments.
+func resolveObjectOfType(repo string, rev string, want Object) string {
+	hexHash, err := resolveRevision(repo, rev)
+	if err == nil {
+		hexHash, err = peelRevision(repo, hexHash, "")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a valid object name %s\n", rev)
+		os.Exit(128)
+	}
+	if objectType, _ := readObject(repo, hexHash); objectType != want {
+		fmt.Fprintf(os.Stderr, "fatal: %s is not a valid '%s' object\n", rev, objectTypeName(want))
+		os.Exit(128)
+	}
+	return hexHash
+}
+
+func tagTarget(content []byte) string {
+	object, _, _ := strings.Cut(strings.TrimPrefix(string(content), "object "), "\n")
+	return object
+}
+
+func objectTypeName(objectType Object) string {
+	switch objectType {
+	case Commit:
+		return "commit"
+	case Tree:
+		return "tree"
+	case Blob:
+		return "blob"
+	case Tag:
+		return "tag"
+	default:
+		return "unknown"
+	}
+}
+
+func objectExists(repo string, hexHash string) bool {
+	_, err := os.Stat(filepath.Join(repo, ".git", "objects", hexHash[:2], hexHash[2:]))
+	return err == nil
+}
+
+func findObjectsByPrefix(repo string, prefix string) []string {
+	files, err := os.ReadDir(filepath.Join(repo, ".git", "objects", prefix[:2]))
+	if err != nil {
+		return nil
+	}
+	matches := []string{}
+	for _, file := range files {
+		if strings.HasPrefix(prefix[:2]+file.Name(), prefix) {
+			matches = append(matches, prefix[:2]+file.Name())
+		}
+	}
+	return matches
+}
+
+func isHexPrefix(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
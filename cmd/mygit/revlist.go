@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/hex"
+	"path"
+	"strings"
+)
+
+/*
+*  ###################### REV-LIST ##############################
+*
+*  rev-list prints the commits of a walk, and with --objects the trees and
+*  blobs they need, each with the path it was found at. Objects already
+*  found in the commits just outside the walk are left out, so that the
+*  list holds what a pack for the range would need.
+ */
+
+// revisionMark is what --left-right prints in front of a commit.
+func (walk *revWalk) revisionMark(hexHash string) string {
+	if walk.left[hexHash] {
+		return "<"
+	}
+	return ">"
+}
+
+// walkObjects returns the annotated tags the revisions named, by their tag
+// name, and then the trees and blobs of the shown commits, as lines of an
+// object name followed by its path.
+func walkObjects(repo string, walk *revWalk, tags []string) []string {
+	uninteresting := map[string]bool{}
+	for _, hexHash := range walk.commits {
+		for _, parent := range walk.commit(hexHash).parents {
+			if walk.excluded[parent] {
+				markTreeSeen(repo, walk.commit(parent).tree, uninteresting)
+			}
+		}
+	}
+	lines := []string{}
+	for _, tagHex := range tags {
+		if uninteresting[tagHex] {
+			continue
+		}
+		uninteresting[tagHex] = true
+		_, content := readObject(repo, tagHex)
+		header, _, _ := strings.Cut(string(content), "\n\n")
+		name := ""
+		for _, line := range strings.Split(header, "\n") {
+			if value, ok := strings.CutPrefix(line, "tag "); ok {
+				name = value
+			}
+		}
+		lines = append(lines, tagHex+" "+name)
+	}
+	var visit func(treeHex string, name string)
+	visit = func(treeHex string, name string) {
+		if uninteresting[treeHex] {
+			return
+		}
+		uninteresting[treeHex] = true
+		lines = append(lines, treeHex+" "+name)
+		if treeHex == emptyTreeHex {
+			return
+		}
+		for _, entry := range readTreeEntries(repo, treeHex) {
+			entryHex := hex.EncodeToString(entry.sha[:])
+			entryName := path.Join(name, entry.name)
+			switch {
+			case isTreePerm(entry.perm):
+				visit(entryHex, entryName)
+			case entry.perm == GITLINK || uninteresting[entryHex]:
+			default:
+				uninteresting[entryHex] = true
+				lines = append(lines, entryHex+" "+entryName)
+			}
+		}
+	}
+	for _, hexHash := range walk.commits {
+		visit(walk.commit(hexHash).tree, "")
+	}
+	return lines
+}
+
+// markTreeSeen adds the tree treeHex and everything below it to seen.
+func markTreeSeen(repo string, treeHex string, seen map[string]bool) {
+	if seen[treeHex] {
+		return
+	}
+	seen[treeHex] = true
+	if treeHex == emptyTreeHex {
+		return
+	}
+	for _, entry := range readTreeEntries(repo, treeHex) {
+		entryHex := hex.EncodeToString(entry.sha[:])
+		switch {
+		case isTreePerm(entry.perm):
+			markTreeSeen(repo, entryHex, seen)
+		case entry.perm != GITLINK:
+			seen[entryHex] = true
+		}
+	}
+}
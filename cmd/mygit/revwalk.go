@@ -0,0 +1,728 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+*  ###################### REVISION WALK ##############################
+*
+*  Commits are walked newest first from the included revisions, never
+*  entering history reachable from the excluded ones. The walk can then be
+*  reordered so that no commit comes before its children, filtered and cut.
+*  With paths, a merge that matches one of its parents on those paths is
+*  only followed through that parent, like git's history simplification.
+ */
+
+// revisionSet is what the revision arguments of log and rev-list select.
+type revisionSet struct {
+	include []string
+	exclude []string
+	// symmetric holds the sides of A...B ranges, whose common history is
+	// excluded.
+	symmetric [][2]string
+	// tags holds the annotated tags that included revisions name, which
+	// rev-list --objects lists as well.
+	tags []string
+}
+
+// revWalkOptions control which of the walked commits are shown and in
+// which order.
+type revWalkOptions struct {
+	// order is "" for the default date ordered walk, or "date",
+	// "author-date" or "topo" to never show parents before children.
+	order       string
+	firstParent bool
+	paths       []string
+	since       time.Time
+	until       time.Time
+	authors     []*regexp.Regexp
+	committers  []*regexp.Regexp
+	greps       []*regexp.Regexp
+	allMatch    bool
+	invertGrep  bool
+	minParents  int
+	maxParents  int
+	skip        int
+	maxCount    int
+	reverse     bool
+	// ancestryPath limits the commits to descendants of the excluded
+	// revisions
+	ancestryPath bool
+}
+
+// revWalk is the result of a walk: the commits to show, in order, and the
+// parents of each walked commit as seen by the walk.
+type revWalk struct {
+	repo     string
+	commits  []string
+	parents  map[string][]string
+	shown    map[string]bool
+	excluded map[string]bool
+	// left holds the commits reached from the left side of A...B ranges
+	left  map[string]bool
+	cache map[string]commitObject
+}
+
+func (walk *revWalk) commit(hexHash string) commitObject {
+	commit, ok := walk.cache[hexHash]
+	if !ok {
+		commit = readCommit(walk.repo, hexHash)
+		walk.cache[hexHash] = commit
+	}
+	return commit
+}
+
+// parseRevisionArguments splits the arguments of log and rev-list into
+// revisions and paths. Arguments after "--" are paths, and so are ones
+// that are not revisions but exist in the working tree.
+func parseRevisionArguments(repo string, args []string) (revisionSet, []string, error) {
+	revs := revisionSet{}
+	paths := []string{}
+	negate := false
+	for i, arg := range args {
+		if arg == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if len(paths) > 0 {
+			if _, err := os.Stat(worktreePath(repo, arg)); err != nil {
+				return revs, nil, fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", arg)
+			}
+			paths = append(paths, arg)
+			continue
+		}
+		if arg == "--not" {
+			negate = !negate
+			continue
+		}
+		// Like --not, these apply where they appear among the revisions
+		if prefix, ok := refOptionPrefixes[arg]; ok {
+			revs.addRefs(repo, prefix, negate)
+			if arg == "--all" {
+				if head := headCommit(repo); head != "" {
+					revs.add(repo, head, negate)
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return revs, nil, fmt.Errorf("unrecognized argument: %s", arg)
+		}
+		if err := revs.add(repo, arg, negate); err != nil {
+			if _, statErr := os.Stat(worktreePath(repo, arg)); statErr == nil {
+				paths = append(paths, arg)
+				continue
+			}
+			return revs, nil, err
+		}
+	}
+	return revs, paths, nil
+}
+
+var refOptionPrefixes = map[string]string{
+	"--all":      "refs/",
+	"--branches": "refs/heads/",
+	"--tags":     "refs/tags/",
+	"--remotes":  "refs/remotes/",
+}
+
+// add adds one revision argument: rev, ^rev, A..B, A...B or rev^!.
+func (revs *revisionSet) add(repo string, arg string, negate bool) error {
+	resolve := func(rev string, include bool) (string, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hexHash, err := resolveRevision(repo, rev)
+		if err == nil {
+			if include {
+				revs.addTags(repo, hexHash)
+			}
+			hexHash, err = peelToType(repo, hexHash, Commit)
+		}
+		if err != nil {
+			return "", fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", arg)
+		}
+		return hexHash, nil
+	}
+	if left, right, ok := strings.Cut(arg, "..."); ok {
+		leftHex, err := resolve(left, true)
+		if err != nil {
+			return err
+		}
+		rightHex, err := resolve(right, true)
+		if err != nil {
+			return err
+		}
+		revs.include = append(revs.include, leftHex, rightHex)
+		revs.symmetric = append(revs.symmetric, [2]string{leftHex, rightHex})
+		return nil
+	}
+	if left, right, ok := strings.Cut(arg, ".."); ok {
+		leftHex, err := resolve(left, negate)
+		if err != nil {
+			return err
+		}
+		rightHex, err := resolve(right, !negate)
+		if err != nil {
+			return err
+		}
+		if negate {
+			leftHex, rightHex = rightHex, leftHex
+		}
+		revs.exclude = append(revs.exclude, leftHex)
+		revs.include = append(revs.include, rightHex)
+		return nil
+	}
+	if rev, ok := strings.CutSuffix(arg, "^!"); ok {
+		hexHash, err := resolve(rev, true)
+		if err != nil {
+			return err
+		}
+		revs.include = append(revs.include, hexHash)
+		revs.exclude = append(revs.exclude, readCommit(repo, hexHash).parents...)
+		return nil
+	}
+	if rev, ok := strings.CutPrefix(arg, "^"); ok {
+		negate = !negate
+		arg = rev
+	}
+	hexHash, err := resolve(arg, !negate)
+	if err != nil {
+		return err
+	}
+	if negate {
+		revs.exclude = append(revs.exclude, hexHash)
+	} else {
+		revs.include = append(revs.include, hexHash)
+	}
+	return nil
+}
+
+// addRefs includes the commits refs below prefix point to, for --all,
+// --branches, --tags and --remotes.
+func (revs *revisionSet) addRefs(repo string, prefix string, negate bool) {
+	for _, name := range listRefs(repo, prefix) {
+		hexHash, ok := resolveRef(repo, name)
+		if !ok {
+			continue
+		}
+		if commit, err := peelToType(repo, hexHash, Commit); err == nil {
+			if negate {
+				revs.exclude = append(revs.exclude, commit)
+			} else {
+				revs.addTags(repo, hexHash)
+				revs.include = append(revs.include, commit)
+			}
+		}
+	}
+}
+
+// addTags records hexHash and the tags it points to for as long as they
+// are annotated tags.
+func (revs *revisionSet) addTags(repo string, hexHash string) {
+	for {
+		objectType, content := readObject(repo, hexHash)
+		if objectType != Tag {
+			return
+		}
+		revs.tags = append(revs.tags, hexHash)
+		hexHash = tagTarget(content)
+	}
+}
+
+// commitQueue pops the commit with the highest generation first, then the
+// one with the newest date, and commits with the same date in the order
+// they were pushed. Commits pushed without a generation are ordered by date
+// alone.
+type commitQueue struct {
+	items []queuedCommit
+	count int
+}
+
+type queuedCommit struct {
+	hexHash    string
+	generation uint64
+	date       int64
+	order      int
+}
+
+func (queue *commitQueue) Len() int {
+	return len(queue.items)
+}
+
+func (queue *commitQueue) Less(i, j int) bool {
+	if queue.items[i].generation != queue.items[j].generation {
+		return queue.items[i].generation > queue.items[j].generation
+	}
+	if queue.items[i].date != queue.items[j].date {
+		return queue.items[i].date > queue.items[j].date
+	}
+	return queue.items[i].order < queue.items[j].order
+}
+
+func (queue *commitQueue) Swap(i, j int) {
+	queue.items[i], queue.items[j] = queue.items[j], queue.items[i]
+}
+
+func (queue *commitQueue) Push(x any) {
+	queue.items = append(queue.items, x.(queuedCommit))
+}
+
+func (queue *commitQueue) Pop() any {
+	last := queue.items[len(queue.items)-1]
+	queue.items = queue.items[:len(queue.items)-1]
+	return last
+}
+
+func (queue *commitQueue) push(hexHash string, date int64) {
+	queue.pushGeneration(hexHash, 0, date)
+}
+
+func (queue *commitQueue) pushGeneration(hexHash string, generation uint64, date int64) {
+	heap.Push(queue, queuedCommit{hexHash: hexHash, generation: generation, date: date, order: queue.count})
+	queue.count++
+}
+
+func (queue *commitQueue) pop() string {
+	return heap.Pop(queue).(queuedCommit).hexHash
+}
+
+func commitDate(commit commitObject) int64 {
+	return dateTime(parseIdentity(commit.committer).date).Unix()
+}
+
+func authorDate(commit commitObject) int64 {
+	return dateTime(parseIdentity(commit.author).date).Unix()
+}
+
+// walkRevisions walks the history selected by revs.
+func walkRevisions(repo string, revs revisionSet, options revWalkOptions) *revWalk {
+	walk := &revWalk{repo: repo, parents: map[string][]string{}, shown: map[string]bool{}, left: map[string]bool{}, cache: map[string]commitObject{}}
+	walk.excluded = reachableCommits(repo, revs.exclude...)
+	for _, sides := range revs.symmetric {
+		right := reachableCommits(repo, sides[1])
+		for hexHash := range reachableCommits(repo, sides[0]) {
+			if right[hexHash] {
+				walk.excluded[hexHash] = true
+			} else {
+				walk.left[hexHash] = true
+			}
+		}
+	}
+
+	queue := &commitQueue{}
+	seen := map[string]bool{}
+	for _, hexHash := range revs.include {
+		if !seen[hexHash] && !walk.excluded[hexHash] {
+			seen[hexHash] = true
+			queue.push(hexHash, commitDate(walk.commit(hexHash)))
+		}
+	}
+	walked := []string{}
+	for queue.Len() > 0 {
+		hexHash := queue.pop()
+		commit := walk.commit(hexHash)
+		parents, shown := walk.followedParents(hexHash, commit, options)
+		walk.parents[hexHash] = parents
+		walk.shown[hexHash] = shown
+		walked = append(walked, hexHash)
+		for _, parent := range parents {
+			if !seen[parent] && !walk.excluded[parent] {
+				seen[parent] = true
+				queue.push(parent, commitDate(walk.commit(parent)))
+			}
+		}
+	}
+
+	switch options.order {
+	case "topo":
+		walked = walk.sortTopologically(walked, nil)
+	case "date":
+		walked = walk.sortTopologically(walked, commitDate)
+	case "author-date":
+		walked = walk.sortTopologically(walked, authorDate)
+	}
+
+	var onAncestryPath map[string]bool
+	if options.ancestryPath {
+		onAncestryPath = walk.descendants(walked, revs.exclude)
+	}
+	skipped := 0
+	for _, hexHash := range walked {
+		if options.maxCount >= 0 && len(walk.commits) >= options.maxCount {
+			break
+		}
+		if !walk.shown[hexHash] || !walk.matches(walk.commit(hexHash), options) {
+			continue
+		}
+		if onAncestryPath != nil && !onAncestryPath[hexHash] {
+			continue
+		}
+		if skipped < options.skip {
+			skipped++
+			continue
+		}
+		walk.commits = append(walk.commits, hexHash)
+	}
+	if options.reverse {
+		for i, j := 0, len(walk.commits)-1; i < j; i, j = i+1, j-1 {
+			walk.commits[i], walk.commits[j] = walk.commits[j], walk.commits[i]
+		}
+	}
+	return walk
+}
+
+// descendants returns the walked commits that have one of bottoms as an
+// ancestor.
+func (walk *revWalk) descendants(walked []string, bottoms []string) map[string]bool {
+	result := map[string]bool{}
+	done := map[string]bool{}
+	var visit func(hexHash string) bool
+	visit = func(hexHash string) bool {
+		if !done[hexHash] {
+			done[hexHash] = true
+			for _, parent := range walk.parents[hexHash] {
+				if slices.Contains(bottoms, parent) || visit(parent) {
+					result[hexHash] = true
+				}
+			}
+		}
+		return result[hexHash]
+	}
+	for _, hexHash := range walked {
+		visit(hexHash)
+	}
+	return result
+}
+
+// followedParents returns the parents the walk continues with and whether
+// the commit is shown, which with paths depends on whether it changes them.
+func (walk *revWalk) followedParents(hexHash string, commit commitObject, options revWalkOptions) ([]string, bool) {
+	parents := commit.parents
+	if options.firstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+	if len(options.paths) == 0 {
+		return parents, true
+	}
+	if len(parents) == 0 {
+		return parents, !walk.sameOnPaths(commit.tree, emptyTreeHex, options.paths)
+	}
+	for _, parent := range parents {
+		if walk.sameOnPaths(commit.tree, walk.commit(parent).tree, options.paths) {
+			return []string{parent}, false
+		}
+	}
+	return parents, true
+}
+
+// sameOnPaths reports whether two trees record the same objects at paths.
+func (walk *revWalk) sameOnPaths(treeA string, treeB string, paths []string) bool {
+	for _, name := range paths {
+		if treeEntryAt(walk.repo, treeA, name) != treeEntryAt(walk.repo, treeB, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// treeEntryAt returns the object found at the slash separated path name
+// below tree, or "" when there is none.
+func treeEntryAt(repo string, treeHex string, name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return treeHex
+	}
+	if treeHex == emptyTreeHex {
+		return ""
+	}
+	first, rest, _ := strings.Cut(name, "/")
+	for _, entry := range readTreeEntries(repo, treeHex) {
+		if entry.name != first {
+			continue
+		}
+		entryHex := hex.EncodeToString(entry.sha[:])
+		if rest == "" {
+			return entryHex
+		}
+		if !isTreePerm(entry.perm) {
+			return ""
+		}
+		return treeEntryAt(repo, entryHex, rest)
+	}
+	return ""
+}
+
+// matches applies the date, parent count and message filters.
+func (walk *revWalk) matches(commit commitObject, options revWalkOptions) bool {
+	date := dateTime(parseIdentity(commit.committer).date)
+	if !options.since.IsZero() && date.Before(options.since) {
+		return false
+	}
+	if !options.until.IsZero() && date.After(options.until) {
+		return false
+	}
+	if len(commit.parents) < options.minParents {
+		return false
+	}
+	if options.maxParents >= 0 && len(commit.parents) > options.maxParents {
+		return false
+	}
+	matchesAny := func(patterns []*regexp.Regexp, value string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+		return len(patterns) == 0
+	}
+	author, committer := parseIdentity(commit.author), parseIdentity(commit.committer)
+	if !matchesAny(options.authors, author.name+" <"+author.email+">") ||
+		!matchesAny(options.committers, committer.name+" <"+committer.email+">") {
+		return false
+	}
+	if len(options.greps) == 0 {
+		return true
+	}
+	matched := options.allMatch
+	for _, pattern := range options.greps {
+		found := pattern.MatchString(commit.message)
+		if options.allMatch {
+			matched = matched && found
+		} else {
+			matched = matched || found
+		}
+	}
+	return matched != options.invertGrep
+}
+
+// sortTopologically reorders commits so that every commit comes before its
+// parents. With a nil date the most recently reached branch is continued
+// first, which keeps lines of history together; otherwise the newest ready
+// commit comes first.
+func (walk *revWalk) sortTopologically(commits []string, date func(commitObject) int64) []string {
+	children := map[string]int{}
+	inWalk := map[string]bool{}
+	for _, hexHash := range commits {
+		inWalk[hexHash] = true
+	}
+	for _, hexHash := range commits {
+		for _, parent := range walk.parents[hexHash] {
+			if inWalk[parent] {
+				children[parent]++
+			}
+		}
+	}
+
+	sorted := []string{}
+	ready := []string{}
+	queue := &commitQueue{}
+	push := func(hexHash string) {
+		if date == nil {
+			ready = append(ready, hexHash)
+		} else {
+			queue.push(hexHash, date(walk.commit(hexHash)))
+		}
+	}
+	for _, hexHash := range commits {
+		if children[hexHash] == 0 {
+			push(hexHash)
+		}
+	}
+	// The first tip is continued first
+	for i, j := 0, len(ready)-1; i < j; i, j = i+1, j-1 {
+		ready[i], ready[j] = ready[j], ready[i]
+	}
+	for len(ready) > 0 || queue.Len() > 0 {
+		var hexHash string
+		if date == nil {
+			hexHash = ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+		} else {
+			hexHash = queue.pop()
+		}
+		sorted = append(sorted, hexHash)
+		for _, parent := range walk.parents[hexHash] {
+			if !inWalk[parent] {
+				continue
+			}
+			children[parent]--
+			if children[parent] == 0 {
+				push(parent)
+			}
+		}
+	}
+	return sorted
+}
+
+// shownParents returns the parents of a shown commit rewritten to skip the
+// commits the walk hides, as needed to draw the graph.
+func (walk *revWalk) shownParents(hexHash string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	var visit func(parent string)
+	visit = func(parent string) {
+		if seen[parent] {
+			return
+		}
+		seen[parent] = true
+		if _, walked := walk.parents[parent]; !walked {
+			return
+		}
+		if walk.shown[parent] {
+			result = append(result, parent)
+			return
+		}
+		for _, grandparent := range walk.parents[parent] {
+			visit(grandparent)
+		}
+	}
+	for _, parent := range walk.parents[hexHash] {
+		visit(parent)
+	}
+	return result
+}
+
+// walkFlags are the command line options shared by log and rev-list to
+// select and order commits.
+type walkFlags struct {
+	MaxCount        int      `short:"n" long:"max-count" default:"-1" description:"Limit the number of commits to output"`
+	Skip            int      `long:"skip" description:"Skip that many commits before starting to show them"`
+	Since           string   `long:"since" description:"Show commits more recent than the date"`
+	After           string   `long:"after" description:"Show commits more recent than the date"`
+	Until           string   `long:"until" description:"Show commits older than the date"`
+	Before          string   `long:"before" description:"Show commits older than the date"`
+	Author          []string `long:"author" description:"Show commits whose author matches the pattern"`
+	Committer       []string `long:"committer" description:"Show commits whose committer matches the pattern"`
+	Grep            []string `long:"grep" description:"Show commits whose message matches the pattern"`
+	AllMatch        bool     `long:"all-match" description:"Require every --grep pattern to match"`
+	InvertGrep      bool     `long:"invert-grep" description:"Show commits whose message does not match"`
+	IgnoreCase      bool     `short:"i" long:"regexp-ignore-case" description:"Match patterns regardless of case"`
+	FixedStrings    bool     `short:"F" long:"fixed-strings" description:"Take patterns as fixed strings"`
+	FirstParent     bool     `long:"first-parent" description:"Follow only the first parent of merges"`
+	Merges          bool     `long:"merges" description:"Show only merge commits"`
+	NoMerges        bool     `long:"no-merges" description:"Do not show merge commits"`
+	MinParents      int      `long:"min-parents" description:"Show only commits with at least that many parents"`
+	MaxParents      int      `long:"max-parents" default:"-1" description:"Show only commits with at most that many parents"`
+	TopoOrder       bool     `long:"topo-order" description:"Show no parents before all of their children, keeping lines of history together"`
+	DateOrder       bool     `long:"date-order" description:"Show no parents before all of their children, by commit date"`
+	AuthorDateOrder bool     `long:"author-date-order" description:"Show no parents before all of their children, by author date"`
+	Reverse         bool     `long:"reverse" description:"Output the commits in reverse order"`
+	AncestryPath    bool     `long:"ancestry-path" description:"Show only commits descending from the excluded ones"`
+}
+
+// walkOptions converts the flags to the options of a walk.
+func (flags walkFlags) walkOptions(paths []string) (revWalkOptions, error) {
+	options := revWalkOptions{
+		firstParent:  flags.FirstParent,
+		paths:        paths,
+		allMatch:     flags.AllMatch,
+		invertGrep:   flags.InvertGrep,
+		minParents:   flags.MinParents,
+		maxParents:   flags.MaxParents,
+		skip:         flags.Skip,
+		maxCount:     flags.MaxCount,
+		reverse:      flags.Reverse,
+		ancestryPath: flags.AncestryPath,
+	}
+	switch {
+	case flags.TopoOrder:
+		options.order = "topo"
+	case flags.DateOrder:
+		options.order = "date"
+	case flags.AuthorDateOrder:
+		options.order = "author-date"
+	}
+	if flags.Merges {
+		options.minParents = max(options.minParents, 2)
+	}
+	if flags.NoMerges {
+		options.maxParents = 1
+	}
+	now := time.Now()
+	for _, date := range []struct {
+		value  string
+		target *time.Time
+	}{{flags.Since, &options.since}, {flags.After, &options.since}, {flags.Until, &options.until}, {flags.Before, &options.until}} {
+		if date.value == "" {
+			continue
+		}
+		at, err := parseApproxidate(date.value, now)
+		if err != nil {
+			return options, fmt.Errorf("invalid date '%s'", date.value)
+		}
+		*date.target = at
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		compiled := []*regexp.Regexp{}
+		for _, pattern := range patterns {
+			if flags.FixedStrings {
+				pattern = regexp.QuoteMeta(pattern)
+			}
+			// Patterns match single lines, like in git
+			pattern = "(?m)" + pattern
+			if flags.IgnoreCase {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression: %s", err)
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	var err error
+	if options.authors, err = compile(flags.Author); err != nil {
+		return options, err
+	}
+	if options.committers, err = compile(flags.Committer); err != nil {
+		return options, err
+	}
+	if options.greps, err = compile(flags.Grep); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// splitDoubleDash separates the arguments before "--" from the paths after
+// it, which option parsing would otherwise lose track of.
+func splitDoubleDash(args []string) ([]string, []string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
+
+// expandCountArguments rewrites -<n> into --max-count=<n>.
+func expandCountArguments(args []string) []string {
+	expanded := []string{}
+	for _, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' && strings.Trim(arg[1:], "0123456789") == "" {
+			arg = "--max-count=" + arg[1:]
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// exitWithRevisionError reports an argument that is neither a revision nor a
+// path the way git does.
+func exitWithRevisionError(err error) {
+	if !strings.HasPrefix(err.Error(), "ambiguous argument") {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	fmt.Fprintf(os.Stderr, "fatal: %s.\nUse '--' to separate paths from revisions, like this:\n'git <command> [<revision>...] -- [<file>...]'\n", err)
+	os.Exit(128)
+}
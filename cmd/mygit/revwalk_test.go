@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// writeTestHistory writes commits with the empty tree, each given as its
+// message followed by the messages of its parents, with a branch named
+// after every message. Later commits get later dates.
+func writeTestHistory(t *testing.T, commits [][]string) {
+	t.Helper()
+	chdirTemp(t)
+	names := map[string]string{}
+	for i, commit := range commits {
+		content := "tree " + emptyTreeHex + "\n"
+		for _, parent := range commit[1:] {
+			content += "parent " + names[parent] + "\n"
+		}
+		who := fmt.Sprintf("A U Thor <author@example.com> %d +0000", 1600000000+i*60)
+		content += "author " + who + "\ncommitter " + who + "\n\n" + commit[0] + "\n"
+		names[commit[0]] = createCommitObject([]byte(content))
+		writeRef(".", "refs/heads/"+commit[0], names[commit[0]], "")
+	}
+}
+
+func TestWalkRevisions(t *testing.T) {
+	writeTestHistory(t, [][]string{
+		{"base"}, {"a1", "base"}, {"b1", "base"}, {"a2", "a1"}, {"b2", "b1"}, {"merge", "a2", "b2"},
+	})
+	messages := func(walk *revWalk) []string {
+		result := []string{}
+		for _, hexHash := range walk.commits {
+			result = append(result, messageSubject(walk.commit(hexHash).message))
+		}
+		return result
+	}
+	cases := []struct {
+		args    []string
+		options revWalkOptions
+		want    []string
+	}{
+		{[]string{"merge"}, revWalkOptions{maxParents: -1, maxCount: -1}, []string{"merge", "b2", "a2", "b1", "a1", "base"}},
+		{[]string{"merge"}, revWalkOptions{order: "topo", maxParents: -1, maxCount: -1}, []string{"merge", "b2", "b1", "a2", "a1", "base"}},
+		{[]string{"merge"}, revWalkOptions{firstParent: true, maxParents: -1, maxCount: -1}, []string{"merge", "a2", "a1", "base"}},
+		{[]string{"a1..merge"}, revWalkOptions{maxParents: -1, maxCount: -1}, []string{"merge", "b2", "a2", "b1"}},
+		{[]string{"a1..merge"}, revWalkOptions{ancestryPath: true, maxParents: -1, maxCount: -1}, []string{"merge", "a2"}},
+		{[]string{"a2...b2"}, revWalkOptions{maxParents: -1, maxCount: -1}, []string{"b2", "a2", "b1", "a1"}},
+		{[]string{"merge", "--not", "b1"}, revWalkOptions{maxParents: 1, skip: 1, maxCount: 2, reverse: true}, []string{"a1", "a2"}},
+	}
+	for _, c := range cases {
+		revs, _, err := parseRevisionArguments(".", c.args)
+		if err != nil {
+			t.Fatalf("%v: %v", c.args, err)
+		}
+		if got := messages(walkRevisions(".", revs, c.options)); !slices.Equal(got, c.want) {
+			t.Errorf("%v %+v: got %v, want %v", c.args, c.options, got, c.want)
+		}
+	}
+}
+
+func TestRevListObjectsListsTags(t *testing.T) {
+	repo := newTestRepo(t)
+	writeTestFile(t, repo, "a", "a\n")
+	mygit(t, repo, "add", "a")
+	mygit(t, repo, "commit", "-m", "one")
+	realGit(t, repo, "tag", "-a", "v1", "-m", "v1")
+	realGit(t, repo, "tag", "-a", "nested", "-m", "nested", "v1")
+	writeTestFile(t, repo, "b", "b\n")
+	mygit(t, repo, "add", "b")
+	mygit(t, repo, "commit", "-m", "two")
+	realGit(t, repo, "tag", "-a", "v2", "-m", "v2")
+	for _, args := range [][]string{{"v1"}, {"nested"}, {"v1..v2"}, {"v2", "v2", "v1"}, {"--all"}} {
+		args = append([]string{"rev-list", "--objects"}, args...)
+		if got, want := mygit(t, repo, args...), realGit(t, repo, args...); got != want {
+			t.Errorf("%v =\n%s\nwant\n%s", args, got, want)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+/*
+*  ###################### SEQUENCER ##############################
+*
+*  cherry-pick applies the changes of commits on top of HEAD and revert
+*  applies their inverse, one new commit each. Both merge the trees: a
+*  cherry-pick merges the commit into HEAD with its parent as the base, a
+*  revert merges the parent with the commit as the base.
+*
+*  A pick that stops on conflicts leaves CHERRY_PICK_HEAD, or REVERT_HEAD,
+*  and MERGE_MSG for commit to conclude it. Picking several commits also
+*  keeps the sequence in .git/sequencer:
+*
+*    head          HEAD before the first pick, where --abort goes back to
+*    todo          the commits left to pick, the stopped one first
+*    opts          the options of the sequence
+*    abort-safety  HEAD after the last pick, to notice it was moved
+ */
+
+type replayAction int
+
+const (
+	replayPick replayAction = iota
+	replayRevert
+)
+
+func (action replayAction) String() string {
+	if action == replayRevert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// pickHead is the ref naming the commit of a stopped pick.
+func (action replayAction) pickHead() string {
+	if action == replayRevert {
+		return "REVERT_HEAD"
+	}
+	return "CHERRY_PICK_HEAD"
+}
+
+// todoCommand is the command of the action in the todo list.
+func (action replayAction) todoCommand() string {
+	if action == replayRevert {
+		return "revert"
+	}
+	return "pick"
+}
+
+// replayOptions holds the options of cherry-pick and revert.
+type replayOptions struct {
+	action       replayAction
+	noCommit     bool
+	recordOrigin bool
+	mainline     int
+	edit         bool
+	// explicitEdit is set by -e and --no-edit, which are saved for the
+	// rest of a sequence unlike the default of editing reverts
+	explicitEdit bool
+}
+
+func sequencerPath(repo string, name string) string {
+	return gitPath(repo, "sequencer/"+name)
+}
+
+// replayDirtyIndex refuses to pick on top of staged changes.
+func replayDirtyIndex(action replayAction) {
+	fmt.Fprintf(os.Stderr, "error: your local changes would be overwritten by %s.\n", action)
+	fmt.Fprintf(os.Stderr, "hint: commit your changes or stash them to proceed.\n")
+	fmt.Fprintf(os.Stderr, "fatal: %s failed\n", action)
+	os.Exit(128)
+}
+
+// replayFailed reports an error that stops cherry-pick or revert before
+// anything changed.
+func replayFailed(action replayAction, format string, a ...any) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", a...)
+	fmt.Fprintf(os.Stderr, "fatal: %s failed\n", action)
+	os.Exit(128)
+}
+
+// replayCommits lists the commits args name in the order they are applied:
+// single commits as given, ranges oldest first when picking and newest
+// first when reverting.
+func replayCommits(repo string, args []string, action replayAction) []string {
+	commits := []string{}
+	walked := slices.ContainsFunc(args, func(arg string) bool {
+		return strings.HasPrefix(arg, "^") || strings.Contains(arg, "..")
+	})
+	if walked {
+		revs, _, err := parseRevisionArguments(repo, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		walk := walkRevisions(repo, revs, revWalkOptions{maxCount: -1, maxParents: -1, reverse: action == replayPick})
+		commits = walk.commits
+	} else {
+		for _, arg := range args {
+			hexHash, err := resolveRevision(repo, arg)
+			if err == nil {
+				hexHash, err = peelToType(repo, hexHash, Commit)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", arg)
+				os.Exit(128)
+			}
+			if !slices.Contains(commits, hexHash) {
+				commits = append(commits, hexHash)
+			}
+		}
+	}
+	if len(commits) == 0 {
+		replayFailed(action, "empty commit set passed")
+	}
+	return commits
+}
+
+// startReplay cherry-picks or reverts the commits args name. Several
+// commits are picked as a sequence that can be continued after a stop.
+func startReplay(repo string, config *ini.File, args []string, options replayOptions) {
+	if _, err := os.Stat(gitPath(repo, "sequencer")); err == nil {
+		fmt.Fprintf(os.Stderr, "error: a cherry-pick or revert is already in progress\n")
+		fmt.Fprintf(os.Stderr, "hint: try \"mygit %s (--continue | --abort | --quit)\"\n", options.action)
+		fmt.Fprintf(os.Stderr, "fatal: %s failed\n", options.action)
+		os.Exit(128)
+	}
+	commits := replayCommits(repo, args, options.action)
+	if len(commits) == 1 {
+		if !pickCommit(repo, config, commits[0], options, false) {
+			os.Exit(1)
+		}
+		return
+	}
+	err := os.MkdirAll(gitPath(repo, "sequencer"), 0755)
+	exitIfError(err, fmt.Sprintf("fatal: could not create sequencer directory '%s'", gitPath(repo, "sequencer")))
+	writeGitFile(repo, "sequencer/head", headCommit(repo)+"\n")
+	writeReplayOptions(repo, options)
+	runReplay(repo, config, commits, options)
+}
+
+func writeReplayOptions(repo string, options replayOptions) {
+	content := ""
+	if options.noCommit {
+		content += "\tno-commit = true\n"
+	}
+	if options.explicitEdit {
+		content += fmt.Sprintf("\tedit = %t\n", options.edit)
+	}
+	if options.recordOrigin {
+		content += "\trecord-origin = true\n"
+	}
+	if options.mainline > 0 {
+		content += fmt.Sprintf("\tmainline = %d\n", options.mainline)
+	}
+	if content != "" {
+		writeGitFile(repo, "sequencer/opts", "[options]\n"+content)
+	}
+}
+
+// readReplay loads the sequence in progress, if any, with the commits left
+// to pick.
+func readReplay(repo string) (replayOptions, []string, bool) {
+	content, err := os.ReadFile(sequencerPath(repo, "todo"))
+	if err != nil {
+		return replayOptions{}, nil, false
+	}
+	options := replayOptions{}
+	commits := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == replayRevert.todoCommand() {
+			options.action = replayRevert
+		}
+		hexHash, err := resolveRevision(repo, fields[1])
+		exitIfError(err, fmt.Sprintf("error: invalid line %d: %s", len(commits)+1, line))
+		commits = append(commits, hexHash)
+	}
+	stdin, _ := os.Stdin.Stat()
+	options.edit = options.action == replayRevert && stdin != nil && stdin.Mode()&os.ModeCharDevice != 0
+	if file, err := ini.Load(sequencerPath(repo, "opts")); err == nil {
+		section := file.Section("options")
+		options.noCommit = section.Key("no-commit").MustBool(false)
+		if section.HasKey("edit") {
+			options.edit = section.Key("edit").MustBool(false)
+			options.explicitEdit = true
+		}
+		options.recordOrigin = section.Key("record-origin").MustBool(false)
+		options.mainline = section.Key("mainline").MustInt(0)
+	}
+	return options, commits, true
+}
+
+// runReplay picks commits in turn, saving the ones left to pick so that
+// a stop can be continued.
+func runReplay(repo string, config *ini.File, commits []string, options replayOptions) {
+	for len(commits) > 0 {
+		todo := ""
+		for _, hexHash := range commits {
+			todo += fmt.Sprintf("%s %s %s\n", options.action.todoCommand(), hexHash[:7], readCommit(repo, hexHash).subject())
+		}
+		writeGitFile(repo, "sequencer/todo", todo)
+		if !pickCommit(repo, config, commits[0], options, true) {
+			writeGitFile(repo, "sequencer/abort-safety", headCommit(repo)+"\n")
+			os.Exit(1)
+		}
+		commits = commits[1:]
+	}
+	os.RemoveAll(gitPath(repo, "sequencer"))
+}
+
+// pickCommit applies commit, or its inverse, to the index and the working
+// tree and commits the result unless asked not to. It returns false when
+// it stopped for the user to resolve conflicts or an empty result.
+func pickCommit(repo string, config *ini.File, commit string, options replayOptions, inSequence bool) bool {
+	action := options.action
+	entries := readIndex(repo)
+	if _, ok := stageZeroEntries(entries); !ok {
+		verb := "Cherry-picking"
+		if action == replayRevert {
+			verb = "Reverting"
+		}
+		fmt.Fprintf(os.Stderr, "error: %s is not possible because you have unmerged files.\n", verb)
+		fmt.Fprintf(os.Stderr, "hint: Fix them up in the work tree, and then use 'mygit add <file>'\n"+
+			"hint: as appropriate to mark resolution and make a commit.\n")
+		fmt.Fprintf(os.Stderr, "fatal: %s failed\n", action)
+		os.Exit(128)
+	}
+	head := headCommit(repo)
+	headTree := emptyTreeHex
+	if head != "" {
+		headTree = readCommit(repo, head).tree
+	} else {
+		// Picking onto an unborn branch merges into the empty tree, which
+		// the object store may not have yet
+		writeTreeObject(nil)
+	}
+	// Without committing, picks build on each other in the index
+	oursTree := headTree
+	if options.noCommit {
+		oursTree = hex.EncodeToString(writeIndexTree(entries, ""))
+	} else if changed := indexChanges(repo, head); len(changed) > 0 {
+		replayDirtyIndex(action)
+	}
+
+	picked := readCommit(repo, commit)
+	parent := ""
+	switch {
+	case len(picked.parents) > 1 && options.mainline == 0:
+		replayFailed(action, "commit %s is a merge but no -m option was given.", commit)
+	case options.mainline > len(picked.parents):
+		replayFailed(action, "commit %s does not have parent %d", commit, options.mainline)
+	case len(picked.parents) > 0:
+		parent = picked.parents[max(options.mainline, 1)-1]
+	}
+	parentTree := emptyTreeHex
+	if parent != "" {
+		parentTree = readCommit(repo, parent).tree
+	}
+	label := fmt.Sprintf("%s (%s)", commit[:7], picked.subject())
+	parentLabel := "parent of " + label
+	base, baseLabel, next, nextLabel := parentTree, parentLabel, picked.tree, label
+	if action == replayRevert {
+		base, baseLabel, next, nextLabel = picked.tree, label, parentTree, parentLabel
+	}
+
+	style, _ := mergeStyleFromConfig(configValue(repo, config, "merge", "conflictStyle"))
+	result := newTreeMerge(repo, 0, baseLabel, "HEAD", nextLabel, style).mergeTrees(base, oursTree, next)
+	ours := map[string]treeFile{}
+	flattenTree(repo, oursTree, "", ours)
+	if !updateWorktree(repo, ours, result.files, false, "merge") {
+		fmt.Fprintf(os.Stderr, "fatal: %s failed\n", action)
+		os.Exit(128)
+	}
+	recordConflicts(repo, result)
+	writeGitFile(repo, "AUTO_MERGE", result.tree+"\n")
+	printMergeMessages(result.messages)
+
+	message := replayMessage(picked, commit, parent, options)
+	if !result.clean {
+		writeGitFile(repo, "MERGE_MSG", message)
+		appendConflictsHint(repo)
+		if !options.noCommit || action == replayRevert {
+			writeGitFile(repo, action.pickHead(), commit+"\n")
+		}
+		verb := "apply"
+		if action == replayRevert {
+			verb = "revert"
+		}
+		fmt.Fprintf(os.Stderr, "error: could not %s %s... %s\n", verb, commit[:7], picked.subject())
+		if options.noCommit {
+			fmt.Fprintf(os.Stderr, "hint: after resolving the conflicts, mark the corrected paths\n"+
+				"hint: with 'mygit add <paths>'\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "hint: After resolving the conflicts, mark them with\n"+
+				"hint: \"mygit add <pathspec>\", then run\n"+
+				"hint: \"mygit %[1]s --continue\".\n"+
+				"hint: You can instead skip this commit with \"mygit %[1]s --skip\".\n"+
+				"hint: To abort and get back to the state before \"mygit %[1]s\",\n"+
+				"hint: run \"mygit %[1]s --abort\".\n", action)
+		}
+		return false
+	}
+	if options.noCommit {
+		writeGitFile(repo, "MERGE_MSG", message)
+		if action == replayRevert {
+			writeGitFile(repo, action.pickHead(), commit+"\n")
+		}
+		return true
+	}
+	if result.tree == headTree {
+		writeGitFile(repo, "MERGE_MSG", message)
+		if action == replayPick || inSequence {
+			writeGitFile(repo, action.pickHead(), commit+"\n")
+		}
+		printEmptyPick(repo, action)
+		return false
+	}
+
+	if options.edit {
+		message = editCommitMessage(repo, config, message, "strip")
+		message, _ = cleanupCommitMessage(message, "strip")
+		if message == "" {
+			fmt.Fprintf(os.Stderr, "Aborting commit due to empty commit message.\n")
+			writeGitFile(repo, action.pickHead(), commit+"\n")
+			return false
+		}
+	}
+	author := authorIdentity(config).String()
+	if action == replayPick {
+		author = picked.author
+	}
+	newCommit := commitObject{
+		tree:      result.tree,
+		author:    author,
+		committer: committerIdentity(config).String(),
+		message:   message,
+	}
+	if head != "" {
+		newCommit.parents = []string{head}
+	}
+	commitHex := createCommitObject(newCommit.encode())
+	updateHead(repo, commitHex, fmt.Sprintf("%s: %s", action, newCommit.subject()))
+	printCommitSummary(repo, config, commitHex, true, false)
+	return true
+}
+
+// replayMessage is the message of the commit picking or reverting commit.
+func replayMessage(picked commitObject, commit string, parent string, options replayOptions) string {
+	if options.action == replayRevert {
+		message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", picked.subject(), commit)
+		if len(picked.parents) > 1 {
+			message += ", reversing\nchanges made to " + parent
+		}
+		return message + ".\n"
+	}
+	message := picked.message
+	if options.recordOrigin {
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+		if !parseCommitMessage(message).hasBlock {
+			message += "\n"
+		}
+		message += "(cherry picked from commit " + commit + ")\n"
+	}
+	return message
+}
+
+// printEmptyPick explains that a pick stopped because it changes nothing
+// on top of HEAD.
+func printEmptyPick(repo string, action replayAction) {
+	if action == replayPick {
+		fmt.Fprintf(os.Stderr, "The previous cherry-pick is now empty, possibly due to conflict resolution.\n"+
+			"If you wish to commit it anyway, use:\n"+
+			"\n"+
+			"    mygit commit --allow-empty\n"+
+			"\n"+
+			"Otherwise, please use 'mygit cherry-pick --skip'\n")
+	}
+	if branch, ok := headBranch(repo); ok {
+		fmt.Printf("On branch %s\n", shortRefName(branch))
+	} else {
+		fmt.Printf("HEAD detached at %s\n", headCommit(repo)[:7])
+	}
+	name := "Cherry-pick"
+	if action == replayRevert {
+		name = "Revert"
+	}
+	if _, err := os.Stat(gitPath(repo, "sequencer")); err == nil {
+		fmt.Printf("%s currently in progress.\n", name)
+		fmt.Printf("  (run \"mygit %s --continue\" to continue)\n", action)
+	} else if content, err := os.ReadFile(gitPath(repo, action.pickHead())); err == nil {
+		verb := "cherry-picking"
+		if action == replayRevert {
+			verb = "reverting"
+		}
+		fmt.Printf("You are currently %s commit %s.\n", verb, strings.TrimSpace(string(content))[:7])
+		fmt.Printf("  (all conflicts fixed: run \"mygit %s --continue\")\n", action)
+	} else {
+		fmt.Printf("\nnothing to commit, working tree clean\n")
+		return
+	}
+	fmt.Printf("  (use \"mygit %[1]s --skip\" to skip this patch)\n"+
+		"  (use \"mygit %[1]s --abort\" to cancel the %[1]s operation)\n"+
+		"\n"+
+		"nothing to commit, working tree clean\n", action)
+}
+
+// replayInProgress returns the action of the pick or sequence in progress.
+func replayInProgress(repo string) (replayAction, bool) {
+	if options, _, ok := readReplay(repo); ok {
+		return options.action, true
+	}
+	for _, action := range []replayAction{replayPick, replayRevert} {
+		if _, err := os.Stat(gitPath(repo, action.pickHead())); err == nil {
+			return action, true
+		}
+	}
+	return replayPick, false
+}
+
+// continueReplay commits the resolved pick that stopped and picks the
+// rest of the sequence.
+func continueReplay(repo string, config *ini.File, action replayAction, edit bool) {
+	current, ok := replayInProgress(repo)
+	if !ok {
+		replayFailed(action, "no cherry-pick or revert in progress")
+	}
+	options, commits, inSequence := readReplay(repo)
+	if inSequence && current != action {
+		replayFailed(action, "cannot %s during a %s.", action, current)
+	}
+	for _, pending := range []replayAction{replayPick, replayRevert} {
+		if _, err := os.Stat(gitPath(repo, pending.pickHead())); err == nil {
+			createCommit(repo, config, commitRequest{message: pendingMergeMessage(repo), edit: edit, cleanup: "strip"})
+			break
+		}
+	}
+	if inSequence {
+		if changed := indexChanges(repo, headCommit(repo)); len(changed) > 0 {
+			replayDirtyIndex(action)
+		}
+		runReplay(repo, config, commits[1:], options)
+	}
+}
+
+// skipReplay drops the pick that stopped and picks the rest of the
+// sequence.
+func skipReplay(repo string, config *ini.File, action replayAction) {
+	options, commits, inSequence := readReplay(repo)
+	_, err := os.Stat(gitPath(repo, action.pickHead()))
+	switch {
+	case inSequence && options.action != action:
+		replayFailed(action, "cannot %s during a %s.", action, options.action)
+	case err != nil && !inSequence:
+		replayFailed(action, "no %s in progress", action)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: there is nothing to skip\n"+
+			"hint: have you committed already?\n"+
+			"hint: try \"mygit %s --continue\"\n", action)
+		fmt.Fprintf(os.Stderr, "fatal: %s failed\n", action)
+		os.Exit(128)
+	}
+	head := headCommit(repo)
+	resetMerge(repo, head, "reset: moving to "+head)
+	os.Remove(gitPath(repo, action.pickHead()))
+	os.Remove(gitPath(repo, "MERGE_MSG"))
+	os.Remove(gitPath(repo, "AUTO_MERGE"))
+	if inSequence {
+		runReplay(repo, config, commits[1:], options)
+	}
+}
+
+// abortReplay goes back to where the pick or sequence started.
+func abortReplay(repo string, action replayAction) {
+	_, _, inSequence := readReplay(repo)
+	current, ok := replayInProgress(repo)
+	if !ok {
+		replayFailed(action, "no cherry-pick or revert in progress")
+	}
+	target := headCommit(repo)
+	if inSequence {
+		content, err := os.ReadFile(sequencerPath(repo, "head"))
+		exitIfError(err, fmt.Sprintf("error: cannot open '%s'", sequencerPath(repo, "head")))
+		safety, _ := os.ReadFile(sequencerPath(repo, "abort-safety"))
+		if moved := strings.TrimSpace(string(safety)); moved != "" && moved != target {
+			fmt.Fprintf(os.Stderr, "warning: You seem to have moved HEAD. Not rewinding, check your HEAD!\n")
+		} else {
+			target = strings.TrimSpace(string(content))
+		}
+	}
+	resetMerge(repo, target, "reset: moving to "+target)
+	os.Remove(gitPath(repo, current.pickHead()))
+	os.Remove(gitPath(repo, "MERGE_MSG"))
+	os.Remove(gitPath(repo, "AUTO_MERGE"))
+	os.RemoveAll(gitPath(repo, "sequencer"))
+}
+
+// parseMainline parses the parent number given to -m.
+func parseMainline(value string) int {
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		fmt.Fprintf(os.Stderr, "error: option `mainline' expects a number greater than zero\n")
+		os.Exit(129)
+	}
+	return number
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReplayMessage(t *testing.T) {
+	commit := "e800565cf760e1cb226e183b91177fa09511cac5"
+	cases := []struct {
+		name    string
+		message string
+		parents []string
+		options replayOptions
+		want    string
+	}{
+		{
+			name:    "pick",
+			message: "change b\n",
+			options: replayOptions{action: replayPick},
+			want:    "change b\n",
+		},
+		{
+			name:    "record origin",
+			message: "change b\n\nbody\n",
+			options: replayOptions{action: replayPick, recordOrigin: true},
+			want:    "change b\n\nbody\n\n(cherry picked from commit " + commit + ")\n",
+		},
+		{
+			// The origin joins a trailer block instead of starting one
+			name:    "record origin after trailers",
+			message: "change b\n\nSigned-off-by: A <a@x>\n",
+			options: replayOptions{action: replayPick, recordOrigin: true},
+			want:    "change b\n\nSigned-off-by: A <a@x>\n(cherry picked from commit " + commit + ")\n",
+		},
+		{
+			name:    "revert",
+			message: "change b\n",
+			options: replayOptions{action: replayRevert},
+			want:    "Revert \"change b\"\n\nThis reverts commit " + commit + ".\n",
+		},
+		{
+			name:    "revert merge",
+			message: "merge side\n",
+			parents: []string{"p1", "p2"},
+			options: replayOptions{action: replayRevert, mainline: 1},
+			want:    "Revert \"merge side\"\n\nThis reverts commit " + commit + ", reversing\nchanges made to p1.\n",
+		},
+	}
+	for _, c := range cases {
+		picked := commitObject{message: c.message, parents: c.parents}
+		if got := replayMessage(picked, commit, "p1", c.options); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// newPickRepo creates a repository whose side branch, forked from base,
+// has s1 changing f, then s2 adding g and s3 adding h, by another author.
+// main changes f differently, so that s1 conflicts with it.
+func newPickRepo(t *testing.T) string {
+	t.Helper()
+	repo := newTestRepo(t)
+	commit := func(name string, content string, message string, extra ...string) {
+		writeTestFile(t, repo, name, content)
+		mygit(t, repo, "add", name)
+		mygit(t, repo, append([]string{"commit", "-m", message}, extra...)...)
+	}
+	commit("f", "base\n", "base")
+	mygit(t, repo, "switch", "-c", "side")
+	side := []string{"--author", "S Ide <side@example.com>", "--date", "1500000000 +0000"}
+	commit("f", "side\n", "s1", side...)
+	commit("g", "g\n", "s2", side...)
+	commit("h", "h\n", "s3", side...)
+	mygit(t, repo, "switch", "main")
+	commit("f", "main\n", "main")
+	return repo
+}
+
+func logSubjects(t *testing.T, repo string) string {
+	t.Helper()
+	return strings.ReplaceAll(strings.TrimSpace(mygit(t, repo, "log", "--format=%s")), "\n", " ")
+}
+
+func TestCherryPickRangeKeepsAuthors(t *testing.T) {
+	repo := newPickRepo(t)
+	s2, _ := resolveRevision(repo, "side~1")
+	mygit(t, repo, "cherry-pick", "side~2..side")
+	if subjects := logSubjects(t, repo); subjects != "s3 s2 main base" {
+		t.Errorf("log = %s, want s3 s2 main base", subjects)
+	}
+	picked := readCommit(repo, headCommit(repo))
+	if picked.author != "S Ide <side@example.com> 1500000000 +0000" ||
+		picked.committer != "C O Mitter <committer@example.com> 1600000000 +0000" {
+		t.Errorf("picked commit author %q, committer %q", picked.author, picked.committer)
+	}
+
+	mygit(t, repo, "switch", "-c", "picks", "side~3")
+	mygit(t, repo, "cherry-pick", "-x", s2)
+	if message, want := readCommit(repo, headCommit(repo)).message, "s2\n\n(cherry picked from commit "+s2+")\n"; message != want {
+		t.Errorf("cherry-pick -x message = %q, want %q", message, want)
+	}
+}
+
+func TestCherryPickConflict(t *testing.T) {
+	start := func(t *testing.T) string {
+		repo := newPickRepo(t)
+		s1, _ := resolveRevision(repo, "side~2")
+		result := runMygit(t, repo, "", "cherry-pick", "side~3..side")
+		want := "error: could not apply " + s1[:7] + "... s1\n" +
+			"hint: After resolving the conflicts, mark them with\n" +
+			"hint: \"mygit add <pathspec>\", then run\n" +
+			"hint: \"mygit cherry-pick --continue\".\n"
+		if result.code != 1 || !strings.Contains(result.stderr, want) {
+			t.Fatalf("cherry-pick = %d %q, want a conflict in s1", result.code, result.stderr)
+		}
+		return repo
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		repo := start(t)
+		writeTestFile(t, repo, "f", "resolved\n")
+		mygit(t, repo, "add", "f")
+		mygit(t, repo, "cherry-pick", "--continue")
+		if subjects := logSubjects(t, repo); subjects != "s3 s2 s1 main base" {
+			t.Errorf("log = %s", subjects)
+		}
+		if f := readTestFile(t, repo, "f"); f != "resolved\n" {
+			t.Errorf("f = %q, want the resolution", f)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		repo := start(t)
+		mygit(t, repo, "cherry-pick", "--skip")
+		if subjects := logSubjects(t, repo); subjects != "s3 s2 main base" {
+			t.Errorf("log = %s", subjects)
+		}
+		if f := readTestFile(t, repo, "f"); f != "main\n" {
+			t.Errorf("f = %q after skipping s1", f)
+		}
+	})
+	t.Run("abort", func(t *testing.T) {
+		repo := start(t)
+		mygit(t, repo, "cherry-pick", "--abort")
+		if subjects := logSubjects(t, repo); subjects != "main base" {
+			t.Errorf("log = %s", subjects)
+		}
+		if f := readTestFile(t, repo, "f"); f != "main\n" {
+			t.Errorf("f = %q after abort", f)
+		}
+		if _, err := os.Stat(gitPath(repo, "sequencer")); !os.IsNotExist(err) {
+			t.Errorf("sequencer state kept after abort: %v", err)
+		}
+	})
+}
+
+func TestCherryPickMergeMainline(t *testing.T) {
+	repo := newPickRepo(t)
+	mygit(t, repo, "switch", "-c", "topic", "main~1")
+	mygit(t, repo, "merge", "--no-ff", "-m", "merge side", "side~1")
+	merge := headCommit(repo)
+	mygit(t, repo, "switch", "-c", "other", "main~1")
+	result := runMygit(t, repo, "", "cherry-pick", merge)
+	if want := "error: commit " + merge + " is a merge but no -m option was given.\n"; result.code != 128 || !strings.HasPrefix(result.stderr, want) {
+		t.Errorf("cherry-pick of a merge = %d %q", result.code, result.stderr)
+	}
+	mygit(t, repo, "cherry-pick", "-m", "1", merge)
+	if g := readTestFile(t, repo, "g"); g != "g\n" {
+		t.Errorf("g = %q, want the changes of side", g)
+	}
+	if subjects := logSubjects(t, repo); subjects != "merge side base" {
+		t.Errorf("log = %s", subjects)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	repo := newPickRepo(t)
+	main := headCommit(repo)
+	mygit(t, repo, "revert", "HEAD")
+	want := "Revert \"main\"\n\nThis reverts commit " + main + ".\n"
+	if message := readCommit(repo, headCommit(repo)).message; message != want {
+		t.Errorf("revert message = %q, want %q", message, want)
+	}
+	if f := readTestFile(t, repo, "f"); f != "base\n" {
+		t.Errorf("f = %q after revert, want base", f)
+	}
+}
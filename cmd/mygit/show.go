@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+*  ###################### SHOW ##############################
+*
+*  show prints the objects it is given, in order: commits the way log
+*  does, followed by their changes, annotated tags followed by the object
+*  they point at, the entries of trees and the content of blobs.
+*
+*  tag <name>
+*  Tagger: <name> <email>
+*  Date:   <date>
+*
+*  <message>
+ */
+
+// shownObject is an object to show and the name it was given by.
+type shownObject struct {
+	name    string
+	hexHash string
+}
+
+// isRangeArgument tells whether arg selects commits to walk rather than a
+// single object.
+func isRangeArgument(arg string) bool {
+	return strings.Contains(arg, "..") || strings.HasPrefix(arg, "^") || strings.HasSuffix(arg, "^!")
+}
+
+// showObjects prints objects the way `git show` does. Commits that do not
+// change any of the paths of the options are left out.
+func showObjects(repo string, objects []shownObject, options logOptions) {
+	printer := newLogPrinter(repo, options)
+	for _, object := range objects {
+		hexHash := object.hexHash
+		for hexHash != "" {
+			objectType, content := readObject(repo, hexHash)
+			switch objectType {
+			case Commit:
+				if commit := readCommit(repo, hexHash); touchesPaths(repo, commit, options.paths) {
+					printer.printCommit(hexHash, commit, nil)
+				}
+				hexHash = ""
+			case Tag:
+				printer.printTag(content)
+				hexHash = tagTarget(content)
+			case Tree:
+				printer.printTree(object.name, hexHash)
+				hexHash = ""
+			default:
+				os.Stdout.Write(content)
+				hexHash = ""
+			}
+		}
+	}
+}
+
+// touchesPaths tells whether commit changes paths from every one of its
+// parents.
+func touchesPaths(repo string, commit commitObject, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	parents := commit.parents
+	if len(parents) == 0 {
+		parents = []string{""}
+	}
+	for _, parent := range parents {
+		parentTree := ""
+		if parent != "" {
+			parentTree = readCommit(repo, parent).tree
+		}
+		if len(diffTrees(repo, parentTree, commit.tree, treeDiffOptions{recursive: true}, paths)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// printTag prints an annotated tag with its tagger and message. Like for
+// authors of commits, the date of the tagger is only shown by the medium
+// and fuller formats, and oneline leaves out the tagger.
+func (printer *logPrinter) printTag(content []byte) {
+	if printer.shownOne {
+		fmt.Print("\n")
+	}
+	header, message, hasMessage := strings.Cut(string(content), "\n\n")
+	name, tagger := "", ""
+	for _, line := range strings.Split(header, "\n") {
+		if value, ok := strings.CutPrefix(line, "tag "); ok {
+			name = value
+		}
+		if value, ok := strings.CutPrefix(line, "tagger "); ok {
+			tagger += printer.taggerLines(parseIdentity(value))
+		}
+	}
+	fmt.Printf("tag %s\n%s", name, tagger)
+	if hasMessage {
+		fmt.Print("\n" + message)
+	}
+	printer.shownOne = true
+}
+
+func (printer *logPrinter) taggerLines(tagger identity) string {
+	date, err := formatDate(tagger.date, printer.options.date, printer.now)
+	if err != nil {
+		date = tagger.date
+	}
+	switch {
+	case printer.options.format == "oneline":
+		return ""
+	case printer.options.format == "medium":
+		return "Tagger: " + tagger.name + " <" + tagger.email + ">\nDate:   " + date + "\n"
+	case printer.options.format == "fuller":
+		return "Tagger:     " + tagger.name + " <" + tagger.email + ">\nTaggerDate: " + date + "\n"
+	}
+	return "Tagger: " + tagger.name + " <" + tagger.email + ">\n"
+}
+
+// printTree lists the entries of a tree, with a slash after directories.
+func (printer *logPrinter) printTree(name string, hexHash string) {
+	if printer.shownOne {
+		fmt.Print("\n")
+	}
+	fmt.Printf("tree %s\n\n", name)
+	for _, entry := range readTreeEntries(printer.repo, hexHash) {
+		if isTreePerm(entry.perm) {
+			fmt.Println(entry.name + "/")
+		} else {
+			fmt.Println(entry.name)
+		}
+	}
+	printer.shownOne = true
+}
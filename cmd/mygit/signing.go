@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	pgpSignatureStart  = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureStart  = "-----BEGIN SSH SIGNATURE-----"
+	x509SignatureStart = "-----BEGIN SIGNED MESSAGE-----"
+)
+
+// signPayload signs payload with the program selected by gpg.format and
+// returns the armored signature. keyID overrides user.signingKey.
+func signPayload(config *ini.File, payload []byte, keyID string) (string, error) {
+	if keyID == "" {
+		keyID = configValue(".", config, "user", "signingKey")
+	}
+	switch format := configValue(".", config, "gpg", "format"); format {
+	case "", "openpgp":
+		if keyID == "" {
+			id := committerIdentity(config)
+			keyID = id.name + " <" + id.email + ">"
+		}
+		return gpgSign(signingProgram(config, "openpgp", "gpg"), payload, keyID)
+	case "x509":
+		if keyID == "" {
+			keyID = committerIdentity(config).email
+		}
+		return gpgSign(signingProgram(config, "x509", "gpgsm"), payload, keyID)
+	case "ssh":
+		return sshSign(signingProgram(config, "ssh", "ssh-keygen"), payload, keyID)
+	default:
+		return "", fmt.Errorf("unsupported value for gpg.format: %s", format)
+	}
+}
+
+// signingProgram returns gpg.<format>.program, falling back to gpg.program
+// for OpenPGP and to fallback otherwise.
+func signingProgram(config *ini.File, format string, fallback string) string {
+	if program := configValue(".", config, `gpg "`+format+`"`, "program"); program != "" {
+		return program
+	}
+	if format == "openpgp" {
+		if program := configValue(".", config, "gpg", "program"); program != "" {
+			return program
+		}
+	}
+	return fallback
+}
+
+func gpgSign(program string, payload []byte, keyID string) (string, error) {
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", keyID)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil || !strings.Contains(stderr.String(), "\n[GNUPG:] SIG_CREATED ") {
+		return "", fmt.Errorf("gpg failed to sign the data:\n%s", stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// sshSign signs with ssh-keygen -Y sign. keyID is either the path of a
+// private key or a literal public key, in which case the private key is
+// expected to be in the ssh agent.
+func sshSign(program string, payload []byte, keyID string) (string, error) {
+	if keyID == "" {
+		return "", fmt.Errorf("user.signingKey needs to be set for ssh signing")
+	}
+	tempDir, err := os.MkdirTemp("", "mygit-sign-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	args := []string{"-Y", "sign", "-n", "git"}
+	literal := strings.TrimPrefix(keyID, "key::")
+	if literal != keyID || strings.HasPrefix(keyID, "ssh-") {
+		keyFile := filepath.Join(tempDir, "key.pub")
+		if err := os.WriteFile(keyFile, []byte(literal+"\n"), 0600); err != nil {
+			return "", err
+		}
+		args = append(args, "-U", "-f", keyFile)
+	} else {
+		args = append(args, "-f", expandHome(keyID))
+	}
+	bufferFile := filepath.Join(tempDir, "buffer")
+	if err := os.WriteFile(bufferFile, payload, 0600); err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(program, append(args, bufferFile)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to sign the data with ssh-keygen:\n%s", stderr.String())
+	}
+	signature, err := os.ReadFile(bufferFile + ".sig")
+	if err != nil {
+		return "", fmt.Errorf("failed to read ssh signing data buffer from '%s'", bufferFile+".sig")
+	}
+	return string(signature), nil
+}
+
+// verifySignature checks signature over payload with the program matching
+// the kind of signature and returns the program's report. A non nil error
+// means the signature is bad or could not be checked.
+func verifySignature(config *ini.File, payload []byte, signature string) (string, error) {
+	switch {
+	case strings.HasPrefix(signature, sshSignatureStart):
+		return sshVerify(config, payload, signature)
+	case strings.HasPrefix(signature, pgpSignatureStart):
+		return gpgVerify(signingProgram(config, "openpgp", "gpg"), payload, signature)
+	case strings.HasPrefix(signature, x509SignatureStart):
+		return gpgVerify(signingProgram(config, "x509", "gpgsm"), payload, signature)
+	default:
+		return "", fmt.Errorf("unknown signature format")
+	}
+}
+
+func gpgVerify(program string, payload []byte, signature string) (string, error) {
+	signatureFile, err := writeTempFile("mygit-sig-", signature)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(signatureFile)
+	cmd := exec.Command(program, "--status-fd=1", "--keyid-format=long", "--verify", signatureFile, "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil || !strings.Contains(stdout.String(), "[GNUPG:] GOODSIG ") {
+		return stderr.String(), fmt.Errorf("bad signature")
+	}
+	return stderr.String(), nil
+}
+
+// sshVerify looks the signer up in gpg.ssh.allowedSignersFile and verifies
+// the signature for the principals found there.
+func sshVerify(config *ini.File, payload []byte, signature string) (string, error) {
+	program := signingProgram(config, "ssh", "ssh-keygen")
+	allowedSigners := expandHome(configValue(".", config, `gpg "ssh"`, "allowedSignersFile"))
+	if _, err := os.Stat(allowedSigners); allowedSigners == "" || err != nil {
+		return "", fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	signatureFile, err := writeTempFile("mygit-sig-", signature)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(signatureFile)
+	var principals bytes.Buffer
+	find := exec.Command(program, "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile)
+	find.Stdout = &principals
+	if err := find.Run(); err != nil {
+		check := exec.Command(program, "-Y", "check-novalidate", "-n", "git", "-s", signatureFile)
+		check.Stdin = bytes.NewReader(payload)
+		output, _ := check.CombinedOutput()
+		return string(output) + "No principal matched.\n", fmt.Errorf("no principal matched")
+	}
+	var output []byte
+	for _, principal := range strings.Split(strings.TrimSpace(principals.String()), "\n") {
+		verify := exec.Command(program, "-Y", "verify", "-n", "git", "-f", allowedSigners, "-I", principal, "-s", signatureFile)
+		verify.Stdin = bytes.NewReader(payload)
+		output, err = verify.CombinedOutput()
+		if err == nil {
+			return string(output), nil
+		}
+	}
+	return string(output), fmt.Errorf("bad signature")
+}
+
+// splitCommitSignature separates the gpgsig header from a commit and
+// returns the signature along with the payload that was signed.
+func splitCommitSignature(commit commitObject) (string, []byte, bool) {
+	unsigned := commit
+	unsigned.headers = []commitHeader{}
+	signature := ""
+	for _, header := range commit.headers {
+		if header.key == "gpgsig" {
+			signature = header.value + "\n"
+			continue
+		}
+		unsigned.headers = append(unsigned.headers, header)
+	}
+	return signature, unsigned.encode(), signature != ""
+}
+
+// splitTagSignature separates the signature appended to the message of an
+// annotated tag from the payload that was signed.
+func splitTagSignature(content []byte) (string, []byte, bool) {
+	text := string(content)
+	start := -1
+	for _, marker := range []string{pgpSignatureStart, sshSignatureStart, x509SignatureStart} {
+		if index := strings.LastIndex(text, "\n"+marker); index > start {
+			start = index
+		}
+	}
+	if start < 0 {
+		return "", content, false
+	}
+	return text[start+1:], content[:start+1], true
+}
+
+// signCommit adds the gpgsig header to commit.
+func signCommit(config *ini.File, commit *commitObject, keyID string) {
+	signature, err := signPayload(config, commit.encode(), keyID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\nfatal: failed to write commit object\n", err)
+		os.Exit(128)
+	}
+	commit.headers = append(commit.headers, commitHeader{key: "gpgsig", value: strings.TrimSuffix(signature, "\n")})
+}
+
+func writeTempFile(pattern string, content string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = file.WriteString(content)
+	return file.Name(), err
+}
+
+func expandHome(name string) string {
+	if strings.HasPrefix(name, "~/") {
+		return filepath.Join(os.Getenv("HOME"), name[2:])
+	}
+	return name
+}
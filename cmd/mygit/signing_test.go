@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// sshSigningConfig generates an ed25519 key and returns a config that signs
+// with it and trusts it for t@example.com.
+func sshSigningConfig(t *testing.T) *ini.File {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+	dir := chdirTemp(t)
+	key := filepath.Join(dir, "id_ed25519")
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "test", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %s\n%s", err, output)
+	}
+	publicKey, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(allowedSigners, []byte("t@example.com "+string(publicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := ini.Empty()
+	config.Section("user").Key("name").SetValue("Test User")
+	config.Section("user").Key("email").SetValue("t@example.com")
+	config.Section("user").Key("signingKey").SetValue(key)
+	config.Section("gpg").Key("format").SetValue("ssh")
+	config.Section(`gpg "ssh"`).Key("allowedSignersFile").SetValue(allowedSigners)
+	return config
+}
+
+func TestSSHSignedCommitVerifies(t *testing.T) {
+	config := sshSigningConfig(t)
+	commit := commitObject{
+		tree:      emptyTreeHex,
+		author:    "Test User <t@example.com> 1112911993 +0200",
+		committer: "Test User <t@example.com> 1112911993 +0200",
+		message:   "signed\n",
+	}
+	signCommit(config, &commit, "")
+
+	parsed := parseCommit(commit.encode())
+	signature, payload, signed := splitCommitSignature(parsed)
+	if !signed || !strings.HasPrefix(signature, sshSignatureStart) {
+		t.Fatalf("commit has no ssh signature:\n%s", commit.encode())
+	}
+	if string(parsed.encode()) != string(commit.encode()) {
+		t.Fatal("signed commit does not survive a parse and encode round trip")
+	}
+	if output, err := verifySignature(config, payload, signature); err != nil {
+		t.Fatalf("verifySignature() failed: %s\n%s", err, output)
+	}
+
+	tampered := strings.Replace(string(payload), "signed", "forged", 1)
+	if _, err := verifySignature(config, []byte(tampered), signature); err == nil {
+		t.Fatal("verifySignature() accepted a tampered payload")
+	}
+}
+
+func TestSSHSignedTagVerifies(t *testing.T) {
+	config := sshSigningConfig(t)
+	payload := "object " + emptyTreeHex + "\ntype tree\ntag v1\ntagger Test User <t@example.com> 1112911993 +0200\n\nrelease\n"
+	signature, err := signPayload(config, []byte(payload), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitSignature, splitPayload, signed := splitTagSignature([]byte(payload + signature))
+	if !signed || splitSignature != signature || string(splitPayload) != payload {
+		t.Fatal("splitTagSignature() did not recover the signature")
+	}
+	if output, err := verifySignature(config, splitPayload, splitSignature); err != nil {
+		t.Fatalf("verifySignature() failed: %s\n%s", err, output)
+	}
+}
+
+func TestSSHVerifyRejectsUnknownSigner(t *testing.T) {
+	config := sshSigningConfig(t)
+	payload := []byte("payload\n")
+	signature, err := signPayload(config, payload, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := configValue(".", config, `gpg "ssh"`, "allowedSignersFile")
+	if err := os.WriteFile(allowedSigners, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifySignature(config, payload, signature); err == nil {
+		t.Fatal("verifySignature() accepted a key missing from the allowed signers")
+	}
+}
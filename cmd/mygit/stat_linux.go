@@ -0,0 +1,7 @@
+package main
+
+import "syscall"
+
+func statCtime(stat *syscall.Stat_t) syscall.Timespec {
+	return stat.Ctim
+}
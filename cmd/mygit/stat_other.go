@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// fillIndexStat copies the stat data git uses to detect changed files.
+// Other platforms expose no inode data, so only times and size are recorded.
+func fillIndexStat(entry *indexEntry, info os.FileInfo) {
+	entry.ctimeSec = uint32(info.ModTime().Unix())
+	entry.ctimeNsec = uint32(info.ModTime().Nanosecond())
+	entry.mtimeSec = entry.ctimeSec
+	entry.mtimeNsec = entry.ctimeNsec
+	entry.size = uint32(info.Size())
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillIndexStat copies the stat data git uses to detect changed files.
+func fillIndexStat(entry *indexEntry, info os.FileInfo) {
+	entry.mtimeSec = uint32(info.ModTime().Unix())
+	entry.mtimeNsec = uint32(info.ModTime().Nanosecond())
+	entry.size = uint32(info.Size())
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	ctime := statCtime(stat)
+	entry.ctimeSec = uint32(ctime.Sec)
+	entry.ctimeNsec = uint32(ctime.Nsec)
+	entry.dev = uint32(stat.Dev)
+	entry.ino = uint32(stat.Ino)
+	entry.uid = stat.Uid
+	entry.gid = stat.Gid
+}
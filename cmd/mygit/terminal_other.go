@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// terminalWidth is the number of columns of the terminal on standard
+// output. Other platforms do not report it.
+func terminalWidth() int {
+	return 0
+}
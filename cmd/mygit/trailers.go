@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prefixes git itself writes in trailer blocks. A block containing one of
+// them only needs a quarter of its lines to be trailers.
+var generatedTrailerPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// trailerLine is one line, plus its continuation lines, of a trailer
+// block. Lines of the block that are not trailers have an empty token and
+// are kept verbatim in raw.
+type trailerLine struct {
+	token string
+	value string
+	raw   string
+}
+
+// String formats the trailer with the canonical ": " separator, the way
+// git rewrites every trailer it outputs.
+func (line trailerLine) String() string {
+	if line.token == "" {
+		return line.raw
+	}
+	return line.token + ": " + line.value
+}
+
+// unfolded returns the value with its continuation lines joined.
+func (line trailerLine) unfolded() string {
+	parts := strings.Split(line.value, "\n")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// commitMessage is a message split into the text before the trailer block,
+// the block itself and the comments and blank lines that end the message.
+type commitMessage struct {
+	body     string
+	trailers []trailerLine
+	tail     string
+	hasBlock bool
+}
+
+// trailerPlacement holds the --where, --if-exists and --if-missing
+// settings that apply to a trailer being added.
+type trailerPlacement struct {
+	where     string
+	ifExists  string
+	ifMissing string
+}
+
+var defaultTrailerPlacement = trailerPlacement{where: "end", ifExists: "addIfDifferentNeighbor", ifMissing: "add"}
+
+func parseCommitMessage(message string) commitMessage {
+	lines := strings.SplitAfter(message, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	// Trailing comments and blank lines are not part of the message proper
+	end := len(lines)
+	for end > 0 && (strings.HasPrefix(lines[end-1], "#") || strings.TrimSpace(lines[end-1]) == "") {
+		end--
+	}
+	parsed := commitMessage{body: strings.Join(lines[:end], ""), tail: strings.Join(lines[end:], "")}
+
+	titleEnd := 0
+	for titleEnd < end && strings.TrimSpace(lines[titleEnd]) != "" {
+		titleEnd++
+	}
+	start := findTrailerBlockStart(lines[titleEnd:end])
+	if start < 0 {
+		return parsed
+	}
+	start += titleEnd
+	parsed.hasBlock = true
+	parsed.body = strings.Join(lines[:start], "")
+	for _, line := range lines[start:end] {
+		text := strings.TrimSuffix(line, "\n")
+		if len(parsed.trailers) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			previous := &parsed.trailers[len(parsed.trailers)-1]
+			previous.raw += "\n" + text
+			previous.value += "\n" + text
+			continue
+		}
+		token, value, ok := splitTrailer(text, ":")
+		if !ok {
+			token, value = "", ""
+		}
+		parsed.trailers = append(parsed.trailers, trailerLine{token: token, value: value, raw: text})
+	}
+	return parsed
+}
+
+// findTrailerBlockStart returns the index of the first line of the trailer
+// block among lines, or -1 when the last paragraph is not one. It follows
+// git: every line has to be a trailer, unless a line git generates is
+// present, in which case a quarter of them is enough.
+func findTrailerBlockStart(lines []string) int {
+	trailerLines, nonTrailerLines, continuationLines := 0, 0, 0
+	recognizedPrefix := false
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSuffix(lines[i], "\n")
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			nonTrailerLines += continuationLines
+			if (recognizedPrefix && trailerLines*3 >= nonTrailerLines) || (trailerLines > 0 && nonTrailerLines == 0) {
+				return i + 1
+			}
+			return -1
+		}
+		generated := false
+		for _, prefix := range generatedTrailerPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				generated = true
+			}
+		}
+		if _, _, ok := splitTrailer(line, ":"); ok || generated {
+			trailerLines++
+			continuationLines = 0
+			recognizedPrefix = recognizedPrefix || generated
+		} else if line[0] == ' ' || line[0] == '\t' {
+			continuationLines++
+		} else {
+			nonTrailerLines += 1 + continuationLines
+			continuationLines = 0
+		}
+	}
+	return -1
+}
+
+// splitTrailer parses "<token><separator><value>" where the token is made
+// of alphanumerics and dashes and the separator is one of separators.
+func splitTrailer(line string, separators string) (string, string, bool) {
+	tokenEnd := 0
+	for tokenEnd < len(line) && (isAlphanumeric(line[tokenEnd]) || line[tokenEnd] == '-') {
+		tokenEnd++
+	}
+	separator := tokenEnd
+	for separator < len(line) && (line[separator] == ' ' || line[separator] == '\t') {
+		separator++
+	}
+	if tokenEnd == 0 || separator == len(line) || !strings.ContainsRune(separators, rune(line[separator])) {
+		return "", "", false
+	}
+	return line[:tokenEnd], strings.TrimSpace(line[separator+1:]), true
+}
+
+// parseTrailerArgument parses the argument of --trailer, where the value
+// may be omitted.
+func parseTrailerArgument(argument string) (trailerLine, error) {
+	if token, value, ok := splitTrailer(argument, ":="); ok {
+		return trailerLine{token: token, value: value}, nil
+	}
+	token := strings.TrimSpace(argument)
+	if _, _, ok := splitTrailer(token+":", ":"); !ok {
+		return trailerLine{}, fmt.Errorf("invalid trailer '%s'", argument)
+	}
+	return trailerLine{token: token}, nil
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// addTrailer adds trailer to the message honoring placement the way git
+// interpret-trailers does.
+func (message *commitMessage) addTrailer(trailer trailerLine, placement trailerPlacement) error {
+	sameToken := []int{}
+	for i, existing := range message.trailers {
+		if existing.token != "" && strings.EqualFold(existing.token, trailer.token) {
+			sameToken = append(sameToken, i)
+		}
+	}
+	position := len(message.trailers)
+	switch placement.where {
+	case "end":
+	case "start":
+		position = 0
+	case "after":
+		if len(sameToken) > 0 {
+			position = sameToken[len(sameToken)-1] + 1
+		}
+	case "before":
+		if len(sameToken) > 0 {
+			position = sameToken[0]
+		} else {
+			position = 0
+		}
+	default:
+		return fmt.Errorf("unknown value '%s' for key 'where'", placement.where)
+	}
+
+	if len(sameToken) == 0 {
+		switch placement.ifMissing {
+		case "add":
+			message.insertTrailer(position, trailer)
+		case "doNothing":
+		default:
+			return fmt.Errorf("unknown value '%s' for key 'ifMissing'", placement.ifMissing)
+		}
+		return nil
+	}
+	switch placement.ifExists {
+	case "add":
+		message.insertTrailer(position, trailer)
+	case "addIfDifferent":
+		for _, i := range sameToken {
+			if message.trailers[i].unfolded() == trailer.value {
+				return nil
+			}
+		}
+		message.insertTrailer(position, trailer)
+	case "addIfDifferentNeighbor":
+		for _, neighbor := range []int{position - 1, position} {
+			if neighbor >= 0 && neighbor < len(message.trailers) &&
+				strings.EqualFold(message.trailers[neighbor].token, trailer.token) &&
+				message.trailers[neighbor].unfolded() == trailer.value {
+				return nil
+			}
+		}
+		message.insertTrailer(position, trailer)
+	case "replace":
+		replaced := sameToken[len(sameToken)-1]
+		if placement.where == "before" || placement.where == "start" {
+			replaced = sameToken[0]
+		}
+		message.trailers = append(message.trailers[:replaced], message.trailers[replaced+1:]...)
+		if position > replaced {
+			position--
+		}
+		message.insertTrailer(position, trailer)
+	case "doNothing":
+	default:
+		return fmt.Errorf("unknown value '%s' for key 'ifExists'", placement.ifExists)
+	}
+	return nil
+}
+
+func (message *commitMessage) insertTrailer(position int, trailer trailerLine) {
+	message.trailers = append(message.trailers[:position], append([]trailerLine{trailer}, message.trailers[position:]...)...)
+}
+
+// String reassembles the message, separating a new trailer block from the
+// body with a blank line.
+func (message commitMessage) String() string {
+	var text strings.Builder
+	text.WriteString(message.body)
+	if len(message.trailers) > 0 && !message.hasBlock {
+		if message.body != "" && !strings.HasSuffix(message.body, "\n") {
+			text.WriteString("\n")
+		}
+		text.WriteString("\n")
+	}
+	for _, trailer := range message.trailers {
+		text.WriteString(trailer.String() + "\n")
+	}
+	text.WriteString(message.tail)
+	return text.String()
+}
+
+// trailerPlacementFromConfig reads the trailer.where, trailer.ifExists and
+// trailer.ifMissing defaults.
+func trailerPlacementFromConfig(lookup func(key string) string) trailerPlacement {
+	placement := defaultTrailerPlacement
+	if where := lookup("where"); where != "" {
+		placement.where = where
+	}
+	if ifExists := lookup("ifExists"); ifExists != "" {
+		placement.ifExists = ifExists
+	}
+	if ifMissing := lookup("ifMissing"); ifMissing != "" {
+		placement.ifMissing = ifMissing
+	}
+	return placement
+}
+
+// placedTrailer is a trailer to add together with the placement settings in
+// effect where it appeared on the command line.
+type placedTrailer struct {
+	trailer   trailerLine
+	placement trailerPlacement
+}
+
+// interpretTrailers adds trailers to message and renders the result. With
+// onlyTrailers only the trailers are printed, and unfold joins continuation
+// lines and normalizes the separators.
+func interpretTrailers(message string, trailers []placedTrailer, onlyTrailers bool, unfold bool) (string, error) {
+	parsed := parseCommitMessage(message)
+	for _, added := range trailers {
+		if err := parsed.addTrailer(added.trailer, added.placement); err != nil {
+			return "", err
+		}
+	}
+	if unfold {
+		for i, trailer := range parsed.trailers {
+			parsed.trailers[i].value = trailer.unfolded()
+		}
+	}
+	if !onlyTrailers {
+		return parsed.String(), nil
+	}
+	var output strings.Builder
+	for _, trailer := range parsed.trailers {
+		if trailer.token != "" {
+			output.WriteString(trailer.String() + "\n")
+		}
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+// Expected outputs come from git interpret-trailers.
+func TestInterpretTrailers(t *testing.T) {
+	signedOff := trailerLine{token: "Signed-off-by", value: "A <a@x>"}
+	reviewed := trailerLine{token: "Reviewed-by", value: "R <r@x>"}
+	cases := []struct {
+		name     string
+		message  string
+		trailers []placedTrailer
+		want     string
+	}{
+		{
+			"new block",
+			"subject\n\nbody\n",
+			[]placedTrailer{{signedOff, defaultTrailerPlacement}},
+			"subject\n\nbody\n\nSigned-off-by: A <a@x>\n",
+		},
+		{
+			"title is not a block",
+			"Foo: bar\n",
+			[]placedTrailer{{signedOff, defaultTrailerPlacement}},
+			"Foo: bar\n\nSigned-off-by: A <a@x>\n",
+		},
+		{
+			"existing block is normalized",
+			"subject\n\nFoo:1\nBar :2\n",
+			[]placedTrailer{{reviewed, defaultTrailerPlacement}},
+			"subject\n\nFoo: 1\nBar: 2\nReviewed-by: R <r@x>\n",
+		},
+		{
+			"same neighbor is not repeated",
+			"subject\n\nSigned-off-by: A <a@x>\n",
+			[]placedTrailer{{signedOff, defaultTrailerPlacement}},
+			"subject\n\nSigned-off-by: A <a@x>\n",
+		},
+		{
+			"generated prefix makes a block",
+			"subject\n\nnot a trailer\nalso not\nthird\nSigned-off-by: A <a@x>\n",
+			[]placedTrailer{{reviewed, trailerPlacement{where: "before", ifExists: "add", ifMissing: "add"}}},
+			"subject\n\nReviewed-by: R <r@x>\nnot a trailer\nalso not\nthird\nSigned-off-by: A <a@x>\n",
+		},
+		{
+			"replace",
+			"subject\n\nReviewed-by: old\nSigned-off-by: A <a@x>\n",
+			[]placedTrailer{{reviewed, trailerPlacement{where: "end", ifExists: "replace", ifMissing: "add"}}},
+			"subject\n\nSigned-off-by: A <a@x>\nReviewed-by: R <r@x>\n",
+		},
+		{
+			"comments stay after the block",
+			"subject\n\n# comment\n",
+			[]placedTrailer{{signedOff, defaultTrailerPlacement}},
+			"subject\n\nSigned-off-by: A <a@x>\n\n# comment\n",
+		},
+	}
+	for _, c := range cases {
+		got, err := interpretTrailers(c.message, c.trailers, false, false)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
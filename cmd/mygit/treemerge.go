@@ -0,0 +1,688 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+/*
+*  ###################### TREE MERGE ##############################
+*
+*  A port of git's ort merge strategy. The base and both sides are walked
+*  together, and paths where all three agree, or where only one side made
+*  a change, are resolved right away. The rest become entries recording
+*  what each of the three has at the path (the stages), as a mask of
+*  which ones have a file and which ones a directory.
+*
+*  Renames are found separately for each side, between the files it
+*  deleted and the ones it added, and move the stages of the source over
+*  to the destination so that its content is merged with the other side's
+*  changes to the source. The entries are then merged one by one, files
+*  below a directory before the directory itself so that a file in the
+*  way of a directory that is still there can be moved aside.
+*
+*  Several merge bases are first merged with each other, one after the
+*  other, the result standing in for the base as a made up commit.
+ */
+
+// mergeEntry is a path the sides of a merge disagree on. stages holds
+// what the base, ours and theirs have there, directories included, and
+// pathnames where each of them came from once renames are followed.
+type mergeEntry struct {
+	stages    [3]treeFile
+	pathnames [3]string
+	// filemask and dirmask tell which stages are files and directories,
+	// matchMask which of them are equal: 3 for the base and ours, 5 for
+	// the base and theirs and 6 for the two sides.
+	filemask, dirmask, matchMask int
+	dfConflict, pathConflict     bool
+	// The merged version, once clean is set or the entry was processed
+	result treeFile
+	clean  bool
+}
+
+// mergeRename is a rename found on one side of a merge.
+type mergeRename struct {
+	oldName, newName string
+	side             int
+}
+
+// treeMerge merges three trees. depth is above zero for the merges of
+// merge bases, whose conflicts are committed as they are.
+type treeMerge struct {
+	repo  string
+	depth int
+	// The labels of the conflict markers, also naming the sides in the
+	// messages
+	ancestor, branch1, branch2 string
+	style                      mergeStyle
+
+	entries  map[string]*mergeEntry
+	pairs    [3][]filePair
+	relevant [3]map[string]bool
+	// results holds the merged files and filled the directories that
+	// have any of them.
+	results    map[string]treeFile
+	filled     map[string]bool
+	conflicted map[string]*mergeEntry
+	messages   map[string][]string
+}
+
+// treeMergeResult is the outcome of a merge: the merged tree, with the
+// conflicted files as merged as they could be, the entries left
+// conflicted and the messages explaining them, by path.
+type treeMergeResult struct {
+	tree       string
+	files      map[string]treeFile
+	conflicted map[string]*mergeEntry
+	messages   map[string][]string
+	clean      bool
+}
+
+func newTreeMerge(repo string, depth int, ancestor string, branch1 string, branch2 string, style mergeStyle) *treeMerge {
+	merge := &treeMerge{
+		repo:       repo,
+		depth:      depth,
+		ancestor:   ancestor,
+		branch1:    branch1,
+		branch2:    branch2,
+		style:      style,
+		entries:    map[string]*mergeEntry{},
+		results:    map[string]treeFile{},
+		filled:     map[string]bool{},
+		conflicted: map[string]*mergeEntry{},
+		messages:   map[string][]string{},
+	}
+	merge.relevant[1], merge.relevant[2] = map[string]bool{}, map[string]bool{}
+	return merge
+}
+
+// mergeTrees merges the changes from baseTree to ours and to theirs.
+func (merge *treeMerge) mergeTrees(baseTree string, ours string, theirs string) treeMergeResult {
+	merge.collect("", [3]string{baseTree, ours, theirs})
+	merge.processRenames(merge.detectRenames())
+	merge.processEntries()
+	entries := make([]indexEntry, 0, len(merge.results))
+	for name, file := range merge.results {
+		entries = append(entries, indexEntry{name: name, mode: indexModeFromPerm(file.perm), sha: file.sha})
+	}
+	return treeMergeResult{
+		tree:       hex.EncodeToString(writeIndexTree(entries, "")),
+		files:      merge.results,
+		conflicted: merge.conflicted,
+		messages:   merge.messages,
+		clean:      len(merge.conflicted) == 0,
+	}
+}
+
+// message records a message about name. Those of the merges of merge
+// bases are dropped.
+func (merge *treeMerge) message(name string, format string, args ...any) {
+	if merge.depth > 0 {
+		return
+	}
+	merge.messages[name] = append(merge.messages[name], fmt.Sprintf(format, args...))
+}
+
+// collect walks the entries of the three trees below dir, which are ""
+// where a side has no directory.
+func (merge *treeMerge) collect(dir string, trees [3]string) {
+	var sides [3]map[string]tree
+	names := map[string]bool{}
+	for i, treeHex := range trees {
+		sides[i] = map[string]tree{}
+		for _, entry := range diffTreeEntries(merge.repo, treeHex) {
+			sides[i][entry.name] = entry
+			names[entry.name] = true
+		}
+	}
+	for name := range names {
+		fullName := path.Join(dir, name)
+		entry := &mergeEntry{pathnames: [3]string{fullName, fullName, fullName}}
+		present := [3]bool{}
+		for i := range 3 {
+			found, ok := sides[i][name]
+			if !ok {
+				continue
+			}
+			present[i] = true
+			if isTreePerm(found.perm) {
+				entry.dirmask |= 1 << i
+				entry.stages[i] = treeFile{perm: DIR, sha: found.sha}
+			} else {
+				entry.filemask |= 1 << i
+				entry.stages[i] = treeFile{perm: found.perm, sha: found.sha}
+			}
+		}
+		matches := func(i int, j int) bool {
+			return present[i] && present[j] && entry.stages[i] == entry.stages[j]
+		}
+		side1Base, side2Base, sidesMatch := matches(0, 1), matches(0, 2), matches(1, 2)
+		switch {
+		case side1Base && side2Base:
+			entry.matchMask = 7
+		case side1Base:
+			entry.matchMask = 3
+		case side2Base:
+			entry.matchMask = 5
+		case sidesMatch:
+			entry.matchMask = 6
+		}
+		merge.entries[fullName] = entry
+		resolved := -1
+		switch {
+		case entry.matchMask == 7:
+			resolved = 0
+		case entry.filemask == 7 && sidesMatch:
+			resolved = 1
+		case entry.filemask == 7 && side1Base:
+			resolved = 2
+		case entry.filemask == 7 && side2Base:
+			resolved = 1
+		}
+		if resolved >= 0 {
+			entry.result, entry.clean = entry.stages[resolved], true
+			continue
+		}
+		merge.collectRenamePairs(fullName, entry)
+		entry.dfConflict = entry.filemask != 0 && entry.dirmask != 0
+		if entry.dirmask != 0 {
+			subtrees := [3]string{}
+			for i := range 3 {
+				if entry.dirmask&(1<<i) != 0 {
+					subtrees[i] = hex.EncodeToString(entry.stages[i].sha[:])
+				}
+			}
+			merge.collect(fullName, subtrees)
+		}
+	}
+}
+
+// collectRenamePairs notes the files a side deleted or added at name as
+// possible rename sources and destinations. A source only needs to be
+// compared by content when the other side changed it too.
+func (merge *treeMerge) collectRenamePairs(name string, entry *mergeEntry) {
+	for side := 1; side <= 2; side++ {
+		sideMask := 1 << side
+		switch {
+		case entry.filemask&1 != 0 && entry.filemask&sideMask == 0:
+			base := entry.stages[0]
+			merge.pairs[side] = append(merge.pairs[side], filePair{name: name, old: diffSide{perm: base.perm, sha: base.sha}, status: 'D'})
+			if entry.matchMask&entry.filemask == 0 {
+				merge.relevant[side][name] = true
+			}
+		case entry.filemask&1 == 0 && entry.filemask&sideMask != 0:
+			added := entry.stages[side]
+			merge.pairs[side] = append(merge.pairs[side], filePair{name: name, new: diffSide{perm: added.perm, sha: added.sha}, status: 'A'})
+		}
+	}
+}
+
+// detectRenames finds the renames of both sides, ordered by source so
+// that a file renamed by both comes up twice in a row.
+func (merge *treeMerge) detectRenames() []mergeRename {
+	renames := []mergeRename{}
+	for side := 1; side <= 2; side++ {
+		options := renameOptions{detect: findRenames, minimumScore: defaultSimilarityScore, relevantSources: merge.relevant[side]}
+		slices.SortFunc(merge.pairs[side], func(a filePair, b filePair) int {
+			return strings.Compare(a.name, b.name)
+		})
+		for _, pair := range detectRenames(merge.repo, merge.pairs[side], options) {
+			if pair.status == 'R' {
+				renames = append(renames, mergeRename{oldName: pair.oldName, newName: pair.name, side: side})
+			}
+		}
+	}
+	slices.SortStableFunc(renames, func(a mergeRename, b mergeRename) int {
+		if c := strings.Compare(a.oldName, b.oldName); c != 0 {
+			return c
+		}
+		return a.side - b.side
+	})
+	return renames
+}
+
+// processRenames moves the stages of each rename source over to its
+// destination, merging the content right away where the destination
+// collides with another file, and turns the source into a removal.
+func (merge *treeMerge) processRenames(renames []mergeRename) {
+	for i := 0; i < len(renames); i++ {
+		rename := renames[i]
+		oldName, newName := rename.oldName, rename.newName
+		oldEntry, newEntry := merge.entries[oldName], merge.entries[newName]
+		if oldEntry == nil || oldEntry.clean {
+			continue
+		}
+		if i+1 < len(renames) && renames[i+1].oldName == oldName {
+			otherName := renames[i+1].newName
+			i++
+			side1, side2 := merge.entries[newName], merge.entries[otherName]
+			if newName == otherName {
+				// Both sides renamed it the same way
+				side1.stages[0] = oldEntry.stages[0]
+				side1.filemask |= 1
+				oldEntry.result, oldEntry.clean = treeFile{}, true
+				continue
+			}
+			pathnames := [3]string{oldName, newName, otherName}
+			merged, clean := merge.mergeContent(oldName, oldEntry.stages[0], side1.stages[1], side2.stages[2], pathnames, 1+2*merge.depth)
+			// Binary files are not merged but take our side, which is
+			// not what theirs should get
+			wasBinary := !clean && merged == side1.stages[1]
+			side1.stages[1] = merged
+			if wasBinary {
+				merged = side2.stages[2]
+			}
+			side2.stages[2] = merged
+			side1.pathConflict, side2.pathConflict, oldEntry.pathConflict = true, true, true
+			merge.message(oldName, "CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.", oldName, newName, merge.branch1, otherName, merge.branch2)
+			continue
+		}
+
+		target, other := rename.side, 3-rename.side
+		sourceDeleted := oldEntry.filemask == 1
+		collision := newEntry.filemask&(1<<other) != 0
+		typeChanged := !sourceDeleted && isRegularPerm(oldEntry.stages[other].perm) != isRegularPerm(newEntry.stages[target].perm)
+		if typeChanged && collision {
+			// A double rename, one of them hidden by a new file of
+			// another type at the source; treat it as a plain rename
+			collision = false
+		}
+		renameBranch, deleteBranch := merge.branch1, merge.branch2
+		if target == 2 {
+			renameBranch, deleteBranch = merge.branch2, merge.branch1
+		}
+		switch {
+		case collision && !sourceDeleted:
+			var pathnames [3]string
+			pathnames[0], pathnames[other], pathnames[target] = oldName, oldName, newName
+			sides := [3]*mergeEntry{oldEntry, nil, nil}
+			sides[other], sides[target] = oldEntry, newEntry
+			merged, clean := merge.mergeContent(oldName, oldEntry.stages[0], sides[1].stages[1], sides[2].stages[2], pathnames, 1+2*merge.depth)
+			newEntry.stages[target] = merged
+			if !clean {
+				merge.message(newName, "CONFLICT (rename involved in collision): rename of %s -> %s has content conflicts AND collides with another path; this may result in nested conflict markers.", oldName, newName)
+			}
+		case collision && sourceDeleted:
+			newEntry.pathConflict = true
+			merge.message(newName, "CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.", oldName, newName, renameBranch, deleteBranch)
+		default:
+			newEntry.stages[0] = oldEntry.stages[0]
+			newEntry.filemask |= 1
+			newEntry.pathnames[0] = oldName
+			switch {
+			case typeChanged:
+				oldEntry.stages[0] = treeFile{}
+				oldEntry.filemask &= 6
+			case sourceDeleted:
+				newEntry.pathConflict = true
+				merge.message(newName, "CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.", oldName, newName, renameBranch, deleteBranch)
+			default:
+				newEntry.stages[other] = oldEntry.stages[other]
+				newEntry.filemask |= 1 << other
+				newEntry.pathnames[other] = oldName
+			}
+		}
+		if !typeChanged {
+			oldEntry.result, oldEntry.clean = treeFile{}, true
+		}
+	}
+}
+
+// compareMergePaths sorts a directory right before what it contains.
+func compareMergePaths(a string, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	c1, c2 := byte('/'), byte('/')
+	if i < len(a) {
+		c1 = a[i]
+	}
+	if i < len(b) {
+		c2 = b[i]
+	}
+	if c1 == c2 {
+		if i < len(a) {
+			return 1
+		}
+		return -1
+	}
+	return int(c1) - int(c2)
+}
+
+// processEntries merges the entries, the contents of a directory before
+// the directory.
+func (merge *treeMerge) processEntries() {
+	names := make([]string, 0, len(merge.entries))
+	for name := range merge.entries {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, compareMergePaths)
+	for i := len(names) - 1; i >= 0; i-- {
+		entry := merge.entries[names[i]]
+		if entry.clean {
+			merge.record(names[i], entry.result)
+			continue
+		}
+		merge.processEntry(names[i], entry)
+	}
+}
+
+// record adds version to the merged tree at name.
+func (merge *treeMerge) record(name string, version treeFile) {
+	switch {
+	case version.perm == "":
+		return
+	case isTreePerm(version.perm):
+		flattenTree(merge.repo, hex.EncodeToString(version.sha[:]), name, merge.results)
+		merge.filled[name] = true
+	default:
+		merge.results[name] = version
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		merge.filled[dir] = true
+	}
+}
+
+// uniquePath finds a free path for a file that has to be moved away from
+// name, named after the branch it came from.
+func (merge *treeMerge) uniquePath(name string, branch string) string {
+	base := name + "~" + strings.ReplaceAll(branch, "/", "_")
+	unique := base
+	for suffix := 0; merge.entries[unique] != nil; suffix++ {
+		unique = fmt.Sprintf("%s_%d", base, suffix)
+	}
+	return unique
+}
+
+// dropDirectoryStages forgets the directories of entry once they no
+// longer matter to the file.
+func (entry *mergeEntry) dropDirectoryStages() {
+	entry.matchMask &^= entry.dirmask
+	entry.dirmask = 0
+	for i := range 3 {
+		if entry.filemask&(1<<i) == 0 {
+			entry.stages[i] = treeFile{}
+		}
+	}
+}
+
+func (merge *treeMerge) processEntry(name string, entry *mergeEntry) {
+	if entry.dirmask != 0 && entry.filemask == 0 {
+		return
+	}
+	dfIndex := 0
+	switch {
+	case entry.dfConflict && !merge.filled[name]:
+		// The directory went away, leaving the place to the file
+		entry.dfConflict = false
+		entry.dropDirectoryStages()
+	case entry.dfConflict:
+		if entry.filemask == 1 {
+			return
+		}
+		moved := *entry
+		moved.dropDirectoryStages()
+		dfIndex = 1
+		if entry.dirmask&2 != 0 {
+			dfIndex = 2
+		}
+		branch := merge.branch1
+		if dfIndex == 2 {
+			branch = merge.branch2
+		}
+		oldName := name
+		name = merge.uniquePath(name, branch)
+		merge.entries[name] = &moved
+		merge.message(name, "CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.", oldName, branch, name)
+		entry.filemask = 0
+		entry = &moved
+	}
+
+	switch {
+	case entry.matchMask != 0:
+		entry.clean = !entry.dfConflict && !entry.pathConflict
+		if entry.matchMask == 6 {
+			entry.result = entry.stages[1]
+			break
+		}
+		side := 1
+		if 7&^entry.matchMask == 4 {
+			side = 2
+		}
+		entry.result = entry.stages[side]
+		if entry.result.perm == "" {
+			entry.clean = true
+		}
+	case entry.filemask >= 6 && fileKind(entry.stages[1].perm) != fileKind(entry.stages[2].perm):
+		if merge.depth > 0 {
+			entry.result, entry.clean = entry.stages[0], false
+			break
+		}
+		base, ours, theirs := entry.stages[0], entry.stages[1], entry.stages[2]
+		renameOurs, renameTheirs := isRegularPerm(ours.perm), false
+		if !renameOurs {
+			renameTheirs = isRegularPerm(theirs.perm)
+			if !renameTheirs {
+				renameOurs, renameTheirs = true, true
+			}
+		}
+		which := "one"
+		if renameOurs && renameTheirs {
+			which = "both"
+		}
+		merge.message(name, "CONFLICT (distinct types): %s had different types on each side; renamed %s of them so each can be recorded somewhere.", name, which)
+		entry.clean = false
+		theirsEntry := *entry
+		theirsEntry.result = theirs
+		theirsEntry.stages[1] = treeFile{}
+		theirsEntry.filemask = 5
+		if fileKind(theirs.perm) != fileKind(base.perm) {
+			theirsEntry.stages[0] = treeFile{}
+			theirsEntry.filemask = 4
+		}
+		entry.result = ours
+		entry.stages[2] = treeFile{}
+		entry.filemask = 3
+		if fileKind(ours.perm) != fileKind(base.perm) {
+			entry.stages[0] = treeFile{}
+			entry.filemask = 2
+		}
+		oursName, theirsName := "", name
+		if renameOurs {
+			oursName = merge.uniquePath(name, merge.branch1)
+			merge.entries[oursName] = entry
+		}
+		if renameTheirs {
+			theirsName = merge.uniquePath(name, merge.branch2)
+		}
+		merge.entries[theirsName] = &theirsEntry
+		if renameOurs && renameTheirs {
+			delete(merge.entries, name)
+		}
+		merge.conflicted[theirsName] = &theirsEntry
+		merge.record(theirsName, theirsEntry.result)
+		if renameOurs {
+			name = oursName
+		}
+	case entry.filemask >= 6:
+		merged, clean := merge.mergeContent(name, entry.stages[0], entry.stages[1], entry.stages[2], entry.pathnames, 2*merge.depth)
+		entry.clean = clean && !entry.dfConflict && !entry.pathConflict
+		entry.result = merged
+		if clean && entry.dfConflict {
+			entry.filemask = 1 << dfIndex
+			entry.stages[dfIndex] = merged
+		}
+		if !clean {
+			reason := "content"
+			if entry.filemask == 6 {
+				reason = "add/add"
+			}
+			if merged.perm == GITLINK {
+				reason = "submodule"
+			}
+			merge.message(name, "CONFLICT (%s): Merge conflict in %s", reason, name)
+		}
+	case entry.filemask == 3 || entry.filemask == 5:
+		side := 1
+		if entry.filemask == 5 {
+			side = 2
+		}
+		entry.result = entry.stages[side]
+		if merge.depth > 0 {
+			entry.result = entry.stages[0]
+		}
+		entry.clean = false
+		modifyBranch, deleteBranch := merge.branch1, merge.branch2
+		if side == 2 {
+			modifyBranch, deleteBranch = merge.branch2, merge.branch1
+		}
+		// A rename/delete conflict has already been reported when the
+		// content is unchanged
+		if !entry.pathConflict || entry.stages[0].sha != entry.stages[side].sha {
+			merge.message(name, "CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.", name, deleteBranch, modifyBranch, modifyBranch, name)
+		}
+	case entry.filemask == 2 || entry.filemask == 4:
+		side := 1
+		if entry.filemask == 4 {
+			side = 2
+		}
+		entry.result = entry.stages[side]
+		entry.clean = !entry.dfConflict && !entry.pathConflict
+	case entry.filemask == 1:
+		entry.result = treeFile{}
+		entry.clean = !entry.pathConflict
+	}
+	if !entry.clean {
+		merge.conflicted[name] = entry
+	}
+	merge.record(name, entry.result)
+}
+
+// mergeContent merges the versions of a file of the same type, naming
+// the sides in the conflict markers after pathnames when the file was
+// renamed. It reports whether the merge was clean.
+func (merge *treeMerge) mergeContent(name string, base treeFile, ours treeFile, theirs treeFile, pathnames [3]string, extraMarkerSize int) (treeFile, bool) {
+	result := treeFile{perm: theirs.perm}
+	clean := true
+	if ours.perm != theirs.perm && ours.perm != base.perm {
+		result.perm = ours.perm
+		clean = theirs.perm == base.perm
+	}
+	twoWay := fileKind(base.perm) != fileKind(ours.perm)
+	switch {
+	case ours.sha == theirs.sha || ours.sha == base.sha:
+		result.sha = theirs.sha
+	case theirs.sha == base.sha:
+		result.sha = ours.sha
+	case isRegularPerm(ours.perm):
+		var baseContent []byte
+		if !twoWay {
+			baseContent = readBlob(merge.repo, hex.EncodeToString(base.sha[:]))
+		}
+		oursContent := readBlob(merge.repo, hex.EncodeToString(ours.sha[:]))
+		theirsContent := readBlob(merge.repo, hex.EncodeToString(theirs.sha[:]))
+		labels := [3]string{merge.ancestor, merge.branch1, merge.branch2}
+		if pathnames[0] != pathnames[1] || pathnames[1] != pathnames[2] {
+			labels = [3]string{merge.ancestor + ":" + pathnames[0], merge.branch1 + ":" + pathnames[1], merge.branch2 + ":" + pathnames[2]}
+		}
+		var merged []byte
+		conflicts := 0
+		switch {
+		case isBinaryContent(baseContent) || isBinaryContent(oursContent) || isBinaryContent(theirsContent):
+			// The merges of merge bases keep the base, which is as
+			// good an ancestor as any
+			merged = baseContent
+			if merge.depth == 0 {
+				merged, conflicts = oursContent, 1
+				merge.message(name, "warning: Cannot merge binary files: %s (%s vs. %s)", name, labels[1], labels[2])
+			}
+		default:
+			merged, conflicts = mergeLines(baseContent, oursContent, theirsContent, lineMergeOptions{
+				lineDiffOptions: lineDiffOptions{algorithm: "myers"},
+				level:           mergeZealous,
+				style:           merge.style,
+				markerSize:      defaultMarkerSize + extraMarkerSize,
+				ancestor:        labels[0],
+				ours:            labels[1],
+				theirs:          labels[2],
+			})
+		}
+		hash, err := hex.DecodeString(createBlobObject(merged))
+		exitIfError(err, fmt.Sprintf("fatal: unable to add %s to database", name))
+		result.sha = [20]byte(hash)
+		clean = clean && conflicts == 0
+		merge.message(name, "Auto-merging %s", name)
+	case ours.perm == GITLINK:
+		// Submodules are not cloned, so there is nothing to merge them
+		// with
+		clean = false
+		result.sha = ours.sha
+		if merge.depth > 0 && twoWay {
+			result = base
+		}
+	case ours.perm == SYMLINK:
+		clean = false
+		result.sha = ours.sha
+		if merge.depth > 0 {
+			result = base
+		}
+	}
+	return result, clean
+}
+
+// commitMerge merges commits, standing in made up commits for the merges
+// of their merge bases.
+type commitMerge struct {
+	repo  string
+	walk  *ancestryWalk
+	style mergeStyle
+	// virtual holds the trees of the made up commits.
+	virtual map[string]string
+}
+
+// mergeCommits merges the changes other made since its merge bases with
+// head into head, naming the sides branch1 and branch2.
+func mergeCommits(repo string, head string, other string, branch1 string, branch2 string, style mergeStyle) treeMergeResult {
+	merge := &commitMerge{repo: repo, walk: newAncestryWalk(repo), style: style, virtual: map[string]string{}}
+	return merge.merge(head, other, 0, branch1, branch2)
+}
+
+func (merge *commitMerge) tree(hexHash string) string {
+	if treeHex, ok := merge.virtual[hexHash]; ok {
+		return treeHex
+	}
+	return readCommit(merge.repo, hexHash).tree
+}
+
+// virtualCommit makes up a commit for treeHex with the given parents,
+// which the ancestry walk treats as newer than anything.
+func (merge *commitMerge) virtualCommit(treeHex string, parents ...string) string {
+	name := fmt.Sprintf("virtual commit %d", len(merge.virtual))
+	merge.virtual[name] = treeHex
+	merge.walk.commits[name] = &ancestryCommit{parents: parents, generation: generationInfinity}
+	return name
+}
+
+func (merge *commitMerge) merge(head string, other string, depth int, branch1 string, branch2 string) treeMergeResult {
+	bases := merge.walk.mergeBases(head, []string{other})
+	slices.Reverse(bases)
+	var base, ancestor string
+	switch {
+	case len(bases) == 0:
+		base, ancestor = merge.virtualCommit(emptyTreeHex), "empty tree"
+	case len(bases) > 1:
+		base, ancestor = bases[0], "merged common ancestors"
+	default:
+		base, ancestor = bases[0], bases[0][:7]
+	}
+	for _, next := range bases[min(1, len(bases)):] {
+		merged := merge.merge(base, next, depth+1, "Temporary merge branch 1", "Temporary merge branch 2")
+		base = merge.virtualCommit(merged.tree, base, next)
+	}
+	return newTreeMerge(merge.repo, depth, ancestor, branch1, branch2, merge.style).mergeTrees(merge.tree(base), merge.tree(head), merge.tree(other))
+}
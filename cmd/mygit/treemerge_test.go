@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompareMergePaths(t *testing.T) {
+	names := []string{"a/b", "a-b", "a", "a/b/c", "ab"}
+	slices.SortFunc(names, compareMergePaths)
+	if want := []string{"a-b", "a", "a/b", "a/b/c", "ab"}; !slices.Equal(names, want) {
+		t.Errorf("sorted %v, want %v", names, want)
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	merge := &treeMerge{entries: map[string]*mergeEntry{"f": {}, "f~topic_x": {}, "f~topic_x_0": {}}}
+	if got, want := merge.uniquePath("f", "topic/x"), "f~topic_x_1"; got != want {
+		t.Errorf("uniquePath = %q, want %q", got, want)
+	}
+	if got, want := merge.uniquePath("g", "HEAD"), "g~HEAD"; got != want {
+		t.Errorf("uniquePath = %q, want %q", got, want)
+	}
+}
+
+// newMergeTreeRepo creates branches main and side changing the same line
+// of c, each adding a file of its own, and a branch clean adding n on top
+// of the commit main starts from. The tree hashes below come from git.
+func newMergeTreeRepo(t *testing.T) string {
+	t.Helper()
+	repo := newTestRepo(t)
+	commit := func(message string, files map[string]string) {
+		for name, content := range files {
+			writeTestFile(t, repo, name, content)
+			mygit(t, repo, "add", name)
+		}
+		mygit(t, repo, "commit", "-m", message)
+	}
+	commit("base", map[string]string{"c": "1\n2\n3\n", "keep": "k\n"})
+	mygit(t, repo, "switch", "-c", "side")
+	commit("side", map[string]string{"c": "1\nside\n3\n", "s": "s\n"})
+	mygit(t, repo, "switch", "-c", "clean", "main")
+	commit("clean", map[string]string{"n": "n\n"})
+	mygit(t, repo, "switch", "main")
+	commit("main", map[string]string{"c": "1\nmain\n3\n", "m": "m\n"})
+	return repo
+}
+
+func TestMergeTreeWriteTree(t *testing.T) {
+	repo := newMergeTreeRepo(t)
+	cases := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"main", "side"}, 1, "88f89aebb996d4808f0e89ebfa5709d470f1f4b0\n" +
+			"100644 01e79c32a8c99c557f0757da7cb6d65b3414466d 1\tc\n" +
+			"100644 3e99f1cd4053a970965394ec868f74d9dc0ab6e8 2\tc\n" +
+			"100644 cd3b0e37e2c34d75ff2f18c53dbb455ae6a8b462 3\tc\n" +
+			"\nAuto-merging c\nCONFLICT (content): Merge conflict in c\n"},
+		{[]string{"--name-only", "--no-messages", "main", "side"}, 1, "88f89aebb996d4808f0e89ebfa5709d470f1f4b0\nc\n"},
+		{[]string{"main", "clean"}, 0, "05c3a561a6eb9725bcee4111cb94c4fa5eaa3c35\n"},
+	}
+	for _, c := range cases {
+		result := runMygit(t, repo, "", append([]string{"merge-tree", "--write-tree"}, c.args...)...)
+		if result.code != c.code || result.stdout != c.want {
+			t.Errorf("merge-tree --write-tree %v = %d\n%s\nwant %d\n%s", c.args, result.code, result.stdout, c.code, c.want)
+		}
+	}
+}
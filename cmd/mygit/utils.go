@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
 )
 
 func exitIfError(err error, msg string) {
@@ -21,6 +26,15 @@ func exitIfError(err error, msg string) {
 	}
 }
 
+// requireRepository exits the way git does when dir is not the top of a
+// repository, before a command creates parts of one there.
+func requireRepository(dir string) {
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): .git\n")
+		os.Exit(128)
+	}
+}
+
 func getOctetFromByte(b byte) string {
 	bits := fmt.Sprintf("%b", b)
 	for range 8 - len(bits) {
@@ -71,7 +85,7 @@ func getPktLinedData(raw string) string {
 	rawDataLen := len(raw)
 	// 4 per length data, 1 for newLine character
 	totalLen := rawDataLen + 4 + 1
-	hexLen := hex.EncodeToString([]byte(string(totalLen)))
+	hexLen := fmt.Sprintf("%x", totalLen)
 	missingBytes := 4 - len(hexLen)
 	lenStr := ""
 	for range missingBytes {
@@ -155,3 +169,67 @@ func writeHeaderToContent(data []byte, objectType Object) []byte {
 	contentWithHeader = append(contentWithHeader, data...)
 	return contentWithHeader
 }
+
+// launchEditor opens file in the editor configured through $GIT_EDITOR,
+// core.editor, $VISUAL or $EDITOR, falling back to vi, and waits for it to
+// exit.
+func launchEditor(file string, config *ini.File) {
+	editor := os.Getenv("GIT_EDITOR")
+	if editor == "" {
+		editor = configValue(".", config, "core", "editor")
+	}
+	for _, variable := range []string{"VISUAL", "EDITOR"} {
+		if editor == "" {
+			editor = os.Getenv(variable)
+		}
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	runEditor(editor, file)
+}
+
+// launchSequenceEditor opens the todo list of an interactive rebase in
+// $GIT_SEQUENCE_EDITOR or sequence.editor, falling back to the editor of
+// commit messages.
+func launchSequenceEditor(file string, config *ini.File) {
+	editor := os.Getenv("GIT_SEQUENCE_EDITOR")
+	if editor == "" {
+		editor = configValue(".", config, "sequence", "editor")
+	}
+	if editor == "" {
+		launchEditor(file, config)
+		return
+	}
+	runEditor(editor, file)
+}
+
+func runEditor(editor string, file string) {
+	if editor == ":" {
+		return
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, file)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: There was a problem with the editor '%s'.\n", editor)
+		os.Exit(1)
+	}
+}
+
+// attachOptionalArguments joins the options in names with the argument
+// following them, when there is one, so that like in git "--merged main"
+// means --merged=main while a bare --merged keeps its default value.
+func attachOptionalArguments(arguments []string, names ...string) []string {
+	attached := []string{}
+	for i := 0; i < len(arguments); i++ {
+		argument := arguments[i]
+		if slices.Contains(names, argument) && i+1 < len(arguments) && !strings.HasPrefix(arguments[i+1], "-") {
+			argument += "=" + arguments[i+1]
+			i++
+		}
+		attached = append(attached, argument)
+	}
+	return attached
+}
@@ -0,0 +1,1129 @@
+// Derived from git's xdiff: xprepare.c, xdiffi.c, xpatience.c and
+// xhistogram.c.
+//
+//  LibXDiff by Davide Libenzi ( File Differential Library )
+//  Copyright (C) 2003-2016 Davide Libenzi, Johannes E. Schindelin
+//
+//  This library is free software; you can redistribute it and/or
+//  modify it under the terms of the GNU Lesser General Public
+//  License as published by the Free Software Foundation; either
+//  version 2.1 of the License, or (at your option) any later version.
+//
+//  This library is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+//  Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public
+//  License along with this library; if not, see
+//  <http://www.gnu.org/licenses/>.
+//
+// The histogram diff is derived from xhistogram.c:
+//
+//  Copyright (C) 2010, Google Inc.
+//  and other copyright owners as documented in JGit's IP log.
+//
+//  This program and the accompanying materials are made available
+//  under the terms of the Eclipse Distribution License v1.0 which
+//  accompanies this distribution, is reproduced below, and is
+//  available at http://www.eclipse.org/org/documents/edl-v10.php
+//
+//  All rights reserved.
+//
+//  Redistribution and use in source and binary forms, with or
+//  without modification, are permitted provided that the following
+//  conditions are met:
+//
+//  - Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+//
+//  - Redistributions in binary form must reproduce the above
+//    copyright notice, this list of conditions and the following
+//    disclaimer in the documentation and/or other materials provided
+//    with the distribution.
+//
+//  - Neither the name of the Eclipse Foundation, Inc. nor the
+//    names of its contributors may be used to endorse or promote
+//    products derived from this software without specific prior
+//    written permission.
+//
+//  THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND
+//  CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+//  INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES
+//  OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+//  ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR
+//  CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+//  SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT
+//  NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+//  LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//  CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+//  STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+//  ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+//  ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+/*
+*  ###################### LINE DIFF ##############################
+*
+*  A port of the xdiff library git compares files with. Lines are
+*  classified first so that equal lines share a number. Myers' algorithm,
+*  or the patience or histogram algorithm, then marks the changed lines of
+*  each side. Groups of changed lines are finally slid to where git shows
+*  them, and turned into a list of changes.
+ */
+
+// lineDiffOptions select the algorithm and which whitespace differences
+// make lines unequal.
+type lineDiffOptions struct {
+	// algorithm is "myers", "minimal", "patience" or "histogram"
+	algorithm         string
+	ignoreAllSpace    bool
+	ignoreSpaceChange bool
+	ignoreSpaceAtEOL  bool
+	// noIndentHeuristic leaves groups of changes that can slide where
+	// they are instead of placing them by indentation, as merges do
+	noIndentHeuristic bool
+}
+
+// lineChange replaces chg1 lines of the old side starting at i1 with chg2
+// lines of the new side starting at i2.
+type lineChange struct {
+	i1, i2     int
+	chg1, chg2 int
+}
+
+// xdfile is one side of a diff.
+type xdfile struct {
+	recs []string
+	// ha is the class of each line, equal for equal lines
+	ha []int
+	// changed marks changed lines, with an unchanged entry on either end
+	changed []bool
+	// dstart and dend delimit the lines between the common head and tail
+	dstart, dend int
+	// rindex and rha are the lines left to compare by Myers' algorithm
+	rindex []int
+	rha    []int
+}
+
+func (file *xdfile) isChanged(i int) bool {
+	return file.changed[i+1]
+}
+
+func (file *xdfile) setChanged(i int, changed bool) {
+	file.changed[i+1] = changed
+}
+
+// splitLines splits data into lines that keep their newline.
+func splitLines(data []byte) []string {
+	lines := []string{}
+	for len(data) > 0 {
+		end := len(data)
+		for i, b := range data {
+			if b == '\n' {
+				end = i + 1
+				break
+			}
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+func isXdiffSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lineKey returns what lines are compared by, under the whitespace options.
+func lineKey(line string, options lineDiffOptions) string {
+	if !options.ignoreAllSpace && !options.ignoreSpaceChange && !options.ignoreSpaceAtEOL {
+		return line
+	}
+	var key strings.Builder
+	space := false
+	for i := 0; i < len(line); i++ {
+		if !isXdiffSpace(line[i]) {
+			if space && options.ignoreSpaceChange {
+				key.WriteByte(' ')
+			}
+			space = false
+			key.WriteByte(line[i])
+			continue
+		}
+		if options.ignoreAllSpace || options.ignoreSpaceChange {
+			space = true
+			continue
+		}
+		// Only the trailing whitespace is ignored
+		end := i
+		for end < len(line) && isXdiffSpace(line[end]) {
+			end++
+		}
+		if end < len(line) {
+			key.WriteString(line[i:end])
+		}
+		i = end - 1
+	}
+	return key.String()
+}
+
+// lineClasses numbers the distinct lines of both sides and counts how often
+// each occurs on either side.
+type lineClasses struct {
+	ids    map[string]int
+	count1 []int
+	count2 []int
+}
+
+func (classes *lineClasses) classify(line string, options lineDiffOptions, side int) int {
+	key := lineKey(line, options)
+	id, ok := classes.ids[key]
+	if !ok {
+		id = len(classes.count1)
+		classes.ids[key] = id
+		classes.count1 = append(classes.count1, 0)
+		classes.count2 = append(classes.count2, 0)
+	}
+	if side == 1 {
+		classes.count1[id]++
+	} else {
+		classes.count2[id]++
+	}
+	return id
+}
+
+func newXdfile(lines []string, classes *lineClasses, options lineDiffOptions, side int) *xdfile {
+	file := &xdfile{recs: lines, changed: make([]bool, len(lines)+2), dstart: 0, dend: len(lines) - 1}
+	for _, line := range lines {
+		file.ha = append(file.ha, classes.classify(line, options, side))
+	}
+	return file
+}
+
+// prepareDiff classifies the lines of both sides. For Myers' algorithm, the
+// common head and tail are set aside and lines that cannot match are
+// marked changed right away.
+func prepareDiff(a []string, b []string, options lineDiffOptions, optimize bool) (*xdfile, *xdfile, *lineClasses) {
+	classes := &lineClasses{ids: map[string]int{}}
+	file1 := newXdfile(a, classes, options, 1)
+	file2 := newXdfile(b, classes, options, 2)
+	if optimize {
+		trimCommonEnds(file1, file2)
+		cleanupRecords(classes, file1, file2)
+	}
+	return file1, file2, classes
+}
+
+func trimCommonEnds(file1 *xdfile, file2 *xdfile) {
+	limit := min(len(file1.recs), len(file2.recs))
+	i := 0
+	for i < limit && file1.ha[i] == file2.ha[i] {
+		i++
+	}
+	file1.dstart, file2.dstart = i, i
+	limit -= i
+	j := 0
+	for j < limit && file1.ha[len(file1.recs)-1-j] == file2.ha[len(file2.recs)-1-j] {
+		j++
+	}
+	file1.dend = len(file1.recs) - j - 1
+	file2.dend = len(file2.recs) - j - 1
+}
+
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+const (
+	maxEqualLimit    = 1024
+	simscanWindow    = 100
+	keepDiscardRun   = 4
+	maxCostMin       = 256
+	snakeCount       = 20
+	heuristicMinCost = 256
+	heuristicFactor  = 4
+)
+
+// cleanupRecords leaves out of the Myers search the lines without a match
+// on the other side, and lines with many matches amid such lines.
+func cleanupRecords(classes *lineClasses, file1 *xdfile, file2 *xdfile) {
+	discards := func(file *xdfile, counts []int) []byte {
+		limit := min(bogoSqrt(len(file.recs)), maxEqualLimit)
+		discard := make([]byte, len(file.recs)+1)
+		for i := file.dstart; i <= file.dend; i++ {
+			matches := counts[file.ha[i]]
+			switch {
+			case matches == 0:
+				discard[i] = 0
+			case matches >= limit:
+				discard[i] = 2
+			default:
+				discard[i] = 1
+			}
+		}
+		return discard
+	}
+	discard1 := discards(file1, classes.count2)
+	discard2 := discards(file2, classes.count1)
+	keep := func(file *xdfile, discard []byte) {
+		for i := file.dstart; i <= file.dend; i++ {
+			if discard[i] == 1 || (discard[i] == 2 && !cleanMultimatch(discard, i, file.dstart, file.dend)) {
+				file.rindex = append(file.rindex, i)
+				file.rha = append(file.rha, file.ha[i])
+			} else {
+				file.setChanged(i, true)
+			}
+		}
+	}
+	keep(file1, discard1)
+	keep(file2, discard2)
+}
+
+// cleanMultimatch reports whether the line i, which has many matches,
+// sits in a run of mostly unmatched lines and can be discarded.
+func cleanMultimatch(discard []byte, i int, start int, end int) bool {
+	if i-start > simscanWindow {
+		start = i - simscanWindow
+	}
+	if end-i > simscanWindow {
+		end = i + simscanWindow
+	}
+	unmatchedBefore, multiBefore := 0, 1
+	for r := 1; i-r >= start; r++ {
+		if discard[i-r] == 0 {
+			unmatchedBefore++
+		} else if discard[i-r] == 2 {
+			multiBefore++
+		} else {
+			break
+		}
+	}
+	if unmatchedBefore == 0 {
+		return false
+	}
+	unmatchedAfter, multiAfter := 0, 1
+	for r := 1; i+r <= end; r++ {
+		if discard[i+r] == 0 {
+			unmatchedAfter++
+		} else if discard[i+r] == 2 {
+			multiAfter++
+		} else {
+			break
+		}
+	}
+	if unmatchedAfter == 0 {
+		return false
+	}
+	unmatched := unmatchedBefore + unmatchedAfter
+	multi := multiBefore + multiAfter
+	return multi*keepDiscardRun < multi+unmatched
+}
+
+// myersEnv holds the limits and the diagonal vectors of the search.
+type myersEnv struct {
+	maxCost int
+	kvdf    []int
+	kvdb    []int
+	// base is the index of diagonal 0 in the vectors
+	base int
+}
+
+type myersSplit struct {
+	i1, i2       int
+	minLo, minHi bool
+}
+
+// myersDiff runs Myers' algorithm over the lines prepareDiff left.
+func myersDiff(file1 *xdfile, file2 *xdfile, needMinimal bool) {
+	diagonals := len(file1.rindex) + len(file2.rindex) + 3
+	env := &myersEnv{
+		maxCost: max(bogoSqrt(diagonals), maxCostMin),
+		kvdf:    make([]int, diagonals),
+		kvdb:    make([]int, diagonals),
+		base:    len(file2.rindex) + 1,
+	}
+	recordsCompare(file1, 0, len(file1.rindex), file2, 0, len(file2.rindex), needMinimal, env)
+}
+
+func recordsCompare(file1 *xdfile, off1 int, lim1 int, file2 *xdfile, off2 int, lim2 int, needMinimal bool, env *myersEnv) {
+	ha1, ha2 := file1.rha, file2.rha
+	for off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			file2.setChanged(file2.rindex[off2], true)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			file1.setChanged(file1.rindex[off1], true)
+		}
+	default:
+		split := myersSplitPoint(ha1, off1, lim1, ha2, off2, lim2, needMinimal, env)
+		recordsCompare(file1, off1, split.i1, file2, off2, split.i2, split.minLo, env)
+		recordsCompare(file1, split.i1, lim1, file2, split.i2, lim2, split.minHi, env)
+	}
+}
+
+// myersSplitPoint finds where the shortest edit script crosses the middle
+// diagonal, or a good enough point once the search gets too expensive.
+func myersSplitPoint(ha1 []int, off1 int, lim1 int, ha2 []int, off2 int, lim2 int, needMinimal bool, env *myersEnv) myersSplit {
+	kvdf := func(d int) *int { return &env.kvdf[env.base+d] }
+	kvdb := func(d int) *int { return &env.kvdb[env.base+d] }
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > snakeCount {
+				gotSnake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return myersSplit{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = math.MaxInt
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = math.MaxInt
+		} else {
+			bmax--
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > snakeCount {
+				gotSnake = true
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return myersSplit{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+
+		if needMinimal {
+			continue
+		}
+
+		// Past some cost, settle for a diagonal that got far with a
+		// long enough run of matching lines
+		if gotSnake && ec > heuristicMinCost {
+			best, split := 0, myersSplit{}
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := fmid - d
+				if d > fmid {
+					dd = d - fmid
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > heuristicFactor*ec && v > best &&
+					off1+snakeCount <= i1 && i1 < lim1 &&
+					off2+snakeCount <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == snakeCount {
+							best = v
+							split = myersSplit{i1: i1, i2: i2}
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				split.minLo, split.minHi = true, false
+				return split
+			}
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := bmid - d
+				if d > bmid {
+					dd = d - bmid
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > heuristicFactor*ec && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCount &&
+					off2 < i2 && i2 <= lim2-snakeCount {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == snakeCount-1 {
+							best = v
+							split = myersSplit{i1: i1, i2: i2}
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				split.minLo, split.minHi = false, true
+				return split
+			}
+		}
+
+		// Enough is enough: take the furthest reaching path
+		if ec >= env.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := min(*kvdf(d), lim1)
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := math.MaxInt, math.MaxInt
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := max(off1, *kvdb(d))
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return myersSplit{i1: fbest1, i2: fbest - fbest1, minLo: true}
+			}
+			return myersSplit{i1: bbest1, i2: bbest - bbest1, minHi: true}
+		}
+	}
+}
+
+// markAllChanged marks count lines from the 1-based line as changed.
+func (file *xdfile) markAllChanged(line int, count int) {
+	for ; count > 0; count-- {
+		file.setChanged(line-1, true)
+		line++
+	}
+}
+
+// fallBackToMyers diffs a range of lines with Myers' algorithm, which the
+// patience and histogram algorithms do where they find no unique lines.
+func fallBackToMyers(file1 *xdfile, file2 *xdfile, options lineDiffOptions, line1 int, count1 int, line2 int, count2 int) {
+	options.algorithm = "myers"
+	sub1, sub2 := doLineDiff(file1.recs[line1-1:line1-1+count1], file2.recs[line2-1:line2-1+count2], options)
+	copy(file1.changed[line1:line1+count1], sub1.changed[1:1+count1])
+	copy(file2.changed[line2:line2+count2], sub2.changed[1:1+count2])
+}
+
+type patienceEntry struct {
+	line1, line2 int
+	hash         int
+	next         *patienceEntry
+	previous     *patienceEntry
+}
+
+// nonUnique marks a patienceEntry whose line is not unique on both sides.
+const nonUnique = -1
+
+func patienceDiff(file1 *xdfile, file2 *xdfile, options lineDiffOptions, line1 int, count1 int, line2 int, count2 int) {
+	if count1 == 0 {
+		file2.markAllChanged(line2, count2)
+		return
+	}
+	if count2 == 0 {
+		file1.markAllChanged(line1, count1)
+		return
+	}
+
+	// Index the lines of the first side in order, noting whether they
+	// are unique on both sides
+	entries := map[int]*patienceEntry{}
+	var first, last *patienceEntry
+	hasMatches := false
+	for i := line1; i < line1+count1; i++ {
+		hash := file1.ha[i-1]
+		if entry, ok := entries[hash]; ok {
+			entry.line2 = nonUnique
+			continue
+		}
+		entry := &patienceEntry{line1: i, hash: hash}
+		entries[hash] = entry
+		if first == nil {
+			first = entry
+		}
+		if last != nil {
+			last.next = entry
+			entry.previous = last
+		}
+		last = entry
+	}
+	for i := line2; i < line2+count2; i++ {
+		entry, ok := entries[file2.ha[i-1]]
+		if !ok {
+			continue
+		}
+		hasMatches = true
+		if entry.line2 != 0 {
+			entry.line2 = nonUnique
+		} else {
+			entry.line2 = i
+		}
+	}
+	if !hasMatches {
+		file1.markAllChanged(line1, count1)
+		file2.markAllChanged(line2, count2)
+		return
+	}
+
+	// The longest sequence of unique common lines in the same order on
+	// both sides, by patience sorting
+	sequence := []*patienceEntry{}
+	for entry := first; entry != nil; entry = entry.next {
+		if entry.line2 == 0 || entry.line2 == nonUnique {
+			continue
+		}
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > entry.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		entry.previous = nil
+		if left >= 0 {
+			entry.previous = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, entry)
+		} else {
+			sequence[left+1] = entry
+		}
+	}
+	if len(sequence) == 0 {
+		fallBackToMyers(file1, file2, options, line1, count1, line2, count2)
+		return
+	}
+	entry := sequence[len(sequence)-1]
+	entry.next = nil
+	for entry.previous != nil {
+		entry.previous.next = entry
+		entry = entry.previous
+	}
+
+	// Diff the ranges between the common lines
+	end1, end2 := line1+count1, line2+count2
+	match := func(a int, b int) bool { return file1.ha[a-1] == file2.ha[b-1] }
+	for common := entry; ; {
+		var next1, next2 int
+		if common != nil {
+			next1, next2 = common.line1, common.line2
+			for next1 > line1 && next2 > line2 && match(next1-1, next2-1) {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && match(line1, line2) {
+			line1++
+			line2++
+		}
+		if next1 > line1 || next2 > line2 {
+			patienceDiff(file1, file2, options, line1, next1-line1, line2, next2-line2)
+		}
+		if common == nil {
+			return
+		}
+		for common.next != nil && common.next.line1 == common.line1+1 && common.next.line2 == common.line2+1 {
+			common = common.next
+		}
+		line1, line2 = common.line1+1, common.line2+1
+		common = common.next
+	}
+}
+
+// histogramMaxChain is how often a line may occur and still anchor the
+// histogram algorithm.
+const histogramMaxChain = 64
+
+type histogramRegion struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+func histogramDiff(file1 *xdfile, file2 *xdfile, options lineDiffOptions, line1 int, count1 int, line2 int, count2 int) {
+	for {
+		if count1 <= 0 && count2 <= 0 {
+			return
+		}
+		if count1 == 0 {
+			file2.markAllChanged(line2, count2)
+			return
+		}
+		if count2 == 0 {
+			file1.markAllChanged(line1, count1)
+			return
+		}
+		lcs, fallBack := histogramLCS(file1, file2, line1, count1, line2, count2)
+		if fallBack {
+			fallBackToMyers(file1, file2, options, line1, count1, line2, count2)
+			return
+		}
+		if lcs.begin1 == 0 && lcs.begin2 == 0 {
+			file1.markAllChanged(line1, count1)
+			file2.markAllChanged(line2, count2)
+			return
+		}
+		histogramDiff(file1, file2, options, line1, lcs.begin1-line1, line2, lcs.begin2-line2)
+		end1, end2 := line1+count1-1, line2+count2-1
+		count1, line1 = end1-lcs.end1, lcs.end1+1
+		count2, line2 = end2-lcs.end2, lcs.end2+1
+	}
+}
+
+// histogramLCS finds the longest run of common lines, preferring the runs
+// whose lines are rare on the first side. It asks for a fallback when the
+// common lines are all too frequent.
+func histogramLCS(file1 *xdfile, file2 *xdfile, line1 int, count1 int, line2 int, count2 int) (histogramRegion, bool) {
+	end1, end2 := line1+count1-1, line2+count2-1
+	// For each class, the occurrences on the first side from first to
+	// last, chained through next
+	type record struct {
+		ptr, cnt int
+	}
+	records := map[int]*record{}
+	lineRecord := make([]*record, count1)
+	next := make([]int, count1)
+	for ptr := end1; ptr >= line1; ptr-- {
+		hash := file1.ha[ptr-1]
+		if rec, ok := records[hash]; ok {
+			next[ptr-line1] = rec.ptr
+			rec.ptr = ptr
+			rec.cnt++
+			lineRecord[ptr-line1] = rec
+			continue
+		}
+		rec := &record{ptr: ptr, cnt: 1}
+		records[hash] = rec
+		lineRecord[ptr-line1] = rec
+	}
+
+	lcs := histogramRegion{}
+	bestCount := histogramMaxChain + 1
+	hasCommon := false
+	match := func(a int, b int) bool { return file1.ha[a-1] == file2.ha[b-1] }
+	for bPtr := line2; bPtr <= end2; {
+		bNext := bPtr + 1
+		rec, ok := records[file2.ha[bPtr-1]]
+		if ok && rec.cnt > bestCount {
+			hasCommon = true
+			rec = nil
+		}
+		if rec != nil {
+			hasCommon = true
+			as := rec.ptr
+			for {
+				np := next[as-line1]
+				bs, ae, be := bPtr, as, bPtr
+				rc := rec.cnt
+				for line1 < as && line2 < bs && match(as-1, bs-1) {
+					as--
+					bs--
+					if rc > 1 {
+						rc = min(rc, lineRecord[as-line1].cnt)
+					}
+				}
+				for ae < end1 && be < end2 && match(ae+1, be+1) {
+					ae++
+					be++
+					if rc > 1 {
+						rc = min(rc, lineRecord[ae-line1].cnt)
+					}
+				}
+				if bNext <= be {
+					bNext = be + 1
+				}
+				if lcs.end1-lcs.begin1 < ae-as || rc < bestCount {
+					lcs = histogramRegion{begin1: as, begin2: bs, end1: ae, end2: be}
+					bestCount = rc
+				}
+				if np == 0 {
+					break
+				}
+				for np != 0 && np <= ae {
+					np = next[np-line1]
+				}
+				if np == 0 {
+					break
+				}
+				as = np
+			}
+		}
+		bPtr = bNext
+	}
+	return lcs, hasCommon && histogramMaxChain < bestCount
+}
+
+// doLineDiff marks the changed lines of a and b.
+func doLineDiff(a []string, b []string, options lineDiffOptions) (*xdfile, *xdfile) {
+	switch options.algorithm {
+	case "patience":
+		file1, file2, _ := prepareDiff(a, b, options, false)
+		patienceDiff(file1, file2, options, 1, len(a), 1, len(b))
+		return file1, file2
+	case "histogram":
+		file1, file2, _ := prepareDiff(a, b, options, false)
+		histogramDiff(file1, file2, options, 1, len(a), 1, len(b))
+		return file1, file2
+	}
+	file1, file2, _ := prepareDiff(a, b, options, true)
+	myersDiff(file1, file2, options.algorithm == "minimal")
+	return file1, file2
+}
+
+// diffLines compares a and b, lines with their newline, and returns the
+// changes in order.
+func diffLines(a []string, b []string, options lineDiffOptions) []lineChange {
+	file1, file2 := doLineDiff(a, b, options)
+	compactChanges(file1, file2, options)
+	compactChanges(file2, file1, options)
+
+	changes := []lineChange{}
+	for i1, i2 := len(a), len(b); i1 >= 0 || i2 >= 0; i1, i2 = i1-1, i2-1 {
+		if file1.isChanged(i1-1) || file2.isChanged(i2-1) {
+			l1, l2 := i1, i2
+			for file1.isChanged(i1 - 1) {
+				i1--
+			}
+			for file2.isChanged(i2 - 1) {
+				i2--
+			}
+			changes = append(changes, lineChange{i1: i1, i2: i2, chg1: l1 - i1, chg2: l2 - i2})
+		}
+	}
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes
+}
+
+// changeGroup is a run of changed lines from start up to end.
+type changeGroup struct {
+	start, end int
+}
+
+func (file *xdfile) firstGroup() changeGroup {
+	group := changeGroup{}
+	for file.isChanged(group.end) {
+		group.end++
+	}
+	return group
+}
+
+func (file *xdfile) nextGroup(group *changeGroup) bool {
+	if group.end == len(file.recs) {
+		return false
+	}
+	group.start = group.end + 1
+	for group.end = group.start; file.isChanged(group.end); group.end++ {
+	}
+	return true
+}
+
+func (file *xdfile) previousGroup(group *changeGroup) bool {
+	if group.start == 0 {
+		return false
+	}
+	group.end = group.start - 1
+	for group.start = group.end; file.isChanged(group.start - 1); group.start-- {
+	}
+	return true
+}
+
+func (file *xdfile) slideDown(group *changeGroup) bool {
+	if group.end < len(file.recs) && file.ha[group.start] == file.ha[group.end] {
+		file.setChanged(group.start, false)
+		file.setChanged(group.end, true)
+		group.start++
+		group.end++
+		for file.isChanged(group.end) {
+			group.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (file *xdfile) slideUp(group *changeGroup) bool {
+	if group.start > 0 && file.ha[group.start-1] == file.ha[group.end-1] {
+		group.start--
+		group.end--
+		file.setChanged(group.start, true)
+		file.setChanged(group.end, false)
+		for file.isChanged(group.start - 1) {
+			group.start--
+		}
+		return true
+	}
+	return false
+}
+
+const indentHeuristicMaxSliding = 100
+
+// compactChanges slides every group of changed lines of file as far down
+// as it goes, merging groups that meet, and then back up to line up with
+// a change on the other side or to where the indentation suggests.
+func compactChanges(file *xdfile, other *xdfile, options lineDiffOptions) {
+	group, otherGroup := file.firstGroup(), other.firstGroup()
+	for {
+		if group.end != group.start {
+			var groupSize, earliestEnd, endMatchingOther int
+			for {
+				groupSize = group.end - group.start
+				endMatchingOther = -1
+				for file.slideUp(&group) {
+					other.previousGroup(&otherGroup)
+				}
+				earliestEnd = group.end
+				if otherGroup.end > otherGroup.start {
+					endMatchingOther = group.end
+				}
+				for file.slideDown(&group) {
+					other.nextGroup(&otherGroup)
+					if otherGroup.end > otherGroup.start {
+						endMatchingOther = group.end
+					}
+				}
+				if groupSize == group.end-group.start {
+					break
+				}
+			}
+
+			switch {
+			case group.end == earliestEnd:
+			case endMatchingOther != -1:
+				for otherGroup.end == otherGroup.start {
+					file.slideUp(&group)
+					other.previousGroup(&otherGroup)
+				}
+			case !options.noIndentHeuristic:
+				shift := max(earliestEnd, group.end-groupSize-1, group.end-indentHeuristicMaxSliding)
+				bestShift := -1
+				var bestScore splitScore
+				for ; shift <= group.end; shift++ {
+					score := splitScore{}
+					score.add(file.measureSplit(shift))
+					score.add(file.measureSplit(shift - groupSize))
+					if bestShift == -1 || score.compare(bestScore) <= 0 {
+						bestScore, bestShift = score, shift
+					}
+				}
+				for group.end > bestShift {
+					file.slideUp(&group)
+					other.previousGroup(&otherGroup)
+				}
+			}
+		}
+		if !file.nextGroup(&group) {
+			break
+		}
+		other.nextGroup(&otherGroup)
+	}
+}
+
+const (
+	maxIndent                       = 200
+	maxBlanks                       = 20
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+)
+
+// lineIndent returns the width of the leading whitespace of line, or -1
+// when the line is blank.
+func lineIndent(line string) int {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if !isXdiffSpace(c) {
+			return indent
+		}
+		if c == ' ' {
+			indent++
+		} else if c == '\t' {
+			indent += 8 - indent%8
+		}
+		if indent >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+// measureSplit describes the lines around a split before line split.
+func (file *xdfile) measureSplit(split int) splitMeasurement {
+	m := splitMeasurement{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(file.recs) {
+		m.endOfFile = true
+	} else {
+		m.indent = lineIndent(file.recs[split])
+	}
+	for i := split - 1; i >= 0; i-- {
+		m.preIndent = lineIndent(file.recs[i])
+		if m.preIndent != -1 {
+			break
+		}
+		m.preBlank++
+		if m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	for i := split + 1; i < len(file.recs); i++ {
+		m.postIndent = lineIndent(file.recs[i])
+		if m.postIndent != -1 {
+			break
+		}
+		m.postBlank++
+		if m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (score *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		score.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		score.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	score.penalty += totalBlankWeight * totalBlank
+	score.penalty += postBlankWeight * postBlank
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	score.effectiveIndent += indent
+	switch {
+	case indent == -1 || m.preIndent == -1:
+	case indent > m.preIndent:
+		if anyBlanks {
+			score.penalty += relativeIndentWithBlankPenalty
+		} else {
+			score.penalty += relativeIndentPenalty
+		}
+	case indent == m.preIndent:
+	case m.postIndent != -1 && m.postIndent > indent:
+		if anyBlanks {
+			score.penalty += relativeOutdentWithBlankPenalty
+		} else {
+			score.penalty += relativeOutdentPenalty
+		}
+	default:
+		if anyBlanks {
+			score.penalty += relativeDedentWithBlankPenalty
+		} else {
+			score.penalty += relativeDedentPenalty
+		}
+	}
+}
+
+func (score splitScore) compare(other splitScore) int {
+	indents := 0
+	if score.effectiveIndent > other.effectiveIndent {
+		indents = 1
+	} else if score.effectiveIndent < other.effectiveIndent {
+		indents = -1
+	}
+	return indentWeight*indents + (score.penalty - other.penalty)
+}
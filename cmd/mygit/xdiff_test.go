@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	lines := func(text string) []string {
+		return splitLines([]byte(text))
+	}
+	cases := []struct {
+		name    string
+		a, b    string
+		options lineDiffOptions
+		want    []lineChange
+	}{
+		{
+			name: "change in the middle",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: []lineChange{{i1: 1, i2: 1, chg1: 1, chg2: 1}},
+		},
+		{
+			name: "missing newline at the end",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: []lineChange{{i1: 1, i2: 1, chg1: 1, chg2: 1}},
+		},
+		{
+			// The indent heuristic moves the added function below the
+			// blank line instead of splitting the closing brace off
+			name: "indent heuristic",
+			a:    "f1() {\n  a\n}\n\nf3() {\n  c\n}\n",
+			b:    "f1() {\n  a\n}\n\nf2() {\n  b\n}\n\nf3() {\n  c\n}\n",
+			want: []lineChange{{i1: 4, i2: 4, chg1: 0, chg2: 4}},
+		},
+		{
+			name:    "patience anchors unique lines",
+			a:       "x\na\nb\nx\n",
+			b:       "a\nx\nb\n",
+			options: lineDiffOptions{algorithm: "patience"},
+			want:    []lineChange{{i1: 0, i2: 0, chg1: 1, chg2: 0}, {i1: 2, i2: 1, chg1: 0, chg2: 1}, {i1: 3, i2: 3, chg1: 1, chg2: 0}},
+		},
+		{
+			name:    "histogram",
+			a:       "a\nb\nc\n",
+			b:       "c\nb\na\n",
+			options: lineDiffOptions{algorithm: "histogram"},
+			want:    []lineChange{{i1: 0, i2: 0, chg1: 2, chg2: 0}, {i1: 3, i2: 1, chg1: 0, chg2: 2}},
+		},
+		{
+			name:    "ignore all space",
+			a:       "a b\nc\n",
+			b:       "ab \nd\n",
+			options: lineDiffOptions{ignoreAllSpace: true},
+			want:    []lineChange{{i1: 1, i2: 1, chg1: 1, chg2: 1}},
+		},
+		{
+			name:    "ignore space change",
+			a:       " a  b\n a b\n",
+			b:       "  a b \na b\n",
+			options: lineDiffOptions{ignoreSpaceChange: true},
+			want:    []lineChange{{i1: 1, i2: 1, chg1: 1, chg2: 1}},
+		},
+	}
+	for _, c := range cases {
+		if c.options.algorithm == "" {
+			c.options.algorithm = "myers"
+		}
+		got := diffLines(lines(c.a), lines(c.b), c.options)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDiffLinesLargeInput(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 5000; i++ {
+		a.WriteString(strings.Repeat("x", i%7) + "\n")
+		if i%50 != 0 {
+			b.WriteString(strings.Repeat("x", i%7) + "\n")
+		}
+	}
+	changes := diffLines(splitLines([]byte(a.String())), splitLines([]byte(b.String())), lineDiffOptions{algorithm: "myers"})
+	removed := 0
+	for _, change := range changes {
+		if change.chg2 != 0 {
+			t.Fatalf("unexpected addition %+v", change)
+		}
+		removed += change.chg1
+	}
+	if removed != 100 {
+		t.Errorf("removed %d lines, want 100", removed)
+	}
+}
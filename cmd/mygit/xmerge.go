@@ -0,0 +1,422 @@
+// Derived from git's xdiff/xmerge.c.
+//
+//  LibXDiff by Davide Libenzi ( File Differential Library )
+//  Copyright (C) 2003-2006 Davide Libenzi, Johannes E. Schindelin
+//
+//  This library is free software; you can redistribute it and/or
+//  modify it under the terms of the GNU Lesser General Public
+//  License as published by the Free Software Foundation; either
+//  version 2.1 of the License, or (at your option) any later version.
+//
+//  This library is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+//  Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public
+//  License along with this library; if not, see
+//  <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+/*
+*  ###################### LINE MERGE ##############################
+*
+*  A port of xdiff's three-way merge. Both sides are diffed against the
+*  base, and the changes are walked in order: a change made by one side
+*  only is taken, changes of both sides that overlap are a conflict. The
+*  conflicts are then refined by diffing the two sides against each other,
+*  so that only the lines that really differ are left between markers:
+*
+*    <<<<<<< ours
+*    lines of ours
+*    ||||||| base        (diff3 and zdiff3 only)
+*    lines of the base
+*    =======
+*    lines of theirs
+*    >>>>>>> theirs
+ */
+
+type mergeLevel int
+
+const (
+	// mergeMinimal makes every overlapping change a conflict
+	mergeMinimal mergeLevel = iota
+	// mergeEager takes changes both sides made the same way
+	mergeEager
+	// mergeZealous narrows conflicts down to the lines that differ
+	mergeZealous
+	// mergeZealousAlnum also joins conflicts only separated by lines
+	// without letters or digits
+	mergeZealousAlnum
+)
+
+type mergeStyle int
+
+const (
+	mergeStyleMerge mergeStyle = iota
+	mergeStyleDiff3
+	mergeStyleZdiff3
+)
+
+// mergeFavor resolves conflicts by taking a side instead of writing
+// markers.
+type mergeFavor int
+
+const (
+	favorNone mergeFavor = iota
+	favorOurs
+	favorTheirs
+	favorUnion
+)
+
+const defaultMarkerSize = 7
+
+type lineMergeOptions struct {
+	lineDiffOptions
+	level      mergeLevel
+	style      mergeStyle
+	favor      mergeFavor
+	markerSize int
+	// The labels written after the markers
+	ancestor, ours, theirs string
+}
+
+// mergeStyleFromConfig reads a merge.conflictStyle value.
+func mergeStyleFromConfig(value string) (mergeStyle, bool) {
+	switch value {
+	case "merge":
+		return mergeStyleMerge, true
+	case "diff3":
+		return mergeStyleDiff3, true
+	case "zdiff3":
+		return mergeStyleZdiff3, true
+	}
+	return mergeStyleMerge, false
+}
+
+// mergeRegion is a stretch of the base changed by ours, theirs or both,
+// with the lines each side has there.
+type mergeRegion struct {
+	// mode is 0 for a conflict, 1 to take ours, 2 to take theirs, 3 to
+	// take both and 4 when both sides made the same change
+	mode int
+	// The lines of the base, of ours and of theirs
+	i0, chg0 int
+	i1, chg1 int
+	i2, chg2 int
+}
+
+type lineMerge struct {
+	options lineMergeOptions
+	base    []string
+	ours    []string
+	theirs  []string
+	regions []mergeRegion
+}
+
+// mergeLines merges the changes ours and theirs made to base. It returns
+// the result and the number of conflicts written into it.
+func mergeLines(base []byte, ours []byte, theirs []byte, options lineMergeOptions) ([]byte, int) {
+	merge := &lineMerge{options: options, base: splitLines(base), ours: splitLines(ours), theirs: splitLines(theirs)}
+	diffOptions := options.lineDiffOptions
+	diffOptions.noIndentHeuristic = true
+	changes1 := diffLines(merge.base, merge.ours, diffOptions)
+	changes2 := diffLines(merge.base, merge.theirs, diffOptions)
+	if len(changes1) == 0 {
+		return bytes.Clone(theirs), 0
+	}
+	if len(changes2) == 0 {
+		return bytes.Clone(ours), 0
+	}
+	merge.collect(changes1, changes2)
+	if options.style == mergeStyleZdiff3 {
+		merge.trimConflicts()
+	} else if merge.level() >= mergeZealous {
+		merge.refineConflicts()
+		merge.simplifyNonConflicts()
+	}
+	return merge.write()
+}
+
+// level returns the merge level, which diff3 caps because the base shown
+// between the sides would no longer line up with a narrowed conflict.
+func (merge *lineMerge) level() mergeLevel {
+	if merge.options.style == mergeStyleDiff3 && merge.options.level > mergeEager {
+		return mergeEager
+	}
+	return merge.options.level
+}
+
+// appendRegion adds a region, or grows the last one when they overlap,
+// which then is a conflict unless both are taken from the same side.
+func (merge *lineMerge) appendRegion(region mergeRegion) {
+	if n := len(merge.regions); n > 0 {
+		last := &merge.regions[n-1]
+		if region.i1 <= last.i1+last.chg1 || region.i2 <= last.i2+last.chg2 {
+			if region.mode != last.mode {
+				last.mode = 0
+			}
+			last.chg0 = region.i0 + region.chg0 - last.i0
+			last.chg1 = region.i1 + region.chg1 - last.i1
+			last.chg2 = region.i2 + region.chg2 - last.i2
+			return
+		}
+	}
+	merge.regions = append(merge.regions, region)
+}
+
+func (merge *lineMerge) linesMatch(a string, b string) bool {
+	return lineKey(a, merge.options.lineDiffOptions) == lineKey(b, merge.options.lineDiffOptions)
+}
+
+func (merge *lineMerge) collect(changes1 []lineChange, changes2 []lineChange) {
+	for len(changes1) > 0 && len(changes2) > 0 {
+		x1, x2 := changes1[0], changes2[0]
+		if x1.i1+x1.chg1 < x2.i1 {
+			merge.appendRegion(mergeRegion{mode: 1, i0: x1.i1, chg0: x1.chg1, i1: x1.i2, chg1: x1.chg2, i2: x2.i2 - x2.i1 + x1.i1, chg2: x1.chg1})
+			changes1 = changes1[1:]
+			continue
+		}
+		if x2.i1+x2.chg1 < x1.i1 {
+			merge.appendRegion(mergeRegion{mode: 2, i0: x2.i1, chg0: x2.chg1, i1: x1.i2 - x1.i1 + x2.i1, chg1: x2.chg1, i2: x2.i2, chg2: x2.chg2})
+			changes2 = changes2[1:]
+			continue
+		}
+		if merge.level() == mergeMinimal || x1.i1 != x2.i1 || x1.chg1 != x2.chg1 || x1.chg2 != x2.chg2 || !merge.sameChange(x1, x2) {
+			off := x1.i1 - x2.i1
+			ffo := off + x1.chg1 - x2.chg1
+			i0, i1, i2 := x1.i1, x1.i2, x2.i2
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := x1.i1 + x1.chg1 - i0
+			chg1 := x1.i2 + x1.chg2 - i1
+			chg2 := x2.i2 + x2.chg2 - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			merge.appendRegion(mergeRegion{mode: 0, i0: i0, chg0: chg0, i1: i1, chg1: chg1, i2: i2, chg2: chg2})
+		}
+		end1 := x1.i1 + x1.chg1
+		end2 := x2.i1 + x2.chg1
+		if end1 >= end2 {
+			changes2 = changes2[1:]
+		}
+		if end2 >= end1 {
+			changes1 = changes1[1:]
+		}
+	}
+	for _, x1 := range changes1 {
+		merge.appendRegion(mergeRegion{mode: 1, i0: x1.i1, chg0: x1.chg1, i1: x1.i2, chg1: x1.chg2, i2: x1.i1 + len(merge.theirs) - len(merge.base), chg2: x1.chg1})
+	}
+	for _, x2 := range changes2 {
+		merge.appendRegion(mergeRegion{mode: 2, i0: x2.i1, chg0: x2.chg1, i1: x2.i1 + len(merge.ours) - len(merge.base), chg1: x2.chg1, i2: x2.i2, chg2: x2.chg2})
+	}
+}
+
+// sameChange reports whether both sides put the same lines in place of
+// the same lines of the base.
+func (merge *lineMerge) sameChange(x1 lineChange, x2 lineChange) bool {
+	for i := range x1.chg2 {
+		if !merge.linesMatch(merge.ours[x1.i2+i], merge.theirs[x2.i2+i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// refineConflicts diffs the two sides of each conflict and splits it into
+// the parts that differ. Sides found identical are no conflict at all.
+func (merge *lineMerge) refineConflicts() {
+	options := merge.options.lineDiffOptions
+	options.noIndentHeuristic = true
+	regions := []mergeRegion{}
+	for _, region := range merge.regions {
+		if region.mode != 0 || region.chg1 == 0 || region.chg2 == 0 {
+			regions = append(regions, region)
+			continue
+		}
+		changes := diffLines(merge.ours[region.i1:region.i1+region.chg1], merge.theirs[region.i2:region.i2+region.chg2], options)
+		if len(changes) == 0 {
+			region.mode = 4
+			regions = append(regions, region)
+			continue
+		}
+		for _, change := range changes {
+			regions = append(regions, mergeRegion{
+				i0: region.i0, chg0: region.chg0,
+				i1: region.i1 + change.i1, chg1: change.chg1,
+				i2: region.i2 + change.i2, chg2: change.chg2,
+			})
+		}
+	}
+	merge.regions = regions
+}
+
+// simplifyNonConflicts joins conflicts separated by three lines or less,
+// which takes up no more room than showing them apart. At the alnum level
+// any number of lines without letters or digits joins them too.
+func (merge *lineMerge) simplifyNonConflicts() {
+	regions := merge.regions[:0]
+	for _, region := range merge.regions {
+		if n := len(regions); n > 0 {
+			last := &regions[n-1]
+			begin, end := last.i1+last.chg1, region.i1
+			if last.mode == 0 && region.mode == 0 && (end-begin <= 3 || merge.options.level == mergeZealousAlnum && !linesContainAlnum(merge.ours[begin:end])) {
+				last.chg1 = region.i1 + region.chg1 - last.i1
+				last.chg2 = region.i2 + region.chg2 - last.i2
+				continue
+			}
+		}
+		regions = append(regions, region)
+	}
+	merge.regions = regions
+}
+
+func linesContainAlnum(lines []string) bool {
+	for _, line := range lines {
+		for _, c := range []byte(line) {
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trimConflicts drops the lines both sides agree on from the start and
+// end of each conflict, for zdiff3.
+func (merge *lineMerge) trimConflicts() {
+	for i := range merge.regions {
+		region := &merge.regions[i]
+		if region.mode != 0 {
+			continue
+		}
+		for region.chg1 > 0 && region.chg2 > 0 && merge.linesMatch(merge.ours[region.i1], merge.theirs[region.i2]) {
+			region.i1++
+			region.i2++
+			region.chg1--
+			region.chg2--
+		}
+		for region.chg1 > 0 && region.chg2 > 0 && merge.linesMatch(merge.ours[region.i1+region.chg1-1], merge.theirs[region.i2+region.chg2-1]) {
+			region.chg1--
+			region.chg2--
+		}
+	}
+}
+
+// isEOLCRLF tells whether line i of lines ends in CR LF, going by the line
+// before a last line without newline. It returns -1 when there is no line
+// ending to go by.
+func isEOLCRLF(lines []string, i int) int {
+	crlf := func(line string) int {
+		if strings.HasSuffix(line, "\r\n") {
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case len(lines) == 0:
+		return -1
+	case i < len(lines)-1 || strings.HasSuffix(lines[i], "\n"):
+		return crlf(lines[i])
+	case i == 0:
+		return -1
+	}
+	return crlf(lines[i-1])
+}
+
+// needsCR tells whether the lines written around a region end in CR LF,
+// like the lines before it on both sides, or like the base.
+func (merge *lineMerge) needsCR(region mergeRegion) bool {
+	needs := isEOLCRLF(merge.ours, max(region.i1-1, 0))
+	if needs != 0 {
+		needs = isEOLCRLF(merge.theirs, max(region.i2-1, 0))
+	}
+	if needs != 0 {
+		needs = isEOLCRLF(merge.base, 0)
+	}
+	return needs > 0
+}
+
+// copyLines writes lines, ending the last one with a newline when asked
+// to and it has none.
+func copyLines(out *bytes.Buffer, lines []string, addNewline bool, cr bool) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if addNewline && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		if cr {
+			out.WriteByte('\r')
+		}
+		out.WriteByte('\n')
+	}
+}
+
+func (merge *lineMerge) marker(out *bytes.Buffer, c byte, label string, cr bool) {
+	size := merge.options.markerSize
+	if size <= 0 {
+		size = defaultMarkerSize
+	}
+	out.WriteString(strings.Repeat(string(c), size))
+	if label != "" {
+		out.WriteString(" " + label)
+	}
+	if cr {
+		out.WriteByte('\r')
+	}
+	out.WriteByte('\n')
+}
+
+// write puts the result together from the lines of ours the regions leave
+// alone and what each region resolved to.
+func (merge *lineMerge) write() ([]byte, int) {
+	out := &bytes.Buffer{}
+	conflicts, next := 0, 0
+	for _, region := range merge.regions {
+		if region.mode == 0 && merge.options.favor != favorNone {
+			region.mode = int(merge.options.favor)
+		}
+		if region.mode == 4 {
+			continue
+		}
+		copyLines(out, merge.ours[next:region.i1], false, false)
+		ours := merge.ours[region.i1 : region.i1+region.chg1]
+		theirs := merge.theirs[region.i2 : region.i2+region.chg2]
+		if region.mode == 0 {
+			conflicts++
+			cr := merge.needsCR(region)
+			merge.marker(out, '<', merge.options.ours, cr)
+			copyLines(out, ours, true, cr)
+			if merge.options.style != mergeStyleMerge {
+				merge.marker(out, '|', merge.options.ancestor, cr)
+				copyLines(out, merge.base[region.i0:region.i0+region.chg0], true, cr)
+			}
+			merge.marker(out, '=', "", cr)
+			copyLines(out, theirs, true, cr)
+			merge.marker(out, '>', merge.options.theirs, cr)
+		} else {
+			if region.mode&1 != 0 {
+				copyLines(out, ours, region.mode&2 != 0, merge.needsCR(region))
+			}
+			if region.mode&2 != 0 {
+				copyLines(out, theirs, false, false)
+			}
+		}
+		next = region.i1 + region.chg1
+	}
+	copyLines(out, merge.ours[next:], false, false)
+	return out.Bytes(), conflicts
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	cases := []struct {
+		name               string
+		base, ours, theirs string
+		style              mergeStyle
+		favor              mergeFavor
+		want               string
+		wantConflicts      int
+	}{
+		{
+			name:   "changes apart",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			want:   "a\nB\nc\nd\nE\n",
+		},
+		{
+			name:          "conflict",
+			base:          "a\nb\nc\n",
+			ours:          "a\nX\nc\n",
+			theirs:        "a\nY\nc\n",
+			want:          "a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nc\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "diff3",
+			base:          "a\nb\nc\n",
+			ours:          "a\nX\nc\n",
+			theirs:        "a\nY\nc\n",
+			style:         mergeStyleDiff3,
+			want:          "a\n<<<<<<< ours\nX\n||||||| base\nb\n=======\nY\n>>>>>>> theirs\nc\n",
+			wantConflicts: 1,
+		},
+		{
+			// zdiff3 moves the lines both sides changed the same way out
+			// of the conflict
+			name:          "zdiff3",
+			base:          "a\nb\nc\np\n",
+			ours:          "a\nX\nc\nq\n",
+			theirs:        "a\nX\nc\nr\n",
+			style:         mergeStyleZdiff3,
+			want:          "a\nX\nc\n<<<<<<< ours\nq\n||||||| base\np\n=======\nr\n>>>>>>> theirs\n",
+			wantConflicts: 1,
+		},
+		{
+			name:   "union",
+			base:   "a\nb\nc\n",
+			ours:   "a\nX\nc\n",
+			theirs: "a\nY\nc\n",
+			favor:  favorUnion,
+			want:   "a\nX\nY\nc\n",
+		},
+	}
+	for _, c := range cases {
+		options := lineMergeOptions{
+			level:      mergeZealousAlnum,
+			style:      c.style,
+			favor:      c.favor,
+			markerSize: defaultMarkerSize,
+			ancestor:   "base",
+			ours:       "ours",
+			theirs:     "theirs",
+		}
+		got, conflicts := mergeLines([]byte(c.base), []byte(c.ours), []byte(c.theirs), options)
+		if string(got) != c.want || conflicts != c.wantConflicts {
+			t.Errorf("%s: got %q with %d conflicts, want %q with %d", c.name, got, conflicts, c.want, c.wantConflicts)
+		}
+	}
+}
+
+func TestMergeFileCommand(t *testing.T) {
+	repo := t.TempDir()
+	for name, content := range map[string]string{"base": "1\n2\n3\n", "ours": "1\nours\n3\n", "theirs": "1\ntheirs\n3\n"} {
+		writeTestFile(t, repo, name, content)
+	}
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, "1\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n3\n"},
+		{[]string{"-L", "mine", "-L", "orig", "-L", "yours", "--diff3"},
+			"1\n<<<<<<< mine\nours\n||||||| orig\n2\n=======\ntheirs\n>>>>>>> yours\n3\n"},
+	}
+	for _, c := range cases {
+		args := append(append([]string{"merge-file", "-p"}, c.args...), "ours", "base", "theirs")
+		result := runMygit(t, repo, "", args...)
+		if result.code != 1 || result.stdout != c.want {
+			t.Errorf("merge-file -p %v = %d\n%s\nwant 1\n%s", c.args, result.code, result.stdout, c.want)
+		}
+	}
+	// Without -p the result replaces ours
+	if result := runMygit(t, repo, "", "merge-file", "ours", "base", "theirs"); result.code != 1 || result.stdout != "" {
+		t.Errorf("merge-file = %d %q", result.code, result.stdout)
+	}
+	if merged, err := os.ReadFile(worktreePath(repo, "ours")); err != nil || string(merged) != cases[0].want {
+		t.Errorf("ours holds %q, %v", merged, err)
+	}
+}